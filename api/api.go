@@ -2,8 +2,11 @@ package api
 
 import (
 	"context"
+	"io"
+	"time"
 
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
@@ -35,6 +38,7 @@ type Boost interface {
 	BoostIndexerAnnounceAllDeals(ctx context.Context) error                                                                        //perm:admin
 	BoostOfflineDealWithData(ctx context.Context, dealUuid uuid.UUID, filePath string) (*ProviderDealRejectionInfo, error)         //perm:admin
 	BoostDeal(ctx context.Context, dealUuid uuid.UUID) (*smtypes.ProviderDealState, error)                                         //perm:admin
+	BoostDealRetry(ctx context.Context, dealUuid uuid.UUID) error                                                                  //perm:admin
 	BoostDummyDeal(context.Context, smtypes.DealParams) (*ProviderDealRejectionInfo, error)                                        //perm:admin
 	BoostDagstoreInitializeShard(ctx context.Context, key string) error                                                            //perm:admin
 	BoostDagstoreInitializeAll(ctx context.Context, params DagstoreInitializeAllParams) (<-chan DagstoreInitializeAllEvent, error) //perm:admin
@@ -42,6 +46,36 @@ type Boost interface {
 
 	BoostDagstoreListShards(ctx context.Context) ([]DagstoreShardInfo, error) //perm:read
 
+	// BoostOfflineDealWithDataStream writes the data for an offline deal from
+	// the stream to a new file at filePath on the boost node, and imports it.
+	// The piece commitment is calculated as the data is written, so the file
+	// doesn't need to be read again to verify it.
+	BoostOfflineDealWithDataStream(ctx context.Context, dealUuid uuid.UUID, filePath string, data io.Reader) (*ProviderDealRejectionInfo, error) //perm:admin
+	// BoostOfflineDealsImport imports the data for several offline deals,
+	// matching each file on the boost node with a deal by deal uuid or by
+	// piece CID, or by calculating the commP of every file in a directory.
+	// Progress events are streamed back as each file is processed.
+	BoostOfflineDealsImport(ctx context.Context, params OfflineDealsImportParams) (<-chan OfflineDealsImportEvent, error) //perm:admin
+	// BoostTransferBandwidthLimits returns the limits on the rate at which
+	// deal data is downloaded, in bytes per second
+	BoostTransferBandwidthLimits(ctx context.Context) (transporttypes.BandwidthLimits, error) //perm:read
+	// BoostSetTransferBandwidthLimits changes the limits on the rate at which
+	// deal data is downloaded. The new limits apply to running transfers but
+	// are not saved to the config file.
+	BoostSetTransferBandwidthLimits(ctx context.Context, limits transporttypes.BandwidthLimits) error //perm:admin
+	// BoostFundsReconcile compares the funds tagged for active deals with
+	// the escrow balance and the publish message wallet balances, and lists
+	// tags for deals that are no longer active. If release is true the
+	// orphaned tags are released.
+	BoostFundsReconcile(ctx context.Context, release bool) (*FundsReconcileReport, error) //perm:admin
+	// BoostStagingGC lists the files in the staging areas that are not used
+	// by any deal, and deletes the files that are older than the grace
+	// period unless dryRun is true.
+	BoostStagingGC(ctx context.Context, dryRun bool) (*StagingGCReport, error) //perm:admin
+	// BoostDealCancel cancels a deal that has not yet been published, and
+	// records the reason it was cancelled
+	BoostDealCancel(ctx context.Context, dealUuid uuid.UUID, reason string) error //perm:admin
+
 	// RuntimeSubsystems returns the subsystems that are enabled
 	// in this instance.
 	RuntimeSubsystems(ctx context.Context) (lapi.MinerSubsystems, error) //perm:read
@@ -99,6 +133,124 @@ type DagstoreInitializeAllParams struct {
 	IncludeSealed  bool
 }
 
+// FundsReconcileReport compares the funds tagged for deals in the boost
+// database with the balances on chain
+type FundsReconcileReport struct {
+	EscrowAvailable abi.TokenAmount
+	EscrowLocked    abi.TokenAmount
+	// Collateral tagged for active deals and for deals that are no longer
+	// active
+	CollateralTaggedActive   abi.TokenAmount
+	CollateralTaggedOrphaned abi.TokenAmount
+	// The amount by which collateral tagged for active deals exceeds the
+	// available escrow balance
+	CollateralShortfall abi.TokenAmount
+	PubMsgWallets       []FundsReconcileWallet
+	Orphaned            []FundsOrphanedTag
+	// True if the orphaned tags were released
+	Released bool
+}
+
+// FundsReconcileWallet compares the funds tagged in a publish storage deals
+// message wallet with the wallet balance
+type FundsReconcileWallet struct {
+	Address        address.Address
+	Balance        abi.TokenAmount
+	TaggedActive   abi.TokenAmount
+	TaggedOrphaned abi.TokenAmount
+	Shortfall      abi.TokenAmount
+}
+
+// FundsOrphanedTag is funds tagged for a deal that is no longer active
+type FundsOrphanedTag struct {
+	DealUUID     uuid.UUID
+	CreatedAt    time.Time
+	Collateral   abi.TokenAmount
+	PubMsg       abi.TokenAmount
+	PubMsgWallet address.Address
+	Reason       string
+}
+
+// OfflineDealsImportParams are the parameters for a bulk import of offline
+// deal data
+type OfflineDealsImportParams struct {
+	// The files to import. File paths must be absolute paths on the boost
+	// node.
+	Entries []OfflineDealsImportEntry
+	// If set, the files to import are read from the CSV or JSON (.json)
+	// manifest file at this path on the boost node. Relative file paths in
+	// the manifest are relative to the directory that contains it.
+	// Entries must be empty.
+	ManifestPath string
+	// If set, the commP of every file in the directory is calculated and
+	// each file is imported as the data for the offline deal waiting for
+	// that piece. Entries and ManifestPath must be empty.
+	AutoMatchDir string
+	// The number of files to calculate commP for at the same time when
+	// auto-matching
+	MaxConcurrency int
+}
+
+// OfflineDealsImportEntry matches a file with an offline deal by deal uuid,
+// or if DealUUID is not set, with the offline deal for the piece that is
+// waiting for data
+type OfflineDealsImportEntry struct {
+	DealUUID uuid.UUID
+	PieceCID *cid.Cid
+	FilePath string
+}
+
+// OfflineDealsImportEvent reports the progress of a bulk offline deal data
+// import
+type OfflineDealsImportEvent struct {
+	FilePath string
+	DealUUID uuid.UUID
+	PieceCID *cid.Cid
+	Event    string // "commp", "import", "error"
+	Success  bool
+	Error    string
+	Total    int
+	Current  int
+}
+
+// StagingGCReport lists the files in the staging areas that are not used
+// by any deal
+type StagingGCReport struct {
+	Orphaned []StagingOrphanedFile
+	// The files that are kept so that failed deals can be retried
+	Retained []StagingRetainedFile
+	// The total size of the retained files
+	RetainedBytes uint64
+	// The total size of the orphaned files that are eligible for deletion
+	// but have not been deleted
+	ReclaimableBytes uint64
+	// The total size of the orphaned files that were deleted
+	DeletedBytes uint64
+	// True if the orphaned files were only reported, not deleted
+	DryRun bool
+}
+
+// StagingOrphanedFile is a file in a staging area that is not used by any
+// deal
+type StagingOrphanedFile struct {
+	Path    string
+	Size    uint64
+	ModTime time.Time
+	// True if the file was modified within the grace period, so it is not
+	// yet eligible for deletion
+	InGracePeriod bool
+	Deleted       bool
+}
+
+// StagingRetainedFile is a file in a staging area that is kept so that a
+// failed deal can be retried
+type StagingRetainedFile struct {
+	Path string
+	Size uint64
+	// The file is kept until this time
+	Until time.Time
+}
+
 // DagstoreInitializeAllEvent represents an initialization event.
 type DagstoreInitializeAllEvent struct {
 	Key     string
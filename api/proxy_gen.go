@@ -4,8 +4,10 @@ package api
 
 import (
 	"context"
+	"io"
 
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
@@ -44,12 +46,28 @@ type BoostStruct struct {
 
 		BoostDeal func(p0 context.Context, p1 uuid.UUID) (*smtypes.ProviderDealState, error) `perm:"admin"`
 
+		BoostDealCancel func(p0 context.Context, p1 uuid.UUID, p2 string) error `perm:"admin"`
+
+		BoostDealRetry func(p0 context.Context, p1 uuid.UUID) error `perm:"admin"`
+
 		BoostDummyDeal func(p0 context.Context, p1 smtypes.DealParams) (*ProviderDealRejectionInfo, error) `perm:"admin"`
 
+		BoostFundsReconcile func(p0 context.Context, p1 bool) (*FundsReconcileReport, error) `perm:"admin"`
+
 		BoostIndexerAnnounceAllDeals func(p0 context.Context) error `perm:"admin"`
 
 		BoostOfflineDealWithData func(p0 context.Context, p1 uuid.UUID, p2 string) (*ProviderDealRejectionInfo, error) `perm:"admin"`
 
+		BoostOfflineDealWithDataStream func(p0 context.Context, p1 uuid.UUID, p2 string, p3 io.Reader) (*ProviderDealRejectionInfo, error) `perm:"admin"`
+
+		BoostOfflineDealsImport func(p0 context.Context, p1 OfflineDealsImportParams) (<-chan OfflineDealsImportEvent, error) `perm:"admin"`
+
+		BoostSetTransferBandwidthLimits func(p0 context.Context, p1 transporttypes.BandwidthLimits) error `perm:"admin"`
+
+		BoostStagingGC func(p0 context.Context, p1 bool) (*StagingGCReport, error) `perm:"admin"`
+
+		BoostTransferBandwidthLimits func(p0 context.Context) (transporttypes.BandwidthLimits, error) `perm:"read"`
+
 		DealsConsiderOfflineRetrievalDeals func(p0 context.Context) (bool, error) `perm:"admin"`
 
 		DealsConsiderOfflineStorageDeals func(p0 context.Context) (bool, error) `perm:"admin"`
@@ -275,6 +293,28 @@ func (s *BoostStub) BoostDeal(p0 context.Context, p1 uuid.UUID) (*smtypes.Provid
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostDealCancel(p0 context.Context, p1 uuid.UUID, p2 string) error {
+	if s.Internal.BoostDealCancel == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealCancel(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostDealCancel(p0 context.Context, p1 uuid.UUID, p2 string) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealRetry(p0 context.Context, p1 uuid.UUID) error {
+	if s.Internal.BoostDealRetry == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealRetry(p0, p1)
+}
+
+func (s *BoostStub) BoostDealRetry(p0 context.Context, p1 uuid.UUID) error {
+	return ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDummyDeal(p0 context.Context, p1 smtypes.DealParams) (*ProviderDealRejectionInfo, error) {
 	if s.Internal.BoostDummyDeal == nil {
 		return nil, ErrNotSupported
@@ -286,6 +326,17 @@ func (s *BoostStub) BoostDummyDeal(p0 context.Context, p1 smtypes.DealParams) (*
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostFundsReconcile(p0 context.Context, p1 bool) (*FundsReconcileReport, error) {
+	if s.Internal.BoostFundsReconcile == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostFundsReconcile(p0, p1)
+}
+
+func (s *BoostStub) BoostFundsReconcile(p0 context.Context, p1 bool) (*FundsReconcileReport, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *BoostStruct) BoostIndexerAnnounceAllDeals(p0 context.Context) error {
 	if s.Internal.BoostIndexerAnnounceAllDeals == nil {
 		return ErrNotSupported
@@ -308,6 +359,61 @@ func (s *BoostStub) BoostOfflineDealWithData(p0 context.Context, p1 uuid.UUID, p
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostOfflineDealWithDataStream(p0 context.Context, p1 uuid.UUID, p2 string, p3 io.Reader) (*ProviderDealRejectionInfo, error) {
+	if s.Internal.BoostOfflineDealWithDataStream == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealWithDataStream(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostOfflineDealWithDataStream(p0 context.Context, p1 uuid.UUID, p2 string, p3 io.Reader) (*ProviderDealRejectionInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostOfflineDealsImport(p0 context.Context, p1 OfflineDealsImportParams) (<-chan OfflineDealsImportEvent, error) {
+	if s.Internal.BoostOfflineDealsImport == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealsImport(p0, p1)
+}
+
+func (s *BoostStub) BoostOfflineDealsImport(p0 context.Context, p1 OfflineDealsImportParams) (<-chan OfflineDealsImportEvent, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostSetTransferBandwidthLimits(p0 context.Context, p1 transporttypes.BandwidthLimits) error {
+	if s.Internal.BoostSetTransferBandwidthLimits == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostSetTransferBandwidthLimits(p0, p1)
+}
+
+func (s *BoostStub) BoostSetTransferBandwidthLimits(p0 context.Context, p1 transporttypes.BandwidthLimits) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostStagingGC(p0 context.Context, p1 bool) (*StagingGCReport, error) {
+	if s.Internal.BoostStagingGC == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostStagingGC(p0, p1)
+}
+
+func (s *BoostStub) BoostStagingGC(p0 context.Context, p1 bool) (*StagingGCReport, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostTransferBandwidthLimits(p0 context.Context) (transporttypes.BandwidthLimits, error) {
+	if s.Internal.BoostTransferBandwidthLimits == nil {
+		return *new(transporttypes.BandwidthLimits), ErrNotSupported
+	}
+	return s.Internal.BoostTransferBandwidthLimits(p0)
+}
+
+func (s *BoostStub) BoostTransferBandwidthLimits(p0 context.Context) (transporttypes.BandwidthLimits, error) {
+	return *new(transporttypes.BandwidthLimits), ErrNotSupported
+}
+
 func (s *BoostStruct) DealsConsiderOfflineRetrievalDeals(p0 context.Context) (bool, error) {
 	if s.Internal.DealsConsiderOfflineRetrievalDeals == nil {
 		return false, ErrNotSupported
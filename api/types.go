@@ -24,6 +24,9 @@ import (
 type ProviderDealRejectionInfo struct {
 	Accepted bool
 	Reason   string // The rejection reason, if the deal is rejected
+	// The token the client uses to authenticate the upload of the deal
+	// data, if the deal has the "push" transfer type
+	TransferToken string
 }
 
 type MultiaddrSlice []ma.Multiaddr
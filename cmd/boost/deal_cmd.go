@@ -9,10 +9,16 @@ import (
 	bcli "github.com/filecoin-project/boost/cli"
 	clinode "github.com/filecoin-project/boost/cli/node"
 	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/storagemarket/datatransfer"
 	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/transport/graphsynctransport"
+	"github.com/filecoin-project/boost/transport/pushtransport"
 	types2 "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
+	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
+	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api"
 	chain_types "github.com/filecoin-project/lotus/chain/types"
@@ -20,6 +26,12 @@ import (
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
 	inet "github.com/libp2p/go-libp2p-core/network"
 	"github.com/urfave/cli/v2"
 )
@@ -87,14 +99,29 @@ var dealCmd = &cli.Command{
 	Usage: "Make an online deal with Boost",
 	Flags: append([]cli.Flag{
 		&cli.StringFlag{
-			Name:     "http-url",
-			Usage:    "http url to CAR file",
-			Required: true,
+			Name:  "http-url",
+			Usage: "http url to CAR file",
 		},
 		&cli.StringSliceFlag{
 			Name:  "http-headers",
 			Usage: "http headers to be passed with the request (e.g key=value)",
 		},
+		&cli.StringFlag{
+			Name:  "push-file",
+			Usage: "path to a CAR file to upload to the storage provider (instead of the provider downloading it from http-url)",
+		},
+		&cli.StringFlag{
+			Name:  "graphsync-car",
+			Usage: "path to a CAR file that the storage provider pulls from this client over graphsync; the command waits until the transfer is complete",
+		},
+		&cli.StringFlag{
+			Name:  "local-path",
+			Usage: "path to the CAR file on a filesystem that the storage provider can access (e.g a network share)",
+		},
+		&cli.StringFlag{
+			Name:  "push-url",
+			Usage: "http url of the storage provider's upload endpoint (e.g http://provider.example.com:8444); if not set the CAR file is uploaded over libp2p",
+		},
 	}, dealFlags...),
 	Before: before,
 	Action: func(cctx *cli.Context) error {
@@ -176,7 +203,58 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	transfer := types.Transfer{
 		Size: carFileSize,
 	}
+	pushFile := cctx.String("push-file")
+	localPath := cctx.String("local-path")
+	graphsyncCar := cctx.String("graphsync-car")
 	if isOnline {
+		var sources int
+		for _, flag := range []string{"http-url", "push-file", "local-path", "graphsync-car"} {
+			if cctx.IsSet(flag) {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("must set exactly one of http-url, push-file, local-path or graphsync-car for an online deal")
+		}
+	}
+
+	var waitServed func(context.Context) error
+	if isOnline && graphsyncCar != "" {
+		// The provider pulls the CAR file from this client over graphsync,
+		// so start serving it before sending the deal proposal
+		srv, err := newGraphsyncServer(ctx, n)
+		if err != nil {
+			return fmt.Errorf("starting graphsync server: %w", err)
+		}
+		waitServed, err = srv.Serve(dealUuid, addrInfo.ID, rootCid, graphsyncCar)
+		if err != nil {
+			return err
+		}
+
+		var addrs []string
+		for _, maddr := range n.Host.Addrs() {
+			addrs = append(addrs, maddr.String())
+		}
+		paramsBytes, err := json.Marshal(&types2.GraphsyncRequest{PeerID: n.Host.ID().String(), Multiaddrs: addrs})
+		if err != nil {
+			return fmt.Errorf("marshalling request parameters: %w", err)
+		}
+		transfer.Type = "graphsync"
+		transfer.Params = paramsBytes
+	} else if isOnline && pushFile != "" {
+		// The client uploads the CAR file to the provider after the deal
+		// is accepted, so there are no transfer parameters
+		transfer.Type = "push"
+	} else if isOnline && localPath != "" {
+		// The provider transfers the CAR file from a path on a filesystem
+		// that it can access
+		paramsBytes, err := datatransfer.TransferLocal.MarshallParams(&datatransfer.TransferLocalParams{Path: localPath})
+		if err != nil {
+			return fmt.Errorf("marshalling request parameters: %w", err)
+		}
+		transfer.Type = datatransfer.TransferLocal.Type()
+		transfer.Params = paramsBytes
+	} else if isOnline {
 		// Store the path to the CAR file as a transfer parameter
 		transferParams := &types2.HttpRequest{URL: cctx.String("http-url")}
 
@@ -257,9 +335,36 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	}
 
 	if !resp.Accepted {
+		if waitServed != nil {
+			// stop serving the CAR file
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_ = waitServed(cancelled)
+		}
 		return fmt.Errorf("deal proposal rejected: %s", resp.Message)
 	}
 
+	if waitServed != nil {
+		fmt.Printf("deal accepted, waiting for the storage provider to pull %s\n", graphsyncCar)
+		if err := waitServed(ctx); err != nil {
+			return fmt.Errorf("serving deal data: %w", err)
+		}
+	}
+
+	if transfer.Type == "push" {
+		uploadUrl := pushtransport.Libp2pUploadURL(addrInfo.ID)
+		if cctx.IsSet("push-url") {
+			uploadUrl = cctx.String("push-url")
+		}
+
+		fmt.Printf("deal accepted, uploading %s to %s\n", pushFile, uploadUrl)
+		uploader := pushtransport.NewUploader(n.Host)
+		err = uploader.Upload(ctx, uploadUrl, dealUuid.String(), resp.TransferToken, pushFile)
+		if err != nil {
+			return fmt.Errorf("uploading deal data: %w", err)
+		}
+	}
+
 	msg := "sent deal proposal"
 	if !isOnline {
 		msg += " for offline deal"
@@ -269,7 +374,13 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	msg += fmt.Sprintf("  storage provider: %s\n", maddr)
 	msg += fmt.Sprintf("  client wallet: %s\n", walletAddr)
 	msg += fmt.Sprintf("  payload cid: %s\n", rootCid)
-	if isOnline {
+	if transfer.Type == "push" {
+		msg += fmt.Sprintf("  uploaded file: %s\n", pushFile)
+	} else if transfer.Type == "graphsync" {
+		msg += fmt.Sprintf("  transferred file: %s\n", graphsyncCar)
+	} else if localPath != "" && isOnline {
+		msg += fmt.Sprintf("  local path: %s\n", localPath)
+	} else if isOnline {
 		msg += fmt.Sprintf("  url: %s\n", cctx.String("http-url"))
 	}
 	msg += fmt.Sprintf("  commp: %s\n", dealProposal.Proposal.PieceCID)
@@ -312,6 +423,36 @@ func dealProposal(ctx context.Context, n *clinode.Node, clientAddr address.Addre
 	}, nil
 }
 
+// newGraphsyncServer starts go-data-transfer over graphsync on the client
+// node's host, so that the storage provider can pull the deal data
+func newGraphsyncServer(ctx context.Context, n *clinode.Node) (*graphsynctransport.Server, error) {
+	// Blocks are read from the deal's CAR file, so graphsync's own
+	// blockstore is never used
+	bs := bstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	gs := gsimpl.New(ctx, gsnet.NewFromLibp2pHost(n.Host), storeutil.LinkSystemForBlockstore(bs))
+	tp := gstransport.NewTransport(n.Host.ID(), gs)
+	dt, err := dtimpl.NewDataTransfer(dssync.MutexWrap(datastore.NewMapDatastore()), dtnet.NewFromLibp2pHost(n.Host), tp)
+	if err != nil {
+		return nil, fmt.Errorf("creating data transfer manager: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	dt.OnReady(func(err error) { ready <- err })
+	if err := dt.Start(ctx); err != nil {
+		return nil, fmt.Errorf("starting data transfer manager: %w", err)
+	}
+	select {
+	case err := <-ready:
+		if err != nil {
+			return nil, fmt.Errorf("starting data transfer manager: %w", err)
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return graphsynctransport.NewServer(dt)
+}
+
 func doRpc(ctx context.Context, s inet.Stream, req interface{}, resp interface{}) error {
 	errc := make(chan error)
 	go func() {
@@ -132,6 +132,14 @@ func statusMessage(resp *types.DealStatusResponse) string {
 		return "Announcing"
 	case dealcheckpoints.IndexedAndAnnounced.String():
 		return "Sealing"
+	case dealcheckpoints.PreCommitted.String():
+		return "Sector Pre-committed"
+	case dealcheckpoints.Active.String():
+		return "Active"
+	case dealcheckpoints.Expired.String():
+		return "Expired"
+	case dealcheckpoints.Slashed.String():
+		return "Slashed"
 	case dealcheckpoints.Complete.String():
 		if resp.DealStatus.Error != "" {
 			return "Error: " + resp.DealStatus.Error
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+var dealsCmd = &cli.Command{
+	Name:  "deals",
+	Usage: "Manage Boost deals",
+	Subcommands: []*cli.Command{
+		dealsRetryCmd,
+		dealsCancelCmd,
+	},
+}
+
+var dealsRetryCmd = &cli.Command{
+	Name:      "retry",
+	ArgsUsage: "<deal uuid>",
+	Usage:     "Retry a failed deal from the last checkpoint it reached",
+	Flags:     []cli.Flag{},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("must provide a single deal uuid")
+		}
+
+		id := cctx.Args().First()
+		dealUuid, err := uuid.Parse(id)
+		if err != nil {
+			return fmt.Errorf("failed to parse deal uuid '%s'", id)
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := napi.BoostDealRetry(ctx, dealUuid); err != nil {
+			return fmt.Errorf("failed to retry deal %s: %w", dealUuid, err)
+		}
+
+		fmt.Println("Deal scheduled for retry")
+		return nil
+	},
+}
+
+var dealsCancelCmd = &cli.Command{
+	Name:      "cancel",
+	ArgsUsage: "<deal uuid>",
+	Usage:     "Cancel a deal that has not yet been published",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "reason",
+			Usage: "the reason the deal was cancelled, recorded as the deal error",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("must provide a single deal uuid")
+		}
+
+		id := cctx.Args().First()
+		dealUuid, err := uuid.Parse(id)
+		if err != nil {
+			return fmt.Errorf("failed to parse deal uuid '%s'", id)
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := napi.BoostDealCancel(ctx, dealUuid, cctx.String("reason")); err != nil {
+			return fmt.Errorf("failed to cancel deal %s: %w", dealUuid, err)
+		}
+
+		fmt.Println("Deal cancelled")
+		return nil
+	},
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/storagemarket/dealfilter"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+var filterCmd = &cli.Command{
+	Name:  "filter",
+	Usage: "Manage the built-in storage deal filter",
+	Subcommands: []*cli.Command{
+		filterTestCmd,
+	},
+}
+
+var filterTestCmd = &cli.Command{
+	Name:      "test",
+	Usage:     "Test the deal filter rules against a deal proposal",
+	ArgsUsage: "<proposal.json>",
+	Description: "The proposal file has the same JSON format as the input to a deal filter command: " +
+		"the deal parameters, and optionally the ClientPeerID of the peer that sent the proposal",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "rules",
+			Usage: "path of the rules file (defaults to the FilterRulesFile in the boost repo config)",
+		},
+		&cli.Int64Flag{
+			Name:  "epoch",
+			Usage: "the current chain epoch, for rules that match on the deal start epoch",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: filter test <proposal.json>")
+		}
+
+		rulesPath := cctx.String("rules")
+		if rulesPath == "" {
+			var err error
+			rulesPath, err = rulesPathFromRepo(cctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		bz, err := os.ReadFile(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("reading proposal file: %w", err)
+		}
+		var prop struct {
+			types.DealParams
+			ClientPeerID string
+		}
+		if err := json.Unmarshal(bz, &prop); err != nil {
+			return fmt.Errorf("parsing proposal file: %w", err)
+		}
+
+		params := types.DealFilterParams{DealParams: &prop.DealParams}
+		if prop.ClientPeerID != "" {
+			params.ClientPeerID, err = peer.Decode(prop.ClientPeerID)
+			if err != nil {
+				return fmt.Errorf("parsing ClientPeerID: %w", err)
+			}
+		}
+
+		head := func(ctx context.Context) (abi.ChainEpoch, error) {
+			if !cctx.IsSet("epoch") {
+				return 0, fmt.Errorf("the rules match on the deal start epoch: set the current epoch with --epoch")
+			}
+			return abi.ChainEpoch(cctx.Int64("epoch")), nil
+		}
+		f, err := dealfilter.NewRulesFilter(rulesPath, head)
+		if err != nil {
+			return err
+		}
+
+		d, err := f.Evaluate(cctx.Context, params)
+		if err != nil {
+			return err
+		}
+
+		rule := d.Rule
+		if rule == "" {
+			rule = "(no rule matched: default action)"
+		}
+		if d.Accept {
+			fmt.Printf("accept\nrule: %s\n", rule)
+		} else {
+			fmt.Printf("reject\nrule: %s\nreason: %s\n", rule, d.Reason)
+		}
+		return nil
+	},
+}
+
+// rulesPathFromRepo gets the path of the deal filter rules file from the
+// config in the boost repo
+func rulesPathFromRepo(cctx *cli.Context) (string, error) {
+	repoPath, err := homedir.Expand(cctx.String(FlagBoostRepo))
+	if err != nil {
+		return "", fmt.Errorf("expanding repo path: %w", err)
+	}
+
+	c, err := config.FromFile(filepath.Join(repoPath, "config.toml"), config.DefaultBoost())
+	if err != nil {
+		return "", fmt.Errorf("reading boost config: %w", err)
+	}
+	cfg, ok := c.(*config.Boost)
+	if !ok {
+		return "", fmt.Errorf("invalid config from repo, got: %T", c)
+	}
+
+	path := cfg.Dealmaking.FilterRulesFile
+	if path == "" {
+		return "", fmt.Errorf("no FilterRulesFile is configured in the boost repo at %s: specify the rules file with --rules", repoPath)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	return path, nil
+}
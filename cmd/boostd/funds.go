@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	bapi "github.com/filecoin-project/boost/api"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+var fundsCmd = &cli.Command{
+	Name:  "funds",
+	Usage: "Manage the funds used for deal making",
+	Subcommands: []*cli.Command{
+		fundsReconcileCmd,
+	},
+}
+
+var fundsReconcileCmd = &cli.Command{
+	Name:  "reconcile",
+	Usage: "Compare the funds tagged for deals with the balances on chain, and list tags for deals that are no longer active",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "release",
+			Usage: "release the funds tagged for deals that are no longer active",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		rep, err := napi.BoostFundsReconcile(ctx, cctx.Bool("release"))
+		if err != nil {
+			return err
+		}
+
+		printReconcileReport(rep)
+		return nil
+	},
+}
+
+func printReconcileReport(rep *bapi.FundsReconcileReport) {
+	fmt.Println("Escrow:")
+	fmt.Printf("  Available:         %s\n", fil(rep.EscrowAvailable))
+	fmt.Printf("  Locked:            %s\n", fil(rep.EscrowLocked))
+	fmt.Printf("  Tagged (active):   %s\n", fil(rep.CollateralTaggedActive))
+	fmt.Printf("  Tagged (orphaned): %s\n", fil(rep.CollateralTaggedOrphaned))
+	if !rep.CollateralShortfall.IsZero() {
+		fmt.Println(color.RedString("  Shortfall:         %s", fil(rep.CollateralShortfall)))
+	}
+
+	for _, w := range rep.PubMsgWallets {
+		fmt.Printf("Publish message wallet %s:\n", w.Address)
+		fmt.Printf("  Balance:           %s\n", fil(w.Balance))
+		fmt.Printf("  Tagged (active):   %s\n", fil(w.TaggedActive))
+		fmt.Printf("  Tagged (orphaned): %s\n", fil(w.TaggedOrphaned))
+		if !w.Shortfall.IsZero() {
+			fmt.Println(color.RedString("  Shortfall:         %s", fil(w.Shortfall)))
+		}
+	}
+
+	fmt.Println()
+	if len(rep.Orphaned) == 0 {
+		fmt.Println("No orphaned tags")
+		return
+	}
+
+	if rep.Released {
+		fmt.Printf("Released %d orphaned tags:\n", len(rep.Orphaned))
+	} else {
+		fmt.Printf("%d orphaned tags (use --release to release them):\n", len(rep.Orphaned))
+	}
+	tw := tablewriter.New(
+		tablewriter.Col("Deal"),
+		tablewriter.Col("Created"),
+		tablewriter.Col("Collateral"),
+		tablewriter.Col("PubMsg"),
+		tablewriter.Col("Reason"),
+	)
+	for _, o := range rep.Orphaned {
+		tw.Write(map[string]interface{}{
+			"Deal":       o.DealUUID,
+			"Created":    o.CreatedAt.Format("2006-01-02 15:04:05"),
+			"Collateral": fil(o.Collateral),
+			"PubMsg":     fil(o.PubMsg),
+			"Reason":     o.Reason,
+		})
+	}
+	_ = tw.Flush(os.Stdout)
+}
+
+func fil(amt abi.TokenAmount) string {
+	return types.FIL(amt).Short()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	bapi "github.com/filecoin-project/boost/api"
+	bcli "github.com/filecoin-project/boost/cli"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+var importDataCmd = &cli.Command{
+	Name:  "import-data",
+	Usage: "Import the data for several offline deals made with Boost",
+	Description: "Either list the files to import in a manifest, or have boost calculate the commP of every file " +
+		"in a directory and match each file with the offline deal for that piece.\n\n" +
+		"A CSV manifest has one row per file: the deal uuid or piece CID, followed by the file path on the boost node.\n" +
+		"A JSON manifest (.json) is a list of objects with the fields \"DealUUID\" or \"PieceCID\", and \"FilePath\".\n" +
+		"The manifest is read by the boost node, and relative file paths are resolved relative to the directory that contains the manifest.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "path of a CSV or JSON file on the boost node that matches each file with a deal",
+		},
+		&cli.StringFlag{
+			Name:  "auto-match",
+			Usage: "path of a directory on the boost node: the commP of each file in the directory is calculated and the file is imported for the matching offline deal",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Usage: "the number of files to calculate commP for at the same time when auto-matching",
+			Value: 4,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+
+		manifest := cctx.String("manifest")
+		autoMatch := cctx.String("auto-match")
+		if (manifest == "") == (autoMatch == "") {
+			return fmt.Errorf("must specify exactly one of --manifest or --auto-match")
+		}
+
+		if manifest != "" {
+			// The manifest is read by the boost node, which doesn't share
+			// the working directory of this command
+			var err error
+			manifest, err = filepath.Abs(manifest)
+			if err != nil {
+				return fmt.Errorf("getting absolute path of manifest: %w", err)
+			}
+		}
+
+		params := bapi.OfflineDealsImportParams{
+			ManifestPath:   manifest,
+			AutoMatchDir:   autoMatch,
+			MaxConcurrency: cctx.Int("parallel"),
+		}
+
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ch, err := napi.BoostOfflineDealsImport(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		imported := 0
+		failed := 0
+		for {
+			select {
+			case evt, ok := <-ch:
+				if !ok {
+					fmt.Printf("\nImported data for %d offline deals, %d failed\n", imported, failed)
+					if failed > 0 {
+						return fmt.Errorf("failed to import %d files", failed)
+					}
+					return nil
+				}
+
+				if evt.Event == "error" {
+					return fmt.Errorf("import failed: %s", evt.Error)
+				}
+
+				_, _ = fmt.Fprint(os.Stdout, color.New(color.BgHiBlack).Sprintf("(%d/%d)", evt.Current, evt.Total))
+				_, _ = fmt.Fprint(os.Stdout, " ")
+				if evt.Event == "commp" {
+					if evt.Success {
+						_, _ = fmt.Fprintln(os.Stdout, evt.FilePath, color.New(color.Reset).Sprint("COMMP"), evt.PieceCID)
+					} else {
+						failed++
+						_, _ = fmt.Fprintln(os.Stdout, evt.FilePath, color.New(color.FgRed).Sprint("COMMP ERROR"), evt.Error)
+					}
+					continue
+				}
+
+				if evt.Success {
+					imported++
+					_, _ = fmt.Fprintln(os.Stdout, evt.FilePath, color.New(color.FgGreen).Sprint("IMPORTED"), evt.DealUUID)
+				} else {
+					failed++
+					_, _ = fmt.Fprintln(os.Stdout, evt.FilePath, color.New(color.FgRed).Sprint("ERROR"), evt.Error)
+				}
+
+			case <-ctx.Done():
+				return fmt.Errorf("aborted")
+			}
+		}
+	},
+}
@@ -515,26 +515,27 @@ func addMinerAddressToDatastore(ds datastore.Batching, minerActor address.Addres
 func boostDealMakingCfg(mktsCfg *lotus_config.StorageMiner) config.DealmakingConfig {
 	ldm := mktsCfg.Dealmaking
 	return config.DealmakingConfig{
-		ConsiderOnlineStorageDeals:        ldm.ConsiderOnlineStorageDeals,
-		ConsiderOfflineStorageDeals:       ldm.ConsiderOfflineStorageDeals,
-		ConsiderOnlineRetrievalDeals:      ldm.ConsiderOnlineRetrievalDeals,
-		ConsiderOfflineRetrievalDeals:     ldm.ConsiderOfflineRetrievalDeals,
-		ConsiderVerifiedStorageDeals:      ldm.ConsiderVerifiedStorageDeals,
-		ConsiderUnverifiedStorageDeals:    ldm.ConsiderUnverifiedStorageDeals,
-		PieceCidBlocklist:                 ldm.PieceCidBlocklist,
-		ExpectedSealDuration:              config.Duration(ldm.ExpectedSealDuration),
-		MaxDealStartDelay:                 config.Duration(ldm.MaxDealStartDelay),
-		PublishMsgPeriod:                  config.Duration(ldm.PublishMsgPeriod),
-		PublishMsgMaxDealsPerMsg:          ldm.MaxDealsPerPublishMsg,
-		PublishMsgMaxFee:                  mktsCfg.Fees.MaxPublishDealsFee,
-		MaxProviderCollateralMultiplier:   ldm.MaxProviderCollateralMultiplier,
-		MaxStagingDealsBytes:              ldm.MaxStagingDealsBytes,
-		SimultaneousTransfersForStorage:   ldm.SimultaneousTransfersForStorage,
-		SimultaneousTransfersForRetrieval: ldm.SimultaneousTransfersForRetrieval,
-		StartEpochSealingBuffer:           ldm.StartEpochSealingBuffer,
-		Filter:                            ldm.Filter,
-		RetrievalFilter:                   ldm.RetrievalFilter,
-		RetrievalPricing:                  ldm.RetrievalPricing,
+		ConsiderOnlineStorageDeals:               ldm.ConsiderOnlineStorageDeals,
+		ConsiderOfflineStorageDeals:              ldm.ConsiderOfflineStorageDeals,
+		ConsiderOnlineRetrievalDeals:             ldm.ConsiderOnlineRetrievalDeals,
+		ConsiderOfflineRetrievalDeals:            ldm.ConsiderOfflineRetrievalDeals,
+		ConsiderVerifiedStorageDeals:             ldm.ConsiderVerifiedStorageDeals,
+		ConsiderUnverifiedStorageDeals:           ldm.ConsiderUnverifiedStorageDeals,
+		PieceCidBlocklist:                        ldm.PieceCidBlocklist,
+		ExpectedSealDuration:                     config.Duration(ldm.ExpectedSealDuration),
+		MaxDealStartDelay:                        config.Duration(ldm.MaxDealStartDelay),
+		PublishMsgPeriod:                         config.Duration(ldm.PublishMsgPeriod),
+		PublishMsgMaxDealsPerMsg:                 ldm.MaxDealsPerPublishMsg,
+		PublishMsgMaxFee:                         mktsCfg.Fees.MaxPublishDealsFee,
+		MaxProviderCollateralMultiplier:          ldm.MaxProviderCollateralMultiplier,
+		MaxStagingDealsBytes:                     ldm.MaxStagingDealsBytes,
+		SimultaneousTransfersForStorage:          ldm.SimultaneousTransfersForStorage,
+		SimultaneousTransfersForStoragePerClient: ldm.SimultaneousTransfersForStoragePerClient,
+		SimultaneousTransfersForRetrieval:        ldm.SimultaneousTransfersForRetrieval,
+		StartEpochSealingBuffer:                  ldm.StartEpochSealingBuffer,
+		Filter:                                   ldm.Filter,
+		RetrievalFilter:                          ldm.RetrievalFilter,
+		RetrievalPricing:                         ldm.RetrievalPricing,
 	}
 }
 
@@ -42,8 +42,13 @@ func main() {
 			retrievalDealsCmd,
 			indexProvCmd,
 			offlineDealCmd,
+			importDataCmd,
+			dealsCmd,
 			logCmd,
 			dagstoreCmd,
+			filterCmd,
+			fundsCmd,
+			storageCmd,
 		},
 	}
 	app.Setup()
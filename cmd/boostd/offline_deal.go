@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/filecoin-project/boost/api"
 	bcli "github.com/filecoin-project/boost/cli"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
@@ -22,6 +24,11 @@ var offlineDealCmd = &cli.Command{
 			Usage:    "path of the file containing the offline deal data",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name: "stream-from",
+			Usage: "stream the deal data from this local file to boost, which writes it to a new file at --filepath " +
+				"and calculates commP as the data arrives",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		napi, closer, err := bcli.GetBoostAPI(cctx)
@@ -37,7 +44,18 @@ var offlineDealCmd = &cli.Command{
 		if err != nil {
 			return fmt.Errorf("failed to parse deal uuid '%s'", id)
 		}
-		rej, err := napi.BoostOfflineDealWithData(cctx.Context, dealUuid, filePath)
+		var rej *api.ProviderDealRejectionInfo
+		if streamFrom := cctx.String("stream-from"); streamFrom != "" {
+			f, ferr := os.Open(streamFrom)
+			if ferr != nil {
+				return fmt.Errorf("failed to open '%s': %w", streamFrom, ferr)
+			}
+			defer f.Close() //nolint:errcheck
+
+			rej, err = napi.BoostOfflineDealWithDataStream(cctx.Context, dealUuid, filePath, f)
+		} else {
+			rej, err = napi.BoostOfflineDealWithData(cctx.Context, dealUuid, filePath)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to execute offline deal: %w", err)
 		}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	bapi "github.com/filecoin-project/boost/api"
+	bcli "github.com/filecoin-project/boost/cli"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+var storageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "Manage the staging areas that deal data is downloaded to",
+	Subcommands: []*cli.Command{
+		storageGCCmd,
+	},
+}
+
+var storageGCCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "Delete files in the staging areas that are not used by any deal",
+	Description: "Files in the staging areas that are not used by any active deal are deleted, " +
+		"once they are older than the grace period set by Dealmaking.StagingGCGracePeriod in the config.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "list the orphaned files without deleting them",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		rep, err := napi.BoostStagingGC(ctx, cctx.Bool("dry-run"))
+		if err != nil {
+			return err
+		}
+
+		printStagingGCReport(rep)
+		return nil
+	},
+}
+
+func printStagingGCReport(rep *bapi.StagingGCReport) {
+	if len(rep.Retained) > 0 {
+		tw := tablewriter.New(
+			tablewriter.Col("Path"),
+			tablewriter.Col("Size"),
+			tablewriter.Col("Kept Until"),
+		)
+		for _, r := range rep.Retained {
+			tw.Write(map[string]interface{}{
+				"Path":       r.Path,
+				"Size":       humanize.IBytes(r.Size),
+				"Kept Until": r.Until.Format("2006-01-02 15:04:05"),
+			})
+		}
+		fmt.Println("Files kept so that failed deals can be retried:")
+		_ = tw.Flush(os.Stdout)
+		fmt.Printf("Retained: %d files, %s\n\n", len(rep.Retained), humanize.IBytes(rep.RetainedBytes))
+	}
+
+	if len(rep.Orphaned) == 0 {
+		fmt.Println("No orphaned files in the staging areas")
+		return
+	}
+
+	tw := tablewriter.New(
+		tablewriter.Col("Path"),
+		tablewriter.Col("Size"),
+		tablewriter.Col("Modified"),
+		tablewriter.Col("Status"),
+	)
+	for _, o := range rep.Orphaned {
+		status := "reclaimable"
+		switch {
+		case o.Deleted:
+			status = "deleted"
+		case o.InGracePeriod:
+			status = "in grace period"
+		}
+		tw.Write(map[string]interface{}{
+			"Path":     o.Path,
+			"Size":     humanize.IBytes(o.Size),
+			"Modified": o.ModTime.Format("2006-01-02 15:04:05"),
+			"Status":   status,
+		})
+	}
+	_ = tw.Flush(os.Stdout)
+
+	fmt.Println()
+	fmt.Printf("Orphaned files: %d\n", len(rep.Orphaned))
+	fmt.Printf("Deleted:        %s\n", humanize.IBytes(rep.DeletedBytes))
+	fmt.Printf("Reclaimable:    %s\n", humanize.IBytes(rep.ReclaimableBytes))
+	if rep.DryRun && rep.ReclaimableBytes > 0 {
+		fmt.Println("Dry run: run without --dry-run to delete the reclaimable files")
+	}
+}
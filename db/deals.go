@@ -4,16 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 	"github.com/google/uuid"
 	"github.com/graph-gophers/graphql-go"
 	"github.com/ipfs/go-cid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Used for SELECT statements: "ID, CreatedAt, ..."
@@ -77,6 +80,7 @@ func newDealAccessor(db *sql.DB, deal *types.ProviderDealState) *dealAccessor {
 			"Checkpoint":            &ckptFieldDef{f: &deal.Checkpoint},
 			"CheckpointAt":          &fieldDef{f: &deal.CheckpointAt},
 			"Error":                 &fieldDef{f: &deal.Err},
+			"ErrCheckpoint":         &ckptFieldDef{f: &deal.ErrCheckpoint},
 			// Needed so the deal can be looked up by signed proposal cid
 			"SignedProposalCID": &signedPropFieldDef{prop: deal.ClientDealProposal},
 		},
@@ -224,12 +228,201 @@ func (d *DealsDB) Count(ctx context.Context) (int, error) {
 	return count, err
 }
 
+// activeCheckpoints are the checkpoints of deals that have not yet been
+// handed off to the sealer
+var activeCheckpoints = []dealcheckpoints.Checkpoint{
+	dealcheckpoints.Accepted,
+	dealcheckpoints.Transferred,
+	dealcheckpoints.Published,
+	dealcheckpoints.PublishConfirmed,
+	dealcheckpoints.AddedPiece,
+}
+
+// ListActive lists all deals that have not yet been handed off to the sealer
 func (d *DealsDB) ListActive(ctx context.Context) ([]*types.ProviderDealState, error) {
-	return d.list(ctx, 0, 0, "Checkpoint != ?", dealcheckpoints.Complete.String())
+	return d.ListByCheckpoint(ctx, activeCheckpoints...)
+}
+
+// ListByCheckpoint lists all deals at any of the given checkpoints
+func (d *DealsDB) ListByCheckpoint(ctx context.Context, checkpoints ...dealcheckpoints.Checkpoint) ([]*types.ProviderDealState, error) {
+	if len(checkpoints) == 0 {
+		return nil, nil
+	}
+
+	where, args := checkpointIn(checkpoints)
+	return d.list(ctx, 0, 0, where, args...)
+}
+
+// checkpointIn returns a where clause that matches any of the given
+// checkpoints, and its arguments
+func checkpointIn(checkpoints []dealcheckpoints.Checkpoint) (string, []interface{}) {
+	args := make([]interface{}, 0, len(checkpoints))
+	for _, cp := range checkpoints {
+		args = append(args, cp.String())
+	}
+	return "Checkpoint IN (?" + strings.Repeat(", ?", len(checkpoints)-1) + ")", args
+}
+
+// CountActive counts the deals that have not yet been handed off to the
+// sealer, by client and by checkpoint
+func (d *DealsDB) CountActive(ctx context.Context) (*types.ActiveDealCounts, error) {
+	where, args := checkpointIn(activeCheckpoints)
+	qry := "SELECT ClientAddress, Checkpoint, count(*) FROM Deals WHERE " + where + " GROUP BY ClientAddress, Checkpoint"
+	rows, err := d.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, xerrors.Errorf("counting active deals: %w", err)
+	}
+	defer rows.Close()
+
+	counts := &types.ActiveDealCounts{
+		ByClient:     make(map[string]int),
+		ByCheckpoint: make(map[string]int),
+	}
+	for rows.Next() {
+		var clientBytes []byte
+		var checkpoint string
+		var count int
+		if err := rows.Scan(&clientBytes, &checkpoint, &count); err != nil {
+			return nil, xerrors.Errorf("scanning active deal count: %w", err)
+		}
+
+		client, err := address.NewFromBytes(clientBytes)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing client address: %w", err)
+		}
+
+		counts.Total += count
+		counts.ByClient[client.String()] += count
+		counts.ByCheckpoint[checkpoint] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("counting active deals: %w", err)
+	}
+
+	return counts, nil
+}
+
+// ClientUsage is the use of the provider's resources by a client address or
+// peer
+type ClientUsage struct {
+	// The number of deals that have not yet been handed off to the sealer
+	ActiveDeals int
+	// The total piece size of the deals created since a given time (offline
+	// deals have no transfer size)
+	PieceBytes uint64
+}
+
+// ClientUsage gets the usage of the given client address and of the given
+// peer, counting the piece size of deals created since the given time
+func (d *DealsDB) ClientUsage(ctx context.Context, since time.Time, client address.Address, peerID peer.ID) (*ClientUsage, *ClientUsage, error) {
+	byClient, byPeer, err := d.clientUsage(ctx, since, "(ClientAddress = ? OR ClientPeerID = ?)", client.Bytes(), string(peerID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientUsage := &ClientUsage{}
+	if u, ok := byClient[client.String()]; ok {
+		clientUsage = u
+	}
+	peerUsage := &ClientUsage{}
+	if u, ok := byPeer[peerID.String()]; ok {
+		peerUsage = u
+	}
+	return clientUsage, peerUsage, nil
 }
 
+// AllClientUsage gets the usage of all client addresses and peers with active
+// deals or with deals created since the given time
+func (d *DealsDB) AllClientUsage(ctx context.Context, since time.Time) (map[string]*ClientUsage, map[string]*ClientUsage, error) {
+	return d.clientUsage(ctx, since, "")
+}
+
+func (d *DealsDB) clientUsage(ctx context.Context, since time.Time, whereClause string, whereArgs ...interface{}) (map[string]*ClientUsage, map[string]*ClientUsage, error) {
+	activeWhere, args := checkpointIn(activeCheckpoints)
+	qry := "SELECT ClientAddress, ClientPeerID, Checkpoint, PieceSize, CreatedAt FROM Deals " +
+		"WHERE (" + activeWhere + " OR CreatedAt >= ?)"
+	args = append(args, since.Format(sqlite3.SQLiteTimestampFormats[0]))
+	if whereClause != "" {
+		qry += " AND " + whereClause
+		args = append(args, whereArgs...)
+	}
+
+	rows, err := d.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting client usage: %w", err)
+	}
+	defer rows.Close()
+
+	byClient := make(map[string]*ClientUsage)
+	byPeer := make(map[string]*ClientUsage)
+	add := func(m map[string]*ClientUsage, key string, active bool, pieceBytes uint64) {
+		u, ok := m[key]
+		if !ok {
+			u = &ClientUsage{}
+			m[key] = u
+		}
+		if active {
+			u.ActiveDeals++
+		}
+		u.PieceBytes += pieceBytes
+	}
+
+	for rows.Next() {
+		var clientBytes []byte
+		var peerID string
+		var checkpoint string
+		var pieceSize uint64
+		var createdAt time.Time
+		if err := rows.Scan(&clientBytes, &peerID, &checkpoint, &pieceSize, &createdAt); err != nil {
+			return nil, nil, xerrors.Errorf("scanning client usage: %w", err)
+		}
+
+		client, err := address.NewFromBytes(clientBytes)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("parsing client address: %w", err)
+		}
+
+		cp, err := dealcheckpoints.FromString(checkpoint)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("parsing checkpoint: %w", err)
+		}
+		active := cp < dealcheckpoints.IndexedAndAnnounced
+		var pieceBytes uint64
+		if !createdAt.Before(since) {
+			pieceBytes = pieceSize
+		}
+
+		add(byClient, client.String(), active, pieceBytes)
+		if peerID != "" {
+			add(byPeer, peer.ID(peerID).String(), active, pieceBytes)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, xerrors.Errorf("getting client usage: %w", err)
+	}
+
+	return byClient, byPeer, nil
+}
+
+// ListRetryable lists the deals that failed after they were published but
+// before the deal data was added to a sector, and that failed since the
+// given time
+func (d *DealsDB) ListRetryable(ctx context.Context, failedSince time.Time) ([]*types.ProviderDealState, error) {
+	return d.list(ctx, 0, 0, "Checkpoint = ? AND Error != '' AND ErrCheckpoint IN (?, ?) AND CheckpointAt >= ?",
+		dealcheckpoints.Complete.String(), dealcheckpoints.Published.String(), dealcheckpoints.PublishConfirmed.String(),
+		failedSince.Format(sqlite3.SQLiteTimestampFormats[0]))
+}
+
+// ListCompleted lists all deals that have reached a terminal checkpoint
 func (d *DealsDB) ListCompleted(ctx context.Context) ([]*types.ProviderDealState, error) {
-	return d.list(ctx, 0, 0, "Checkpoint = ?", dealcheckpoints.Complete.String())
+	return d.list(ctx, 0, 0, "Checkpoint IN (?, ?, ?)",
+		dealcheckpoints.Complete.String(), dealcheckpoints.Expired.String(), dealcheckpoints.Slashed.String())
+}
+
+// ListOfflineImported lists all offline deals that have had their data
+// imported, whatever their checkpoint
+func (d *DealsDB) ListOfflineImported(ctx context.Context) ([]*types.ProviderDealState, error) {
+	return d.list(ctx, 0, 0, "IsOffline = ? AND InboundFilePath != ?", true, "")
 }
 
 func (d *DealsDB) List(ctx context.Context, cursor *graphql.ID, offset int, limit int) ([]*types.ProviderDealState, error) {
@@ -82,8 +82,11 @@ func TestDealsDB(t *testing.T) {
 
 	finished, err := GenerateDeals()
 	require.NoError(t, err)
-	for _, deal := range finished {
+	for i, deal := range finished {
 		deal.Checkpoint = dealcheckpoints.Complete
+		if i == 0 {
+			deal.Checkpoint = dealcheckpoints.Slashed
+		}
 		err = db.Insert(ctx, &deal)
 		req.NoError(err)
 	}
@@ -91,4 +94,108 @@ func TestDealsDB(t *testing.T) {
 	fds, err := db.ListCompleted(ctx)
 	req.NoError(err)
 	req.Len(fds, len(finished))
+
+	ads, err := db.ListActive(ctx)
+	req.NoError(err)
+	req.Len(ads, len(deals))
+
+	sds, err := db.ListByCheckpoint(ctx, dealcheckpoints.Slashed, dealcheckpoints.Published)
+	req.NoError(err)
+	req.Len(sds, 2)
+
+	counts, err := db.CountActive(ctx)
+	req.NoError(err)
+	req.Equal(len(deals), counts.Total)
+	// The first and last generated deals have the same client
+	req.Equal(2, counts.ByClient[deals[0].ClientDealProposal.Proposal.Client.String()])
+	req.Equal(1, counts.ByClient[deals[1].ClientDealProposal.Proposal.Client.String()])
+	req.Equal(len(deals)-1, counts.ByCheckpoint[dealcheckpoints.Accepted.String()])
+	req.Equal(1, counts.ByCheckpoint[dealcheckpoints.Published.String()])
+
+	// Completed deals don't count as active, but do count towards the
+	// piece bytes
+	client := deals[0].ClientDealProposal.Proposal.Client
+	clientUsage, peerUsage, err := db.ClientUsage(ctx, time.Now().Add(-time.Hour), client, deals[0].ClientPeerID)
+	req.NoError(err)
+	req.Equal(2, clientUsage.ActiveDeals)
+	pieceSize := func(ds ...types.ProviderDealState) uint64 {
+		total := uint64(0)
+		for _, d := range ds {
+			total += uint64(d.ClientDealProposal.Proposal.PieceSize)
+		}
+		return total
+	}
+	req.Equal(pieceSize(deals[0], deals[4], finished[0], finished[4]), clientUsage.PieceBytes)
+	req.Equal(1, peerUsage.ActiveDeals)
+	req.Equal(pieceSize(deals[0], finished[0]), peerUsage.PieceBytes)
+
+	// Deals created before the given time don't count towards the piece
+	// bytes
+	clientUsage, _, err = db.ClientUsage(ctx, time.Now().Add(time.Hour), client, deals[0].ClientPeerID)
+	req.NoError(err)
+	req.Equal(2, clientUsage.ActiveDeals)
+	req.Zero(clientUsage.PieceBytes)
+
+	byClient, byPeer, err := db.AllClientUsage(ctx, time.Now().Add(-time.Hour))
+	req.NoError(err)
+	req.Len(byClient, 4)
+	req.Len(byPeer, len(deals)+len(finished))
+
+	// Deals that have been handed off to the sealer don't count as active
+	sealed, err := GenerateDeals()
+	require.NoError(t, err)
+	sealedDeal := sealed[0]
+	sealedDeal.Checkpoint = dealcheckpoints.Active
+	sealedDeal.ClientDealProposal.Proposal.Client = client
+	err = db.Insert(ctx, &sealedDeal)
+	req.NoError(err)
+
+	ads, err = db.ListActive(ctx)
+	req.NoError(err)
+	req.Len(ads, len(deals))
+
+	counts, err = db.CountActive(ctx)
+	req.NoError(err)
+	req.Equal(len(deals), counts.Total)
+
+	clientUsage, _, err = db.ClientUsage(ctx, time.Now().Add(-time.Hour), client, deals[0].ClientPeerID)
+	req.NoError(err)
+	req.Equal(2, clientUsage.ActiveDeals)
+
+	// Deals that failed after they were published can be retried
+	failed := sealed[1]
+	failed.Checkpoint = dealcheckpoints.Complete
+	failed.CheckpointAt = time.Now()
+	failed.ErrCheckpoint = dealcheckpoints.PublishConfirmed
+	failed.Err = "add piece failed"
+	err = db.Insert(ctx, &failed)
+	req.NoError(err)
+
+	rds, err := db.ListRetryable(ctx, time.Now().Add(-time.Hour))
+	req.NoError(err)
+	req.Len(rds, 1)
+	req.Equal(failed.DealUuid, rds[0].DealUuid)
+
+	rds, err = db.ListRetryable(ctx, time.Now().Add(time.Hour))
+	req.NoError(err)
+	req.Empty(rds)
+
+	// Offline deals that have had their data imported are listed whatever
+	// their checkpoint
+	notImported := sealed[2]
+	notImported.InboundFilePath = ""
+	err = db.Insert(ctx, &notImported)
+	req.NoError(err)
+
+	imported, err := db.ListOfflineImported(ctx)
+	req.NoError(err)
+	req.Len(imported, len(deals)+len(finished)+2)
+	foundSealed := false
+	for _, dl := range imported {
+		req.NotEqual(notImported.DealUuid, dl.DealUuid)
+		if dl.DealUuid == sealedDeal.DealUuid {
+			foundSealed = true
+		}
+	}
+	req.True(foundSealed)
 }
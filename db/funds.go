@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	"github.com/mattn/go-sqlite3"
 )
 
@@ -27,10 +29,12 @@ func NewFundsDB(db *sql.DB) *FundsDB {
 	return &FundsDB{db: db}
 }
 
-func (f *FundsDB) Tag(ctx context.Context, dealUuid uuid.UUID, collateral abi.TokenAmount, pubMsg abi.TokenAmount) error {
-	qry := "INSERT INTO FundsTagged (DealUUID, CreatedAt, Collateral, PubMsg) "
-	qry += "VALUES (?, ?, ?, ?)"
-	values := []interface{}{dealUuid, time.Now(), collateral.String(), pubMsg.String()}
+// Tag tags collateral for the deal, and funds in the pubMsgWallet for the
+// publish storage deals message
+func (f *FundsDB) Tag(ctx context.Context, dealUuid uuid.UUID, collateral abi.TokenAmount, pubMsg abi.TokenAmount, pubMsgWallet address.Address) error {
+	qry := "INSERT INTO FundsTagged (DealUUID, CreatedAt, Collateral, PubMsg, PubMsgWallet) "
+	qry += "VALUES (?, ?, ?, ?, ?)"
+	values := []interface{}{dealUuid, time.Now(), collateral.String(), pubMsg.String(), pubMsgWallet.String()}
 	_, err := f.db.ExecContext(ctx, qry, values...)
 	return err
 }
@@ -61,6 +65,24 @@ func (f *FundsDB) Untag(ctx context.Context, dealUuid uuid.UUID) (clt abi.TokenA
 	return *collat.f, *pubMsg.f, err
 }
 
+// TaggedPubMsgWallet returns the wallet in which funds for the publish
+// storage deals message were tagged for the deal. It returns the empty
+// string if the funds were tagged before wallets were recorded.
+func (f *FundsDB) TaggedPubMsgWallet(ctx context.Context, dealUuid uuid.UUID) (string, error) {
+	qry := "SELECT PubMsgWallet FROM FundsTagged WHERE DealUUID = ?"
+	row := f.db.QueryRowContext(ctx, qry, dealUuid)
+
+	var wallet sql.NullString
+	err := row.Scan(&wallet)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("getting tagged publish message wallet: %w", err)
+	}
+	return wallet.String, nil
+}
+
 func (f *FundsDB) InsertLog(ctx context.Context, logs ...*FundsLog) error {
 	now := time.Now()
 	for _, l := range logs {
@@ -140,27 +162,187 @@ func (f *FundsDB) LogsCount(ctx context.Context) (int, error) {
 	return count, err
 }
 
+// TopUpStatus is the state of a message that moves funds into escrow
+type TopUpStatus string
+
+const (
+	// The message is waiting to land on chain
+	TopUpPending TopUpStatus = "Pending"
+	// The message landed on chain and the funds were moved into escrow
+	TopUpConfirmed TopUpStatus = "Confirmed"
+	// The message landed on chain but failed
+	TopUpFailed TopUpStatus = "Failed"
+)
+
+// FundsTopUp is a message sent to move funds into escrow
+type FundsTopUp struct {
+	MsgCID    cid.Cid
+	CreatedAt time.Time
+	Amount    abi.TokenAmount
+	Status    TopUpStatus
+}
+
+// InsertTopUp records a message sent to move funds into escrow
+func (f *FundsDB) InsertTopUp(ctx context.Context, topUp *FundsTopUp) error {
+	if topUp.CreatedAt.IsZero() {
+		topUp.CreatedAt = time.Now()
+	}
+
+	qry := "INSERT INTO FundsTopUps (MsgCID, CreatedAt, Amount, Status) "
+	qry += "VALUES (?, ?, ?, ?)"
+	values := []interface{}{topUp.MsgCID.String(), topUp.CreatedAt, topUp.Amount.String(), string(topUp.Status)}
+	_, err := f.db.ExecContext(ctx, qry, values...)
+	if err != nil {
+		return fmt.Errorf("inserting funds top-up: %w", err)
+	}
+	return nil
+}
+
+// SetTopUpStatus sets the status of the top-up with the given message cid
+func (f *FundsDB) SetTopUpStatus(ctx context.Context, msgCid cid.Cid, status TopUpStatus) error {
+	_, err := f.db.ExecContext(ctx, "UPDATE FundsTopUps SET Status = ? WHERE MsgCID = ?", string(status), msgCid.String())
+	if err != nil {
+		return fmt.Errorf("setting funds top-up status: %w", err)
+	}
+	return nil
+}
+
+// LatestTopUp returns the most recent top-up, or ErrNotFound if there has
+// never been a top-up
+func (f *FundsDB) LatestTopUp(ctx context.Context) (*FundsTopUp, error) {
+	qry := "SELECT MsgCID, CreatedAt, Amount, Status FROM FundsTopUps ORDER BY CreatedAt DESC, RowID DESC LIMIT 1"
+	row := f.db.QueryRowContext(ctx, qry)
+
+	var topUp FundsTopUp
+	msgCid := &cidFieldDef{f: &topUp.MsgCID}
+	amt := &bigIntFieldDef{f: &topUp.Amount}
+	var status string
+	err := row.Scan(&msgCid.cidStr, &topUp.CreatedAt, &amt.marshalled, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting latest funds top-up: %w", err)
+	}
+	if err := msgCid.unmarshall(); err != nil {
+		return nil, fmt.Errorf("unmarshalling funds top-up MsgCID: %w", err)
+	}
+	if err := amt.unmarshall(); err != nil {
+		return nil, fmt.Errorf("unmarshalling funds top-up Amount: %w", err)
+	}
+	topUp.Status = TopUpStatus(status)
+
+	return &topUp, nil
+}
+
+// TopUpsTotal returns the sum of the amounts of the top-ups with the given
+// status that were created at or after since
+func (f *FundsDB) TopUpsTotal(ctx context.Context, status TopUpStatus, since time.Time) (abi.TokenAmount, error) {
+	qry := "SELECT Amount FROM FundsTopUps WHERE Status = ? AND CreatedAt >= ?"
+	rows, err := f.db.QueryContext(ctx, qry, string(status), since.Format(sqlite3.SQLiteTimestampFormats[0]))
+	if err != nil {
+		return abi.NewTokenAmount(0), fmt.Errorf("getting funds top-ups total: %w", err)
+	}
+	defer rows.Close()
+
+	total := abi.NewTokenAmount(0)
+	for rows.Next() {
+		amt := &bigIntFieldDef{f: new(abi.TokenAmount)}
+		if err := rows.Scan(&amt.marshalled); err != nil {
+			return abi.NewTokenAmount(0), fmt.Errorf("getting funds top-up amount: %w", err)
+		}
+		if err := amt.unmarshall(); err != nil {
+			return abi.NewTokenAmount(0), fmt.Errorf("unmarshalling funds top-up Amount: %w", err)
+		}
+		if amt.f.Int != nil {
+			total = big.Add(total, *amt.f)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return abi.NewTokenAmount(0), fmt.Errorf("getting funds top-ups total: %w", err)
+	}
+
+	return total, nil
+}
+
+// FundsTag is the funds tagged for a deal
+type FundsTag struct {
+	DealUUID   uuid.UUID
+	CreatedAt  time.Time
+	Collateral abi.TokenAmount
+	PubMsg     abi.TokenAmount
+	// The wallet that funds for the publish storage deals message were
+	// tagged in (undefined for funds tagged before wallets were recorded)
+	PubMsgWallet address.Address
+}
+
+// ListTagged lists the funds tagged for each deal, oldest first
+func (f *FundsDB) ListTagged(ctx context.Context) ([]FundsTag, error) {
+	qry := "SELECT DealUUID, CreatedAt, Collateral, PubMsg, PubMsgWallet FROM FundsTagged ORDER BY CreatedAt, RowID"
+	rows, err := f.db.QueryContext(ctx, qry)
+	if err != nil {
+		return nil, fmt.Errorf("listing tagged funds: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make([]FundsTag, 0, 16)
+	for rows.Next() {
+		var tag FundsTag
+		collat := &bigIntFieldDef{f: &tag.Collateral}
+		pubMsg := &bigIntFieldDef{f: &tag.PubMsg}
+		var wallet sql.NullString
+		err := rows.Scan(&tag.DealUUID, &tag.CreatedAt, &collat.marshalled, &pubMsg.marshalled, &wallet)
+		if err != nil {
+			return nil, fmt.Errorf("getting tagged funds: %w", err)
+		}
+		if err := collat.unmarshall(); err != nil {
+			return nil, fmt.Errorf("unmarshalling tagged Collateral: %w", err)
+		}
+		if err := pubMsg.unmarshall(); err != nil {
+			return nil, fmt.Errorf("unmarshalling tagged PubMsg: %w", err)
+		}
+		if wallet.Valid && wallet.String != "" {
+			tag.PubMsgWallet, err = address.NewFromString(wallet.String)
+			if err != nil {
+				return nil, fmt.Errorf("parsing tagged PubMsgWallet %s: %w", wallet.String, err)
+			}
+		}
+
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
 type TotalTagged struct {
 	Collateral abi.TokenAmount
 	PubMsg     abi.TokenAmount
+	// The funds tagged for the publish storage deals message in each wallet.
+	// Funds that were tagged before wallets were recorded have the key "".
+	PubMsgByWallet map[string]abi.TokenAmount
 }
 
 func (f *FundsDB) TotalTagged(ctx context.Context) (*TotalTagged, error) {
-	rows, err := f.db.QueryContext(ctx, "SELECT Collateral, PubMsg FROM FundsTagged")
+	rows, err := f.db.QueryContext(ctx, "SELECT Collateral, PubMsg, PubMsgWallet FROM FundsTagged")
 	if err != nil {
 		return nil, fmt.Errorf("getting total tagged: %w", err)
 	}
 	defer rows.Close()
 
 	tt := &TotalTagged{
-		Collateral: abi.NewTokenAmount(0),
-		PubMsg:     abi.NewTokenAmount(0),
+		Collateral:     abi.NewTokenAmount(0),
+		PubMsg:         abi.NewTokenAmount(0),
+		PubMsgByWallet: make(map[string]abi.TokenAmount),
 	}
 
 	for rows.Next() {
 		collat := &bigIntFieldDef{f: new(abi.TokenAmount)}
 		pubMsg := &bigIntFieldDef{f: new(abi.TokenAmount)}
-		err := rows.Scan(&collat.marshalled, &pubMsg.marshalled)
+		var wallet sql.NullString
+		err := rows.Scan(&collat.marshalled, &pubMsg.marshalled, &wallet)
 		if err != nil {
 			return nil, fmt.Errorf("getting total tagged: %w", err)
 		}
@@ -179,6 +361,12 @@ func (f *FundsDB) TotalTagged(ctx context.Context) (*TotalTagged, error) {
 		}
 		if pubMsg.f.Int != nil {
 			tt.PubMsg = big.Add(tt.PubMsg, *pubMsg.f)
+
+			walletTagged, ok := tt.PubMsgByWallet[wallet.String]
+			if !ok {
+				walletTagged = abi.NewTokenAmount(0)
+			}
+			tt.PubMsgByWallet[wallet.String] = big.Add(walletTagged, *pubMsg.f)
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -7,8 +7,11 @@ import (
 
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 
 	"github.com/stretchr/testify/require"
 )
@@ -19,6 +22,7 @@ func TestFundsDB(t *testing.T) {
 
 	sqldb := CreateTestTmpDB(t)
 	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, Migrate(sqldb))
 
 	db := NewFundsDB(sqldb)
 	tt, err := db.TotalTagged(ctx)
@@ -32,13 +36,39 @@ func TestFundsDB(t *testing.T) {
 	req.Equal(int64(0), collat.Int64())
 	req.True(pub.IsZero())
 
-	err = db.Tag(ctx, dealUUID, abi.NewTokenAmount(1111), abi.NewTokenAmount(2222))
+	err = db.Tag(ctx, dealUUID, abi.NewTokenAmount(1111), abi.NewTokenAmount(2222), address.TestAddress)
 	req.NoError(err)
 
+	dealUUID2 := uuid.New()
+	err = db.Tag(ctx, dealUUID2, abi.NewTokenAmount(1), abi.NewTokenAmount(2), address.TestAddress2)
+	req.NoError(err)
+
+	wallet, err := db.TaggedPubMsgWallet(ctx, dealUUID2)
+	req.NoError(err)
+	req.Equal(address.TestAddress2.String(), wallet)
+	_, err = db.TaggedPubMsgWallet(ctx, uuid.New())
+	req.True(xerrors.Is(err, ErrNotFound))
+
 	tt, err = db.TotalTagged(ctx)
 	req.NoError(err)
-	req.Equal(int64(1111), tt.Collateral.Int64())
-	req.Equal(int64(2222), tt.PubMsg.Int64())
+	req.Equal(int64(1112), tt.Collateral.Int64())
+	req.Equal(int64(2224), tt.PubMsg.Int64())
+	req.Len(tt.PubMsgByWallet, 2)
+	req.Equal(int64(2222), tt.PubMsgByWallet[address.TestAddress.String()].Int64())
+	req.Equal(int64(2), tt.PubMsgByWallet[address.TestAddress2.String()].Int64())
+
+	tags, err := db.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tags, 2)
+	req.Equal(dealUUID, tags[0].DealUUID)
+	req.Equal(int64(1111), tags[0].Collateral.Int64())
+	req.Equal(int64(2222), tags[0].PubMsg.Int64())
+	req.Equal(address.TestAddress, tags[0].PubMsgWallet)
+	req.Equal(dealUUID2, tags[1].DealUUID)
+	req.Equal(address.TestAddress2, tags[1].PubMsgWallet)
+
+	_, _, err = db.Untag(ctx, dealUUID2)
+	req.NoError(err)
 
 	collat, pub, err = db.Untag(ctx, dealUUID)
 	req.NoError(err)
@@ -106,4 +136,53 @@ func TestFundsDB(t *testing.T) {
 	req.NoError(err)
 	req.Len(logs, 1)
 	req.Equal(oldest.DealUUID, logs[0].DealUUID)
+
+}
+
+func TestFundsTopUpsDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, Migrate(sqldb))
+
+	db := NewFundsDB(sqldb)
+	_, err := db.LatestTopUp(ctx)
+	req.True(xerrors.Is(err, ErrNotFound))
+
+	start := time.Now().Add(-time.Minute)
+	msgs := []cid.Cid{testutil.GenerateCid(), testutil.GenerateCid(), testutil.GenerateCid()}
+	for i, msg := range msgs {
+		err = db.InsertTopUp(ctx, &FundsTopUp{
+			MsgCID: msg,
+			Amount: abi.NewTokenAmount(int64(100 * (i + 1))),
+			Status: TopUpPending,
+		})
+		req.NoError(err)
+	}
+
+	latest, err := db.LatestTopUp(ctx)
+	req.NoError(err)
+	req.Equal(msgs[2], latest.MsgCID)
+	req.EqualValues(300, latest.Amount.Int64())
+	req.Equal(TopUpPending, latest.Status)
+
+	req.NoError(db.SetTopUpStatus(ctx, msgs[0], TopUpConfirmed))
+	req.NoError(db.SetTopUpStatus(ctx, msgs[1], TopUpFailed))
+	req.NoError(db.SetTopUpStatus(ctx, msgs[2], TopUpConfirmed))
+
+	latest, err = db.LatestTopUp(ctx)
+	req.NoError(err)
+	req.Equal(TopUpConfirmed, latest.Status)
+
+	// Only top-ups with the given status should be included
+	total, err := db.TopUpsTotal(ctx, TopUpConfirmed, start)
+	req.NoError(err)
+	req.EqualValues(400, total.Int64())
+
+	// Top-ups created before since should not be included
+	total, err = db.TopUpsTotal(ctx, TopUpConfirmed, time.Now().Add(time.Hour))
+	req.NoError(err)
+	req.True(total.IsZero())
 }
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE Deals
+  ADD ErrCheckpoint TEXT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- The checkpoint that existing failed deals reached before they failed is not
+-- known (and their inbound files have already been removed), so mark them as
+-- failed at Complete, which means they cannot be retried
+UPDATE Deals SET ErrCheckpoint = 'Complete';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS OfflineDropFiles (
+    Path TEXT PRIMARY KEY,
+    Size INT,
+    ModTime INT,
+    PieceCID TEXT,
+    PieceSize INT,
+    Error TEXT
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// OfflineDropFile is the result of calculating the commP of a file in an
+// offline deal drop directory
+type OfflineDropFile struct {
+	Path string
+	// The size and modification time of the file when its commP was
+	// calculated
+	Size    int64
+	ModTime time.Time
+	// The commP of the file (undefined if it could not be calculated)
+	PieceCID  cid.Cid
+	PieceSize abi.PaddedPieceSize
+	// Why the commP could not be calculated
+	Error string
+}
+
+type OfflineDropDB struct {
+	db *sql.DB
+}
+
+func NewOfflineDropDB(db *sql.DB) *OfflineDropDB {
+	return &OfflineDropDB{db: db}
+}
+
+// Set saves the result of calculating the commP of the file, replacing any
+// previous result for the same path
+func (d *OfflineDropDB) Set(ctx context.Context, f *OfflineDropFile) error {
+	var pieceCid interface{}
+	if f.PieceCID.Defined() {
+		pieceCid = f.PieceCID.String()
+	}
+
+	qry := "INSERT OR REPLACE INTO OfflineDropFiles (Path, Size, ModTime, PieceCID, PieceSize, Error) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?)"
+	values := []interface{}{f.Path, f.Size, f.ModTime.UnixNano(), pieceCid, uint64(f.PieceSize), f.Error}
+	_, err := d.db.ExecContext(ctx, qry, values...)
+	if err != nil {
+		return fmt.Errorf("saving offline drop file %s: %w", f.Path, err)
+	}
+	return nil
+}
+
+// Get returns the saved result of calculating the commP of the file at
+// path, or ErrNotFound
+func (d *OfflineDropDB) Get(ctx context.Context, path string) (*OfflineDropFile, error) {
+	qry := "SELECT Path, Size, ModTime, PieceCID, PieceSize, Error FROM OfflineDropFiles WHERE Path = ?"
+	row := d.db.QueryRowContext(ctx, qry, path)
+
+	var f OfflineDropFile
+	var modTime int64
+	var pieceSize uint64
+	pieceCid := &cidFieldDef{f: &f.PieceCID}
+	err := row.Scan(&f.Path, &f.Size, &modTime, &pieceCid.cidStr, &pieceSize, &f.Error)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting offline drop file %s: %w", path, err)
+	}
+	if err := pieceCid.unmarshall(); err != nil {
+		return nil, fmt.Errorf("unmarshalling offline drop file PieceCID: %w", err)
+	}
+	f.ModTime = time.Unix(0, modTime)
+	f.PieceSize = abi.PaddedPieceSize(pieceSize)
+
+	return &f, nil
+}
+
+// Delete removes the saved result for the file at path
+func (d *OfflineDropDB) Delete(ctx context.Context, path string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM OfflineDropFiles WHERE Path = ?", path)
+	if err != nil {
+		return fmt.Errorf("deleting offline drop file %s: %w", path, err)
+	}
+	return nil
+}
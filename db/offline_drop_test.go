@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineDropDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, Migrate(sqldb))
+
+	db := NewOfflineDropDB(sqldb)
+
+	_, err := db.Get(ctx, "/drop/a.car")
+	req.True(xerrors.Is(err, ErrNotFound))
+
+	modTime := time.Now()
+	car := &OfflineDropFile{
+		Path:      "/drop/a.car",
+		Size:      1234,
+		ModTime:   modTime,
+		PieceCID:  testutil.GenerateCid(),
+		PieceSize: abi.PaddedPieceSize(2048),
+	}
+	req.NoError(db.Set(ctx, car))
+
+	notCar := &OfflineDropFile{
+		Path:    "/drop/b.txt",
+		Size:    5,
+		ModTime: modTime,
+		Error:   "calculating commP: not a car file",
+	}
+	req.NoError(db.Set(ctx, notCar))
+
+	f, err := db.Get(ctx, car.Path)
+	req.NoError(err)
+	req.Equal(car.Size, f.Size)
+	req.True(car.ModTime.Equal(f.ModTime))
+	req.Equal(car.PieceCID, f.PieceCID)
+	req.Equal(car.PieceSize, f.PieceSize)
+	req.Empty(f.Error)
+
+	f, err = db.Get(ctx, notCar.Path)
+	req.NoError(err)
+	req.Equal(cid.Undef, f.PieceCID)
+	req.Equal(notCar.Error, f.Error)
+
+	// Setting the file again should replace the previous result
+	car.Size = 4321
+	req.NoError(db.Set(ctx, car))
+	f, err = db.Get(ctx, car.Path)
+	req.NoError(err)
+	req.Equal(int64(4321), f.Size)
+
+	req.NoError(db.Delete(ctx, car.Path))
+	_, err = db.Get(ctx, car.Path)
+	req.True(xerrors.Is(err, ErrNotFound))
+	_, err = db.Get(ctx, notCar.Path)
+	req.NoError(err)
+}
@@ -25,14 +25,45 @@ func NewStorageDB(db *sql.DB) *StorageDB {
 	return &StorageDB{db: db}
 }
 
-func (s *StorageDB) Tag(ctx context.Context, dealUuid uuid.UUID, size uint64) error {
-	qry := "INSERT INTO StorageTagged (DealUUID, CreatedAt, TransferSize) "
-	qry += "VALUES (?, ?, ?)"
-	values := []interface{}{dealUuid, time.Now(), fmt.Sprintf("%d", size)}
+// Tag tags storage space for the deal in the staging area at stagingAreaPath
+func (s *StorageDB) Tag(ctx context.Context, dealUuid uuid.UUID, size uint64, stagingAreaPath string) error {
+	qry := "INSERT INTO StorageTagged (DealUUID, CreatedAt, TransferSize, StagingAreaPath) "
+	qry += "VALUES (?, ?, ?, ?)"
+	values := []interface{}{dealUuid, time.Now(), fmt.Sprintf("%d", size), stagingAreaPath}
 	_, err := s.db.ExecContext(ctx, qry, values...)
 	return err
 }
 
+// SetLegacyTaggedPath sets the staging area path of storage that was tagged
+// before staging areas were recorded. It returns the number of deals that
+// were updated.
+func (s *StorageDB) SetLegacyTaggedPath(ctx context.Context, stagingAreaPath string) (int64, error) {
+	qry := "UPDATE StorageTagged SET StagingAreaPath = ? WHERE StagingAreaPath IS NULL OR StagingAreaPath = ''"
+	res, err := s.db.ExecContext(ctx, qry, stagingAreaPath)
+	if err != nil {
+		return 0, fmt.Errorf("setting legacy tagged staging area path: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// TaggedPath returns the path of the staging area in which storage space is
+// tagged for the deal. Storage that was tagged before staging areas were
+// recorded has the path "" (until SetLegacyTaggedPath is called).
+func (s *StorageDB) TaggedPath(ctx context.Context, dealUuid uuid.UUID) (string, error) {
+	qry := "SELECT StagingAreaPath FROM StorageTagged WHERE DealUUID = ?"
+	row := s.db.QueryRowContext(ctx, qry, dealUuid)
+
+	var path sql.NullString
+	err := row.Scan(&path)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("getting tagged staging area path: %w", err)
+	}
+	return path.String, nil
+}
+
 func (s *StorageDB) Untag(ctx context.Context, dealUuid uuid.UUID) (uint64, error) {
 	qry := "SELECT TransferSize FROM StorageTagged WHERE DealUUID = ?"
 	row := s.db.QueryRowContext(ctx, qry, dealUuid)
@@ -111,33 +142,99 @@ func (s *StorageDB) Logs(ctx context.Context) ([]StorageLog, error) {
 	return storageLogs, nil
 }
 
-func (s *StorageDB) TotalTagged(ctx context.Context) (uint64, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT TransferSize FROM StorageTagged")
+// StorageTag is the storage space tagged for a deal
+type StorageTag struct {
+	DealUUID     uuid.UUID
+	TransferSize uint64
+	// The path of the staging area in which the space is tagged ("" for
+	// storage that was tagged before staging areas were recorded)
+	StagingAreaPath string
+}
+
+// ListTagged lists the storage space tagged for each deal
+func (s *StorageDB) ListTagged(ctx context.Context) ([]StorageTag, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DealUUID, TransferSize, StagingAreaPath FROM StorageTagged")
 	if err != nil {
-		return 0, fmt.Errorf("getting total tagged: %w", err)
+		return nil, fmt.Errorf("listing tagged storage: %w", err)
 	}
 	defer rows.Close()
 
-	total := big.NewIntUnsigned(0)
+	tags := make([]StorageTag, 0, 16)
+	for rows.Next() {
+		var tag StorageTag
+		size := &bigIntFieldDef{f: new(big.Int)}
+		var path sql.NullString
+		err := rows.Scan(&tag.DealUUID, &size.marshalled, &path)
+		if err != nil {
+			return nil, fmt.Errorf("getting tagged storage: %w", err)
+		}
+
+		err = size.unmarshall()
+		if err != nil {
+			return nil, fmt.Errorf("unmarshalling TransferSize: %w", err)
+		}
+		tag.TransferSize = (*size.f).Uint64()
+		tag.StagingAreaPath = path.String
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
 
+func (s *StorageDB) TotalTagged(ctx context.Context) (uint64, error) {
+	byPath, err := s.TotalTaggedByPath(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := uint64(0)
+	for _, tagged := range byPath {
+		total += tagged
+	}
+	return total, nil
+}
+
+// TotalTaggedByPath returns the total storage tagged in each staging area.
+// Storage that was tagged before staging areas were recorded has the
+// path "" (until SetLegacyTaggedPath is called).
+func (s *StorageDB) TotalTaggedByPath(ctx context.Context) (map[string]uint64, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT TransferSize, StagingAreaPath FROM StorageTagged")
+	if err != nil {
+		return nil, fmt.Errorf("getting total tagged: %w", err)
+	}
+	defer rows.Close()
+
+	totals := make(map[string]big.Int)
 	for rows.Next() {
 		val := &bigIntFieldDef{f: new(big.Int)}
-		err := rows.Scan(&val.marshalled)
+		var path sql.NullString
+		err := rows.Scan(&val.marshalled, &path)
 		if err != nil {
-			return 0, fmt.Errorf("getting TransferSize: %w", err)
+			return nil, fmt.Errorf("getting TransferSize: %w", err)
 		}
 
 		err = val.unmarshall()
 		if err != nil {
-			return 0, fmt.Errorf("unmarshalling untagged TransferSize: %w", err)
+			return nil, fmt.Errorf("unmarshalling untagged TransferSize: %w", err)
 		}
 		if val.f.Int != nil {
-			total = big.Add(total, *val.f)
+			total, ok := totals[path.String]
+			if !ok {
+				total = big.NewIntUnsigned(0)
+			}
+			totals[path.String] = big.Add(total, *val.f)
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("getting total tagged: %w", err)
+		return nil, fmt.Errorf("getting total tagged: %w", err)
 	}
 
-	return total.Uint64(), nil
+	byPath := make(map[string]uint64, len(totals))
+	for path, total := range totals {
+		byPath[path] = total.Uint64()
+	}
+	return byPath, nil
 }
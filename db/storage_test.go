@@ -17,6 +17,7 @@ func TestStorageDB(t *testing.T) {
 
 	sqldb := CreateTestTmpDB(t)
 	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, Migrate(sqldb))
 
 	db := NewStorageDB(sqldb)
 
@@ -29,12 +30,53 @@ func TestStorageDB(t *testing.T) {
 	req.True(xerrors.Is(err, ErrNotFound))
 	req.Equal(uint64(0), amt)
 
-	err = db.Tag(ctx, dealUUID, 1111)
+	err = db.Tag(ctx, dealUUID, 1111, "/mnt/a")
+	req.NoError(err)
+
+	dealUUID2 := uuid.New()
+	err = db.Tag(ctx, dealUUID2, 2, "/mnt/b")
 	req.NoError(err)
 
 	total, err := db.TotalTagged(ctx)
 	req.NoError(err)
-	req.Equal(uint64(1111), total)
+	req.Equal(uint64(1113), total)
+
+	byPath, err := db.TotalTaggedByPath(ctx)
+	req.NoError(err)
+	req.Equal(map[string]uint64{"/mnt/a": 1111, "/mnt/b": 2}, byPath)
+
+	tags, err := db.ListTagged(ctx)
+	req.NoError(err)
+	req.ElementsMatch([]StorageTag{
+		{DealUUID: dealUUID, TransferSize: 1111, StagingAreaPath: "/mnt/a"},
+		{DealUUID: dealUUID2, TransferSize: 2, StagingAreaPath: "/mnt/b"},
+	}, tags)
+
+	path, err := db.TaggedPath(ctx, dealUUID2)
+	req.NoError(err)
+	req.Equal("/mnt/b", path)
+
+	// Storage tagged before staging areas were recorded should be moved to
+	// the legacy staging area
+	legacy := uuid.New()
+	req.NoError(db.Tag(ctx, legacy, 3, ""))
+	n, err := db.SetLegacyTaggedPath(ctx, "/mnt/incoming")
+	req.NoError(err)
+	req.EqualValues(1, n)
+	path, err = db.TaggedPath(ctx, legacy)
+	req.NoError(err)
+	req.Equal("/mnt/incoming", path)
+	path, err = db.TaggedPath(ctx, dealUUID)
+	req.NoError(err)
+	req.Equal("/mnt/a", path)
+	_, err = db.Untag(ctx, legacy)
+	req.NoError(err)
+
+	_, err = db.Untag(ctx, dealUUID2)
+	req.NoError(err)
+
+	_, err = db.TaggedPath(ctx, dealUUID2)
+	req.True(xerrors.Is(err, ErrNotFound))
 
 	amt, err = db.Untag(ctx, dealUUID)
 	req.NoError(err)
@@ -0,0 +1,752 @@
+package dealpublisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
+	marketactor "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/fx"
+)
+
+var log = logging.Logger("dealpublisher")
+
+type publisherAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	GasEstimateMessageGas(context.Context, *types.Message, *api.MessageSendSpec, types.TipSetKey) (*types.Message, error)
+	MpoolPushMessage(context.Context, *types.Message, *api.MessageSendSpec) (*types.SignedMessage, error)
+	StateCall(context.Context, *types.Message, types.TipSetKey) (*api.InvocResult, error)
+	StateGetActor(context.Context, address.Address, types.TipSetKey) (*types.Actor, error)
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (miner.MinerInfo, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+}
+
+type Config struct {
+	// The miner that the deals are published for. The wallets are checked
+	// on startup to make sure they can publish deals for the miner.
+	Miner address.Address
+	// The wallets used to send publish storage deals messages. Each deal is
+	// published from the wallet in which funds were tagged for the deal's
+	// publish message. Deals with no tagged wallet are published from the
+	// wallet with the fewest pending messages and the highest balance.
+	Wallets []address.Address
+	// The amount of time to wait for more deals to be ready to publish
+	// before publishing them all as a batch
+	Period time.Duration
+	// The maximum number of deals to include in a single publish storage
+	// deals message
+	MaxDealsPerMsg uint64
+	// The maximum fee to pay for a publish storage deals message
+	MaxFee abi.TokenAmount
+	// Deals are held while the base fee is above MaxBaseFee
+	// (zero means that deals are never held because of the base fee)
+	MaxBaseFee abi.TokenAmount
+	// Deals are always published at least this many epochs before the
+	// start epoch of the deal, whatever the base fee
+	StartEpochSafetyMargin abi.ChainEpoch
+}
+
+// DealPublisher batches deals into publish storage deals messages.
+//
+// Deals are published when the batch is full or when the batch period has
+// elapsed. If the base fee is above the configured maximum, or the
+// projected cost of the message is above the maximum fee, the batch is held
+// until the fees come down, or until a deal in the batch gets too close to
+// its start epoch.
+//
+// Before a batch is sent, the message is simulated against the chain head.
+// If the simulation fails (eg because a client's balance has dropped) the
+// batch is bisected to find the invalid deals. The invalid deals are failed
+// and the rest of the deals are published.
+type DealPublisher struct {
+	api publisherAPI
+	cfg Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// how often to check the chain while deals are waiting to be published
+	checkInterval time.Duration
+	// wakes up the publisher loop
+	wake chan struct{}
+
+	lk          sync.Mutex
+	pending     []*pendingDeal
+	periodStart time.Time
+	force       bool
+	status      Status
+}
+
+var _ smtypes.LoggingDealPublisher = (*DealPublisher)(nil)
+
+type pendingDeal struct {
+	dealUuid uuid.UUID
+	deal     market2.ClientDealProposal
+	// the wallet to publish the deal from (address.Undef if the publisher
+	// should pick the wallet)
+	wallet address.Address
+	log    smtypes.DealPublishLog
+	result chan publishResult
+}
+
+// String identifies the deal in log messages
+func (pd *pendingDeal) String() string {
+	if pd.dealUuid != uuid.Nil {
+		return pd.dealUuid.String()
+	}
+	return "with piece cid " + pd.deal.Proposal.PieceCID.String()
+}
+
+func (pd *pendingDeal) logw(msg string, kvs ...interface{}) {
+	if pd.log != nil {
+		pd.log(msg, kvs...)
+	}
+}
+
+type publishResult struct {
+	msgCid cid.Cid
+	err    error
+}
+
+// Status describes the publisher's latest decision about the pending deals
+type Status struct {
+	// True if there are deals that are waiting to be published
+	Waiting bool
+	// Why the deals are waiting, or why they were published
+	Reason string
+	// The base fee when the decision was made
+	BaseFee abi.TokenAmount
+	// The projected cost of the next publish storage deals message
+	ProjectedCost abi.TokenAmount
+	// The epoch by which the pending deals will be published, whatever the
+	// base fee
+	Deadline abi.ChainEpoch
+	// When the decision was made
+	At time.Time
+}
+
+// PendingDeals are the deals that are waiting to be published
+type PendingDeals struct {
+	Deals          []market2.ClientDealProposal
+	PeriodStart    time.Time
+	Period         time.Duration
+	MaxDealsPerMsg uint64
+	Status         Status
+}
+
+func New(cfg Config) func(lc fx.Lifecycle, api v1api.FullNode) *DealPublisher {
+	return func(lc fx.Lifecycle, api v1api.FullNode) *DealPublisher {
+		p := newDealPublisher(api, cfg)
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				if err := p.checkWallets(ctx); err != nil {
+					return err
+				}
+				p.Start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				p.Stop()
+				return nil
+			},
+		})
+		return p
+	}
+}
+
+func newDealPublisher(api publisherAPI, cfg Config) *DealPublisher {
+	if cfg.MaxDealsPerMsg == 0 {
+		cfg.MaxDealsPerMsg = 1
+	}
+	if cfg.MaxFee.Int == nil {
+		cfg.MaxFee = big.Zero()
+	}
+	if cfg.MaxBaseFee.Int == nil {
+		cfg.MaxBaseFee = big.Zero()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DealPublisher{
+		api:           api,
+		cfg:           cfg,
+		ctx:           ctx,
+		cancel:        cancel,
+		checkInterval: time.Duration(build.BlockDelaySecs) * time.Second,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (p *DealPublisher) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+func (p *DealPublisher) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Publish adds the deal to the next publish storage deals message, and
+// waits for the message to be sent. It returns the cid of the message.
+func (p *DealPublisher) Publish(ctx context.Context, deal market2.ClientDealProposal) (cid.Cid, error) {
+	return p.PublishWithLog(ctx, uuid.Nil, deal, address.Undef, nil)
+}
+
+// PublishWithLog is like Publish, but it publishes the deal from the given
+// wallet (the wallet in which funds were tagged for the deal's publish
+// message), and it records what happens to the deal while it is being
+// published in the deal log. If wallet is address.Undef the publisher picks
+// the wallet.
+func (p *DealPublisher) PublishWithLog(ctx context.Context, dealUuid uuid.UUID, deal market2.ClientDealProposal, wallet address.Address, dealLog smtypes.DealPublishLog) (cid.Cid, error) {
+	pd := &pendingDeal{
+		dealUuid: dealUuid,
+		deal:     deal,
+		wallet:   wallet,
+		log:      dealLog,
+		result:   make(chan publishResult, 1),
+	}
+	if wallet != address.Undef && !p.isWallet(wallet) {
+		// The wallet may have been removed from the config since the
+		// funds were tagged
+		pd.logw("wallet that funds were tagged in is no longer a publish wallet, picking another wallet", "wallet", wallet)
+		pd.wallet = address.Undef
+	}
+
+	p.lk.Lock()
+	if len(p.pending) == 0 {
+		p.periodStart = time.Now()
+	}
+	p.pending = append(p.pending, pd)
+	p.lk.Unlock()
+
+	log.Infow("deal added to publish batch", "proposal", deal.Proposal.PieceCID, "start epoch", deal.Proposal.StartEpoch)
+	p.wakeUp()
+
+	select {
+	case res := <-pd.result:
+		return res.msgCid, res.err
+	case <-ctx.Done():
+		if p.removePending(pd) {
+			log.Infow("deal removed from publish batch", "deal", pd.String(), "err", ctx.Err())
+			return cid.Undef, ctx.Err()
+		}
+	case <-p.ctx.Done():
+		return cid.Undef, errors.New("deal publisher shutting down")
+	}
+
+	// The deal is no longer in the batch because it is already being
+	// published, so wait for the result
+	select {
+	case res := <-pd.result:
+		return res.msgCid, res.err
+	case <-p.ctx.Done():
+		return cid.Undef, errors.New("deal publisher shutting down")
+	}
+}
+
+// PendingDeals returns the deals that are waiting to be published, and the
+// publisher's latest decision about them
+func (p *DealPublisher) PendingDeals() PendingDeals {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	deals := make([]market2.ClientDealProposal, 0, len(p.pending))
+	for _, pd := range p.pending {
+		deals = append(deals, pd.deal)
+	}
+
+	return PendingDeals{
+		Deals:          deals,
+		PeriodStart:    p.periodStart,
+		Period:         p.cfg.Period,
+		MaxDealsPerMsg: p.cfg.MaxDealsPerMsg,
+		Status:         p.status,
+	}
+}
+
+// ForcePublishPendingDeals publishes the pending deals straight away,
+// whatever the base fee
+func (p *DealPublisher) ForcePublishPendingDeals() {
+	p.lk.Lock()
+	p.force = true
+	p.lk.Unlock()
+
+	log.Infow("forcing publish of pending deals")
+	p.wakeUp()
+}
+
+func (p *DealPublisher) wakeUp() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// removePending removes the deal from the pending deals, and returns false
+// if the deal was not pending
+func (p *DealPublisher) removePending(pd *pendingDeal) bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	for i, d := range p.pending {
+		if d == pd {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DealPublisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+
+		p.check()
+	}
+}
+
+// check decides whether to publish the pending deals, and publishes them
+func (p *DealPublisher) check() {
+	p.lk.Lock()
+	force := p.force
+	p.force = false
+	pending := make([]*pendingDeal, len(p.pending))
+	copy(pending, p.pending)
+	periodStart := p.periodStart
+	p.lk.Unlock()
+
+	if len(pending) == 0 {
+		p.setStatus(Status{Reason: "no deals waiting to be published"})
+		return
+	}
+
+	head, err := p.api.ChainHead(p.ctx)
+	if err != nil {
+		log.Errorw("getting chain head", "err", err)
+		return
+	}
+
+	st := p.decide(head, pending, periodStart, force)
+	p.setStatus(st)
+	if st.Waiting {
+		log.Debugw("holding deals", "count", len(pending), "reason", st.Reason)
+		return
+	}
+
+	log.Infow("publishing deals", "count", len(pending), "reason", st.Reason)
+	p.publishAll(head, pending)
+}
+
+// decide whether to publish the pending deals now or to hold them
+func (p *DealPublisher) decide(head *types.TipSet, pending []*pendingDeal, periodStart time.Time, force bool) Status {
+	st := Status{
+		BaseFee: head.Blocks()[0].ParentBaseFee,
+		At:      time.Now(),
+	}
+
+	// The pending deals must be published before the deal with the
+	// earliest start epoch gets within the safety margin
+	st.Deadline = pending[0].deal.Proposal.StartEpoch
+	for _, pd := range pending[1:] {
+		if pd.deal.Proposal.StartEpoch < st.Deadline {
+			st.Deadline = pd.deal.Proposal.StartEpoch
+		}
+	}
+	st.Deadline -= p.cfg.StartEpochSafetyMargin
+
+	st.ProjectedCost = p.projectedCost(head, pending)
+
+	full := uint64(len(pending)) >= p.cfg.MaxDealsPerMsg
+	periodEnd := periodStart.Add(p.cfg.Period)
+	switch {
+	case force:
+		st.Reason = "publish was forced"
+	case head.Height() >= st.Deadline:
+		st.Reason = fmt.Sprintf("a deal is within %d epochs of its start epoch", p.cfg.StartEpochSafetyMargin)
+	case !full && time.Now().Before(periodEnd):
+		st.Waiting = true
+		st.Reason = fmt.Sprintf("waiting for more deals until %s", periodEnd.Format(time.RFC3339))
+	case !p.cfg.MaxBaseFee.IsZero() && st.BaseFee.GreaterThan(p.cfg.MaxBaseFee):
+		st.Waiting = true
+		st.Reason = fmt.Sprintf("base fee %s is above the maximum %s", types.FIL(st.BaseFee).Short(), types.FIL(p.cfg.MaxBaseFee).Short())
+	case !p.cfg.MaxFee.IsZero() && st.ProjectedCost.GreaterThan(p.cfg.MaxFee):
+		st.Waiting = true
+		st.Reason = fmt.Sprintf("projected cost %s is above the maximum fee %s", types.FIL(st.ProjectedCost).Short(), types.FIL(p.cfg.MaxFee).Short())
+	case full:
+		st.Reason = "batch is full"
+	default:
+		st.Reason = "batch period has elapsed"
+	}
+
+	return st
+}
+
+// projectedCost estimates the cost of the message that publishes the first
+// batch of pending deals
+func (p *DealPublisher) projectedCost(head *types.TipSet, pending []*pendingDeal) abi.TokenAmount {
+	// The first batch is made up of the deals that are published from the
+	// same wallet as the first deal
+	var batch []*pendingDeal
+	for _, pd := range pending {
+		if pd.wallet == pending[0].wallet && uint64(len(batch)) < p.cfg.MaxDealsPerMsg {
+			batch = append(batch, pd)
+		}
+	}
+	from := pending[0].wallet
+	if from == address.Undef {
+		from = p.pickWallet(head)
+	}
+
+	msg, err := p.publishMsg(batch, from)
+	if err != nil {
+		log.Warnw("creating publish message to estimate cost", "err", err)
+		return big.Zero()
+	}
+
+	est, err := p.api.GasEstimateMessageGas(p.ctx, msg, &api.MessageSendSpec{MaxFee: p.cfg.MaxFee}, head.Key())
+	if err != nil {
+		log.Warnw("estimating publish message gas", "err", err)
+		return big.Zero()
+	}
+
+	// The message pays the base fee plus the premium, up to the fee cap
+	gasPrice := big.Add(head.Blocks()[0].ParentBaseFee, est.GasPremium)
+	if gasPrice.GreaterThan(est.GasFeeCap) {
+		gasPrice = est.GasFeeCap
+	}
+	return big.Mul(big.NewInt(est.GasLimit), gasPrice)
+}
+
+// publishAll publishes the pending deals, in batches of up to
+// MaxDealsPerMsg deals
+func (p *DealPublisher) publishAll(head *types.TipSet, pending []*pendingDeal) {
+	var ready []*pendingDeal
+	for _, pd := range pending {
+		// Skip deals that were removed from the batch since the pending
+		// deals were checked (eg because the deal was cancelled)
+		if !p.removePending(pd) {
+			continue
+		}
+
+		// A deal can't be published once its start epoch has passed
+		prop := pd.deal.Proposal
+		if head.Height() > prop.StartEpoch {
+			pd.result <- publishResult{
+				err: fmt.Errorf("cannot publish deal with piece CID %s: current epoch %d has passed deal proposal start epoch %d",
+					prop.PieceCID, head.Height(), prop.StartEpoch),
+			}
+			continue
+		}
+		ready = append(ready, pd)
+	}
+
+	// Group the deals by the wallet they are published from, in the order
+	// in which the deals were added
+	var wallets []address.Address
+	byWallet := make(map[address.Address][]*pendingDeal)
+	for _, pd := range ready {
+		if _, ok := byWallet[pd.wallet]; !ok {
+			wallets = append(wallets, pd.wallet)
+		}
+		byWallet[pd.wallet] = append(byWallet[pd.wallet], pd)
+	}
+
+	for _, w := range wallets {
+		deals := byWallet[w]
+		for len(deals) > 0 {
+			batch := deals
+			if uint64(len(batch)) > p.cfg.MaxDealsPerMsg {
+				batch = batch[:p.cfg.MaxDealsPerMsg]
+			}
+			deals = deals[len(batch):]
+
+			from := w
+			if from == address.Undef {
+				from = p.pickWallet(head)
+			}
+			p.publishValid(head, batch, from)
+		}
+	}
+}
+
+// publishValid publishes the valid deals in the batch from the wallet
+func (p *DealPublisher) publishValid(head *types.TipSet, batch []*pendingDeal, from address.Address) {
+	for _, b := range p.validBatches(head, batch, from) {
+		msgCid, err := p.publishBatch(b, from)
+		if err != nil {
+			log.Errorw("publishing deals", "count", len(b), "wallet", from, "err", err)
+		} else {
+			log.Infow("published deals", "count", len(b), "wallet", from, "msg", msgCid)
+		}
+		for _, pd := range b {
+			pd.result <- publishResult{msgCid: msgCid, err: err}
+		}
+	}
+}
+
+// validBatches simulates the publish message for the batch. If the
+// simulation fails, it finds the invalid deals in the batch and fails them.
+// It returns the batches of remaining deals that should be published.
+func (p *DealPublisher) validBatches(head *types.TipSet, batch []*pendingDeal, from address.Address) [][]*pendingDeal {
+	err := p.simulate(head, batch, from)
+	if err == nil {
+		return [][]*pendingDeal{batch}
+	}
+
+	var simErr *simulationError
+	if !errors.As(err, &simErr) {
+		// The simulation itself failed (eg because of a network error), so
+		// just try to publish the batch
+		log.Warnw("simulating publish message", "count", len(batch), "err", err)
+		return [][]*pendingDeal{batch}
+	}
+
+	log.Warnw("publish message simulation failed, looking for invalid deals", "count", len(batch), "err", err)
+	invalid := make(map[*pendingDeal]error)
+	groups := p.bisect(head, batch, from, simErr, invalid)
+
+	// Tell the remaining deals why the batch was split
+	for _, pd := range batch {
+		if _, ok := invalid[pd]; ok {
+			continue
+		}
+		if len(invalid) == 0 {
+			pd.logw("publish batch was split because the deals are not valid together", "err", err)
+		}
+		for bad, badErr := range invalid {
+			pd.logw("publish batch was split to remove an invalid deal", "invalid deal", bad.String(), "err", badErr)
+		}
+	}
+
+	// Fail the invalid deals
+	for pd, err := range invalid {
+		log.Warnw("removing invalid deal from publish batch", "deal", pd.String(), "err", err)
+		pd.result <- publishResult{
+			err: fmt.Errorf("deal %s was removed from publish batch because publish message simulation failed: %w", pd, err),
+		}
+	}
+
+	// If the remaining deals are valid together, publish them in a single
+	// message. Otherwise publish each group of valid deals separately.
+	var remaining []*pendingDeal
+	for _, g := range groups {
+		remaining = append(remaining, g...)
+	}
+	if len(groups) <= 1 {
+		return groups
+	}
+	if err := p.simulate(head, remaining, from); err == nil {
+		return [][]*pendingDeal{remaining}
+	}
+	return groups
+}
+
+// bisect splits a batch that failed simulation in half, and simulates each
+// half, until it finds the individual deals that are invalid. It returns
+// the groups of deals that simulate successfully, and adds the invalid deals
+// to the invalid map.
+func (p *DealPublisher) bisect(head *types.TipSet, batch []*pendingDeal, from address.Address, simErr error, invalid map[*pendingDeal]error) [][]*pendingDeal {
+	if len(batch) == 1 {
+		invalid[batch[0]] = simErr
+		return nil
+	}
+
+	var groups [][]*pendingDeal
+	mid := len(batch) / 2
+	for _, half := range [][]*pendingDeal{batch[:mid], batch[mid:]} {
+		err := p.simulate(head, half, from)
+		var halfErr *simulationError
+		if !errors.As(err, &halfErr) {
+			// Either the half is valid, or it couldn't be simulated, in
+			// which case assume that it's valid
+			groups = append(groups, half)
+			continue
+		}
+		groups = append(groups, p.bisect(head, half, from, halfErr, invalid)...)
+	}
+	return groups
+}
+
+// simulationError is returned when the publish message fails simulation
+type simulationError struct {
+	msg string
+}
+
+func (e *simulationError) Error() string {
+	return e.msg
+}
+
+// simulate calls the publish message from the wallet against the chain head
+// without sending it to the network
+func (p *DealPublisher) simulate(head *types.TipSet, batch []*pendingDeal, from address.Address) error {
+	msg, err := p.publishMsg(batch, from)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.api.StateCall(p.ctx, msg, head.Key())
+	if err != nil {
+		return fmt.Errorf("calling publish storage deals: %w", err)
+	}
+	if res.MsgRct != nil && res.MsgRct.ExitCode != exitcode.Ok {
+		return &simulationError{msg: fmt.Sprintf("publish storage deals failed with exit code %s: %s", res.MsgRct.ExitCode, res.Error)}
+	}
+	if res.Error != "" {
+		return &simulationError{msg: fmt.Sprintf("publish storage deals failed: %s", res.Error)}
+	}
+	return nil
+}
+
+// pickWallet picks the wallet to send the next publish message from: the
+// wallet with the fewest messages waiting in the message pool (so that a
+// stuck message doesn't block publishing), and then the highest balance
+func (p *DealPublisher) pickWallet(head *types.TipSet) address.Address {
+	if len(p.cfg.Wallets) == 1 {
+		return p.cfg.Wallets[0]
+	}
+
+	var picked address.Address
+	var pickedPending uint64
+	var pickedBal abi.TokenAmount
+	for _, w := range p.cfg.Wallets {
+		// The number of pending messages is the difference between the next
+		// nonce in the message pool and the nonce of the actor on chain
+		act, err := p.api.StateGetActor(p.ctx, w, head.Key())
+		if err != nil {
+			log.Warnw("getting publish wallet actor", "wallet", w, "err", err)
+			continue
+		}
+		nonce, err := p.api.MpoolGetNonce(p.ctx, w)
+		if err != nil {
+			log.Warnw("getting publish wallet nonce", "wallet", w, "err", err)
+			continue
+		}
+		var pending uint64
+		if nonce > act.Nonce {
+			pending = nonce - act.Nonce
+		}
+
+		if picked == address.Undef ||
+			pending < pickedPending ||
+			(pending == pickedPending && act.Balance.GreaterThan(pickedBal)) {
+			picked = w
+			pickedPending = pending
+			pickedBal = act.Balance
+		}
+	}
+
+	if picked == address.Undef {
+		return p.cfg.Wallets[0]
+	}
+	return picked
+}
+
+func (p *DealPublisher) isWallet(addr address.Address) bool {
+	for _, w := range p.cfg.Wallets {
+		if w == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWallets checks that each wallet can send publish storage deals
+// messages for the miner, ie that it is the worker or a control address of
+// the miner
+func (p *DealPublisher) checkWallets(ctx context.Context) error {
+	if p.cfg.Miner == address.Undef {
+		return nil
+	}
+
+	mi, err := p.api.StateMinerInfo(ctx, p.cfg.Miner, types.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting miner info for %s: %w", p.cfg.Miner, err)
+	}
+
+	for _, w := range p.cfg.Wallets {
+		id, err := p.api.StateLookupID(ctx, w, types.EmptyTSK)
+		if err != nil {
+			return fmt.Errorf("looking up id of publish storage deals wallet %s: %w", w, err)
+		}
+
+		ok := id == mi.Worker
+		for _, ctl := range mi.ControlAddresses {
+			ok = ok || id == ctl
+		}
+		if !ok {
+			return fmt.Errorf("publish storage deals wallet %s is not the worker or a control address of miner %s", w, p.cfg.Miner)
+		}
+	}
+	return nil
+}
+
+func (p *DealPublisher) publishBatch(batch []*pendingDeal, from address.Address) (cid.Cid, error) {
+	msg, err := p.publishMsg(batch, from)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := p.api.MpoolPushMessage(p.ctx, msg, &api.MessageSendSpec{MaxFee: p.cfg.MaxFee})
+	if err != nil {
+		return cid.Undef, fmt.Errorf("pushing publish storage deals message: %w", err)
+	}
+	return smsg.Cid(), nil
+}
+
+func (p *DealPublisher) publishMsg(batch []*pendingDeal, from address.Address) (*types.Message, error) {
+	deals := make([]market2.ClientDealProposal, 0, len(batch))
+	for _, pd := range batch {
+		deals = append(deals, pd.deal)
+	}
+
+	params, err := actors.SerializeParams(&market2.PublishStorageDealsParams{Deals: deals})
+	if err != nil {
+		return nil, fmt.Errorf("serializing publish storage deals params: %w", err)
+	}
+
+	return &types.Message{
+		To:     marketactor.Address,
+		From:   from,
+		Value:  types.NewInt(0),
+		Method: marketactor.Methods.PublishStorageDeals,
+		Params: params,
+	}, nil
+}
+
+func (p *DealPublisher) setStatus(st Status) {
+	if st.At.IsZero() {
+		st.At = time.Now()
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.status = st
+}
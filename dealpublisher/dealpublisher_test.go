@@ -0,0 +1,529 @@
+package dealpublisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealPublisherHoldsWhileBaseFeeHigh(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(200))
+	dp := startPublisher(t, fapi, Config{
+		MaxDealsPerMsg:         8,
+		MaxBaseFee:             abi.NewTokenAmount(100),
+		StartEpochSafetyMargin: 100,
+	})
+
+	res := publishInBackground(dp, mkDeal(t, 2000))
+
+	// The base fee is above the maximum so the deal should be held
+	require.Eventually(t, func() bool {
+		st := dp.PendingDeals().Status
+		return st.Waiting && st.BaseFee.Equals(abi.NewTokenAmount(200))
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, dp.PendingDeals().Deals, 1)
+	require.Contains(t, dp.PendingDeals().Status.Reason, "base fee")
+	require.Empty(t, fapi.pushed())
+
+	// When the base fee comes down the deal should be published
+	fapi.setHead(1001, abi.NewTokenAmount(50))
+	r := waitResult(t, res)
+	require.NoError(t, r.err)
+	require.Len(t, fapi.pushed(), 1)
+	require.Equal(t, fapi.pushed()[0], r.msgCid)
+	require.Empty(t, dp.PendingDeals().Deals)
+}
+
+func TestDealPublisherSafetyMargin(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(200))
+	dp := startPublisher(t, fapi, Config{
+		MaxDealsPerMsg:         8,
+		MaxBaseFee:             abi.NewTokenAmount(100),
+		StartEpochSafetyMargin: 100,
+	})
+
+	// The deal is within the safety margin of its start epoch, so it should
+	// be published even though the base fee is high
+	res := publishInBackground(dp, mkDeal(t, 1100))
+	r := waitResult(t, res)
+	require.NoError(t, r.err)
+	require.Len(t, fapi.pushed(), 1)
+}
+
+func TestDealPublisherMaxFee(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	dp := startPublisher(t, fapi, Config{
+		MaxDealsPerMsg:         8,
+		MaxFee:                 abi.NewTokenAmount(1000),
+		StartEpochSafetyMargin: 100,
+	})
+
+	// The projected cost of the message is gas limit * (base fee + premium),
+	// which is above the max fee
+	res := publishInBackground(dp, mkDeal(t, 2000))
+	require.Eventually(t, func() bool {
+		return dp.PendingDeals().Status.Waiting
+	}, time.Second, 10*time.Millisecond)
+	st := dp.PendingDeals().Status
+	require.Contains(t, st.Reason, "projected cost")
+	expected := big.Mul(big.NewInt(fakeGasLimit), big.Add(abi.NewTokenAmount(100), fakeGasPremium))
+	require.True(t, expected.Equals(st.ProjectedCost))
+
+	// Forcing publish should publish the deal whatever the cost
+	dp.ForcePublishPendingDeals()
+	r := waitResult(t, res)
+	require.NoError(t, r.err)
+	require.Len(t, fapi.pushed(), 1)
+}
+
+func TestDealPublisherBatches(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	dp := startPublisher(t, fapi, Config{
+		Period:                 time.Hour,
+		MaxDealsPerMsg:         2,
+		StartEpochSafetyMargin: 100,
+	})
+
+	// The batch is not full and the period has not elapsed, so the first
+	// deal should wait for more deals
+	res1 := publishInBackground(dp, mkDeal(t, 2000))
+	require.Eventually(t, func() bool {
+		return len(dp.PendingDeals().Deals) == 1 && dp.PendingDeals().Status.Waiting
+	}, time.Second, 10*time.Millisecond)
+	require.Contains(t, dp.PendingDeals().Status.Reason, "waiting for more deals")
+
+	// When the batch is full it should be published
+	res2 := publishInBackground(dp, mkDeal(t, 2000))
+	r1 := waitResult(t, res1)
+	r2 := waitResult(t, res2)
+	require.NoError(t, r1.err)
+	require.NoError(t, r2.err)
+	require.Equal(t, r1.msgCid, r2.msgCid)
+	require.Len(t, fapi.pushed(), 1)
+
+	// A deal whose start epoch has passed should fail
+	res3 := publishInBackground(dp, mkDeal(t, 999))
+	dp.ForcePublishPendingDeals()
+	r3 := waitResult(t, res3)
+	require.Error(t, r3.err)
+	require.Len(t, fapi.pushed(), 1)
+}
+
+func TestDealPublisherCancelPendingDeal(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	dp := startPublisher(t, fapi, Config{
+		Period:                 time.Hour,
+		MaxDealsPerMsg:         2,
+		StartEpochSafetyMargin: 100,
+	})
+
+	// Cancelling the context of a deal that is waiting in the batch should
+	// remove the deal from the batch
+	ctx, cancel := context.WithCancel(context.Background())
+	res1 := make(chan publishResult, 1)
+	go func() {
+		msgCid, err := dp.Publish(ctx, mkDeal(t, 2000))
+		res1 <- publishResult{msgCid: msgCid, err: err}
+	}()
+	require.Eventually(t, func() bool {
+		return len(dp.PendingDeals().Deals) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	r1 := waitResult(t, res1)
+	require.ErrorIs(t, r1.err, context.Canceled)
+	require.Empty(t, dp.PendingDeals().Deals)
+
+	// The cancelled deal should not count towards filling the batch
+	res2 := publishInBackground(dp, mkDeal(t, 2001))
+	require.Eventually(t, func() bool {
+		return len(dp.PendingDeals().Deals) == 1 && dp.PendingDeals().Status.Waiting
+	}, time.Second, 10*time.Millisecond)
+	require.Empty(t, fapi.pushed())
+
+	res3 := publishInBackground(dp, mkDeal(t, 2002))
+	r2 := waitResult(t, res2)
+	r3 := waitResult(t, res3)
+	require.NoError(t, r2.err)
+	require.NoError(t, r3.err)
+	require.Len(t, fapi.pushed(), 1)
+}
+
+func TestDealPublisherIsolatesInvalidDeals(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	dp := startPublisher(t, fapi, Config{
+		Period:                 time.Hour,
+		MaxDealsPerMsg:         4,
+		StartEpochSafetyMargin: 100,
+	})
+
+	deals := make([]market2.ClientDealProposal, 4)
+	for i := range deals {
+		deals[i] = mkDeal(t, 2000)
+	}
+	invalidUuid := uuid.New()
+	fapi.setInvalid(deals[2].Proposal.PieceCID)
+
+	var logsLk sync.Mutex
+	logs := make(map[int][]string)
+	results := make([]chan publishResult, len(deals))
+	for i, deal := range deals {
+		i := i
+		dealUuid := uuid.New()
+		if i == 2 {
+			dealUuid = invalidUuid
+		}
+		results[i] = make(chan publishResult, 1)
+		go func(deal market2.ClientDealProposal) {
+			msgCid, err := dp.PublishWithLog(context.Background(), dealUuid, deal, address.Undef, func(msg string, kvs ...interface{}) {
+				logsLk.Lock()
+				defer logsLk.Unlock()
+				logs[i] = append(logs[i], fmt.Sprint(append([]interface{}{msg}, kvs...)...))
+			})
+			results[i] <- publishResult{msgCid: msgCid, err: err}
+		}(deal)
+	}
+
+	// The invalid deal should fail
+	r := waitResult(t, results[2])
+	require.Error(t, r.err)
+	require.Contains(t, r.err.Error(), "simulation failed")
+
+	// The other deals should be published together in a single message
+	var msgCid cid.Cid
+	for _, i := range []int{0, 1, 3} {
+		r := waitResult(t, results[i])
+		require.NoError(t, r.err)
+		if msgCid != cid.Undef {
+			require.Equal(t, msgCid, r.msgCid)
+		}
+		msgCid = r.msgCid
+	}
+	require.Equal(t, []cid.Cid{msgCid}, fapi.pushed())
+
+	// The deal logs for the other deals should record which deal caused
+	// the split
+	logsLk.Lock()
+	defer logsLk.Unlock()
+	for _, i := range []int{0, 1, 3} {
+		require.Len(t, logs[i], 1)
+		require.Contains(t, logs[i][0], invalidUuid.String())
+	}
+}
+
+func TestDealPublisherPicksWallet(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	w1, err := address.NewIDAddress(2001)
+	require.NoError(t, err)
+	w2, err := address.NewIDAddress(2002)
+	require.NoError(t, err)
+	fapi.balances[w1] = abi.NewTokenAmount(100)
+	fapi.balances[w2] = abi.NewTokenAmount(200)
+
+	dp := startPublisher(t, fapi, Config{
+		Wallets:                []address.Address{w1, w2},
+		MaxDealsPerMsg:         1,
+		StartEpochSafetyMargin: 100,
+	})
+
+	from := func() address.Address {
+		fapi.lk.Lock()
+		defer fapi.lk.Unlock()
+		return fapi.mpool[len(fapi.mpool)-1].Message.From
+	}
+
+	// With no pending messages, the wallet with the highest balance should
+	// be picked
+	r := waitResult(t, publishInBackground(dp, mkDeal(t, 2000)))
+	require.NoError(t, r.err)
+	require.Equal(t, w2, from())
+
+	// w2 now has a pending message so w1 should be picked
+	r = waitResult(t, publishInBackground(dp, mkDeal(t, 2000)))
+	require.NoError(t, r.err)
+	require.Equal(t, w1, from())
+
+	// Both wallets have one pending message, so w2 should be picked
+	r = waitResult(t, publishInBackground(dp, mkDeal(t, 2000)))
+	require.NoError(t, r.err)
+	require.Equal(t, w2, from())
+}
+
+func TestDealPublisherUsesTaggedWallet(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	w1, err := address.NewIDAddress(2001)
+	require.NoError(t, err)
+	w2, err := address.NewIDAddress(2002)
+	require.NoError(t, err)
+	fapi.balances[w1] = abi.NewTokenAmount(100)
+	fapi.balances[w2] = abi.NewTokenAmount(200)
+
+	dp := startPublisher(t, fapi, Config{
+		Wallets:                []address.Address{w1, w2},
+		MaxDealsPerMsg:         8,
+		StartEpochSafetyMargin: 100,
+	})
+
+	// Deals should be published from the wallet that funds were tagged in,
+	// even though w2 has a higher balance, and deals from different
+	// wallets should be published in separate messages
+	publish := func(wallet address.Address) chan publishResult {
+		res := make(chan publishResult, 1)
+		go func() {
+			msgCid, err := dp.PublishWithLog(context.Background(), uuid.New(), mkDeal(t, 1050), wallet, nil)
+			res <- publishResult{msgCid: msgCid, err: err}
+		}()
+		return res
+	}
+	res1 := publish(w1)
+	res2 := publish(w2)
+	r1 := waitResult(t, res1)
+	require.NoError(t, r1.err)
+	r2 := waitResult(t, res2)
+	require.NoError(t, r2.err)
+	require.NotEqual(t, r1.msgCid, r2.msgCid)
+
+	fapi.lk.Lock()
+	defer fapi.lk.Unlock()
+	from := make(map[cid.Cid]address.Address)
+	for _, msg := range fapi.mpool {
+		from[msg.Cid()] = msg.Message.From
+	}
+	require.Equal(t, w1, from[r1.msgCid])
+	require.Equal(t, w2, from[r2.msgCid])
+}
+
+func TestDealPublisherCheckWallets(t *testing.T) {
+	fapi := newFakeAPI(t, 1000, abi.NewTokenAmount(100))
+	mkAddr := func(id uint64) address.Address {
+		addr, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+		return addr
+	}
+	maddr, owner, worker, control, other := mkAddr(1000), mkAddr(3001), mkAddr(3002), mkAddr(3003), mkAddr(3004)
+	fapi.minerInfo = miner.MinerInfo{Owner: owner, Worker: worker, ControlAddresses: []address.Address{control}}
+
+	// The worker and control addresses can publish deals
+	dp := newDealPublisher(fapi, Config{Miner: maddr, Wallets: []address.Address{worker, control}})
+	require.NoError(t, dp.checkWallets(context.Background()))
+
+	// Other addresses (including the owner) can't
+	for _, w := range []address.Address{owner, other} {
+		dp = newDealPublisher(fapi, Config{Miner: maddr, Wallets: []address.Address{control, w}})
+		err := dp.checkWallets(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), w.String())
+	}
+}
+
+const fakeGasLimit = 1_000_000
+
+var fakeGasPremium = abi.NewTokenAmount(10)
+
+type fakeAPI struct {
+	t *testing.T
+
+	lk      sync.Mutex
+	head    *types.TipSet
+	msgCids []cid.Cid
+	// deals with these piece cids fail simulation
+	invalid map[cid.Cid]struct{}
+	// wallet balances
+	balances map[address.Address]abi.TokenAmount
+	// messages in the message pool
+	mpool []*types.SignedMessage
+	// the miner that deals are published for
+	minerInfo miner.MinerInfo
+}
+
+func newFakeAPI(t *testing.T, height abi.ChainEpoch, baseFee abi.TokenAmount) *fakeAPI {
+	fapi := &fakeAPI{t: t, invalid: make(map[cid.Cid]struct{}), balances: make(map[address.Address]abi.TokenAmount)}
+	fapi.setHead(height, baseFee)
+	return fapi
+}
+
+func (f *fakeAPI) setHead(height abi.ChainEpoch, baseFee abi.TokenAmount) {
+	dummyCid, err := cid.Parse("bafkqaaa")
+	require.NoError(f.t, err)
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(f.t, err)
+
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 miner,
+		Height:                height,
+		ParentStateRoot:       dummyCid,
+		Messages:              dummyCid,
+		ParentMessageReceipts: dummyCid,
+		ParentWeight:          big.Zero(),
+		ParentBaseFee:         baseFee,
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+	}})
+	require.NoError(f.t, err)
+
+	f.lk.Lock()
+	f.head = ts
+	f.lk.Unlock()
+}
+
+func (f *fakeAPI) setInvalid(pieceCid cid.Cid) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.invalid[pieceCid] = struct{}{}
+}
+
+func (f *fakeAPI) pushed() []cid.Cid {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return append([]cid.Cid{}, f.msgCids...)
+}
+
+func (f *fakeAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.head, nil
+}
+
+func (f *fakeAPI) GasEstimateMessageGas(_ context.Context, msg *types.Message, _ *api.MessageSendSpec, _ types.TipSetKey) (*types.Message, error) {
+	est := *msg
+	est.GasLimit = fakeGasLimit
+	est.GasFeeCap = abi.NewTokenAmount(1_000_000)
+	est.GasPremium = fakeGasPremium
+	return &est, nil
+}
+
+func (f *fakeAPI) StateCall(_ context.Context, msg *types.Message, _ types.TipSetKey) (*api.InvocResult, error) {
+	var params market2.PublishStorageDealsParams
+	err := params.UnmarshalCBOR(bytes.NewReader(msg.Params))
+	require.NoError(f.t, err)
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	res := &api.InvocResult{MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok}}
+	for _, deal := range params.Deals {
+		if _, ok := f.invalid[deal.Proposal.PieceCID]; ok {
+			res.MsgRct.ExitCode = exitcode.ErrIllegalArgument
+			res.Error = "client has insufficient balance"
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeAPI) StateGetActor(_ context.Context, addr address.Address, _ types.TipSetKey) (*types.Actor, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	act := &types.Actor{Balance: big.Zero()}
+	if bal, ok := f.balances[addr]; ok {
+		act.Balance = bal
+	}
+	return act, nil
+}
+
+func (f *fakeAPI) MpoolGetNonce(_ context.Context, addr address.Address) (uint64, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	// None of the messages in the message pool land on chain
+	var nonce uint64
+	for _, msg := range f.mpool {
+		if msg.Message.From == addr {
+			nonce++
+		}
+	}
+	return nonce, nil
+}
+
+func (f *fakeAPI) StateMinerInfo(context.Context, address.Address, types.TipSetKey) (miner.MinerInfo, error) {
+	return f.minerInfo, nil
+}
+
+func (f *fakeAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func (f *fakeAPI) MpoolPushMessage(_ context.Context, msg *types.Message, _ *api.MessageSendSpec) (*types.SignedMessage, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	smsg := &types.SignedMessage{
+		Message:   *msg,
+		Signature: crypto.Signature{Type: crypto.SigTypeBLS},
+	}
+	smsg.Message.Nonce = uint64(len(f.msgCids))
+	f.msgCids = append(f.msgCids, smsg.Cid())
+	f.mpool = append(f.mpool, smsg)
+	return smsg, nil
+}
+
+func startPublisher(t *testing.T, fapi *fakeAPI, cfg Config) *DealPublisher {
+	if len(cfg.Wallets) == 0 {
+		wallet, err := address.NewIDAddress(1001)
+		require.NoError(t, err)
+		cfg.Wallets = []address.Address{wallet}
+	}
+
+	dp := newDealPublisher(fapi, cfg)
+	dp.checkInterval = 10 * time.Millisecond
+	dp.Start()
+	t.Cleanup(dp.Stop)
+	return dp
+}
+
+func mkDeal(t *testing.T, startEpoch abi.ChainEpoch) market2.ClientDealProposal {
+	client, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+	provider, err := address.NewIDAddress(1003)
+	require.NoError(t, err)
+
+	return market2.ClientDealProposal{
+		Proposal: market2.DealProposal{
+			PieceCID:             testutil.GenerateCid(),
+			PieceSize:            2048,
+			Client:               client,
+			Provider:             provider,
+			StartEpoch:           startEpoch,
+			EndEpoch:             startEpoch + 518400,
+			StoragePricePerEpoch: big.Zero(),
+			ProviderCollateral:   big.Zero(),
+			ClientCollateral:     big.Zero(),
+		},
+		ClientSignature: crypto.Signature{Type: crypto.SigTypeBLS, Data: []byte("sig")},
+	}
+}
+
+func publishInBackground(dp *DealPublisher, deal market2.ClientDealProposal) chan publishResult {
+	res := make(chan publishResult, 1)
+	go func() {
+		msgCid, err := dp.Publish(context.Background(), deal)
+		res <- publishResult{msgCid: msgCid, err: err}
+	}()
+	return res
+}
+
+func waitResult(t *testing.T, res chan publishResult) publishResult {
+	select {
+	case r := <-res:
+		return r
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for deal to be published")
+		return publishResult{}
+	}
+}
@@ -0,0 +1,285 @@
+package fundmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// How often to check whether escrow needs to be topped up
+const autoTopUpInterval = time.Minute
+
+// AutoTopUpConfig configures moving funds from the pledge collateral wallet
+// into escrow automatically
+type AutoTopUpConfig struct {
+	// When true, escrow is topped up when the available balance falls below
+	// LowWater
+	Enabled bool
+	// Escrow is topped up when the available balance (escrow balance minus
+	// funds tagged for deals) falls below LowWater
+	LowWater abi.TokenAmount
+	// Escrow is topped up to HighWater
+	HighWater abi.TokenAmount
+	// The maximum amount to move in a single top-up (0 means no limit)
+	MaxPerTopUp abi.TokenAmount
+	// The maximum amount to move in all top-ups in the last 24 hours
+	// (0 means no limit)
+	DailyCap abi.TokenAmount
+}
+
+// AutoTopUpStatus is the state of the auto top-up policy
+type AutoTopUpStatus struct {
+	AutoTopUpConfig
+	// The total amount moved to escrow by top-ups that landed on chain in
+	// the last 24 hours
+	ToppedUpLastDay abi.TokenAmount
+	// The cid of the top-up message that is waiting to land on chain
+	// (cid.Undef if there is none)
+	PendingMsg cid.Cid
+	// When the last top-up message was sent, and the amount it moved
+	LastTopUpAt     time.Time
+	LastTopUpAmount abi.TokenAmount
+	// The last error or the reason that escrow could not be topped up
+	LastError string
+}
+
+type autoTopUp struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// triggers a check straight away (eg when a deal is rejected because
+	// of insufficient funds)
+	kick chan struct{}
+
+	lk        sync.Mutex
+	lastError string
+}
+
+func (m *FundManager) Start() {
+	if !m.cfg.AutoTopUp.Enabled {
+		return
+	}
+
+	log.Infow("starting escrow auto top-up",
+		"low water", m.cfg.AutoTopUp.LowWater, "high water", m.cfg.AutoTopUp.HighWater,
+		"max per top-up", m.cfg.AutoTopUp.MaxPerTopUp, "daily cap", m.cfg.AutoTopUp.DailyCap)
+
+	m.topUp.ctx, m.topUp.cancel = context.WithCancel(context.Background())
+	m.topUp.wg.Add(1)
+	go m.runAutoTopUp()
+}
+
+func (m *FundManager) Stop() {
+	if m.topUp.cancel == nil {
+		return
+	}
+	m.topUp.cancel()
+	m.topUp.wg.Wait()
+}
+
+// kickAutoTopUp triggers a check of whether escrow needs to be topped up
+func (m *FundManager) kickAutoTopUp() {
+	if !m.cfg.AutoTopUp.Enabled {
+		return
+	}
+	select {
+	case m.topUp.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (m *FundManager) runAutoTopUp() {
+	defer m.topUp.wg.Done()
+
+	ticker := time.NewTicker(autoTopUpInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.checkAutoTopUp(m.topUp.ctx); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Errorw("escrow auto top-up", "err", err)
+			m.setTopUpError(err.Error())
+		}
+
+		select {
+		case <-m.topUp.ctx.Done():
+			return
+		case <-m.topUp.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAutoTopUp moves funds into escrow if the available escrow balance
+// is below the low-water mark
+func (m *FundManager) checkAutoTopUp(ctx context.Context) error {
+	cfg := m.cfg.AutoTopUp
+
+	// Wait for the previous top-up message to land on chain before sending
+	// another one
+	last, err := m.db.LatestTopUp(ctx)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("getting latest top-up: %w", err)
+	}
+	if last != nil && last.Status == db.TopUpPending {
+		lookup, err := m.api.StateSearchMsg(ctx, types.EmptyTSK, last.MsgCID, api.LookbackNoLimit, true)
+		if err != nil {
+			return fmt.Errorf("searching for top-up message %s: %w", last.MsgCID, err)
+		}
+		if lookup == nil {
+			log.Debugw("waiting for top-up message to land on chain", "msg", last.MsgCID)
+			return nil
+		}
+
+		if lookup.Receipt.ExitCode != exitcode.Ok {
+			if err := m.db.SetTopUpStatus(ctx, last.MsgCID, db.TopUpFailed); err != nil {
+				return err
+			}
+			// Report the failure, and try again at the next check
+			m.setTopUpError(fmt.Sprintf("top-up message %s failed with exit code %s", last.MsgCID, lookup.Receipt.ExitCode))
+			return nil
+		}
+		if err := m.db.SetTopUpStatus(ctx, last.MsgCID, db.TopUpConfirmed); err != nil {
+			return err
+		}
+		log.Infow("top-up message landed on chain", "msg", last.MsgCID)
+	}
+
+	marketBal, err := m.BalanceMarket(ctx)
+	if err != nil {
+		return fmt.Errorf("getting market balance: %w", err)
+	}
+	tagged, err := m.totalTagged(ctx)
+	if err != nil {
+		return fmt.Errorf("getting total tagged: %w", err)
+	}
+
+	avail := big.Sub(marketBal.Available, tagged.Collateral)
+	if !avail.LessThan(cfg.LowWater) {
+		m.setTopUpError("")
+		return nil
+	}
+
+	// Top up to the high-water mark, up to the maximum per top-up
+	amt := big.Sub(cfg.HighWater, avail)
+	if !cfg.MaxPerTopUp.IsZero() {
+		amt = big.Min(amt, cfg.MaxPerTopUp)
+	}
+
+	// Don't go over the daily cap
+	if !cfg.DailyCap.IsZero() {
+		toppedUp, err := m.toppedUpLastDay(ctx)
+		if err != nil {
+			return err
+		}
+		remaining := big.Sub(cfg.DailyCap, toppedUp)
+		if !remaining.GreaterThan(big.Zero()) {
+			m.setTopUpError(fmt.Sprintf("available escrow %s is below the low-water mark %s but the daily cap of %s has been reached",
+				types.FIL(avail).Short(), types.FIL(cfg.LowWater).Short(), types.FIL(cfg.DailyCap).Short()))
+			return nil
+		}
+		amt = big.Min(amt, remaining)
+	}
+
+	// Don't try to move more than there is in the collateral wallet
+	collatBal, err := m.BalancePledgeCollateral(ctx)
+	if err != nil {
+		return fmt.Errorf("getting pledge collateral wallet balance: %w", err)
+	}
+	amt = big.Min(amt, collatBal)
+	if !amt.GreaterThan(big.Zero()) {
+		m.setTopUpError(fmt.Sprintf("available escrow %s is below the low-water mark %s but the pledge collateral wallet %s is empty",
+			types.FIL(avail).Short(), types.FIL(cfg.LowWater).Short(), m.cfg.CollatWallet))
+		return nil
+	}
+
+	log.Infow("topping up escrow", "available", avail, "low water", cfg.LowWater, "amount", amt)
+	msgCid, err := m.MoveFundsToEscrow(ctx, amt)
+	if err != nil {
+		return err
+	}
+
+	// Record the pending message so that it is waited for after a restart
+	err = m.db.InsertTopUp(ctx, &db.FundsTopUp{MsgCID: msgCid, Amount: amt, Status: db.TopUpPending})
+	if err != nil {
+		return fmt.Errorf("persisting top-up to DB: %w", err)
+	}
+
+	err = m.db.InsertLog(ctx, &db.FundsLog{
+		DealUUID: uuid.Nil,
+		Amount:   amt,
+		Text:     fmt.Sprintf("Auto top-up of escrow (message %s)", msgCid),
+	})
+	if err != nil {
+		return fmt.Errorf("persisting top-up funds log to DB: %w", err)
+	}
+
+	m.setTopUpError("")
+	return nil
+}
+
+// toppedUpLastDay returns the total amount moved to escrow by top-ups that
+// landed on chain in the last 24 hours
+func (m *FundManager) toppedUpLastDay(ctx context.Context) (abi.TokenAmount, error) {
+	total, err := m.db.TopUpsTotal(ctx, db.TopUpConfirmed, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return big.Zero(), fmt.Errorf("getting total topped up in last day: %w", err)
+	}
+	return total, nil
+}
+
+func (m *FundManager) setTopUpError(msg string) {
+	m.topUp.lk.Lock()
+	defer m.topUp.lk.Unlock()
+
+	if msg != "" && msg != m.topUp.lastError {
+		log.Warnw("escrow auto top-up", "status", msg)
+	}
+	m.topUp.lastError = msg
+}
+
+// AutoTopUpStatus returns the state of the escrow auto top-up policy
+func (m *FundManager) AutoTopUpStatus(ctx context.Context) (*AutoTopUpStatus, error) {
+	toppedUp, err := m.toppedUpLastDay(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &AutoTopUpStatus{
+		AutoTopUpConfig: m.cfg.AutoTopUp,
+		ToppedUpLastDay: toppedUp,
+		PendingMsg:      cid.Undef,
+		LastTopUpAmount: big.Zero(),
+	}
+
+	last, err := m.db.LatestTopUp(ctx)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("getting latest top-up: %w", err)
+	}
+	if last != nil {
+		if last.Status == db.TopUpPending {
+			st.PendingMsg = last.MsgCID
+		}
+		st.LastTopUpAt = last.CreatedAt
+		st.LastTopUpAmount = last.Amount
+	}
+
+	m.topUp.lk.Lock()
+	st.LastError = m.topUp.lastError
+	m.topUp.lk.Unlock()
+
+	return st, nil
+}
@@ -0,0 +1,242 @@
+package fundmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoTopUp(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+	fundsDB := db.NewFundsDB(sqldb)
+
+	api := &topUpApi{
+		escrow:     abi.NewTokenAmount(100),
+		collatBal:  abi.NewTokenAmount(1000),
+		msgLanded:  make(map[cid.Cid]bool),
+		msgAmounts: make(map[cid.Cid]abi.TokenAmount),
+	}
+	cfg := Config{
+		StorageMiner:  address.TestAddress,
+		CollatWallet:  address.TestAddress2,
+		PubMsgWallets: []address.Address{address.TestAddress2},
+		PubMsgBalMin:  abi.NewTokenAmount(10),
+		AutoTopUp: AutoTopUpConfig{
+			Enabled:     true,
+			LowWater:    abi.NewTokenAmount(50),
+			HighWater:   abi.NewTokenAmount(200),
+			MaxPerTopUp: abi.NewTokenAmount(120),
+			DailyCap:    abi.NewTokenAmount(250),
+		},
+	}
+	fm := newFundManager(api, fundsDB, cfg)
+
+	// Available escrow is above the low-water mark so there should be no
+	// top-up
+	req.NoError(fm.checkAutoTopUp(ctx))
+	req.Empty(api.sent())
+
+	// Tag funds for a deal so that available escrow drops below the
+	// low-water mark (100 - 60 = 40)
+	deals, err := db.GenerateDeals()
+	req.NoError(err)
+	prop := deals[0].ClientDealProposal.Proposal
+	prop.ProviderCollateral = abi.NewTokenAmount(60)
+	_, err = fm.TagFunds(ctx, deals[0].DealUuid, prop)
+	req.NoError(err)
+
+	// Escrow should be topped up to the high-water mark (200 - 40 = 160),
+	// up to the maximum per top-up (120)
+	req.NoError(fm.checkAutoTopUp(ctx))
+	sent := api.sent()
+	req.Len(sent, 1)
+	req.EqualValues(120, api.msgAmounts[sent[0]].Int64())
+
+	// The top-up should be recorded in the funds logs
+	logs, err := fundsDB.Logs(ctx, nil, 0, 0)
+	req.NoError(err)
+	req.Contains(logs[0].Text, sent[0].String())
+	req.EqualValues(120, logs[0].Amount.Int64())
+
+	// The top-up should only count towards the daily cap once the message
+	// has landed on chain
+	st, err := fm.AutoTopUpStatus(ctx)
+	req.NoError(err)
+	req.Equal(sent[0], st.PendingMsg)
+	req.EqualValues(120, st.LastTopUpAmount.Int64())
+	req.True(st.ToppedUpLastDay.IsZero())
+
+	// While the top-up message has not landed on chain there should be no
+	// more top-ups
+	req.NoError(fm.checkAutoTopUp(ctx))
+	req.Len(api.sent(), 1)
+
+	// The pending message should still be waited for after a restart
+	fm = newFundManager(api, fundsDB, cfg)
+	req.NoError(fm.checkAutoTopUp(ctx))
+	req.Len(api.sent(), 1)
+	st, err = fm.AutoTopUpStatus(ctx)
+	req.NoError(err)
+	req.Equal(sent[0], st.PendingMsg)
+
+	// When the message lands, if escrow is still below the low-water mark,
+	// there should be another top-up, limited by the daily cap (250 - 120)
+	api.land(sent[0], false)
+	api.setEscrow(abi.NewTokenAmount(0))
+	req.NoError(fm.checkAutoTopUp(ctx))
+	sent = api.sent()
+	req.Len(sent, 2)
+	req.EqualValues(120, api.msgAmounts[sent[1]].Int64())
+
+	api.land(sent[1], false)
+	req.NoError(fm.checkAutoTopUp(ctx))
+	sent = api.sent()
+	req.Len(sent, 3)
+	req.EqualValues(10, api.msgAmounts[sent[2]].Int64())
+
+	// Once the daily cap has been reached there should be no more top-ups
+	api.land(sent[2], false)
+	req.NoError(fm.checkAutoTopUp(ctx))
+	req.Len(api.sent(), 3)
+
+	st, err = fm.AutoTopUpStatus(ctx)
+	req.NoError(err)
+	req.EqualValues(250, st.ToppedUpLastDay.Int64())
+	req.Contains(st.LastError, "daily cap")
+}
+
+func TestAutoTopUpFailedMessage(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	api := &topUpApi{
+		escrow:     abi.NewTokenAmount(0),
+		collatBal:  abi.NewTokenAmount(1000),
+		msgLanded:  make(map[cid.Cid]bool),
+		msgAmounts: make(map[cid.Cid]abi.TokenAmount),
+	}
+	fm := newFundManager(api, db.NewFundsDB(sqldb), Config{
+		StorageMiner: address.TestAddress,
+		CollatWallet: address.TestAddress2,
+		AutoTopUp: AutoTopUpConfig{
+			Enabled:   true,
+			LowWater:  abi.NewTokenAmount(50),
+			HighWater: abi.NewTokenAmount(200),
+		},
+	})
+
+	req.NoError(fm.checkAutoTopUp(ctx))
+	sent := api.sent()
+	req.Len(sent, 1)
+	req.EqualValues(200, api.msgAmounts[sent[0]].Int64())
+
+	// If the message fails on chain, the failure should be reported
+	api.land(sent[0], true)
+	req.NoError(fm.checkAutoTopUp(ctx))
+	st, err := fm.AutoTopUpStatus(ctx)
+	req.NoError(err)
+	req.Equal(cid.Undef, st.PendingMsg)
+	req.Contains(st.LastError, "failed")
+
+	// A failed top-up should not count towards the daily cap
+	req.True(st.ToppedUpLastDay.IsZero())
+
+	// The top-up should be retried at the next check
+	req.NoError(fm.checkAutoTopUp(ctx))
+	req.Len(api.sent(), 2)
+}
+
+type topUpApi struct {
+	lk         sync.Mutex
+	escrow     abi.TokenAmount
+	collatBal  abi.TokenAmount
+	msgs       []cid.Cid
+	msgLanded  map[cid.Cid]bool
+	msgFailed  bool
+	msgAmounts map[cid.Cid]abi.TokenAmount
+}
+
+func (a *topUpApi) sent() []cid.Cid {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return append([]cid.Cid{}, a.msgs...)
+}
+
+func (a *topUpApi) setEscrow(amt abi.TokenAmount) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.escrow = amt
+}
+
+func (a *topUpApi) land(msg cid.Cid, failed bool) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.msgLanded[msg] = true
+	a.msgFailed = failed
+}
+
+func (a *topUpApi) MarketAddBalance(ctx context.Context, wallet, addr address.Address, amt types.BigInt) (cid.Cid, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	msg := testutil.GenerateCid()
+	a.msgs = append(a.msgs, msg)
+	a.msgAmounts[msg] = amt
+	return msg, nil
+}
+
+func (a *topUpApi) StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (lapi.MarketBalance, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return lapi.MarketBalance{Escrow: a.escrow, Locked: big.Zero()}, nil
+}
+
+func (a *topUpApi) WalletBalance(ctx context.Context, addr address.Address) (types.BigInt, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.collatBal, nil
+}
+
+func (a *topUpApi) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*lapi.MsgLookup, error) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	if !a.msgLanded[msg] {
+		return nil, nil
+	}
+	lookup := &lapi.MsgLookup{Message: msg}
+	if a.msgFailed {
+		lookup.Receipt.ExitCode = exitcode.ErrInsufficientFunds
+	}
+	return lookup, nil
+}
+
+func (a *topUpApi) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return &types.Actor{}, nil
+}
+
+func (a *topUpApi) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return 0, nil
+}
+
+var _ fundManagerAPI = (*topUpApi)(nil)
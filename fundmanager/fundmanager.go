@@ -17,6 +17,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/fx"
 )
 
 var log = logging.Logger("funds")
@@ -25,6 +26,9 @@ type fundManagerAPI interface {
 	MarketAddBalance(ctx context.Context, wallet, addr address.Address, amt types.BigInt) (cid.Cid, error)
 	StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MarketBalance, error)
 	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
+	StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error)
 }
 
 type Config struct {
@@ -34,31 +38,58 @@ type Config struct {
 	// Wallet used as source of pledge collateral when moving funds to
 	// escrow
 	CollatWallet address.Address
-	// Wallet used to send the publish message (and pay gas fees)
-	PubMsgWallet address.Address
+	// Wallets used to send the publish message (and pay gas fees)
+	PubMsgWallets []address.Address
 	// How much to reserve for each publish message
 	PubMsgBalMin abi.TokenAmount
+	// Automatically move funds into escrow when it runs low
+	AutoTopUp AutoTopUpConfig
 }
 
 type FundManager struct {
-	api fundManagerAPI
-	db  *db.FundsDB
-	cfg Config
+	api   fundManagerAPI
+	db    *db.FundsDB
+	cfg   Config
+	topUp autoTopUp
 }
 
-func New(cfg Config) func(api v1api.FullNode, fundsDB *db.FundsDB) *FundManager {
-	return func(api api.FullNode, fundsDB *db.FundsDB) *FundManager {
-		return &FundManager{
-			api: api,
-			db:  fundsDB,
-			cfg: cfg,
+func New(cfg Config) func(lc fx.Lifecycle, api v1api.FullNode, fundsDB *db.FundsDB) *FundManager {
+	return func(lc fx.Lifecycle, api v1api.FullNode, fundsDB *db.FundsDB) *FundManager {
+		m := newFundManager(api, fundsDB, cfg)
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				m.Start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				m.Stop()
+				return nil
+			},
+		})
+		return m
+	}
+}
+
+func newFundManager(api fundManagerAPI, fundsDB *db.FundsDB, cfg Config) *FundManager {
+	for _, amt := range []*abi.TokenAmount{&cfg.AutoTopUp.LowWater, &cfg.AutoTopUp.HighWater, &cfg.AutoTopUp.MaxPerTopUp, &cfg.AutoTopUp.DailyCap} {
+		if amt.Int == nil {
+			*amt = big.Zero()
 		}
 	}
+
+	return &FundManager{
+		api:   api,
+		db:    fundsDB,
+		cfg:   cfg,
+		topUp: autoTopUp{kick: make(chan struct{}, 1)},
+	}
 }
 
 type TagFundsResp struct {
 	Collateral     abi.TokenAmount
 	PublishMessage abi.TokenAmount
+	// The wallet that funds for the publish message were tagged in
+	PublishMessageWallet address.Address
 
 	TotalCollateral     abi.TokenAmount
 	TotalPublishMessage abi.TokenAmount
@@ -79,11 +110,6 @@ func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal
 		return nil, fmt.Errorf("getting market balance: %w", err)
 	}
 
-	pubMsgBal, err := m.BalancePublishMsg(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("getting publish deals message wallet balance: %w", err)
-	}
-
 	// Check that the provider has enough funds in escrow to cover the
 	// collateral requirement for the deal
 	tagged, err := m.totalTagged(ctx)
@@ -91,33 +117,63 @@ func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal
 		return nil, fmt.Errorf("getting total tagged: %w", err)
 	}
 
+	pubMsgBals, err := m.pubMsgBalances(ctx, tagged)
+	if err != nil {
+		return nil, err
+	}
+
 	dealCollateral := proposal.ProviderBalanceRequirement()
 	availForDealCollat := big.Sub(marketBal.Available, tagged.Collateral)
 	if availForDealCollat.LessThan(dealCollateral) {
+		m.kickAutoTopUp()
 		err := fmt.Errorf("%w: available funds %d is less than collateral needed for deal %d: "+
 			"available = funds in escrow %d - amount reserved for other deals %d",
 			ErrInsufficientFunds, availForDealCollat, dealCollateral, marketBal.Available, tagged.Collateral)
 		return nil, err
 	}
 
-	// Check that the provider has enough funds to send a PublishStorageDeals message
-	availForPubMsg := big.Sub(pubMsgBal, tagged.PubMsg)
-	if availForPubMsg.LessThan(m.cfg.PubMsgBalMin) {
+	// Check that the provider has enough funds to send a PublishStorageDeals
+	// message. The publish message is sent from the wallet the funds are
+	// tagged in, so prefer the wallet with the fewest messages waiting in
+	// the message pool (a stuck message would hold up the deal), and then
+	// the wallet with the most available funds.
+	var pubMsgWallet, mostAvailable *PubMsgWalletBalance
+	availForPubMsg := big.Zero()
+	for i := range pubMsgBals {
+		wb := &pubMsgBals[i]
+		availForPubMsg = big.Add(availForPubMsg, wb.Available())
+		if mostAvailable == nil || wb.Available().GreaterThan(mostAvailable.Available()) {
+			mostAvailable = wb
+		}
+		if wb.Available().LessThan(m.cfg.PubMsgBalMin) {
+			continue
+		}
+		if pubMsgWallet == nil ||
+			wb.Pending < pubMsgWallet.Pending ||
+			(wb.Pending == pubMsgWallet.Pending && wb.Available().GreaterThan(pubMsgWallet.Available())) {
+			pubMsgWallet = wb
+		}
+	}
+	if mostAvailable == nil {
+		return nil, errors.New("no publish deals message wallets configured")
+	}
+	if pubMsgWallet == nil {
 		err := fmt.Errorf("%w: available funds %d is less than needed for publish deals message %d: "+
-			"available = funds in publish deals wallet %d - amount reserved for other deals %d",
-			ErrInsufficientFunds, availForPubMsg, m.cfg.PubMsgBalMin, pubMsgBal, tagged.PubMsg)
+			"available = funds in publish deals wallet %s %d - amount reserved for other deals %d",
+			ErrInsufficientFunds, mostAvailable.Available(), m.cfg.PubMsgBalMin, mostAvailable.Address, mostAvailable.Balance, mostAvailable.Tagged)
 		return nil, err
 	}
 
 	// Provider has enough funds to make deal, so persist tagged funds
-	err = m.persistTagged(ctx, dealUuid, dealCollateral, m.cfg.PubMsgBalMin)
+	err = m.persistTagged(ctx, dealUuid, dealCollateral, m.cfg.PubMsgBalMin, pubMsgWallet.Address)
 	if err != nil {
 		return nil, fmt.Errorf("saving total tagged: %w", err)
 	}
 
 	return &TagFundsResp{
-		Collateral:     dealCollateral,
-		PublishMessage: m.cfg.PubMsgBalMin,
+		Collateral:           dealCollateral,
+		PublishMessage:       m.cfg.PubMsgBalMin,
+		PublishMessageWallet: pubMsgWallet.Address,
 
 		TotalPublishMessage: big.Add(tagged.PubMsg, m.cfg.PubMsgBalMin),
 		TotalCollateral:     big.Add(tagged.Collateral, dealCollateral),
@@ -142,6 +198,21 @@ func (m *FundManager) totalTagged(ctx context.Context) (*db.TotalTagged, error)
 	return total, nil
 }
 
+// PublishMsgWallet returns the wallet in which funds were tagged for the
+// deal's publish storage deals message, so that the message is sent from
+// that wallet. It returns address.Undef if the wallet was not recorded when
+// the funds were tagged.
+func (m *FundManager) PublishMsgWallet(ctx context.Context, dealUuid uuid.UUID) (address.Address, error) {
+	w, err := m.db.TaggedPubMsgWallet(ctx, dealUuid)
+	if err != nil {
+		return address.Undef, fmt.Errorf("getting tagged publish message wallet from DB: %w", err)
+	}
+	if w == "" {
+		return address.Undef, nil
+	}
+	return address.NewFromString(w)
+}
+
 // UntagFunds untags funds that were associated (tagged) with a deal.
 // It's called when it's no longer necessary to prevent the funds from being
 // used for a different deal (eg because the deal failed / was published)
@@ -167,8 +238,8 @@ func (m *FundManager) UntagFunds(ctx context.Context, dealUuid uuid.UUID) (colla
 	return untaggedCollat, untaggedPublish, nil
 }
 
-func (m *FundManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, dealCollateral abi.TokenAmount, pubMsgBal abi.TokenAmount) error {
-	err := m.db.Tag(ctx, dealUuid, dealCollateral, pubMsgBal)
+func (m *FundManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, dealCollateral abi.TokenAmount, pubMsgBal abi.TokenAmount, pubMsgWallet address.Address) error {
+	err := m.db.Tag(ctx, dealUuid, dealCollateral, pubMsgBal, pubMsgWallet)
 	if err != nil {
 		return fmt.Errorf("persisting tag funds for deal to DB: %w", err)
 	}
@@ -181,14 +252,14 @@ func (m *FundManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, dea
 	pubMsgFundsLog := &db.FundsLog{
 		DealUUID: dealUuid,
 		Amount:   pubMsgBal,
-		Text:     "Tag funds for deal publish message",
+		Text:     fmt.Sprintf("Tag funds for deal publish message in wallet %s", pubMsgWallet),
 	}
 	err = m.db.InsertLog(ctx, collatFundsLog, pubMsgFundsLog)
 	if err != nil {
 		return fmt.Errorf("persisting tag funds log to DB: %w", err)
 	}
 
-	log.Infow("tag", "id", dealUuid, "collateral", dealCollateral, "pubmsgbal", pubMsgBal)
+	log.Infow("tag", "id", dealUuid, "collateral", dealCollateral, "pubmsgbal", pubMsgBal, "pubmsgwallet", pubMsgWallet)
 	return nil
 }
 
@@ -224,14 +295,96 @@ func (m *FundManager) AddressPledgeCollateral() address.Address {
 	return m.cfg.CollatWallet
 }
 
-// BalancePublishMsg returns the amount of funds in the wallet used to send
-// publish storage deals messages
+// PubMsgWalletBalance is the balance of a wallet used to send publish
+// storage deals messages, and the amount in the wallet that is tagged for
+// deals
+type PubMsgWalletBalance struct {
+	Address address.Address
+	Balance abi.TokenAmount
+	Tagged  abi.TokenAmount
+	// The number of messages from the wallet that are waiting in the
+	// message pool
+	Pending uint64
+}
+
+// Available is the amount in the wallet that is not tagged for deals
+func (b PubMsgWalletBalance) Available() abi.TokenAmount {
+	return big.Sub(b.Balance, b.Tagged)
+}
+
+// BalancePublishMsg returns the total amount of funds in the wallets used
+// to send publish storage deals messages
 func (m *FundManager) BalancePublishMsg(ctx context.Context) (abi.TokenAmount, error) {
-	return m.api.WalletBalance(ctx, m.cfg.PubMsgWallet)
+	total := big.Zero()
+	for _, w := range m.cfg.PubMsgWallets {
+		bal, err := m.api.WalletBalance(ctx, w)
+		if err != nil {
+			return big.Zero(), fmt.Errorf("getting balance of publish deals message wallet %s: %w", w, err)
+		}
+		total = big.Add(total, bal)
+	}
+	return total, nil
+}
+
+// BalancesPublishMsg returns the balance of each wallet used to send
+// publish storage deals messages, and the amount tagged in each wallet
+func (m *FundManager) BalancesPublishMsg(ctx context.Context) ([]PubMsgWalletBalance, error) {
+	tagged, err := m.totalTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting total tagged: %w", err)
+	}
+	return m.pubMsgBalances(ctx, tagged)
+}
+
+func (m *FundManager) pubMsgBalances(ctx context.Context, tagged *db.TotalTagged) ([]PubMsgWalletBalance, error) {
+	bals := make([]PubMsgWalletBalance, 0, len(m.cfg.PubMsgWallets))
+	for i, w := range m.cfg.PubMsgWallets {
+		bal, err := m.api.WalletBalance(ctx, w)
+		if err != nil {
+			return nil, fmt.Errorf("getting balance of publish deals message wallet %s: %w", w, err)
+		}
+
+		walletTagged, ok := tagged.PubMsgByWallet[w.String()]
+		if !ok {
+			walletTagged = big.Zero()
+		}
+		// Funds tagged before there were multiple wallets were tagged in
+		// the first wallet
+		if i == 0 {
+			if untracked, ok := tagged.PubMsgByWallet[""]; ok {
+				walletTagged = big.Add(walletTagged, untracked)
+			}
+		}
+
+		bals = append(bals, PubMsgWalletBalance{Address: w, Balance: bal, Tagged: walletTagged, Pending: m.pendingMsgs(ctx, w)})
+	}
+	return bals, nil
+}
+
+// pendingMsgs returns the number of messages from the wallet that are
+// waiting in the message pool: the difference between the next nonce in
+// the message pool and the nonce of the actor on chain
+func (m *FundManager) pendingMsgs(ctx context.Context, w address.Address) uint64 {
+	act, err := m.api.StateGetActor(ctx, w, types.EmptyTSK)
+	if err != nil {
+		log.Warnw("getting publish deals message wallet actor", "wallet", w, "err", err)
+		return 0
+	}
+	nonce, err := m.api.MpoolGetNonce(ctx, w)
+	if err != nil {
+		log.Warnw("getting publish deals message wallet nonce", "wallet", w, "err", err)
+		return 0
+	}
+	if nonce > act.Nonce {
+		return nonce - act.Nonce
+	}
+	return 0
 }
 
-func (m *FundManager) AddressPublishMsg() address.Address {
-	return m.cfg.PubMsgWallet
+// AddressesPublishMsg returns the wallets used to send publish storage deals
+// messages
+func (m *FundManager) AddressesPublishMsg() []address.Address {
+	return m.cfg.PubMsgWallets
 }
 
 func toSharedBalance(bal api.MarketBalance) storagemarket.Balance {
@@ -12,6 +12,7 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/google/uuid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/stretchr/testify/require"
 )
@@ -24,6 +25,7 @@ func TestFundManager(t *testing.T) {
 
 	sqldb := db.CreateTestTmpDB(t)
 	require.NoError(t, db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, db.Migrate(sqldb))
 
 	fundsDB := db.NewFundsDB(sqldb)
 
@@ -32,9 +34,9 @@ func TestFundManager(t *testing.T) {
 		api: api,
 		db:  fundsDB,
 		cfg: Config{
-			StorageMiner: address.TestAddress,
-			PubMsgWallet: address.TestAddress2,
-			PubMsgBalMin: abi.NewTokenAmount(10),
+			StorageMiner:  address.TestAddress,
+			PubMsgWallets: []address.Address{address.TestAddress2},
+			PubMsgBalMin:  abi.NewTokenAmount(10),
 		},
 	}
 
@@ -61,8 +63,9 @@ func TestFundManager(t *testing.T) {
 	avail := big.Sub(mb.Escrow, mb.Locked)
 
 	ex := &TagFundsResp{
-		Collateral:     prop.ProviderCollateral,
-		PublishMessage: fm.cfg.PubMsgBalMin,
+		Collateral:           prop.ProviderCollateral,
+		PublishMessage:       fm.cfg.PubMsgBalMin,
+		PublishMessageWallet: address.TestAddress2,
 
 		TotalCollateral:     prop.ProviderCollateral,
 		TotalPublishMessage: fm.cfg.PubMsgBalMin,
@@ -107,6 +110,154 @@ func TestFundManager(t *testing.T) {
 	req.EqualValues(10, total.PubMsg.Int64())
 }
 
+func TestFundManagerMultiplePubMsgWallets(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	w1, err := address.NewIDAddress(1001)
+	req.NoError(err)
+	w2, err := address.NewIDAddress(1002)
+	req.NoError(err)
+
+	api := &walletsMockApi{balances: map[address.Address]abi.TokenAmount{
+		w1: abi.NewTokenAmount(25),
+		w2: abi.NewTokenAmount(30),
+	}}
+	fm := newFundManager(api, db.NewFundsDB(sqldb), Config{
+		StorageMiner:  address.TestAddress,
+		PubMsgWallets: []address.Address{w1, w2},
+		PubMsgBalMin:  abi.NewTokenAmount(10),
+	})
+
+	deals, err := db.GenerateDeals()
+	req.NoError(err)
+	prop := deals[0].ClientDealProposal.Proposal
+	prop.ProviderCollateral = abi.NewTokenAmount(1)
+	tag := func(dealUuid uuid.UUID) (*TagFundsResp, error) {
+		return fm.TagFunds(ctx, dealUuid, prop)
+	}
+
+	// Funds should be tagged in the wallet with the most available funds:
+	// w2 (30 available), then w1 (25), then w2 (20), then w1 (15),
+	// then w2 (10)
+	expected := []address.Address{w2, w1, w2, w1, w2}
+	dealUuids := make([]uuid.UUID, 0, len(expected))
+	for _, w := range expected {
+		dealUuid := uuid.New()
+		rsp, err := tag(dealUuid)
+		req.NoError(err)
+		req.Equal(w, rsp.PublishMessageWallet)
+		dealUuids = append(dealUuids, dealUuid)
+	}
+
+	bals, err := fm.BalancesPublishMsg(ctx)
+	req.NoError(err)
+	req.Len(bals, 2)
+	req.Equal(w1, bals[0].Address)
+	req.EqualValues(20, bals[0].Tagged.Int64())
+	req.EqualValues(5, bals[0].Available().Int64())
+	req.Equal(w2, bals[1].Address)
+	req.EqualValues(30, bals[1].Tagged.Int64())
+	req.EqualValues(0, bals[1].Available().Int64())
+
+	total, err := fm.BalancePublishMsg(ctx)
+	req.NoError(err)
+	req.EqualValues(55, total.Int64())
+
+	// Neither wallet has enough available funds for another deal
+	_, err = tag(uuid.New())
+	req.ErrorIs(err, ErrInsufficientFunds)
+
+	// When a deal is untagged, its funds become available in the wallet
+	// they were tagged in
+	_, _, err = fm.UntagFunds(ctx, dealUuids[0])
+	req.NoError(err)
+	rsp, err := tag(uuid.New())
+	req.NoError(err)
+	req.Equal(w2, rsp.PublishMessageWallet)
+}
+
+func TestFundManagerPubMsgWalletWithPendingMessages(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	w1, err := address.NewIDAddress(1001)
+	req.NoError(err)
+	w2, err := address.NewIDAddress(1002)
+	req.NoError(err)
+
+	// w2 has more funds but has a message stuck in the message pool
+	api := &walletsMockApi{
+		balances: map[address.Address]abi.TokenAmount{
+			w1: abi.NewTokenAmount(20),
+			w2: abi.NewTokenAmount(30),
+		},
+		pending: map[address.Address]uint64{w2: 1},
+	}
+	fm := newFundManager(api, db.NewFundsDB(sqldb), Config{
+		StorageMiner:  address.TestAddress,
+		PubMsgWallets: []address.Address{w1, w2},
+		PubMsgBalMin:  abi.NewTokenAmount(10),
+	})
+
+	deals, err := db.GenerateDeals()
+	req.NoError(err)
+	prop := deals[0].ClientDealProposal.Proposal
+	prop.ProviderCollateral = abi.NewTokenAmount(1)
+
+	bals, err := fm.BalancesPublishMsg(ctx)
+	req.NoError(err)
+	req.EqualValues(0, bals[0].Pending)
+	req.EqualValues(1, bals[1].Pending)
+
+	// Funds should be tagged in the wallet without pending messages while
+	// it has enough available funds, and then in the wallet with pending
+	// messages
+	expected := []address.Address{w1, w1, w2, w2, w2}
+	for _, w := range expected {
+		rsp, err := fm.TagFunds(ctx, uuid.New(), prop)
+		req.NoError(err)
+		req.Equal(w, rsp.PublishMessageWallet)
+	}
+
+	_, err = fm.TagFunds(ctx, uuid.New(), prop)
+	req.ErrorIs(err, ErrInsufficientFunds)
+}
+
+type walletsMockApi struct {
+	mockApi
+	balances map[address.Address]abi.TokenAmount
+	// the number of messages from each wallet in the message pool
+	pending map[address.Address]uint64
+}
+
+func (m walletsMockApi) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return &types.Actor{Balance: m.balances[addr], Nonce: 5}, nil
+}
+
+func (m walletsMockApi) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return 5 + m.pending[addr], nil
+}
+
+func (m walletsMockApi) StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (lapi.MarketBalance, error) {
+	return lapi.MarketBalance{
+		Escrow: big.NewInt(1000),
+		Locked: big.NewInt(0),
+	}, nil
+}
+
+func (m walletsMockApi) WalletBalance(ctx context.Context, a address.Address) (types.BigInt, error) {
+	return m.balances[a], nil
+}
+
 type mockApi struct {
 }
 
@@ -125,4 +276,16 @@ func (m mockApi) WalletBalance(ctx context.Context, a address.Address) (types.Bi
 	return big.NewInt(50), nil
 }
 
+func (m mockApi) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*lapi.MsgLookup, error) {
+	return nil, nil
+}
+
+func (m mockApi) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return &types.Actor{}, nil
+}
+
+func (m mockApi) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return 0, nil
+}
+
 var _ fundManagerAPI = (*mockApi)(nil)
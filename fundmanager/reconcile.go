@@ -0,0 +1,212 @@
+package fundmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+// Funds are tagged for a new deal before the deal is saved to the database,
+// so a tag with no deal is only considered orphaned once it's older than
+// the grace period
+const orphanedTagGracePeriod = 10 * time.Minute
+
+// The text of the funds log that records the release of an orphaned tag
+const releaseOrphanedTagLogText = "Release orphaned funds tag"
+
+// OrphanedTag is funds that are tagged for a deal that is no longer active
+type OrphanedTag struct {
+	db.FundsTag
+	// Why the tag is orphaned
+	Reason string
+}
+
+// ReconcileWallet compares the funds tagged in a publish storage deals
+// message wallet with the wallet balance
+type ReconcileWallet struct {
+	Address address.Address
+	Balance abi.TokenAmount
+	// Funds tagged in the wallet for active deals
+	TaggedActive abi.TokenAmount
+	// Funds tagged in the wallet for deals that are no longer active
+	TaggedOrphaned abi.TokenAmount
+	// The amount by which funds tagged for active deals exceed the balance
+	Shortfall abi.TokenAmount
+}
+
+// ReconcileReport compares the funds tagged in the database with the
+// balances on chain
+type ReconcileReport struct {
+	// The storage market actor escrow balance for the storage miner
+	EscrowAvailable abi.TokenAmount
+	EscrowLocked    abi.TokenAmount
+	// Collateral tagged for active deals
+	CollateralTaggedActive abi.TokenAmount
+	// Collateral tagged for deals that are no longer active
+	CollateralTaggedOrphaned abi.TokenAmount
+	// The amount by which collateral tagged for active deals exceeds the
+	// available escrow balance
+	CollateralShortfall abi.TokenAmount
+	// The publish storage deals message wallets
+	PubMsgWallets []ReconcileWallet
+	// Tags for deals that are no longer active
+	Orphaned []OrphanedTag
+	// True if the orphaned tags were released
+	Released bool
+}
+
+// Reconcile compares the funds tagged for active deals with the escrow
+// balance and the publish storage deals message wallet balances, and finds
+// tags for deals that are no longer active.
+// If release is true, the orphaned tags are untagged so that the funds can
+// be used for other deals.
+func (m *FundManager) Reconcile(ctx context.Context, dealsDB *db.DealsDB, release bool) (*ReconcileReport, error) {
+	tags, err := m.db.ListTagged(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	marketBal, err := m.BalanceMarket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting market balance: %w", err)
+	}
+
+	rep := &ReconcileReport{
+		EscrowAvailable:          marketBal.Available,
+		EscrowLocked:             marketBal.Locked,
+		CollateralTaggedActive:   big.Zero(),
+		CollateralTaggedOrphaned: big.Zero(),
+		CollateralShortfall:      big.Zero(),
+		Orphaned:                 []OrphanedTag{},
+	}
+
+	wallets := make(map[address.Address]*ReconcileWallet)
+	var walletOrder []address.Address
+	getWallet := func(a address.Address) *ReconcileWallet {
+		// Funds tagged before wallets were recorded were tagged in the
+		// first wallet
+		if a == address.Undef && len(m.cfg.PubMsgWallets) > 0 {
+			a = m.cfg.PubMsgWallets[0]
+		}
+		w, ok := wallets[a]
+		if !ok {
+			w = &ReconcileWallet{
+				Address:        a,
+				Balance:        big.Zero(),
+				TaggedActive:   big.Zero(),
+				TaggedOrphaned: big.Zero(),
+				Shortfall:      big.Zero(),
+			}
+			wallets[a] = w
+			walletOrder = append(walletOrder, a)
+		}
+		return w
+	}
+	for _, a := range m.cfg.PubMsgWallets {
+		getWallet(a)
+	}
+
+	for _, tag := range tags {
+		reason, err := m.orphanedReason(ctx, dealsDB, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		w := getWallet(tag.PubMsgWallet)
+		if reason == "" {
+			rep.CollateralTaggedActive = big.Add(rep.CollateralTaggedActive, tag.Collateral)
+			w.TaggedActive = big.Add(w.TaggedActive, tag.PubMsg)
+			continue
+		}
+
+		rep.CollateralTaggedOrphaned = big.Add(rep.CollateralTaggedOrphaned, tag.Collateral)
+		w.TaggedOrphaned = big.Add(w.TaggedOrphaned, tag.PubMsg)
+		rep.Orphaned = append(rep.Orphaned, OrphanedTag{FundsTag: tag, Reason: reason})
+	}
+
+	if rep.CollateralTaggedActive.GreaterThan(marketBal.Available) {
+		rep.CollateralShortfall = big.Sub(rep.CollateralTaggedActive, marketBal.Available)
+	}
+
+	for _, a := range walletOrder {
+		w := wallets[a]
+		if a != address.Undef {
+			w.Balance, err = m.api.WalletBalance(ctx, a)
+			if err != nil {
+				return nil, fmt.Errorf("getting balance of publish deals message wallet %s: %w", a, err)
+			}
+		}
+		if w.TaggedActive.GreaterThan(w.Balance) {
+			w.Shortfall = big.Sub(w.TaggedActive, w.Balance)
+		}
+		rep.PubMsgWallets = append(rep.PubMsgWallets, *w)
+	}
+
+	if release {
+		for _, o := range rep.Orphaned {
+			if err := m.releaseOrphanedTag(ctx, o); err != nil {
+				return nil, err
+			}
+		}
+		rep.Released = true
+	}
+
+	return rep, nil
+}
+
+// orphanedReason returns the reason that the tag is orphaned, or the empty
+// string if the tag is for an active deal
+func (m *FundManager) orphanedReason(ctx context.Context, dealsDB *db.DealsDB, tag db.FundsTag) (string, error) {
+	deal, err := dealsDB.ByID(ctx, tag.DealUUID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("getting deal %s: %w", tag.DealUUID, err)
+		}
+		if time.Since(tag.CreatedAt) < orphanedTagGracePeriod {
+			return "", nil
+		}
+		return "deal not found", nil
+	}
+
+	// Funds are untagged once the publish message has been confirmed, or
+	// if the deal fails
+	if deal.Checkpoint < dealcheckpoints.PublishConfirmed {
+		return "", nil
+	}
+	if deal.Err != "" {
+		return "deal failed: " + deal.Err, nil
+	}
+	return fmt.Sprintf("deal publish has already been confirmed (checkpoint %s)", deal.Checkpoint), nil
+}
+
+func (m *FundManager) releaseOrphanedTag(ctx context.Context, o OrphanedTag) error {
+	collat, pub, err := m.db.Untag(ctx, o.DealUUID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			// The tag was released in the meantime
+			return nil
+		}
+		return fmt.Errorf("releasing orphaned funds tag for deal %s: %w", o.DealUUID, err)
+	}
+
+	tot := big.Add(collat, pub)
+	err = m.db.InsertLog(ctx, &db.FundsLog{
+		DealUUID: o.DealUUID,
+		Amount:   tot,
+		Text:     fmt.Sprintf("%s: %s", releaseOrphanedTagLogText, o.Reason),
+	})
+	if err != nil {
+		return fmt.Errorf("persisting release orphaned funds tag log to DB: %w", err)
+	}
+
+	log.Infow("released orphaned funds tag", "id", o.DealUUID, "amount", tot, "reason", o.Reason)
+	return nil
+}
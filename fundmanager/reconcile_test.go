@@ -0,0 +1,126 @@
+package fundmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+	fundsDB := db.NewFundsDB(sqldb)
+	dealsDB := db.NewDealsDB(sqldb)
+
+	fm := newFundManager(&mockApi{}, fundsDB, Config{
+		StorageMiner:  address.TestAddress,
+		PubMsgWallets: []address.Address{address.TestAddress2},
+		PubMsgBalMin:  abi.NewTokenAmount(10),
+	})
+
+	deals, err := db.GenerateDeals()
+	req.NoError(err)
+
+	// An active deal
+	active := deals[0]
+	req.NoError(dealsDB.Insert(ctx, &active))
+	// A deal that failed
+	failed := deals[1]
+	failed.Checkpoint = dealcheckpoints.Complete
+	failed.Err = "data transfer failed"
+	req.NoError(dealsDB.Insert(ctx, &failed))
+	// A deal that has been published
+	published := deals[2]
+	published.Checkpoint = dealcheckpoints.PublishConfirmed
+	req.NoError(dealsDB.Insert(ctx, &published))
+	// A deal that has just been accepted but not yet saved to the DB
+	recent := uuid.New()
+	// A deal that is not in the DB
+	missing := uuid.New()
+
+	tag := func(dealUuid uuid.UUID, collat int64) {
+		err := fundsDB.Tag(ctx, dealUuid, abi.NewTokenAmount(collat), abi.NewTokenAmount(10), address.TestAddress2)
+		req.NoError(err)
+	}
+	tag(active.DealUuid, 6)
+	tag(failed.DealUuid, 1)
+	tag(published.DealUuid, 2)
+	tag(recent, 6)
+	tag(missing, 3)
+
+	// Make the tag for the missing deal older than the grace period
+	_, err = sqldb.ExecContext(ctx, "UPDATE FundsTagged SET CreatedAt = ? WHERE DealUUID = ?",
+		time.Now().Add(-time.Hour).Format(sqlite3.SQLiteTimestampFormats[0]), missing)
+	req.NoError(err)
+
+	rep, err := fm.Reconcile(ctx, dealsDB, false)
+	req.NoError(err)
+	req.False(rep.Released)
+
+	// Available escrow is 10 but there is 12 tagged for active deals
+	req.EqualValues(10, rep.EscrowAvailable.Int64())
+	req.EqualValues(12, rep.CollateralTaggedActive.Int64())
+	req.EqualValues(6, rep.CollateralTaggedOrphaned.Int64())
+	req.EqualValues(2, rep.CollateralShortfall.Int64())
+
+	req.Len(rep.PubMsgWallets, 1)
+	req.Equal(address.TestAddress2, rep.PubMsgWallets[0].Address)
+	req.EqualValues(50, rep.PubMsgWallets[0].Balance.Int64())
+	req.EqualValues(20, rep.PubMsgWallets[0].TaggedActive.Int64())
+	req.EqualValues(30, rep.PubMsgWallets[0].TaggedOrphaned.Int64())
+	req.True(rep.PubMsgWallets[0].Shortfall.IsZero())
+
+	orphaned := make(map[uuid.UUID]string)
+	for _, o := range rep.Orphaned {
+		orphaned[o.DealUUID] = o.Reason
+	}
+	req.Len(orphaned, 3)
+	req.Contains(orphaned[failed.DealUuid], failed.Err)
+	req.Contains(orphaned[published.DealUuid], "PublishConfirmed")
+	req.Contains(orphaned[missing], "not found")
+
+	// Nothing should have been released
+	tags, err := fundsDB.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tags, 5)
+
+	// Release the orphaned tags
+	rep, err = fm.Reconcile(ctx, dealsDB, true)
+	req.NoError(err)
+	req.True(rep.Released)
+	req.Len(rep.Orphaned, 3)
+
+	tags, err = fundsDB.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tags, 2)
+	req.ElementsMatch([]uuid.UUID{active.DealUuid, recent}, []uuid.UUID{tags[0].DealUUID, tags[1].DealUUID})
+
+	// Each release should be recorded in the funds logs
+	logs, err := fundsDB.Logs(ctx, nil, 0, 0)
+	req.NoError(err)
+	released := 0
+	for _, l := range logs {
+		if strings.HasPrefix(l.Text, releaseOrphanedTagLogText) {
+			released++
+		}
+	}
+	req.Equal(3, released)
+
+	// There should be no more orphaned tags
+	rep, err = fm.Reconcile(ctx, dealsDB, false)
+	req.NoError(err)
+	req.Empty(rep.Orphaned)
+}
@@ -3,8 +3,10 @@ package gql
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/dealpublisher"
 	"github.com/filecoin-project/boost/fundmanager"
 	gqltypes "github.com/filecoin-project/boost/gql/types"
 	"github.com/filecoin-project/boost/node/config"
@@ -36,38 +38,40 @@ type dealListResolver struct {
 // resolver translates from a request for a graphql field to the data for
 // that field
 type resolver struct {
-	cfg        *config.Boost
-	repo       lotus_repo.LockedRepo
-	h          host.Host
-	dealsDB    *db.DealsDB
-	logsDB     *db.LogsDB
-	fundsDB    *db.FundsDB
-	fundMgr    *fundmanager.FundManager
-	storageMgr *storagemanager.StorageManager
-	provider   *storagemarket.Provider
-	legacyProv lotus_storagemarket.StorageProvider
-	legacyDT   lotus_dtypes.ProviderDataTransfer
-	publisher  *storageadapter.DealPublisher
-	spApi      sealingpipeline.API
-	fullNode   v1api.FullNode
-}
-
-func NewResolver(cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, logsDB *db.LogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, publisher *storageadapter.DealPublisher, fullNode v1api.FullNode) *resolver {
+	cfg             *config.Boost
+	repo            lotus_repo.LockedRepo
+	h               host.Host
+	dealsDB         *db.DealsDB
+	logsDB          *db.LogsDB
+	fundsDB         *db.FundsDB
+	fundMgr         *fundmanager.FundManager
+	storageMgr      *storagemanager.StorageManager
+	provider        *storagemarket.Provider
+	legacyProv      lotus_storagemarket.StorageProvider
+	legacyDT        lotus_dtypes.ProviderDataTransfer
+	publisher       *dealpublisher.DealPublisher
+	legacyPublisher *storageadapter.DealPublisher
+	spApi           sealingpipeline.API
+	fullNode        v1api.FullNode
+}
+
+func NewResolver(cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, logsDB *db.LogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, publisher *dealpublisher.DealPublisher, legacyPublisher *storageadapter.DealPublisher, fullNode v1api.FullNode) *resolver {
 	return &resolver{
-		cfg:        cfg,
-		repo:       r,
-		h:          h,
-		dealsDB:    dealsDB,
-		logsDB:     logsDB,
-		fundsDB:    fundsDB,
-		fundMgr:    fundMgr,
-		storageMgr: storageMgr,
-		provider:   provider,
-		legacyProv: legacyProv,
-		legacyDT:   legacyDT,
-		publisher:  publisher,
-		spApi:      spApi,
-		fullNode:   fullNode,
+		cfg:             cfg,
+		repo:            r,
+		h:               h,
+		dealsDB:         dealsDB,
+		logsDB:          logsDB,
+		fundsDB:         fundsDB,
+		fundMgr:         fundMgr,
+		storageMgr:      storageMgr,
+		provider:        provider,
+		legacyProv:      legacyProv,
+		legacyDT:        legacyDT,
+		publisher:       publisher,
+		legacyPublisher: legacyPublisher,
+		spApi:           spApi,
+		fullNode:        fullNode,
 	}
 }
 
@@ -77,6 +81,20 @@ type storageResolver struct {
 	Pending     gqltypes.Uint64
 	Free        gqltypes.Uint64
 	MountPoint  string
+	Areas       []*stagingAreaResolver
+	Reclaimable gqltypes.Uint64
+}
+
+type stagingAreaResolver struct {
+	Path          string
+	MaxBytes      gqltypes.Uint64
+	Weight        gqltypes.Uint64
+	Tagged        gqltypes.Uint64
+	Staged        gqltypes.Uint64
+	Transferred   gqltypes.Uint64
+	Free          gqltypes.Uint64
+	DiskAvailable gqltypes.Uint64
+	DiskLow       bool
 }
 
 // query: deal(id) Deal
@@ -91,7 +109,7 @@ func (r *resolver) Deal(ctx context.Context, args struct{ ID graphql.ID }) (*dea
 		return nil, err
 	}
 
-	return newDealResolver(deal, r.dealsDB, r.logsDB, r.spApi), nil
+	return newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi), nil
 }
 
 type dealsArgs struct {
@@ -119,7 +137,7 @@ func (r *resolver) Deals(ctx context.Context, args dealsArgs) (*dealListResolver
 
 	resolvers := make([]*dealResolver, 0, len(deals))
 	for _, deal := range deals {
-		resolvers = append(resolvers, newDealResolver(&deal, r.dealsDB, r.logsDB, r.spApi))
+		resolvers = append(resolvers, newDealResolver(&deal, r.provider, r.dealsDB, r.logsDB, r.spApi))
 	}
 
 	return &dealListResolver{
@@ -152,7 +170,7 @@ func (r *resolver) DealUpdate(ctx context.Context, args struct{ ID graphql.ID })
 	}
 
 	net := make(chan *dealResolver, 1)
-	net <- newDealResolver(deal, r.dealsDB, r.logsDB, r.spApi)
+	net <- newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi)
 
 	// Updates to deal state are broadcast on pubsub. Pipe these updates to the
 	// client
@@ -164,7 +182,7 @@ func (r *resolver) DealUpdate(ctx context.Context, args struct{ ID graphql.ID })
 		}
 		return nil, xerrors.Errorf("%s: subscribing to deal updates: %w", args.ID, err)
 	}
-	sub := &subLastUpdate{sub: dealUpdatesSub, dealsDB: r.dealsDB, logsDB: r.logsDB, spApi: r.spApi}
+	sub := &subLastUpdate{sub: dealUpdatesSub, provider: r.provider, dealsDB: r.dealsDB, logsDB: r.logsDB, spApi: r.spApi}
 	go func() {
 		sub.Pipe(ctx, net) // blocks until connection is closed
 		close(net)
@@ -203,7 +221,7 @@ func (r *resolver) DealNew(ctx context.Context) (<-chan *dealNewResolver, error)
 			case evti := <-sub.Out():
 				// Pipe the deal to the new deal channel
 				di := evti.(types.ProviderDealState)
-				rsv := newDealResolver(&di, r.dealsDB, r.logsDB, r.spApi)
+				rsv := newDealResolver(&di, r.provider, r.dealsDB, r.logsDB, r.spApi)
 				totalCount, err := r.dealsDB.Count(ctx)
 				if err != nil {
 					log.Errorf("getting total deal count: %w", err)
@@ -226,14 +244,34 @@ func (r *resolver) DealNew(ctx context.Context) (<-chan *dealNewResolver, error)
 	return c, nil
 }
 
-// mutation: dealCancel(id): ID
-func (r *resolver) DealCancel(_ context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+type dealCancelArgs struct {
+	ID     graphql.ID
+	Reason *string
+}
+
+// mutation: dealCancel(id, reason): ID
+func (r *resolver) DealCancel(ctx context.Context, args dealCancelArgs) (graphql.ID, error) {
 	dealUuid, err := toUuid(args.ID)
 	if err != nil {
 		return args.ID, err
 	}
 
-	err = r.provider.CancelDealDataTransfer(dealUuid)
+	var reason string
+	if args.Reason != nil {
+		reason = *args.Reason
+	}
+	err = r.provider.CancelDeal(ctx, dealUuid, reason)
+	return args.ID, err
+}
+
+// mutation: dealRetry(id): ID
+func (r *resolver) DealRetry(_ context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	dealUuid, err := toUuid(args.ID)
+	if err != nil {
+		return args.ID, err
+	}
+
+	err = r.provider.RetryDeal(dealUuid)
 	return args.ID, err
 }
 
@@ -293,15 +331,17 @@ func (r *resolver) dealList(ctx context.Context, cursor *graphql.ID, offset int,
 type dealResolver struct {
 	types.ProviderDealState
 	transferred uint64
+	provider    *storagemarket.Provider
 	dealsDB     *db.DealsDB
 	logsDB      *db.LogsDB
 	spApi       sealingpipeline.API
 }
 
-func newDealResolver(deal *types.ProviderDealState, dealsDB *db.DealsDB, logsDB *db.LogsDB, spApi sealingpipeline.API) *dealResolver {
+func newDealResolver(deal *types.ProviderDealState, provider *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, spApi sealingpipeline.API) *dealResolver {
 	return &dealResolver{
 		ProviderDealState: *deal,
 		transferred:       uint64(deal.NBytesReceived),
+		provider:          provider,
 		dealsDB:           dealsDB,
 		logsDB:            logsDB,
 		spApi:             spApi,
@@ -359,6 +399,10 @@ func (dr *dealResolver) Transferred() gqltypes.Uint64 {
 	return gqltypes.Uint64(dr.ProviderDealState.NBytesReceived)
 }
 
+func (dr *dealResolver) TransferQueuePosition() int32 {
+	return int32(dr.provider.TransferQueuePosition(dr.DealUuid))
+}
+
 type sectorResolver struct {
 	ID     gqltypes.Uint64
 	Offset gqltypes.Uint64
@@ -436,9 +480,12 @@ func (dr *dealResolver) Message(ctx context.Context) string {
 		if dr.IsOffline {
 			return "Awaiting Offline Data Import"
 		}
+		if pos := dr.provider.TransferQueuePosition(dr.DealUuid); pos > 0 {
+			return fmt.Sprintf("Transfer Queued (position %d)", pos)
+		}
 		switch dr.transferred {
 		case 0:
-			return "Transfer Queued"
+			return "Transfer Starting"
 		case 100:
 			return "Transfer Complete"
 		default:
@@ -450,17 +497,29 @@ func (dr *dealResolver) Message(ctx context.Context) string {
 	case dealcheckpoints.Published:
 		return "Awaiting Publish Confirmation"
 	case dealcheckpoints.PublishConfirmed:
+		if pos := dr.provider.AddPieceQueuePosition(dr.DealUuid); pos > 0 {
+			return fmt.Sprintf("Waiting for Sealing Capacity (position %d)", pos)
+		}
 		return "Adding to Sector"
 	case dealcheckpoints.AddedPiece:
 		return "Announcing"
 	case dealcheckpoints.IndexedAndAnnounced:
 		return dr.sealingState(ctx)
+	case dealcheckpoints.PreCommitted:
+		return "Sector Pre-committed on Chain"
+	case dealcheckpoints.Active:
+		return "Active on Chain"
+	case dealcheckpoints.Expired:
+		return "Expired"
+	case dealcheckpoints.Slashed:
+		return "Slashed"
 	case dealcheckpoints.Complete:
-		switch dr.Err {
-		case "":
+		switch {
+		case dr.Err == "":
 			return "Complete"
-		case "Cancelled":
-			return "Cancelled"
+		case dr.Err == storagemarket.DealCancelled || strings.HasPrefix(dr.Err, storagemarket.DealCancelled+": "):
+			// the error is the reason the deal was cancelled, if any
+			return dr.Err
 		}
 		return "Error: " + dr.Err
 	}
@@ -528,10 +587,11 @@ func toUuid(id graphql.ID) (uuid.UUID, error) {
 }
 
 type subLastUpdate struct {
-	sub     event.Subscription
-	dealsDB *db.DealsDB
-	logsDB  *db.LogsDB
-	spApi   sealingpipeline.API
+	sub      event.Subscription
+	provider *storagemarket.Provider
+	dealsDB  *db.DealsDB
+	logsDB   *db.LogsDB
+	spApi    sealingpipeline.API
 }
 
 func (s *subLastUpdate) Pipe(ctx context.Context, net chan *dealResolver) {
@@ -570,7 +630,7 @@ func (s *subLastUpdate) Pipe(ctx context.Context, net chan *dealResolver) {
 	loop:
 		for {
 			di := lastUpdate.(types.ProviderDealState)
-			rsv := newDealResolver(&di, s.dealsDB, s.logsDB, s.spApi)
+			rsv := newDealResolver(&di, s.provider, s.dealsDB, s.logsDB, s.spApi)
 
 			select {
 			case <-ctx.Done():
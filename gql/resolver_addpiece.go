@@ -0,0 +1,42 @@
+package gql
+
+import (
+	"context"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type addPieceQueueResolver struct {
+	Deals                []*dealResolver
+	Waiting              bool
+	Reason               string
+	CheckedAt            graphql.Time
+	MaxSectorsPreCommit1 gqltypes.Uint64
+	MaxSectorsWaitDeals  gqltypes.Uint64
+	MaxPreCommit1Jobs    gqltypes.Uint64
+}
+
+// query: addPieceQueue: AddPieceQueue
+func (r *resolver) AddPieceQueue(ctx context.Context) (*addPieceQueueResolver, error) {
+	queue := r.provider.AddPieceQueue()
+
+	deals := make([]*dealResolver, 0, len(queue.Deals))
+	for _, dealUuid := range queue.Deals {
+		deal, err := r.dealByID(ctx, dealUuid)
+		if err != nil {
+			return nil, err
+		}
+		deals = append(deals, newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi))
+	}
+
+	return &addPieceQueueResolver{
+		Deals:                deals,
+		Waiting:              queue.Waiting,
+		Reason:               queue.Reason,
+		CheckedAt:            graphql.Time{Time: queue.CheckedAt},
+		MaxSectorsPreCommit1: gqltypes.Uint64(queue.Config.MaxSectorsPreCommit1),
+		MaxSectorsWaitDeals:  gqltypes.Uint64(queue.Config.MaxSectorsWaitDeals),
+		MaxPreCommit1Jobs:    gqltypes.Uint64(queue.Config.MaxPreCommit1Jobs),
+	}, nil
+}
@@ -0,0 +1,58 @@
+package gql
+
+import (
+	"context"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+)
+
+type clientQuotaUsageResolver struct {
+	Client              string
+	IsPeer              bool
+	Allowlisted         bool
+	ProposalsLastMinute gqltypes.Uint64
+	ActiveDeals         gqltypes.Uint64
+	BytesLastDay        gqltypes.Uint64
+}
+
+type clientQuotasResolver struct {
+	MaxProposalsPerMinute gqltypes.Uint64
+	MaxActiveDeals        gqltypes.Uint64
+	MaxBytesPerDay        gqltypes.Uint64
+	Allowlist             []string
+	Usage                 []*clientQuotaUsageResolver
+}
+
+// query: clientQuotas: ClientQuotas
+func (r *resolver) ClientQuotas(ctx context.Context) (*clientQuotasResolver, error) {
+	quotas := r.provider.ClientQuotas()
+	usage, err := r.provider.ClientQuotaUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usageResolvers := make([]*clientQuotaUsageResolver, 0, len(usage))
+	for _, u := range usage {
+		usageResolvers = append(usageResolvers, &clientQuotaUsageResolver{
+			Client:              u.Client,
+			IsPeer:              u.IsPeer,
+			Allowlisted:         u.Allowlisted,
+			ProposalsLastMinute: gqltypes.Uint64(u.ProposalsLastMinute),
+			ActiveDeals:         gqltypes.Uint64(u.ActiveDeals),
+			BytesLastDay:        gqltypes.Uint64(u.BytesLastDay),
+		})
+	}
+
+	allowlist := quotas.Allowlist
+	if allowlist == nil {
+		allowlist = []string{}
+	}
+
+	return &clientQuotasResolver{
+		MaxProposalsPerMinute: gqltypes.Uint64(quotas.MaxProposalsPerMinute),
+		MaxActiveDeals:        gqltypes.Uint64(quotas.MaxActiveDeals),
+		MaxBytesPerDay:        gqltypes.Uint64(quotas.MaxBytesPerDay),
+		Allowlist:             allowlist,
+		Usage:                 usageResolvers,
+	}, nil
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/filecoin-project/boost/gql/types"
 	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/graph-gophers/graphql-go"
 	"golang.org/x/xerrors"
 )
@@ -36,16 +38,26 @@ type dealPublishResolver struct {
 	Period         int32
 	MaxDealsPerMsg int32
 	Deals          []*basicDealResolver
+	Waiting        bool
+	Reason         string
+	BaseFee        types.BigInt
+	MaxBaseFee     types.BigInt
+	ProjectedCost  types.BigInt
+	MaxFee         types.BigInt
+	Deadline       types.Uint64
 }
 
 // query: dealPublish: DealPublish
 func (r *resolver) DealPublish(ctx context.Context) (*dealPublishResolver, error) {
-	// Get deals pending publish from deal publisher
+	// Get deals pending publish from the Boost deal publisher and from the
+	// legacy deal publisher
 	pending := r.publisher.PendingDeals()
+	legacyPending := r.legacyPublisher.PendingDeals()
+	pendingDeals := append(pending.Deals, legacyPending.Deals...)
 
-	legacyDealIDs := make(map[string]struct{}, len(pending.Deals))
-	basicDeals := make([]*basicDealResolver, 0, len(pending.Deals))
-	for _, dp := range pending.Deals {
+	legacyDealIDs := make(map[string]struct{}, len(pendingDeals))
+	basicDeals := make([]*basicDealResolver, 0, len(pendingDeals))
+	for _, dp := range pendingDeals {
 		signedProp, err := cborutil.AsIpld(&dp)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to compute signed deal proposal ipld node: %w", err)
@@ -150,16 +162,33 @@ func (r *resolver) DealPublish(ctx context.Context) (*dealPublishResolver, error
 		}
 	}
 
+	st := pending.Status
 	return &dealPublishResolver{
 		Deals:          basicDeals,
-		Period:         int32(pending.PublishPeriod.Seconds()),
-		Start:          graphql.Time{Time: pending.PublishPeriodStart},
-		MaxDealsPerMsg: int32(r.cfg.LotusDealmaking.MaxDealsPerPublishMsg),
+		Period:         int32(pending.Period.Seconds()),
+		Start:          graphql.Time{Time: pending.PeriodStart},
+		MaxDealsPerMsg: int32(pending.MaxDealsPerMsg),
+		Waiting:        st.Waiting,
+		Reason:         st.Reason,
+		BaseFee:        toBigInt(st.BaseFee),
+		MaxBaseFee:     toBigInt(abi.TokenAmount(r.cfg.Dealmaking.PublishMsgMaxBaseFee)),
+		ProjectedCost:  toBigInt(st.ProjectedCost),
+		MaxFee:         toBigInt(abi.TokenAmount(r.cfg.Dealmaking.PublishMsgMaxFee)),
+		Deadline:       types.Uint64(st.Deadline),
 	}, nil
 }
 
+// toBigInt converts a token amount that may not have been set to a BigInt
+func toBigInt(amt abi.TokenAmount) types.BigInt {
+	if amt.Int == nil {
+		return types.BigInt{Int: big.Zero()}
+	}
+	return types.BigInt{Int: amt}
+}
+
 // mutation: dealPublishNow(): bool
 func (r *resolver) DealPublishNow(ctx context.Context) (bool, error) {
 	r.publisher.ForcePublishPendingDeals()
+	r.legacyPublisher.ForcePublishPendingDeals()
 	return true, nil
 }
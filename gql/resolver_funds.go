@@ -6,7 +6,9 @@ import (
 	"time"
 
 	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/filecoin-project/go-address"
 	"github.com/graph-gophers/graphql-go"
+	"github.com/ipfs/go-cid"
 )
 
 type fundsEscrow struct {
@@ -21,10 +23,24 @@ type fundsWallet struct {
 	Tagged  gqltypes.BigInt
 }
 
+type fundsAutoTopUp struct {
+	Enabled         bool
+	LowWater        gqltypes.BigInt
+	HighWater       gqltypes.BigInt
+	MaxPerTopUp     gqltypes.BigInt
+	DailyCap        gqltypes.BigInt
+	ToppedUpLastDay gqltypes.BigInt
+	PendingMsg      string
+	LastTopUpAt     *graphql.Time
+	LastTopUpAmount gqltypes.BigInt
+	LastError       string
+}
+
 type funds struct {
 	Escrow     fundsEscrow
 	Collateral fundsWallet
-	PubMsg     fundsWallet
+	PubMsg     []fundsWallet
+	AutoTopUp  fundsAutoTopUp
 }
 
 // query: funds: Funds
@@ -39,9 +55,17 @@ func (r *resolver) Funds(ctx context.Context) (*funds, error) {
 		return nil, fmt.Errorf("getting market balance: %w", err)
 	}
 
-	balPubMsg, err := r.fundMgr.BalancePublishMsg(ctx)
+	balsPubMsg, err := r.fundMgr.BalancesPublishMsg(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getting publish message balance: %w", err)
+		return nil, fmt.Errorf("getting publish message balances: %w", err)
+	}
+	pubMsg := make([]fundsWallet, 0, len(balsPubMsg))
+	for _, b := range balsPubMsg {
+		pubMsg = append(pubMsg, fundsWallet{
+			Address: b.Address.String(),
+			Balance: gqltypes.BigInt{Int: b.Balance},
+			Tagged:  gqltypes.BigInt{Int: b.Tagged},
+		})
 	}
 
 	balCollateral, err := r.fundMgr.BalancePledgeCollateral(ctx)
@@ -49,6 +73,19 @@ func (r *resolver) Funds(ctx context.Context) (*funds, error) {
 		return nil, fmt.Errorf("getting pledge collateral balance: %w", err)
 	}
 
+	topUp, err := r.fundMgr.AutoTopUpStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting escrow auto top-up status: %w", err)
+	}
+	var pendingMsg string
+	if topUp.PendingMsg != cid.Undef {
+		pendingMsg = topUp.PendingMsg.String()
+	}
+	var lastTopUpAt *graphql.Time
+	if !topUp.LastTopUpAt.IsZero() {
+		lastTopUpAt = &graphql.Time{Time: topUp.LastTopUpAt}
+	}
+
 	return &funds{
 		Escrow: fundsEscrow{
 			Tagged:    gqltypes.BigInt{Int: tagged.Collateral},
@@ -59,14 +96,105 @@ func (r *resolver) Funds(ctx context.Context) (*funds, error) {
 			Address: r.fundMgr.AddressPledgeCollateral().String(),
 			Balance: gqltypes.BigInt{Int: balCollateral},
 		},
-		PubMsg: fundsWallet{
-			Address: r.fundMgr.AddressPublishMsg().String(),
-			Balance: gqltypes.BigInt{Int: balPubMsg},
-			Tagged:  gqltypes.BigInt{Int: tagged.PubMsg},
+		PubMsg: pubMsg,
+		AutoTopUp: fundsAutoTopUp{
+			Enabled:         topUp.Enabled,
+			LowWater:        gqltypes.BigInt{Int: topUp.LowWater},
+			HighWater:       gqltypes.BigInt{Int: topUp.HighWater},
+			MaxPerTopUp:     gqltypes.BigInt{Int: topUp.MaxPerTopUp},
+			DailyCap:        gqltypes.BigInt{Int: topUp.DailyCap},
+			ToppedUpLastDay: gqltypes.BigInt{Int: topUp.ToppedUpLastDay},
+			PendingMsg:      pendingMsg,
+			LastTopUpAt:     lastTopUpAt,
+			LastTopUpAmount: gqltypes.BigInt{Int: topUp.LastTopUpAmount},
+			LastError:       topUp.LastError,
 		},
 	}, nil
 }
 
+type fundsReconcileWallet struct {
+	Address        string
+	Balance        gqltypes.BigInt
+	TaggedActive   gqltypes.BigInt
+	TaggedOrphaned gqltypes.BigInt
+	Shortfall      gqltypes.BigInt
+}
+
+type fundsOrphanedTag struct {
+	DealUUID     graphql.ID
+	CreatedAt    graphql.Time
+	Collateral   gqltypes.BigInt
+	PubMsg       gqltypes.BigInt
+	PubMsgWallet string
+	Reason       string
+}
+
+type fundsReconcile struct {
+	EscrowAvailable          gqltypes.BigInt
+	EscrowLocked             gqltypes.BigInt
+	CollateralTaggedActive   gqltypes.BigInt
+	CollateralTaggedOrphaned gqltypes.BigInt
+	CollateralShortfall      gqltypes.BigInt
+	PubMsgWallets            []fundsReconcileWallet
+	Orphaned                 []fundsOrphanedTag
+	Released                 bool
+}
+
+// query: fundsReconcile: FundsReconcile
+func (r *resolver) FundsReconcile(ctx context.Context) (*fundsReconcile, error) {
+	return r.fundsReconcile(ctx, false)
+}
+
+// mutation: fundsReleaseOrphanedTags: FundsReconcile
+func (r *resolver) FundsReleaseOrphanedTags(ctx context.Context) (*fundsReconcile, error) {
+	return r.fundsReconcile(ctx, true)
+}
+
+func (r *resolver) fundsReconcile(ctx context.Context, release bool) (*fundsReconcile, error) {
+	rep, err := r.fundMgr.Reconcile(ctx, r.dealsDB, release)
+	if err != nil {
+		return nil, fmt.Errorf("reconciling funds: %w", err)
+	}
+
+	wallets := make([]fundsReconcileWallet, 0, len(rep.PubMsgWallets))
+	for _, w := range rep.PubMsgWallets {
+		wallets = append(wallets, fundsReconcileWallet{
+			Address:        w.Address.String(),
+			Balance:        gqltypes.BigInt{Int: w.Balance},
+			TaggedActive:   gqltypes.BigInt{Int: w.TaggedActive},
+			TaggedOrphaned: gqltypes.BigInt{Int: w.TaggedOrphaned},
+			Shortfall:      gqltypes.BigInt{Int: w.Shortfall},
+		})
+	}
+
+	orphaned := make([]fundsOrphanedTag, 0, len(rep.Orphaned))
+	for _, o := range rep.Orphaned {
+		var wallet string
+		if o.PubMsgWallet != address.Undef {
+			wallet = o.PubMsgWallet.String()
+		}
+		orphaned = append(orphaned, fundsOrphanedTag{
+			DealUUID:     graphql.ID(o.DealUUID.String()),
+			CreatedAt:    graphql.Time{Time: o.CreatedAt},
+			Collateral:   gqltypes.BigInt{Int: o.Collateral},
+			PubMsg:       gqltypes.BigInt{Int: o.PubMsg},
+			PubMsgWallet: wallet,
+			Reason:       o.Reason,
+		})
+	}
+
+	return &fundsReconcile{
+		EscrowAvailable:          gqltypes.BigInt{Int: rep.EscrowAvailable},
+		EscrowLocked:             gqltypes.BigInt{Int: rep.EscrowLocked},
+		CollateralTaggedActive:   gqltypes.BigInt{Int: rep.CollateralTaggedActive},
+		CollateralTaggedOrphaned: gqltypes.BigInt{Int: rep.CollateralTaggedOrphaned},
+		CollateralShortfall:      gqltypes.BigInt{Int: rep.CollateralShortfall},
+		PubMsgWallets:            wallets,
+		Orphaned:                 orphaned,
+		Released:                 rep.Released,
+	}, nil
+}
+
 type fundsLogList struct {
 	TotalCount int32
 	Logs       []*fundsLogResolver
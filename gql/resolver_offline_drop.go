@@ -0,0 +1,38 @@
+package gql
+
+import (
+	"context"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type offlineDropFileResolver struct {
+	Path      string
+	Size      gqltypes.Uint64
+	FirstSeen graphql.Time
+	PieceCid  string
+	Error     string
+}
+
+// query: offlineDropUnmatched: [OfflineDropFile]
+func (r *resolver) OfflineDropUnmatched(_ context.Context) ([]*offlineDropFileResolver, error) {
+	files := r.provider.OfflineDropUnmatched()
+
+	resolvers := make([]*offlineDropFileResolver, 0, len(files))
+	for _, f := range files {
+		pieceCid := ""
+		if f.PieceCID.Defined() {
+			pieceCid = f.PieceCID.String()
+		}
+		resolvers = append(resolvers, &offlineDropFileResolver{
+			Path:      f.Path,
+			Size:      gqltypes.Uint64(f.Size),
+			FirstSeen: graphql.Time{Time: f.FirstSeen},
+			PieceCid:  pieceCid,
+			Error:     f.Error,
+		})
+	}
+
+	return resolvers, nil
+}
@@ -14,10 +14,26 @@ func (r *resolver) Storage(ctx context.Context) (*storageResolver, error) {
 		return nil, err
 	}
 
-	free, err := r.storageMgr.Free(ctx)
+	areasUsage, err := r.storageMgr.StagingAreas(ctx)
 	if err != nil {
 		return nil, err
 	}
+	free := r.storageMgr.FreeSpace(tagged, areasUsage)
+	areas := make([]*stagingAreaResolver, 0, len(areasUsage))
+	areasByPath := make(map[string]*stagingAreaResolver, len(areasUsage))
+	for _, u := range areasUsage {
+		a := &stagingAreaResolver{
+			Path:          u.Path,
+			MaxBytes:      gqltypes.Uint64(u.MaxBytes),
+			Weight:        gqltypes.Uint64(u.Weight),
+			Tagged:        gqltypes.Uint64(u.Tagged),
+			Free:          gqltypes.Uint64(u.Free()),
+			DiskAvailable: gqltypes.Uint64(u.DiskAvailable),
+			DiskLow:       u.DiskLow,
+		}
+		areas = append(areas, a)
+		areasByPath[u.Path] = a
+	}
 
 	activeDeals, err := r.dealsDB.ListActive(ctx)
 	if err != nil {
@@ -33,10 +49,18 @@ func (r *resolver) Storage(ctx context.Context) (*storageResolver, error) {
 			continue
 		}
 
+		area := areasByPath[r.storageMgr.StagingAreaPath(deal.InboundFilePath)]
 		if deal.Checkpoint < dealcheckpoints.Transferred {
-			transferred += r.provider.NBytesReceived(deal.DealUuid)
+			received := r.provider.NBytesReceived(deal.DealUuid)
+			transferred += received
+			if area != nil {
+				area.Transferred += gqltypes.Uint64(received)
+			}
 		} else if deal.Checkpoint < dealcheckpoints.AddedPiece {
 			staged += deal.Transfer.Size
+			if area != nil {
+				area.Staged += gqltypes.Uint64(deal.Transfer.Size)
+			}
 		} else {
 			sealing += deal.Transfer.Size
 		}
@@ -50,5 +74,7 @@ func (r *resolver) Storage(ctx context.Context) (*storageResolver, error) {
 		Pending:     gqltypes.Uint64(tagged - transferred - staged),
 		Free:        gqltypes.Uint64(free),
 		MountPoint:  r.storageMgr.StagingAreaDirPath,
+		Areas:       areas,
+		Reclaimable: gqltypes.Uint64(r.provider.StagingReclaimableBytes()),
 	}, nil
 }
@@ -2,6 +2,7 @@ package gql
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"time"
 
@@ -55,3 +56,71 @@ func (r *resolver) Transfers(_ context.Context) ([]*transferPoint, error) {
 
 	return pts, nil
 }
+
+// query: transferQueue: [Deal]
+func (r *resolver) TransferQueue(ctx context.Context) ([]*dealResolver, error) {
+	queued := r.provider.QueuedTransfers()
+
+	resolvers := make([]*dealResolver, 0, len(queued))
+	for _, dealUuid := range queued {
+		deal, err := r.dealByID(ctx, dealUuid)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi))
+	}
+
+	return resolvers, nil
+}
+
+type transferLimitsResolver struct {
+	Total     gqltypes.Uint64
+	PerDeal   gqltypes.Uint64
+	PerClient gqltypes.Uint64
+}
+
+// query: transferLimits: TransferLimits
+func (r *resolver) TransferLimits(_ context.Context) (*transferLimitsResolver, error) {
+	limits, err := r.provider.TransferBandwidthLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	return &transferLimitsResolver{
+		Total:     gqltypes.Uint64(limits.Total),
+		PerDeal:   gqltypes.Uint64(limits.PerDeal),
+		PerClient: gqltypes.Uint64(limits.PerClient),
+	}, nil
+}
+
+type transferLimitsUpdate struct {
+	Total     *gqltypes.Uint64
+	PerDeal   *gqltypes.Uint64
+	PerClient *gqltypes.Uint64
+}
+
+// mutation: transferLimitsUpdate(update: TransferLimitsUpdate!): Boolean!
+func (r *resolver) TransferLimitsUpdate(args struct{ Update transferLimitsUpdate }) (bool, error) {
+	limits, err := r.provider.TransferBandwidthLimits()
+	if err != nil {
+		return false, err
+	}
+
+	update := args.Update
+	if update.Total != nil {
+		limits.Total = uint64(*update.Total)
+	}
+	if update.PerDeal != nil {
+		limits.PerDeal = uint64(*update.PerDeal)
+	}
+	if update.PerClient != nil {
+		limits.PerClient = uint64(*update.PerClient)
+	}
+
+	err = r.provider.SetTransferBandwidthLimits(limits)
+	if err != nil {
+		return false, fmt.Errorf("setting transfer bandwidth limits: %w", err)
+	}
+
+	return true, nil
+}
@@ -173,7 +173,8 @@ func (w *Wrapper) AnnounceBoostDeal(ctx context.Context, pds *types.ProviderDeal
 }
 
 func (w *Wrapper) DagstoreReinitBoostDeals(ctx context.Context) (bool, error) {
-	deals, err := w.dealsDB.ListActive(ctx)
+	deals, err := w.dealsDB.ListByCheckpoint(ctx, dealcheckpoints.IndexedAndAnnounced,
+		dealcheckpoints.PreCommitted, dealcheckpoints.Active)
 	if err != nil {
 		return false, fmt.Errorf("failed to list active Boost deals: %w", err)
 	}
@@ -272,6 +272,7 @@ func (f *TestFramework) Start() error {
 	cfg.Dealmaking.PublishMsgMaxDealsPerMsg = 1
 	cfg.Dealmaking.PublishMsgPeriod = config.Duration(0)
 	cfg.Dealmaking.MaxStagingDealsBytes = 4000000 // 4 MB
+	cfg.Dealmaking.StagingAreaMinFreeBytes = 0
 	cfg.Storage.ParallelFetchLimit = 10
 
 	err = lr.SetConfig(func(raw interface{}) {
@@ -460,7 +461,9 @@ func (f *TestFramework) WaitForDealAddedToSector(dealUuid uuid.UUID) error {
 					return fmt.Errorf("Deal Error: %s", resp.DealStatus.Error)
 				}
 				return nil
-			case resp.DealStatus.Status == dealcheckpoints.IndexedAndAnnounced.String():
+			case resp.DealStatus.Status == dealcheckpoints.IndexedAndAnnounced.String(),
+				resp.DealStatus.Status == dealcheckpoints.PreCommitted.String(),
+				resp.DealStatus.Status == dealcheckpoints.Active.String():
 				return nil
 			}
 		}
@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/filecoin-project/boost/indexprovider"
-	"github.com/filecoin-project/boost/storagemarket/dealfilter"
 
 	provider "github.com/filecoin-project/index-provider"
 	"github.com/filecoin-project/lotus/markets/idxprov"
@@ -16,6 +15,7 @@ import (
 	"github.com/filecoin-project/boost/api"
 	"github.com/filecoin-project/boost/build"
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/dealpublisher"
 	"github.com/filecoin-project/boost/fundmanager"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/node/config"
@@ -404,10 +404,39 @@ func ConfigBoost(c interface{}) Option {
 	if err != nil {
 		return Error(fmt.Errorf("failed to parse cfg.Wallets.PublishStorageDeals: %s; err: %w", cfg.Wallets.PublishStorageDeals, err))
 	}
+	walletsPSD := []address.Address{walletPSD}
+	for _, w := range cfg.Wallets.AdditionalPublishStorageDeals {
+		addr, err := address.NewFromString(w)
+		if err != nil {
+			return Error(fmt.Errorf("failed to parse cfg.Wallets.AdditionalPublishStorageDeals: %s; err: %w", w, err))
+		}
+		walletsPSD = append(walletsPSD, addr)
+	}
 	walletMiner, err := address.NewFromString(cfg.Wallets.Miner)
 	if err != nil {
 		return Error(fmt.Errorf("failed to parse cfg.Wallets.Miner: %s; err: %w", cfg.Wallets.Miner, err))
 	}
+	if cfg.Funds.EscrowAutoTopUp && abi.TokenAmount(cfg.Funds.EscrowHighWater).LessThanEqual(abi.TokenAmount(cfg.Funds.EscrowLowWater)) {
+		return Error(fmt.Errorf("cfg.Funds.EscrowHighWater %s must be greater than cfg.Funds.EscrowLowWater %s", cfg.Funds.EscrowHighWater, cfg.Funds.EscrowLowWater))
+	}
+
+	stagingAreas := make([]storagemanager.StagingArea, 0, len(cfg.Dealmaking.StagingAreas))
+	for i, a := range cfg.Dealmaking.StagingAreas {
+		if a.Path == "" {
+			return Error(fmt.Errorf("cfg.Dealmaking.StagingAreas[%d].Path must be set", i))
+		}
+		if a.MaxBytes < 0 {
+			return Error(fmt.Errorf("cfg.Dealmaking.StagingAreas[%d].MaxBytes must not be negative", i))
+		}
+		stagingAreas = append(stagingAreas, storagemanager.StagingArea{
+			Path:     a.Path,
+			MaxBytes: uint64(a.MaxBytes),
+			Weight:   a.Weight,
+		})
+	}
+	if cfg.Dealmaking.StagingAreaMinFreeBytes < 0 {
+		return Error(fmt.Errorf("cfg.Dealmaking.StagingAreaMinFreeBytes must not be negative"))
+	}
 
 	return Options(
 		ConfigCommon(&cfg.Common),
@@ -419,14 +448,23 @@ func ConfigBoost(c interface{}) Option {
 		Override(new(*stores.Remote), lotus_modules.RemoteStorage),
 
 		Override(new(*fundmanager.FundManager), fundmanager.New(fundmanager.Config{
-			StorageMiner: walletMiner,
-			CollatWallet: walletPledgeCollat,
-			PubMsgWallet: walletPSD,
-			PubMsgBalMin: abi.NewTokenAmount(1000), // TODO: add to node config
+			StorageMiner:  walletMiner,
+			CollatWallet:  walletPledgeCollat,
+			PubMsgWallets: walletsPSD,
+			PubMsgBalMin:  abi.NewTokenAmount(1000), // TODO: add to node config
+			AutoTopUp: fundmanager.AutoTopUpConfig{
+				Enabled:     cfg.Funds.EscrowAutoTopUp,
+				LowWater:    abi.TokenAmount(cfg.Funds.EscrowLowWater),
+				HighWater:   abi.TokenAmount(cfg.Funds.EscrowHighWater),
+				MaxPerTopUp: abi.TokenAmount(cfg.Funds.EscrowMaxTopUp),
+				DailyCap:    abi.TokenAmount(cfg.Funds.EscrowDailyTopUpCap),
+			},
 		})),
 
 		Override(new(*storagemanager.StorageManager), storagemanager.New(storagemanager.Config{
 			MaxStagingDealsBytes: uint64(cfg.Dealmaking.MaxStagingDealsBytes),
+			StagingAreas:         stagingAreas,
+			MinFreeDiskBytes:     uint64(cfg.Dealmaking.StagingAreaMinFreeBytes),
 		})),
 
 		// Sector API
@@ -440,15 +478,16 @@ func ConfigBoost(c interface{}) Option {
 		Override(new(*indexprovider.Wrapper), indexprovider.NewWrapper(cfg.DAGStore)),
 
 		Override(new(*storagemarket.ChainDealManager), modules.NewChainDealManager),
+		Override(new(*storagemarket.SectorCommittedManager), modules.NewSectorCommittedManager),
 
-		Override(new(*storagemarket.Provider), modules.NewStorageMarketProvider(walletMiner)),
+		Override(new(*storagemarket.Provider), modules.NewStorageMarketProvider(walletMiner, cfg)),
 
 		// GraphQL server
 		Override(new(*gql.Server), modules.NewGraphqlServer(cfg)),
 
 		// Address selector
 		Override(new(*storage.AddressSelector), lotus_modules.AddressSelector(&lotus_config.MinerAddressConfig{
-			DealPublishControl: []string{cfg.Wallets.PublishStorageDeals},
+			DealPublishControl: append([]string{cfg.Wallets.PublishStorageDeals}, cfg.Wallets.AdditionalPublishStorageDeals...),
 		})),
 
 		// Lotus Markets
@@ -494,10 +533,7 @@ func ConfigBoost(c interface{}) Option {
 		Override(HandleIndexProviderKey, modules.HandleIndexProvider),
 
 		// Boost storage deal filter
-		Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, nil)),
-		If(cfg.Dealmaking.Filter != "",
-			Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, dealfilter.CliStorageDealFilter(cfg.Dealmaking.Filter))),
-		),
+		Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, modules.UserStorageDealFilter(cfg.Dealmaking))),
 
 		// Lotus markets storage deal filter
 		Override(new(lotus_dtypes.StorageDealFilter), lotus_modules.BasicDealFilter(cfg.LotusDealmaking, nil)),
@@ -506,10 +542,7 @@ func ConfigBoost(c interface{}) Option {
 		),
 
 		// Boost retrieval deal filter
-		Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(nil)),
-		If(cfg.Dealmaking.RetrievalFilter != "",
-			Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(dealfilter.CliRetrievalDealFilter(cfg.Dealmaking.RetrievalFilter))),
-		),
+		Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(modules.UserRetrievalDealFilter(cfg.Dealmaking))),
 
 		// Lotus markets retrieval deal filter
 		Override(new(lotus_dtypes.RetrievalDealFilter), lotus_modules.RetrievalDealFilter(nil)),
@@ -523,6 +556,17 @@ func ConfigBoost(c interface{}) Option {
 			StartEpochSealingBuffer: cfg.LotusDealmaking.StartEpochSealingBuffer,
 		})),
 
+		// Publishes Boost deals, holding them while the base fee is high
+		Override(new(*dealpublisher.DealPublisher), dealpublisher.New(dealpublisher.Config{
+			Miner:                  walletMiner,
+			Wallets:                walletsPSD,
+			Period:                 time.Duration(cfg.Dealmaking.PublishMsgPeriod),
+			MaxDealsPerMsg:         cfg.Dealmaking.PublishMsgMaxDealsPerMsg,
+			MaxFee:                 abi.TokenAmount(cfg.Dealmaking.PublishMsgMaxFee),
+			MaxBaseFee:             abi.TokenAmount(cfg.Dealmaking.PublishMsgMaxBaseFee),
+			StartEpochSafetyMargin: abi.ChainEpoch(cfg.Dealmaking.PublishMsgStartEpochSafetyMargin),
+		})),
+
 		Override(new(sectorstorage.Unsealer), From(new(lotus_modules.MinerStorageService))),
 		Override(new(stores.SectorIndex), From(new(lotus_modules.MinerSealingService))),
 		Override(new(sectorstorage.SealerConfig), cfg.Storage),
@@ -74,18 +74,57 @@ func DefaultBoost() *Boost {
 			ConsiderUnverifiedStorageDeals: true,
 			PieceCidBlocklist:              []cid.Cid{},
 			// TODO: It'd be nice to set this based on sector size
-			MaxDealStartDelay:               Duration(time.Hour * 24 * 14),
-			ExpectedSealDuration:            Duration(time.Hour * 24),
-			PublishMsgPeriod:                Duration(time.Hour),
-			PublishMsgMaxDealsPerMsg:        8,
-			PublishMsgMaxFee:                types.MustParseFIL("0.05"),
-			MaxProviderCollateralMultiplier: 2,
+			MaxDealStartDelay:                Duration(time.Hour * 24 * 14),
+			ExpectedSealDuration:             Duration(time.Hour * 24),
+			PublishMsgPeriod:                 Duration(time.Hour),
+			PublishMsgMaxDealsPerMsg:         8,
+			PublishMsgMaxFee:                 types.MustParseFIL("0.05"),
+			PublishMsgMaxBaseFee:             types.MustParseFIL("0"),
+			PublishMsgStartEpochSafetyMargin: 2880,
+			MaxProviderCollateralMultiplier:  2,
+			StagingAreas:                     []StagingAreaConfig{},
+			StagingAreaMinFreeBytes:          10 << 30, // 10 GiB
+			StagingGCInterval:                Duration(time.Hour),
+			StagingGCGracePeriod:             Duration(24 * time.Hour),
+			StagingGCDryRun:                  false,
+			FailedDealDataRetention:          Duration(72 * time.Hour),
+
+			SimultaneousTransfersForStorage:          DefaultSimultaneousTransfers,
+			SimultaneousTransfersForStoragePerClient: 0,
+			SimultaneousTransfersForRetrieval:        DefaultSimultaneousTransfers,
 
-			SimultaneousTransfersForStorage:   DefaultSimultaneousTransfers,
-			SimultaneousTransfersForRetrieval: DefaultSimultaneousTransfers,
+			TransferMaxBytesPerSec:           0,
+			TransferMaxBytesPerSecPerDeal:    0,
+			TransferMaxBytesPerSecPerClient:  0,
+			HttpTransferMaxParallelStreams:   4,
+			HttpTransferMinParallelRangeSize: 1 << 30,
+			HttpUploadListenAddress:          "",
+			LocalTransferAllowedPaths:        []string{},
+			OfflineDealDropDirs:              []string{},
+			OfflineDealDropStableDuration:    Duration(time.Minute),
 
 			StartEpochSealingBuffer: 480, // 480 epochs buffer == 4 hours from adding deal to sector to sector being sealed
 
+			AddPieceMaxSectorsPreCommit1: 0,
+			AddPieceMaxSectorsWaitDeals:  0,
+			AddPieceMaxPreCommit1Jobs:    0,
+			AddPieceCheckInterval:        Duration(time.Minute),
+			// Release held deals with 4 hours to go before their start epoch
+			AddPieceStartEpochSafetyMargin: 480,
+
+			ClientQuotas: ClientQuotasConfig{
+				Allowlist: []string{},
+			},
+
+			FilterWebhook: DealFilterWebhookConfig{
+				Timeout: Duration(10 * time.Second),
+				Retries: 2,
+			},
+			RetrievalFilterWebhook: DealFilterWebhookConfig{
+				Timeout: Duration(10 * time.Second),
+				Retries: 2,
+			},
+
 			RetrievalPricing: &lotus_config.RetrievalPricing{
 				Strategy: RetrievalPricingDefaultMode,
 				Default: &lotus_config.RetrievalPricingDefault{
@@ -97,6 +136,18 @@ func DefaultBoost() *Boost {
 			},
 		},
 
+		Wallets: WalletsConfig{
+			AdditionalPublishStorageDeals: []string{},
+		},
+
+		Funds: FundsConfig{
+			EscrowAutoTopUp:     false,
+			EscrowLowWater:      types.MustParseFIL("1"),
+			EscrowHighWater:     types.MustParseFIL("5"),
+			EscrowMaxTopUp:      types.MustParseFIL("5"),
+			EscrowDailyTopUpCap: types.MustParseFIL("20"),
+		},
+
 		LotusDealmaking: lotus_config.DealmakingConfig{
 			ConsiderOnlineStorageDeals:     true,
 			ConsiderOfflineStorageDeals:    true,
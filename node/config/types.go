@@ -36,6 +36,7 @@ type Boost struct {
 	SectorIndexApiInfo string
 	Dealmaking         DealmakingConfig
 	Wallets            WalletsConfig
+	Funds              FundsConfig
 
 	// Lotus configs
 	LotusDealmaking lotus_config.DealmakingConfig
@@ -58,10 +59,33 @@ type WalletsConfig struct {
 	// The wallet used to send PublishStorageDeals messages.
 	// Must be a control or worker address of the miner.
 	PublishStorageDeals string
+	// Additional wallets used to send PublishStorageDeals messages. Funds
+	// for each deal's message are tagged in whichever of these wallets and
+	// PublishStorageDeals has the most funds available, and the deal is
+	// published from that wallet, so that a stuck message doesn't block
+	// later deals.
+	// Each must be a control address of the miner (checked at startup).
+	AdditionalPublishStorageDeals []string
 	// The wallet used as the source for pledge collateral
 	PledgeCollateral string
 }
 
+type FundsConfig struct {
+	// Automatically move funds from the pledge collateral wallet into escrow
+	// when the available escrow balance (escrow minus funds tagged for deals)
+	// falls below EscrowLowWater
+	EscrowAutoTopUp bool
+	// Escrow is topped up when the available balance falls below this amount
+	EscrowLowWater types.FIL
+	// Escrow is topped up to this amount
+	EscrowHighWater types.FIL
+	// The maximum amount to move into escrow in a single top-up. 0 is unlimited.
+	EscrowMaxTopUp types.FIL
+	// The maximum amount to move into escrow in all top-ups in the last
+	// 24 hours. 0 is unlimited.
+	EscrowDailyTopUpCap types.FIL
+}
+
 type LotusDealmakingConfig struct {
 	// A list of Data CIDs to reject when making deals
 	PieceCidBlocklist []cid.Cid
@@ -136,25 +160,173 @@ type DealmakingConfig struct {
 	PublishMsgMaxDealsPerMsg uint64
 	// The maximum network fees to pay when sending the PublishStorageDeals message
 	PublishMsgMaxFee types.FIL
+	// Deals are held while the base fee is above this amount, until the base
+	// fee comes down or a deal gets within PublishMsgStartEpochSafetyMargin
+	// epochs of its start epoch. 0 means deals are never held because of the
+	// base fee.
+	PublishMsgMaxBaseFee types.FIL
+	// Deals are always published at least this many epochs before their start
+	// epoch, whatever the base fee
+	PublishMsgStartEpochSafetyMargin uint64
 	// The maximum collateral that the provider will put up against a deal,
 	// as a multiplier of the minimum collateral bound
 	MaxProviderCollateralMultiplier uint64
 	// The maximum allowed disk usage size in bytes of staging deals not yet
 	// passed to the sealing node by the markets service. 0 is unlimited.
 	MaxStagingDealsBytes int64
-	// The maximum number of parallel online data transfers for storage deals
+	// The directories in which the data for online deals is staged, eg on
+	// separate drives. If empty, deal data is staged in the incoming
+	// directory in the boost repo. MaxStagingDealsBytes limits the total
+	// across all staging areas.
+	StagingAreas []StagingAreaConfig
+	// The amount of disk space in bytes to keep free on the filesystem of
+	// each staging area. Deals that would reduce the free space below this
+	// amount are rejected, and new data transfers are paused while the free
+	// space is below it. 0 disables the check.
+	StagingAreaMinFreeBytes int64
+	// How often to check the staging areas for files that are not used by
+	// any deal (eg because boost crashed or a deal failed partway through).
+	// The first check runs at startup. 0 disables the check.
+	StagingGCInterval Duration
+	// Orphaned staging files that were modified more recently than this are
+	// not deleted
+	StagingGCGracePeriod Duration
+	// If true, orphaned staging files are only reported in the logs, not
+	// deleted
+	StagingGCDryRun bool
+	// How long the data for an online deal that failed after it was
+	// published is kept, so that the deal can be retried. After this the
+	// data is removed by the staging area garbage collection. 0 means the
+	// data is removed as soon as the deal fails.
+	FailedDealDataRetention Duration
+	// The maximum number of parallel online data transfers for storage deals.
+	// Deals over the limit are queued until a transfer finishes.
 	SimultaneousTransfersForStorage uint64
+	// The maximum number of parallel online data transfers for storage deals
+	// from any single client. Unset by default (0), which means the number
+	// of transfers per client is only bound by SimultaneousTransfersForStorage.
+	SimultaneousTransfersForStoragePerClient uint64
 	// The maximum number of parallel online data transfers for retrieval deals
 	SimultaneousTransfersForRetrieval uint64
+	// The maximum rate in bytes per second at which deal data is downloaded,
+	// across all online data transfers for storage deals. 0 is unlimited.
+	TransferMaxBytesPerSec uint64
+	// The maximum rate in bytes per second at which the data for any single
+	// storage deal is downloaded. 0 is unlimited.
+	TransferMaxBytesPerSecPerDeal uint64
+	// The maximum rate in bytes per second at which deal data is downloaded,
+	// across all online data transfers from any single client. 0 is unlimited.
+	TransferMaxBytesPerSecPerClient uint64
+	// The maximum number of concurrent HTTP range requests used to download
+	// the data for a single storage deal, when the server supports range
+	// requests. 0 or 1 means the data is always downloaded with a single
+	// request.
+	HttpTransferMaxParallelStreams int
+	// The minimum size in bytes of each range of deal data that is
+	// downloaded in parallel. Deals smaller than two ranges are downloaded
+	// with a single request.
+	HttpTransferMinParallelRangeSize uint64
+	// The address to listen on for clients that upload deal data with HTTP
+	// PUT, eg "0.0.0.0:8444". Uploads are always accepted over libp2p. If
+	// empty, uploads are not accepted over plain HTTP.
+	HttpUploadListenAddress string
+	// The directories that deals with the "local" transfer type may transfer
+	// data from (eg network shares mounted on the boost host). Local
+	// transfers are rejected if no directories are configured.
+	LocalTransferAllowedPaths []string
+	// Directories that are watched for offline deal data files (eg on a
+	// drive shipped by the client). Once a new file has finished writing,
+	// its commP is calculated and the file is imported as the data for the
+	// offline deal for that piece.
+	OfflineDealDropDirs []string
+	// A file in an offline deal drop directory is considered to have
+	// finished writing once its size has not changed for this long
+	OfflineDealDropStableDuration Duration
 	// Minimum start epoch buffer to give time for sealing of sector with deal.
 	StartEpochSealingBuffer uint64
+	// Published deals are held instead of being added to a sector while
+	// there are more than this many sectors in PreCommit1 (0 means no limit).
+	// Held deals are added to a sector in order of start epoch.
+	AddPieceMaxSectorsPreCommit1 uint64
+	// Published deals are held instead of being added to a sector while
+	// there are more than this many sectors in WaitDeals (0 means no limit)
+	AddPieceMaxSectorsWaitDeals uint64
+	// Published deals are held instead of being added to a sector while
+	// there are more than this many PreCommit1 jobs on the sealing workers
+	// (0 means no limit)
+	AddPieceMaxPreCommit1Jobs uint64
+	// How often to check the sealing pipeline while deals are held
+	AddPieceCheckInterval Duration
+	// A held deal is added to a sector whatever the limits once the chain
+	// head is within this many epochs of the deal's start epoch (0 means
+	// deals are held for as long as the sealing pipeline is over the limits)
+	AddPieceStartEpochSafetyMargin uint64
+	// Limits on the deals accepted from any single client address or peer
+	ClientQuotas ClientQuotasConfig
 
 	// A command used for fine-grained evaluation of storage deals
 	// see https://docs.filecoin.io/mine/lotus/miner-configuration/#using-filters-for-fine-grained-storage-and-retrieval-deal-acceptance for more details
 	Filter string
+	// The path of a TOML file with rules for the built-in storage deal filter.
+	// The rules can match on the client, peer ID, piece size, verified status,
+	// price, duration, start epoch, transfer type and label (a regular
+	// expression) of a deal, and accept or reject it.
+	// A relative path is relative to the boost repo. The rules are reloaded
+	// when the file changes.
+	FilterRulesFile string
+	// An HTTP endpoint used for fine-grained evaluation of storage deals.
+	// The endpoint receives the same JSON as the Filter command.
+	FilterWebhook DealFilterWebhookConfig
 	// A command used for fine-grained evaluation of retrieval deals
 	// see https://docs.filecoin.io/mine/lotus/miner-configuration/#using-filters-for-fine-grained-storage-and-retrieval-deal-acceptance for more details
 	RetrievalFilter string
+	// An HTTP endpoint used for fine-grained evaluation of retrieval deals.
+	// The endpoint receives the same JSON as the RetrievalFilter command.
+	RetrievalFilterWebhook DealFilterWebhookConfig
 
 	RetrievalPricing *lotus_config.RetrievalPricing
 }
+
+type StagingAreaConfig struct {
+	// The path of the staging area directory. A relative path is relative
+	// to the boost repo directory.
+	Path string
+	// The maximum number of bytes of deal data staged in this area.
+	// 0 is unlimited.
+	MaxBytes int64
+	// The relative share of deal data staged in this area, eg an area with
+	// weight 2 gets twice as much deal data as an area with weight 1
+	Weight uint64
+}
+
+type ClientQuotasConfig struct {
+	// The maximum number of deal proposals accepted per minute from a client
+	// address or peer. 0 is unlimited.
+	MaxProposalsPerMinute uint64
+	// The maximum number of active deals (deals that have not yet been
+	// handed off to the sealer) from a client address or peer. 0 is unlimited.
+	MaxActiveDeals uint64
+	// The maximum number of bytes of deal data (by piece size) accepted from
+	// a client address or peer in the last 24 hours. 0 is unlimited.
+	MaxBytesPerDay uint64
+	// Client addresses and peer IDs that are not subject to the quotas
+	Allowlist []string
+}
+
+type DealFilterWebhookConfig struct {
+	// The URL that deals are POSTed to. The endpoint should respond with a
+	// 2xx status code to accept the deal, or a 4xx status code to reject it,
+	// with the reason in the response body. Disabled if empty.
+	URL string
+	// The value of the Authorization header sent with each request,
+	// eg "Bearer <token>"
+	AuthHeader string
+	// How long to wait for the endpoint to respond to each request
+	Timeout Duration
+	// The number of times to retry a request that fails with a network error
+	// or a 5xx status code
+	Retries int
+	// When enabled, deals are accepted if the endpoint can't be reached.
+	// Otherwise they are rejected.
+	FailOpen bool
+}
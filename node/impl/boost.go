@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/filecoin-project/dagstore/shard"
@@ -15,10 +18,13 @@ import (
 	"github.com/filecoin-project/boost/indexprovider"
 
 	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/fundmanager"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket"
 	"github.com/filecoin-project/boost/storagemarket/types"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	lotus_storagemarket "github.com/filecoin-project/go-fil-markets/storagemarket"
@@ -52,6 +58,8 @@ type BoostAPI struct {
 	// Boost
 	StorageProvider *storagemarket.Provider
 	IndexProvider   *indexprovider.Wrapper
+	FundManager     *fundmanager.FundManager
+	DealsDB         *db.DealsDB
 
 	// Legacy Lotus
 	LegacyStorageProvider lotus_storagemarket.StorageProvider
@@ -118,6 +126,14 @@ func (sm *BoostAPI) BoostDeal(ctx context.Context, dealUuid uuid.UUID) (*types.P
 	return sm.StorageProvider.Deal(ctx, dealUuid)
 }
 
+func (sm *BoostAPI) BoostDealCancel(ctx context.Context, dealUuid uuid.UUID, reason string) error {
+	return sm.StorageProvider.CancelDeal(ctx, dealUuid, reason)
+}
+
+func (sm *BoostAPI) BoostDealRetry(ctx context.Context, dealUuid uuid.UUID) error {
+	return sm.StorageProvider.RetryDeal(dealUuid)
+}
+
 func (sm *BoostAPI) BoostIndexerAnnounceAllDeals(ctx context.Context) error {
 	return sm.IndexProvider.IndexerAnnounceAllDeals(ctx)
 }
@@ -127,6 +143,183 @@ func (sm *BoostAPI) BoostOfflineDealWithData(_ context.Context, dealUuid uuid.UU
 	return res, err
 }
 
+func (sm *BoostAPI) BoostOfflineDealWithDataStream(_ context.Context, dealUuid uuid.UUID, filePath string, data io.Reader) (*api.ProviderDealRejectionInfo, error) {
+	res, _, err := sm.StorageProvider.ImportOfflineDealDataStream(dealUuid, filePath, data)
+	return res, err
+}
+
+func (sm *BoostAPI) BoostOfflineDealsImport(ctx context.Context, params api.OfflineDealsImportParams) (<-chan api.OfflineDealsImportEvent, error) {
+	sources := 0
+	for _, set := range []bool{len(params.Entries) > 0, params.ManifestPath != "", params.AutoMatchDir != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return nil, fmt.Errorf("no offline deals to import")
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of entries, a manifest path or an auto-match directory can be specified")
+	}
+
+	var entries []storagemarket.OfflineImportEntry
+	switch {
+	case params.AutoMatchDir != "":
+		st, err := os.Stat(params.AutoMatchDir)
+		if err != nil {
+			return nil, fmt.Errorf("auto-match directory: %w", err)
+		}
+		if !st.IsDir() {
+			return nil, fmt.Errorf("auto-match path %s is not a directory", params.AutoMatchDir)
+		}
+	case params.ManifestPath != "":
+		var err error
+		entries, err = storagemarket.ReadOfflineImportManifest(params.ManifestPath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		entries = make([]storagemarket.OfflineImportEntry, 0, len(params.Entries))
+		for _, e := range params.Entries {
+			if e.FilePath == "" {
+				return nil, fmt.Errorf("no file path for offline deal import entry")
+			}
+			if !filepath.IsAbs(e.FilePath) {
+				return nil, fmt.Errorf("offline deal import entry file path %s is not an absolute path", e.FilePath)
+			}
+			entry := storagemarket.OfflineImportEntry{DealUUID: e.DealUUID, FilePath: e.FilePath}
+			if e.PieceCID != nil {
+				entry.PieceCID = *e.PieceCID
+			}
+			if entry.DealUUID == uuid.Nil && !entry.PieceCID.Defined() {
+				return nil, fmt.Errorf("offline deal import entry for %s must have a deal uuid or a piece CID", e.FilePath)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	res := make(chan api.OfflineDealsImportEvent, 32)
+	send := func(evt api.OfflineDealsImportEvent) {
+		select {
+		case res <- evt:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(res)
+
+		onEvent := func(evt storagemarket.OfflineImportEvent) {
+			e := api.OfflineDealsImportEvent{
+				FilePath: evt.FilePath,
+				DealUUID: evt.DealUUID,
+				Event:    evt.Event,
+				Success:  evt.Error == "",
+				Error:    evt.Error,
+				Total:    evt.Total,
+				Current:  evt.Current,
+			}
+			if evt.PieceCID.Defined() {
+				pieceCid := evt.PieceCID
+				e.PieceCID = &pieceCid
+			}
+			send(e)
+		}
+
+		var err error
+		if params.AutoMatchDir != "" {
+			err = sm.StorageProvider.AutoMatchOfflineDealsData(ctx, params.AutoMatchDir, params.MaxConcurrency, onEvent)
+		} else {
+			err = sm.StorageProvider.ImportOfflineDealsData(ctx, entries, onEvent)
+		}
+		if err != nil {
+			log.Warnw("bulk offline deal import failed", "err", err)
+			send(api.OfflineDealsImportEvent{Event: "error", Error: err.Error()})
+		}
+	}()
+
+	return res, nil
+}
+
+func (sm *BoostAPI) BoostTransferBandwidthLimits(ctx context.Context) (transporttypes.BandwidthLimits, error) {
+	return sm.StorageProvider.TransferBandwidthLimits()
+}
+
+func (sm *BoostAPI) BoostSetTransferBandwidthLimits(ctx context.Context, limits transporttypes.BandwidthLimits) error {
+	return sm.StorageProvider.SetTransferBandwidthLimits(limits)
+}
+
+func (sm *BoostAPI) BoostFundsReconcile(ctx context.Context, release bool) (*api.FundsReconcileReport, error) {
+	rep, err := sm.FundManager.Reconcile(ctx, sm.DealsDB, release)
+	if err != nil {
+		return nil, fmt.Errorf("reconciling funds: %w", err)
+	}
+
+	ret := &api.FundsReconcileReport{
+		EscrowAvailable:          rep.EscrowAvailable,
+		EscrowLocked:             rep.EscrowLocked,
+		CollateralTaggedActive:   rep.CollateralTaggedActive,
+		CollateralTaggedOrphaned: rep.CollateralTaggedOrphaned,
+		CollateralShortfall:      rep.CollateralShortfall,
+		PubMsgWallets:            make([]api.FundsReconcileWallet, 0, len(rep.PubMsgWallets)),
+		Orphaned:                 make([]api.FundsOrphanedTag, 0, len(rep.Orphaned)),
+		Released:                 rep.Released,
+	}
+	for _, w := range rep.PubMsgWallets {
+		ret.PubMsgWallets = append(ret.PubMsgWallets, api.FundsReconcileWallet{
+			Address:        w.Address,
+			Balance:        w.Balance,
+			TaggedActive:   w.TaggedActive,
+			TaggedOrphaned: w.TaggedOrphaned,
+			Shortfall:      w.Shortfall,
+		})
+	}
+	for _, o := range rep.Orphaned {
+		ret.Orphaned = append(ret.Orphaned, api.FundsOrphanedTag{
+			DealUUID:     o.DealUUID,
+			CreatedAt:    o.CreatedAt,
+			Collateral:   o.Collateral,
+			PubMsg:       o.PubMsg,
+			PubMsgWallet: o.PubMsgWallet,
+			Reason:       o.Reason,
+		})
+	}
+	return ret, nil
+}
+
+func (sm *BoostAPI) BoostStagingGC(ctx context.Context, dryRun bool) (*api.StagingGCReport, error) {
+	rep, err := sm.StorageProvider.StagingGC(ctx, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &api.StagingGCReport{
+		Orphaned:         make([]api.StagingOrphanedFile, 0, len(rep.Orphaned)),
+		Retained:         make([]api.StagingRetainedFile, 0, len(rep.Retained)),
+		RetainedBytes:    rep.RetainedBytes,
+		ReclaimableBytes: rep.ReclaimableBytes,
+		DeletedBytes:     rep.DeletedBytes,
+		DryRun:           rep.DryRun,
+	}
+	for _, o := range rep.Orphaned {
+		ret.Orphaned = append(ret.Orphaned, api.StagingOrphanedFile{
+			Path:          o.Path,
+			Size:          o.Size,
+			ModTime:       o.ModTime,
+			InGracePeriod: o.InGracePeriod,
+			Deleted:       o.Deleted,
+		})
+	}
+	for _, r := range rep.Retained {
+		ret.Retained = append(ret.Retained, api.StagingRetainedFile{
+			Path:  r.Path,
+			Size:  r.Size,
+			Until: r.Until,
+		})
+	}
+	return ret, nil
+}
+
 func (sm *BoostAPI) BoostDagstoreGC(ctx context.Context) ([]api.DagstoreShardResult, error) {
 	if sm.DAGStore == nil {
 		return nil, fmt.Errorf("dagstore not available on this node")
@@ -3,10 +3,16 @@ package modules
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket/dealfilter"
 	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v1api"
 	lotus_repo "github.com/filecoin-project/lotus/node/repo"
 )
 
@@ -18,7 +24,8 @@ func BasicDealFilter(cfg config.DealmakingConfig, userCmd dtypes.StorageDealFilt
 	expectedSealTimeFunc dtypes.GetExpectedSealDurationFunc,
 	startDelay dtypes.GetMaxDealStartDelayFunc,
 	r lotus_repo.LockedRepo,
-) dtypes.StorageDealFilter {
+	fullnodeApi v1api.FullNode,
+) (dtypes.StorageDealFilter, error) {
 	return func(onlineOk dtypes.ConsiderOnlineStorageDealsConfigFunc,
 		offlineOk dtypes.ConsiderOfflineStorageDealsConfigFunc,
 		verifiedOk dtypes.ConsiderVerifiedStorageDealsConfigFunc,
@@ -27,7 +34,29 @@ func BasicDealFilter(cfg config.DealmakingConfig, userCmd dtypes.StorageDealFilt
 		expectedSealTimeFunc dtypes.GetExpectedSealDurationFunc,
 		startDelay dtypes.GetMaxDealStartDelayFunc,
 		r lotus_repo.LockedRepo,
-	) dtypes.StorageDealFilter {
+		fullnodeApi v1api.FullNode,
+	) (dtypes.StorageDealFilter, error) {
+		// load the rules for the built-in deal filter
+		var rulesFilter *dealfilter.RulesFilter
+		if cfg.FilterRulesFile != "" {
+			path := cfg.FilterRulesFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(r.Path(), path)
+			}
+
+			var err error
+			rulesFilter, err = dealfilter.NewRulesFilter(path, func(ctx context.Context) (abi.ChainEpoch, error) {
+				head, err := fullnodeApi.ChainHead(ctx)
+				if err != nil {
+					return 0, err
+				}
+				return head.Height(), nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		return func(ctx context.Context, params types.DealFilterParams) (bool, string, error) {
 			deal := params.DealParams
 			pr := deal.ClientDealProposal.Proposal
@@ -89,11 +118,80 @@ func BasicDealFilter(cfg config.DealmakingConfig, userCmd dtypes.StorageDealFilt
 				}
 			}
 
+			if rulesFilter != nil {
+				accept, reason, err := rulesFilter.Filter(ctx, params)
+				if err != nil || !accept {
+					return accept, reason, err
+				}
+			}
+
 			if userCmd != nil {
 				return userCmd(ctx, params)
 			}
 
 			return true, "", nil
+		}, nil
+	}
+}
+
+// UserStorageDealFilter combines the storage deal filter command and webhook
+// from the config. The deal is only accepted if both of them accept it.
+// Returns nil if neither is configured.
+func UserStorageDealFilter(cfg config.DealmakingConfig) dtypes.StorageDealFilter {
+	var filters []dtypes.StorageDealFilter
+	if cfg.Filter != "" {
+		filters = append(filters, dealfilter.CliStorageDealFilter(cfg.Filter))
+	}
+	if cfg.FilterWebhook.URL != "" {
+		filters = append(filters, dealfilter.WebhookStorageDealFilter(webhookConfig(cfg.FilterWebhook)))
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, params types.DealFilterParams) (bool, string, error) {
+		for _, f := range filters {
+			accept, reason, err := f(ctx, params)
+			if err != nil || !accept {
+				return accept, reason, err
+			}
 		}
+		return true, "", nil
+	}
+}
+
+// UserRetrievalDealFilter combines the retrieval deal filter command and
+// webhook from the config. The deal is only accepted if both of them accept
+// it. Returns nil if neither is configured.
+func UserRetrievalDealFilter(cfg config.DealmakingConfig) dtypes.RetrievalDealFilter {
+	var filters []dtypes.RetrievalDealFilter
+	if cfg.RetrievalFilter != "" {
+		filters = append(filters, dealfilter.CliRetrievalDealFilter(cfg.RetrievalFilter))
+	}
+	if cfg.RetrievalFilterWebhook.URL != "" {
+		filters = append(filters, dealfilter.WebhookRetrievalDealFilter(webhookConfig(cfg.RetrievalFilterWebhook)))
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error) {
+		for _, f := range filters {
+			accept, reason, err := f(ctx, state)
+			if err != nil || !accept {
+				return accept, reason, err
+			}
+		}
+		return true, "", nil
+	}
+}
+
+func webhookConfig(cfg config.DealFilterWebhookConfig) dealfilter.WebhookConfig {
+	return dealfilter.WebhookConfig{
+		URL:        cfg.URL,
+		AuthHeader: cfg.AuthHeader,
+		Timeout:    time.Duration(cfg.Timeout),
+		Retries:    cfg.Retries,
+		FailOpen:   cfg.FailOpen,
 	}
 }
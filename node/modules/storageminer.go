@@ -11,13 +11,16 @@ import (
 	"github.com/filecoin-project/boost/build"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/events"
 	ctypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/sigs"
 
 	"github.com/filecoin-project/boost/indexprovider"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/dealpublisher"
 	"github.com/filecoin-project/boost/fundmanager"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/node/config"
@@ -25,7 +28,13 @@ import (
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/boost/storagemarket/logs"
 	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/transport/graphsynctransport"
+	"github.com/filecoin-project/boost/transport/httptransport"
+	"github.com/filecoin-project/boost/transport/localtransport"
+	"github.com/filecoin-project/boost/transport/pushtransport"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	lotus_storagemarket "github.com/filecoin-project/go-fil-markets/storagemarket"
@@ -364,33 +373,104 @@ func NewChainDealManager(a v1api.FullNode) *storagemarket.ChainDealManager {
 	return storagemarket.NewChainDealManager(a, cdmCfg)
 }
 
-func NewStorageMarketProvider(provAddr address.Address) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode,
+func NewSectorCommittedManager(mctx helpers.MetricsCtx, lc fx.Lifecycle, a v1api.FullNode) (*storagemarket.SectorCommittedManager, error) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+	ev, err := events.NewEvents(ctx, a)
+	if err != nil {
+		return nil, fmt.Errorf("creating chain events: %w", err)
+	}
+	return storagemarket.NewSectorCommittedManager(ev, a), nil
+}
+
+func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode,
 	sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager,
-	dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB,
+	dp *dealpublisher.DealPublisher, secb *sectorblocks.SectorBlocks, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB,
 	dagst *dagstore.Wrapper, ps lotus_dtypes.ProviderPieceStore, ip *indexprovider.Wrapper, lp lotus_storagemarket.StorageProvider,
-	cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
+	cdm *storagemarket.ChainDealManager, scm *storagemarket.SectorCommittedManager, dt lotus_dtypes.ProviderDataTransfer, ds lotus_dtypes.MetadataDS) (*storagemarket.Provider, error) {
 	return func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB,
-		fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, sps sealingpipeline.API,
+		fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, dp *dealpublisher.DealPublisher, secb *sectorblocks.SectorBlocks, sps sealingpipeline.API,
 		df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB,
 		dagst *dagstore.Wrapper, ps lotus_dtypes.ProviderPieceStore, ip *indexprovider.Wrapper,
-		lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
-
-		prov, err := storagemarket.NewProvider(h, sqldb, dealsDB, fundMgr, storageMgr, a, dp, provAddr, secb,
-			sps, cdm, df, logsSqlDB.db, logsDB, dagst, ps, ip, lp, &signatureVerifier{a})
+		lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager, scm *storagemarket.SectorCommittedManager,
+		dt lotus_dtypes.ProviderDataTransfer, ds lotus_dtypes.MetadataDS) (*storagemarket.Provider, error) {
+
+		prvCfg := storagemarket.Config{
+			MaxTransferDuration:             24 * 3600 * time.Second,
+			MaxConcurrentTransfers:          cfg.Dealmaking.SimultaneousTransfersForStorage,
+			MaxConcurrentTransfersPerClient: cfg.Dealmaking.SimultaneousTransfersForStoragePerClient,
+			RetryDataRetention:              time.Duration(cfg.Dealmaking.FailedDealDataRetention),
+			ClientQuotas: storagemarket.ClientQuotas{
+				MaxProposalsPerMinute: cfg.Dealmaking.ClientQuotas.MaxProposalsPerMinute,
+				MaxActiveDeals:        cfg.Dealmaking.ClientQuotas.MaxActiveDeals,
+				MaxBytesPerDay:        cfg.Dealmaking.ClientQuotas.MaxBytesPerDay,
+				Allowlist:             cfg.Dealmaking.ClientQuotas.Allowlist,
+			},
+			StagingGC: storagemarket.StagingGCConfig{
+				Interval:    time.Duration(cfg.Dealmaking.StagingGCInterval),
+				GracePeriod: time.Duration(cfg.Dealmaking.StagingGCGracePeriod),
+				DryRun:      cfg.Dealmaking.StagingGCDryRun,
+			},
+			OfflineDrop: storagemarket.OfflineDropConfig{
+				Dirs:           cfg.Dealmaking.OfflineDealDropDirs,
+				StableDuration: time.Duration(cfg.Dealmaking.OfflineDealDropStableDuration),
+			},
+			AddPiece: storagemarket.AddPieceSchedulerConfig{
+				MaxSectorsPreCommit1:   cfg.Dealmaking.AddPieceMaxSectorsPreCommit1,
+				MaxSectorsWaitDeals:    cfg.Dealmaking.AddPieceMaxSectorsWaitDeals,
+				MaxPreCommit1Jobs:      cfg.Dealmaking.AddPieceMaxPreCommit1Jobs,
+				CheckInterval:          time.Duration(cfg.Dealmaking.AddPieceCheckInterval),
+				StartEpochSafetyMargin: abi.ChainEpoch(cfg.Dealmaking.AddPieceStartEpochSafetyMargin),
+			},
+		}
+		bwLimits := transporttypes.BandwidthLimits{
+			Total:     cfg.Dealmaking.TransferMaxBytesPerSec,
+			PerDeal:   cfg.Dealmaking.TransferMaxBytesPerSecPerDeal,
+			PerClient: cfg.Dealmaking.TransferMaxBytesPerSecPerClient,
+		}
+		prov, err := storagemarket.NewProvider(prvCfg, h, sqldb, dealsDB, fundMgr, storageMgr, a, dp, provAddr, secb,
+			sps, cdm, scm, df, logsSqlDB.db, logsDB, dagst, ps, ip, lp, &signatureVerifier{a},
+			httptransport.BandwidthLimitsOpt(bwLimits),
+			httptransport.ParallelDownloadOpt(cfg.Dealmaking.HttpTransferMaxParallelStreams,
+				int64(cfg.Dealmaking.HttpTransferMinParallelRangeSize)))
 		if err != nil {
 			return nil, err
 		}
 
+		// Clients that use go-data-transfer can have the deal data pulled
+		// over graphsync
+		gsTransport, err := graphsynctransport.New(h, dt, logs.NewDealLogger(logsDB))
+		if err != nil {
+			return nil, fmt.Errorf("creating graphsync transport: %w", err)
+		}
+		prov.AddTransport("graphsync", gsTransport)
+
+		// Deal data can be transferred from a filesystem that the provider
+		// can access
+		prov.AddTransport("local", localtransport.New(logs.NewDealLogger(logsDB), localtransport.Config{
+			AllowedRoots: cfg.Dealmaking.LocalTransferAllowedPaths,
+		}))
+
+		// Clients that can't serve the deal data can upload it to the
+		// provider
+		pushTransport := pushtransport.New(h, ds, logs.NewDealLogger(logsDB), pushtransport.Config{
+			ListenAddr: cfg.Dealmaking.HttpUploadListenAddress,
+		})
+		prov.AddTransport("push", pushTransport)
+		lc.Append(fx.Hook{
+			OnStart: pushTransport.Start,
+			OnStop:  pushTransport.Stop,
+		})
+
 		return prov, nil
 	}
 }
 
-func NewGraphqlServer(cfg *config.Boost) func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, publisher *storageadapter.DealPublisher, spApi sealingpipeline.API, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, fullNode v1api.FullNode) *gql.Server {
+func NewGraphqlServer(cfg *config.Boost) func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, publisher *dealpublisher.DealPublisher, legacyPublisher *storageadapter.DealPublisher, spApi sealingpipeline.API, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, fullNode v1api.FullNode) *gql.Server {
 	return func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager,
-		storageMgr *storagemanager.StorageManager, publisher *storageadapter.DealPublisher, spApi sealingpipeline.API,
+		storageMgr *storagemanager.StorageManager, publisher *dealpublisher.DealPublisher, legacyPublisher *storageadapter.DealPublisher, spApi sealingpipeline.API,
 		legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, fullNode v1api.FullNode) *gql.Server {
 
-		resolver := gql.NewResolver(cfg, r, h, dealsDB, logsDB, fundsDB, fundMgr, storageMgr, spApi, prov, legacyProv, legacyDT, publisher, fullNode)
+		resolver := gql.NewResolver(cfg, r, h, dealsDB, logsDB, fundsDB, fundMgr, storageMgr, spApi, prov, legacyProv, legacyDT, publisher, legacyPublisher, fullNode)
 		server := gql.NewServer(resolver)
 
 		lc.Append(fx.Hook{
@@ -2,7 +2,7 @@
 
 import React, {useEffect, useState} from "react";
 import {useMutation, useQuery, useSubscription} from "@apollo/react-hooks";
-import {DealCancelMutation, DealSubscription, EpochQuery} from "./gql";
+import {DealCancelMutation, DealRetryMutation, DealSubscription, EpochQuery} from "./gql";
 import {useNavigate} from "react-router-dom";
 import {dateFormat} from "./util-date";
 import moment from "moment";
@@ -52,7 +52,16 @@ export function DealDetail(props) {
 
     const currentEpochData = useQuery(EpochQuery)
 
-    const [cancelDeal] = useMutation(DealCancelMutation, {
+    const [cancelDealMutation] = useMutation(DealCancelMutation)
+    function cancelDeal() {
+        const reason = window.prompt('Reason for cancelling the deal')
+        if (reason === null) {
+            return
+        }
+        cancelDealMutation({variables: {id: params.dealID, reason: reason}})
+    }
+
+    const [retryDeal] = useMutation(DealRetryMutation, {
         variables: {id: params.dealID}
     })
 
@@ -299,9 +308,15 @@ export function DealDetail(props) {
                 </tbody>
             </table>
 
-            {deal.Checkpoint === 'Accepted' && !deal.IsOffline ? (
+            {deal.Checkpoint === 'Accepted' || deal.Checkpoint === 'Transferred' ? (
+                <div className="buttons">
+                    <div className="button cancel" onClick={cancelDeal}>Cancel Deal</div>
+                </div>
+            ) : null}
+
+            {deal.Checkpoint === 'Complete' && deal.Err !== '' ? (
                 <div className="buttons">
-                    <div className="button cancel" onClick={cancelDeal}>Cancel Transfer</div>
+                    <div className="button retry" onClick={retryDeal}>Retry Deal</div>
                 </div>
             ) : null}
 
@@ -38,7 +38,7 @@ function FundsChart(props) {
     const total = {
         collatBalance: funds.Collateral.Balance,
         escrow: funds.Escrow.Tagged + funds.Escrow.Available + funds.Escrow.Locked,
-        pubMsg: funds.PubMsg.Balance,
+        pubMsg: max(...funds.PubMsg.map(w => w.Balance)),
     }
     const amtMax = max(total.collatBalance, total.escrow, total.pubMsg)
 
@@ -46,7 +46,9 @@ function FundsChart(props) {
         <div className="amounts">
             <CollateralSource collateral={funds.Collateral} amtMax={amtMax} />
             <FundsEscrow escrow={funds.Escrow} amtMax={amtMax} />
-            <PubMsgWallet pubMsg={funds.PubMsg} address={funds.PubMsg.Address} amtMax={amtMax} />
+            {funds.PubMsg.map(w => (
+                <PubMsgWallet key={w.Address} pubMsg={w} address={w.Address} amtMax={amtMax} />
+            ))}
         </div>
 
         <TopupCollateral maxTopup={collatBalance} />
@@ -330,8 +332,8 @@ export function FundsMenuItem(props) {
         escrow.used = funds.Escrow.Tagged + funds.Escrow.Locked
         escrow.total = escrow.used + escrow.free
 
-        pubMsg.total = funds.PubMsg.Balance
-        pubMsg.used = funds.PubMsg.Tagged
+        pubMsg.total = funds.PubMsg.reduce((tot, w) => tot + w.Balance, 0n)
+        pubMsg.used = funds.PubMsg.reduce((tot, w) => tot + w.Tagged, 0n)
         escrow.free = escrow.total - escrow.used
     }
 
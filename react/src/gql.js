@@ -156,6 +156,7 @@ const DealSubscription = gql`
             IsOffline
             Checkpoint
             CheckpointAt
+            Err
             Message
             Transferred
             Transfer {
@@ -179,8 +180,14 @@ const DealSubscription = gql`
 `;
 
 const DealCancelMutation = gql`
-    mutation AppDealCancelMutation($id: ID!) {
-        dealCancel(id: $id)
+    mutation AppDealCancelMutation($id: ID!, $reason: String) {
+        dealCancel(id: $id, reason: $reason)
+    }
+`;
+
+const DealRetryMutation = gql`
+    mutation AppDealRetryMutation($id: ID!) {
+        dealRetry(id: $id)
     }
 `;
 
@@ -462,6 +469,7 @@ export {
     LegacyDealQuery,
     DealSubscription,
     DealCancelMutation,
+    DealRetryMutation,
     NewDealsSubscription,
     StorageQuery,
     LegacyStorageQuery,
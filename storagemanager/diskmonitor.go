@@ -0,0 +1,120 @@
+package storagemanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The interval at which the disk space available to each staging area is
+// checked
+var diskMonitorInterval = 30 * time.Second
+
+type diskMonitor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lk sync.Mutex
+	// staging area path => true if the disk space available is low
+	low map[string]bool
+	// called when the disk space available to any staging area becomes low,
+	// or when it recovers
+	listeners []func(low bool)
+}
+
+// Start the background monitor of disk space available to the staging areas
+func (m *StorageManager) Start() {
+	if m.cfg.MinFreeDiskBytes == 0 {
+		return
+	}
+
+	log.Infow("starting staging area disk space monitor", "min free bytes", m.cfg.MinFreeDiskBytes)
+
+	m.diskMonitor.ctx, m.diskMonitor.cancel = context.WithCancel(context.Background())
+	m.diskMonitor.wg.Add(1)
+	go m.runDiskMonitor()
+}
+
+func (m *StorageManager) Stop() {
+	if m.diskMonitor.cancel == nil {
+		return
+	}
+	m.diskMonitor.cancel()
+	m.diskMonitor.wg.Wait()
+}
+
+// OnDiskSpaceLow registers a listener that is called with true when the disk
+// space available to a staging area falls below the minimum, and with false
+// once there is enough disk space available to every staging area again.
+// If the disk space is already low, the listener is called straight away.
+func (m *StorageManager) OnDiskSpaceLow(cb func(low bool)) {
+	m.diskMonitor.lk.Lock()
+	defer m.diskMonitor.lk.Unlock()
+
+	m.diskMonitor.listeners = append(m.diskMonitor.listeners, cb)
+	if len(m.diskMonitor.low) > 0 {
+		cb(true)
+	}
+}
+
+// isDiskLow returns true if the disk space that will be left once the data
+// for the deals tagged in the staging area has been written is less than
+// the minimum
+func (m *StorageManager) isDiskLow(u StagingAreaUsage) bool {
+	return u.DiskFree() < m.cfg.MinFreeDiskBytes
+}
+
+func (m *StorageManager) runDiskMonitor() {
+	defer m.diskMonitor.wg.Done()
+
+	ticker := time.NewTicker(diskMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		m.checkDiskSpace(m.diskMonitor.ctx)
+
+		select {
+		case <-m.diskMonitor.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDiskSpace checks the disk space available to each staging area, and
+// notifies listeners if the disk space has become low or has recovered
+func (m *StorageManager) checkDiskSpace(ctx context.Context) {
+	usage, err := m.StagingAreas(ctx)
+	if err != nil {
+		log.Errorw("checking staging area disk space", "err", err)
+		return
+	}
+
+	m.diskMonitor.lk.Lock()
+	defer m.diskMonitor.lk.Unlock()
+
+	wasLow := len(m.diskMonitor.low) > 0
+	for _, u := range usage {
+		if u.DiskLow == m.diskMonitor.low[u.Path] {
+			continue
+		}
+		if u.DiskLow {
+			log.Warnw("staging area disk space is low: pausing new data transfers",
+				"path", u.Path, "available", u.DiskAvailable, "pending", u.Pending, "min free bytes", m.cfg.MinFreeDiskBytes)
+			m.diskMonitor.low[u.Path] = true
+		} else {
+			log.Infow("staging area disk space has recovered",
+				"path", u.Path, "available", u.DiskAvailable, "pending", u.Pending, "min free bytes", m.cfg.MinFreeDiskBytes)
+			delete(m.diskMonitor.low, u.Path)
+		}
+	}
+
+	isLow := len(m.diskMonitor.low) > 0
+	if isLow == wasLow {
+		return
+	}
+	for _, cb := range m.diskMonitor.listeners {
+		cb(isLow)
+	}
+}
@@ -0,0 +1,113 @@
+package storagemanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OrphanedFile is a file in a staging area that is not used by any deal
+type OrphanedFile struct {
+	Path    string
+	Size    uint64
+	ModTime time.Time
+	// True if the file was modified within the grace period, so it is not
+	// yet eligible for deletion
+	InGracePeriod bool
+	// True if the file was deleted
+	Deleted bool
+}
+
+// RetainedFile is a file in a staging area that is kept so that a failed
+// deal can be retried
+type RetainedFile struct {
+	Path string
+	Size uint64
+	// The file is kept until this time, after which it is orphaned
+	Until time.Time
+}
+
+// GCReport lists the orphaned files in the staging areas
+type GCReport struct {
+	Orphaned []OrphanedFile
+	// The files that are kept so that failed deals can be retried
+	Retained []RetainedFile
+	// The total size of the retained files
+	RetainedBytes uint64
+	// The total size of the orphaned files that are eligible for deletion
+	// but have not been deleted
+	ReclaimableBytes uint64
+	// The total size of the orphaned files that were deleted
+	DeletedBytes uint64
+	// True if the orphaned files were only reported, not deleted
+	DryRun bool
+}
+
+// GC finds the files in the staging areas that are not in the set of paths
+// in use by deals, or retained so that failed deals can be retried (mapped
+// to the time until which they are retained). Orphaned files that were last
+// modified before the grace period are deleted, unless dryRun is true.
+// The grace period covers files that are created for a new deal before the
+// deal is saved to the database.
+func (m *StorageManager) GC(ctx context.Context, inUse map[string]struct{}, retained map[string]time.Time, gracePeriod time.Duration, dryRun bool) (*GCReport, error) {
+	rep := &GCReport{Orphaned: []OrphanedFile{}, Retained: []RetainedFile{}, DryRun: dryRun}
+	for _, a := range m.areas {
+		entries, err := os.ReadDir(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("reading staging area directory %s: %w", a.Path, err)
+		}
+
+		for _, e := range entries {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !e.Type().IsRegular() {
+				continue
+			}
+
+			filePath := filepath.Join(a.Path, e.Name())
+			if _, ok := inUse[filePath]; ok {
+				continue
+			}
+
+			info, err := e.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					// The file was removed in the meantime
+					continue
+				}
+				return nil, fmt.Errorf("getting info for staging file %s: %w", filePath, err)
+			}
+
+			if until, ok := retained[filePath]; ok {
+				rep.Retained = append(rep.Retained, RetainedFile{Path: filePath, Size: uint64(info.Size()), Until: until})
+				rep.RetainedBytes += uint64(info.Size())
+				continue
+			}
+
+			o := OrphanedFile{
+				Path:          filePath,
+				Size:          uint64(info.Size()),
+				ModTime:       info.ModTime(),
+				InGracePeriod: time.Since(info.ModTime()) < gracePeriod,
+			}
+			if !o.InGracePeriod {
+				if dryRun {
+					rep.ReclaimableBytes += o.Size
+				} else if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+					log.Warnw("failed to delete orphaned staging file", "path", filePath, "err", err)
+					rep.ReclaimableBytes += o.Size
+				} else {
+					log.Infow("deleted orphaned staging file", "path", filePath, "size", o.Size, "modified", o.ModTime)
+					o.Deleted = true
+					rep.DeletedBytes += o.Size
+				}
+			}
+			rep.Orphaned = append(rep.Orphaned, o)
+		}
+	}
+
+	return rep, nil
+}
@@ -8,11 +8,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
 	lotus_repo "github.com/filecoin-project/lotus/node/repo"
 	"github.com/google/uuid"
 	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/fx"
 )
 
 var log = logging.Logger("storagemanager")
@@ -21,59 +25,279 @@ var (
 	StagingAreaDirName = "incoming"
 )
 
+// StagingArea is a directory in which inbound deal data is staged
+type StagingArea struct {
+	// The path of the staging area directory. A relative path is relative
+	// to the repo directory.
+	Path string
+	// The maximum number of bytes of deal data staged in the area.
+	// Zero means that the size is unlimited.
+	MaxBytes uint64
+	// The relative share of deals staged in the area
+	Weight uint64
+}
+
 type Config struct {
+	// The maximum number of bytes of deal data staged across all staging
+	// areas. Zero means that the size is unlimited.
 	MaxStagingDealsBytes uint64
+	// The staging areas. If there are none, deal data is staged in the
+	// incoming directory in the repo.
+	StagingAreas []StagingArea
+	// The amount of disk space to keep free on the filesystem of each
+	// staging area. Deals that would reduce the free space below this
+	// amount are rejected.
+	MinFreeDiskBytes uint64
 }
 
 type StorageManager struct {
-	lr                 lotus_repo.LockedRepo
-	db                 *db.StorageDB
-	cfg                Config
+	lr    lotus_repo.LockedRepo
+	db    *db.StorageDB
+	cfg   Config
+	areas []StagingArea
+	// The path of the first staging area
 	StagingAreaDirPath string
+
+	// statfs gets the disk space on the filesystem that contains a path
+	statfs      func(path string) (fsutil.FsStat, error)
+	diskMonitor diskMonitor
+
+	receivedLk sync.RWMutex
+	// deal uuid => number of bytes of deal data that have been written to
+	// the staging area for the deal
+	received map[uuid.UUID]uint64
 }
 
-func New(cfg Config) func(lr lotus_repo.LockedRepo, sqldb *sql.DB) (*StorageManager, error) {
-	return func(lr lotus_repo.LockedRepo, sqldb *sql.DB) (*StorageManager, error) {
-		stagingPath := filepath.Join(lr.Path(), StagingAreaDirName)
-		err := os.MkdirAll(stagingPath, os.ModePerm)
+func New(cfg Config) func(lc fx.Lifecycle, lr lotus_repo.LockedRepo, sqldb *sql.DB) (*StorageManager, error) {
+	return func(lc fx.Lifecycle, lr lotus_repo.LockedRepo, sqldb *sql.DB) (*StorageManager, error) {
+		// Deal data was staged in the incoming directory in the repo before
+		// staging areas could be configured
+		incomingDir := filepath.Join(lr.Path(), StagingAreaDirName)
+
+		areas := append([]StagingArea{}, cfg.StagingAreas...)
+		if len(areas) == 0 {
+			areas = []StagingArea{{
+				Path:     incomingDir,
+				MaxBytes: cfg.MaxStagingDealsBytes,
+				Weight:   1,
+			}}
+		}
+
+		for i, a := range areas {
+			if a.Path == "" {
+				return nil, fmt.Errorf("staging area %d has no path", i)
+			}
+			if !filepath.IsAbs(a.Path) {
+				areas[i].Path = filepath.Join(lr.Path(), a.Path)
+			}
+			areas[i].Path = filepath.Clean(areas[i].Path)
+			err := os.MkdirAll(areas[i].Path, os.ModePerm)
+			if err != nil {
+				return nil, fmt.Errorf("creating staging area directory %s: %w", areas[i].Path, err)
+			}
+			if a.Weight == 0 {
+				areas[i].Weight = 1
+			}
+		}
+
+		// Storage that was tagged before staging areas were recorded was
+		// tagged in the incoming directory
+		sdb := db.NewStorageDB(sqldb)
+		n, err := sdb.SetLegacyTaggedPath(context.Background(), incomingDir)
 		if err != nil {
 			return nil, err
 		}
+		if n > 0 {
+			log.Infow("set staging area of storage tagged before staging areas were recorded", "deals", n, "path", incomingDir)
+		}
 
-		return &StorageManager{
-			db:                 db.NewStorageDB(sqldb),
+		m := &StorageManager{
+			db:                 sdb,
 			cfg:                cfg,
 			lr:                 lr,
-			StagingAreaDirPath: stagingPath,
-		}, nil
+			areas:              areas,
+			StagingAreaDirPath: areas[0].Path,
+			statfs:             fsutil.Statfs,
+			diskMonitor:        diskMonitor{low: make(map[string]bool)},
+			received:           make(map[uuid.UUID]uint64),
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				m.Start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				m.Stop()
+				return nil
+			},
+		})
+		return m, nil
 	}
 }
 
-// Free
+// Free is the space left for deals across all the staging areas, taking
+// into account both the max size of each staging area and the max size of
+// all the staging areas. If the size is unlimited it returns zero.
 func (m *StorageManager) Free(ctx context.Context) (uint64, error) {
-	// Get the total tagged storage, so that we know how much is available.
 	tagged, err := m.TotalTagged(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("getting total tagged: %w", err)
+		return 0, err
+	}
+	usage, err := m.StagingAreas(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return m.FreeSpace(tagged, usage), nil
+}
+
+// FreeSpace is the space left for deals given the total tagged storage and
+// the usage of each staging area
+func (m *StorageManager) FreeSpace(tagged uint64, usage []StagingAreaUsage) uint64 {
+	// The space left in the staging areas, if they all have a max size
+	areasLimited := true
+	areasFree := uint64(0)
+	for _, u := range usage {
+		if u.MaxBytes == 0 {
+			areasLimited = false
+			break
+		}
+		areasFree += u.Free()
+	}
+
+	if m.cfg.MaxStagingDealsBytes == 0 {
+		if areasLimited {
+			return areasFree
+		}
+		return 0
+	}
+
+	free := uint64(0)
+	if m.cfg.MaxStagingDealsBytes > tagged {
+		free = m.cfg.MaxStagingDealsBytes - tagged
+	}
+	if areasLimited && areasFree < free {
+		free = areasFree
+	}
+	return free
+}
+
+// MaxStagingDealsBytes is the maximum size of the staging area in bytes.
+// Zero means that the size is unlimited.
+func (m *StorageManager) MaxStagingDealsBytes() uint64 {
+	return m.cfg.MaxStagingDealsBytes
+}
+
+// StagingAreaUsage is the storage space tagged for deals in a staging area
+type StagingAreaUsage struct {
+	StagingArea
+	Tagged uint64
+	// The disk space available on the filesystem of the staging area
+	DiskAvailable uint64
+	// The space tagged for deals in the staging area that has not yet been
+	// written to disk (see SetReceived)
+	Pending uint64
+	// True if the disk space available is close to the minimum
+	DiskLow bool
+}
+
+// Free is the space left in the staging area. If the size of the staging
+// area is unlimited it returns zero.
+func (u StagingAreaUsage) Free() uint64 {
+	if u.MaxBytes > u.Tagged {
+		return u.MaxBytes - u.Tagged
+	}
+	return 0
+}
+
+// DiskFree is the disk space that will be left on the filesystem of the
+// staging area once the data for all tagged deals has been written
+func (u StagingAreaUsage) DiskFree() uint64 {
+	if u.DiskAvailable > u.Pending {
+		return u.DiskAvailable - u.Pending
+	}
+	return 0
+}
+
+// StagingAreas returns the storage space tagged in each staging area, and
+// the disk space available on the filesystem of each staging area
+func (m *StorageManager) StagingAreas(ctx context.Context) ([]StagingAreaUsage, error) {
+	tags, err := m.db.ListTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing tagged storage from DB: %w", err)
+	}
+
+	usage := make([]StagingAreaUsage, 0, len(m.areas))
+	for _, a := range m.areas {
+		usage = append(usage, StagingAreaUsage{StagingArea: a})
+	}
+
+	for _, tag := range tags {
+		// Storage may be tagged in a staging area that is no longer
+		// configured, in which case it only counts towards the total
+		var u *StagingAreaUsage
+		for i := range usage {
+			if usage[i].Path == filepath.Clean(tag.StagingAreaPath) {
+				u = &usage[i]
+				break
+			}
+		}
+		if u == nil {
+			continue
+		}
+		u.Tagged += tag.TransferSize
+
+		// Any data that has already been received is already taken into
+		// account in the available disk space
+		if received := m.getReceived(tag.DealUUID); received < tag.TransferSize {
+			u.Pending += tag.TransferSize - received
+		}
 	}
 
-	return m.cfg.MaxStagingDealsBytes - tagged, nil
+	for i := range usage {
+		u := &usage[i]
+		stat, err := m.statfs(u.Path)
+		if err != nil {
+			return nil, fmt.Errorf("getting disk space for staging area %s: %w", u.Path, err)
+		}
+		if stat.Available > 0 {
+			u.DiskAvailable = uint64(stat.Available)
+		}
+		u.DiskLow = m.isDiskLow(*u)
+	}
+
+	return usage, nil
 }
 
 // ErrNoSpaceLeft indicates that there is insufficient storage to accept a deal
 var ErrNoSpaceLeft = errors.New("no space left")
 
-// Tags storage space for the deal.
+// Tags storage space for the deal in the staging area that has the least
+// space tagged relative to its weight.
 // If there is not enough space left, returns ErrNoSpaceLeft.
 func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, size uint64) error {
+	return m.tag(ctx, dealUuid, size, "")
+}
+
+// TagFile tags storage space for the deal in the staging area that contains
+// filePath (eg because the deal data has already been partly downloaded).
+// If filePath is not in any of the staging areas, it behaves like Tag.
+func (m *StorageManager) TagFile(ctx context.Context, dealUuid uuid.UUID, size uint64, filePath string) error {
+	return m.tag(ctx, dealUuid, size, filePath)
+}
+
+func (m *StorageManager) tag(ctx context.Context, dealUuid uuid.UUID, size uint64, filePath string) error {
 	// Get the total tagged storage, so that we know how much is available.
 	log.Debugw("tagging", "id", dealUuid, "size", size, "maxbytes", m.cfg.MaxStagingDealsBytes)
 
-	tagged, err := m.TotalTagged(ctx)
+	usage, err := m.StagingAreas(ctx)
 	if err != nil {
-		return fmt.Errorf("getting total tagged: %w", err)
+		return err
 	}
 
+	tagged, err := m.TotalTagged(ctx)
+	if err != nil {
+		return err
+	}
 	if m.cfg.MaxStagingDealsBytes != 0 {
 		if tagged+size >= m.cfg.MaxStagingDealsBytes {
 			err := fmt.Errorf("%w: cannot accept piece of size %d, on top of already allocated %d bytes, because it would exceed max staging area size %d",
@@ -82,7 +306,12 @@ func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, size uint6
 		}
 	}
 
-	err = m.persistTagged(ctx, dealUuid, size)
+	area, err := pickStagingArea(usage, size, filePath, m.cfg.MinFreeDiskBytes)
+	if err != nil {
+		return err
+	}
+
+	err = m.persistTagged(ctx, dealUuid, size, area.Path)
 	if err != nil {
 		return fmt.Errorf("saving total tagged storage: %w", err)
 	}
@@ -90,8 +319,99 @@ func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, size uint6
 	return nil
 }
 
+// pickStagingArea picks the staging area that contains filePath if there
+// is one, or else the staging area with space for the deal that has the
+// least space tagged relative to its weight
+func pickStagingArea(usage []StagingAreaUsage, size uint64, filePath string, minFreeDiskBytes uint64) (*StagingAreaUsage, error) {
+	hasSpace := func(u StagingAreaUsage) bool {
+		return u.MaxBytes == 0 || u.Tagged+size < u.MaxBytes
+	}
+	hasDiskSpace := func(u StagingAreaUsage) bool {
+		return u.DiskFree() >= size+minFreeDiskBytes
+	}
+
+	if filePath != "" {
+		for i, u := range usage {
+			if isInDir(u.Path, filePath) {
+				if !hasSpace(u) {
+					return nil, fmt.Errorf("%w: cannot accept piece of size %d, on top of already allocated %d bytes, because it would exceed max size %d of staging area %s",
+						ErrNoSpaceLeft, size, u.Tagged, u.MaxBytes, u.Path)
+				}
+				if !hasDiskSpace(u) {
+					return nil, fmt.Errorf("%w: cannot accept piece of size %d because there are only %d bytes of disk space available for staging area %s (%d bytes pending for other deals, %d bytes reserved)",
+						ErrNoSpaceLeft, size, u.DiskAvailable, u.Path, u.Pending, minFreeDiskBytes)
+				}
+				return &usage[i], nil
+			}
+		}
+	}
+
+	var picked *StagingAreaUsage
+	exceedsDisk := false
+	for i, u := range usage {
+		if !hasSpace(u) {
+			continue
+		}
+		if !hasDiskSpace(u) {
+			exceedsDisk = true
+			continue
+		}
+		if picked == nil || float64(u.Tagged+size)/float64(u.Weight) < float64(picked.Tagged+size)/float64(picked.Weight) {
+			picked = &usage[i]
+		}
+	}
+	if picked == nil {
+		if exceedsDisk {
+			return nil, fmt.Errorf("%w: cannot accept piece of size %d because there is not enough disk space available in any staging area (%d bytes reserved)",
+				ErrNoSpaceLeft, size, minFreeDiskBytes)
+		}
+		return nil, fmt.Errorf("%w: cannot accept piece of size %d because it would exceed the max size of every staging area",
+			ErrNoSpaceLeft, size)
+	}
+	return picked, nil
+}
+
+// StagingAreaPath returns the path of the staging area that contains
+// filePath, or the empty string if it is not in any staging area
+func (m *StorageManager) StagingAreaPath(filePath string) string {
+	for _, a := range m.areas {
+		if isInDir(a.Path, filePath) {
+			return a.Path
+		}
+	}
+	return ""
+}
+
+func isInDir(dir string, filePath string) bool {
+	rel, err := filepath.Rel(dir, filePath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// SetReceived records the number of bytes of deal data that have been
+// written to the staging area for a tagged deal, so that the disk space that
+// is still needed for the deal is known without reading the file (the file
+// for a parallel download is sparse, so its size is not the number of bytes
+// written)
+func (m *StorageManager) SetReceived(dealUuid uuid.UUID, received uint64) {
+	m.receivedLk.Lock()
+	defer m.receivedLk.Unlock()
+
+	m.received[dealUuid] = received
+}
+
+func (m *StorageManager) getReceived(dealUuid uuid.UUID) uint64 {
+	m.receivedLk.RLock()
+	defer m.receivedLk.RUnlock()
+
+	return m.received[dealUuid]
+}
+
 // Untag
 func (m *StorageManager) Untag(ctx context.Context, dealUuid uuid.UUID) error {
+	m.receivedLk.Lock()
+	delete(m.received, dealUuid)
+	m.receivedLk.Unlock()
+
 	size, err := m.db.Untag(ctx, dealUuid)
 	if err != nil {
 		return fmt.Errorf("persisting untag storage for deal to DB: %w", err)
@@ -119,8 +439,8 @@ func (m *StorageManager) TotalTagged(ctx context.Context) (uint64, error) {
 	return total, nil
 }
 
-func (m *StorageManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, size uint64) error {
-	err := m.db.Tag(ctx, dealUuid, size)
+func (m *StorageManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, size uint64, stagingAreaPath string) error {
+	err := m.db.Tag(ctx, dealUuid, size, stagingAreaPath)
 	if err != nil {
 		return fmt.Errorf("persisting tagged storage for deal to DB: %w", err)
 	}
@@ -128,21 +448,29 @@ func (m *StorageManager) persistTagged(ctx context.Context, dealUuid uuid.UUID,
 	storageLog := &db.StorageLog{
 		DealUUID:     dealUuid,
 		TransferSize: size,
-		Text:         "Tag staging storage",
+		Text:         fmt.Sprintf("Tag staging storage in %s", stagingAreaPath),
 	}
 	err = m.db.InsertLog(ctx, storageLog)
 	if err != nil {
 		return fmt.Errorf("persisting tag storage log to DB: %w", err)
 	}
 
-	log.Infow("tag storage", "id", dealUuid, "size", size)
+	log.Infow("tag storage", "id", dealUuid, "size", size, "path", stagingAreaPath)
 	return nil
 }
 
-// DownloadFilePath creates a file in the download staging area for the deal
-// with the given uuid
-func (m *StorageManager) DownloadFilePath(dealUuid uuid.UUID) (string, error) {
-	path := path.Join(m.StagingAreaDirPath, dealUuid.String()+".download")
+// DownloadFilePath creates a file for the deal with the given uuid in the
+// staging area in which storage space is tagged for the deal
+func (m *StorageManager) DownloadFilePath(ctx context.Context, dealUuid uuid.UUID) (string, error) {
+	stagingAreaPath, err := m.db.TaggedPath(ctx, dealUuid)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return "", fmt.Errorf("getting staging area for deal: %w", err)
+	}
+	if stagingAreaPath == "" {
+		stagingAreaPath = m.StagingAreaDirPath
+	}
+
+	path := path.Join(stagingAreaPath, dealUuid.String()+".download")
 	file, err := os.Create(path)
 	if err != nil {
 		return "", fmt.Errorf("failed to create download file %s", path)
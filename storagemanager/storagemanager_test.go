@@ -0,0 +1,327 @@
+package storagemanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/lotus/extern/sector-storage/fsutil"
+	lotus_repo "github.com/filecoin-project/lotus/node/repo"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestStagingAreas(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a")
+	pathB := filepath.Join(dir, "b")
+	sm, err := New(Config{
+		MaxStagingDealsBytes: 1000,
+		StagingAreas: []StagingArea{
+			{Path: pathA, MaxBytes: 100, Weight: 1},
+			{Path: pathB, Weight: 3},
+		},
+	})(fxtest.NewLifecycle(t), newRepo(t), sqldb)
+	req.NoError(err)
+	sm.statfs = func(string) (fsutil.FsStat, error) {
+		return fsutil.FsStat{Available: 1 << 40}, nil
+	}
+
+	taggedPath := func(dealUuid uuid.UUID) string {
+		p, err := db.NewStorageDB(sqldb).TaggedPath(ctx, dealUuid)
+		req.NoError(err)
+		return p
+	}
+
+	// Deals should be spread across the areas by weight
+	var deals []uuid.UUID
+	for i := 0; i < 4; i++ {
+		dealUuid := uuid.New()
+		req.NoError(sm.Tag(ctx, dealUuid, 10))
+		deals = append(deals, dealUuid)
+	}
+	req.Equal(pathB, taggedPath(deals[0]))
+	req.Equal(pathB, taggedPath(deals[1]))
+	req.Equal(pathA, taggedPath(deals[2]))
+	req.Equal(pathB, taggedPath(deals[3]))
+
+	usage, err := sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.Len(usage, 2)
+	req.EqualValues(10, usage[0].Tagged)
+	req.EqualValues(90, usage[0].Free())
+	req.EqualValues(30, usage[1].Tagged)
+
+	// The download file should be created in the tagged area
+	downloadPath, err := sm.DownloadFilePath(ctx, deals[1])
+	req.NoError(err)
+	req.Equal(pathB, filepath.Dir(downloadPath))
+	req.Equal(pathB, sm.StagingAreaPath(downloadPath))
+
+	// A deal that is too big for the first area should go to the second
+	large := uuid.New()
+	req.NoError(sm.Tag(ctx, large, 95))
+	req.Equal(pathB, taggedPath(large))
+
+	// When the deal data is already in an area, space should be tagged in
+	// that area
+	existing := uuid.New()
+	req.NoError(sm.TagFile(ctx, existing, 10, filepath.Join(pathA, existing.String()+".download")))
+	req.Equal(pathA, taggedPath(existing))
+
+	// Deals that would exceed the total max bytes should be rejected
+	err = sm.Tag(ctx, uuid.New(), 900)
+	req.ErrorIs(err, ErrNoSpaceLeft)
+
+	// Untagging should free up space in the area
+	req.NoError(sm.Untag(ctx, deals[2]))
+	usage, err = sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.EqualValues(10, usage[0].Tagged)
+}
+
+func TestStagingAreaPaths(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	// Storage tagged before staging areas were recorded has no staging area
+	sdb := db.NewStorageDB(sqldb)
+	legacy := uuid.New()
+	req.NoError(sdb.Tag(ctx, legacy, 10, ""))
+
+	// A relative staging area path should be relative to the repo
+	lr := newRepo(t)
+	other := t.TempDir()
+	sm, err := New(Config{
+		StagingAreas: []StagingArea{
+			{Path: other, MaxBytes: 100},
+			{Path: "staging/b", MaxBytes: 50},
+		},
+	})(fxtest.NewLifecycle(t), lr, sqldb)
+	req.NoError(err)
+	sm.statfs = func(string) (fsutil.FsStat, error) {
+		return fsutil.FsStat{Available: 1 << 40}, nil
+	}
+
+	pathB := filepath.Join(lr.Path(), "staging", "b")
+	req.DirExists(pathB)
+	usage, err := sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.Len(usage, 2)
+	req.Equal(pathB, usage[1].Path)
+
+	// The legacy storage should be moved to the incoming directory in the
+	// repo, rather than being counted against the first staging area
+	p, err := sdb.TaggedPath(ctx, legacy)
+	req.NoError(err)
+	req.Equal(filepath.Join(lr.Path(), StagingAreaDirName), p)
+	req.EqualValues(0, usage[0].Tagged)
+	req.EqualValues(0, usage[1].Tagged)
+
+	// The free space should take into account the max size of each area
+	req.NoError(sm.Tag(ctx, uuid.New(), 30))
+	free, err := sm.Free(ctx)
+	req.NoError(err)
+	req.EqualValues(120, free)
+}
+
+func TestFreeSpace(t *testing.T) {
+	req := require.New(t)
+
+	limited := []StagingAreaUsage{
+		{StagingArea: StagingArea{MaxBytes: 100}, Tagged: 40},
+		{StagingArea: StagingArea{MaxBytes: 50}, Tagged: 60},
+	}
+	unlimited := []StagingAreaUsage{
+		{StagingArea: StagingArea{MaxBytes: 100}, Tagged: 40},
+		{StagingArea: StagingArea{}, Tagged: 60},
+	}
+
+	// No limits at all
+	sm := &StorageManager{}
+	req.EqualValues(0, sm.FreeSpace(100, unlimited))
+	// Only the staging areas are limited
+	req.EqualValues(60, sm.FreeSpace(100, limited))
+
+	// The total limit is lower than the space in the staging areas
+	sm = &StorageManager{cfg: Config{MaxStagingDealsBytes: 130}}
+	req.EqualValues(30, sm.FreeSpace(100, limited))
+	req.EqualValues(30, sm.FreeSpace(100, unlimited))
+	// The space in the staging areas is lower than the total limit
+	sm = &StorageManager{cfg: Config{MaxStagingDealsBytes: 1000}}
+	req.EqualValues(60, sm.FreeSpace(100, limited))
+	req.EqualValues(900, sm.FreeSpace(100, unlimited))
+	// More is tagged than the total limit
+	req.EqualValues(0, sm.FreeSpace(1100, unlimited))
+}
+
+func TestStagingAreaDiskSpace(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	dir := t.TempDir()
+	sm, err := New(Config{
+		StagingAreas:     []StagingArea{{Path: dir}},
+		MinFreeDiskBytes: 100,
+	})(fxtest.NewLifecycle(t), newRepo(t), sqldb)
+	req.NoError(err)
+
+	available := int64(200)
+	sm.statfs = func(string) (fsutil.FsStat, error) {
+		return fsutil.FsStat{Available: available}, nil
+	}
+
+	var lowEvents []bool
+	sm.OnDiskSpaceLow(func(low bool) {
+		lowEvents = append(lowEvents, low)
+	})
+
+	// There is enough disk space for the deal on top of the reserve
+	first := uuid.New()
+	req.NoError(sm.Tag(ctx, first, 50))
+
+	// The space tagged for the first deal has not yet been written, so
+	// there isn't enough disk space for the second deal
+	err = sm.Tag(ctx, uuid.New(), 60)
+	req.ErrorIs(err, ErrNoSpaceLeft)
+
+	// Once part of the data for the first deal has been received, only the
+	// remainder is pending. The size of the download file is not used, as
+	// the file may be sparse.
+	downloadPath, err := sm.DownloadFilePath(ctx, first)
+	req.NoError(err)
+	req.NoError(os.Truncate(downloadPath, 50))
+	err = sm.Tag(ctx, uuid.New(), 60)
+	req.ErrorIs(err, ErrNoSpaceLeft)
+	sm.SetReceived(first, 40)
+	second := uuid.New()
+	req.NoError(sm.Tag(ctx, second, 60))
+
+	usage, err := sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.Len(usage, 1)
+	req.EqualValues(110, usage[0].Tagged)
+	req.EqualValues(70, usage[0].Pending)
+	req.EqualValues(200, usage[0].DiskAvailable)
+	req.False(usage[0].DiskLow)
+
+	// When the disk space drops below the reserve the listeners should be
+	// notified
+	sm.checkDiskSpace(ctx)
+	req.Empty(lowEvents)
+	available = 150
+	sm.checkDiskSpace(ctx)
+	req.Equal([]bool{true}, lowEvents)
+	sm.checkDiskSpace(ctx)
+	req.Equal([]bool{true}, lowEvents)
+
+	usage, err = sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.True(usage[0].DiskLow)
+
+	// When the disk space recovers the listeners should be notified
+	available = 1000
+	sm.checkDiskSpace(ctx)
+	req.Equal([]bool{true, false}, lowEvents)
+
+	// The bytes received should be forgotten when the deal is untagged
+	req.NoError(sm.Untag(ctx, first))
+	req.NoError(sm.Tag(ctx, first, 50))
+	usage, err = sm.StagingAreas(ctx)
+	req.NoError(err)
+	req.EqualValues(110, usage[0].Pending)
+}
+
+func TestGC(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(db.Migrate(sqldb))
+
+	dir := t.TempDir()
+	sm, err := New(Config{
+		StagingAreas: []StagingArea{{Path: dir}},
+	})(fxtest.NewLifecycle(t), newRepo(t), sqldb)
+	req.NoError(err)
+
+	writeFile := func(name string, size int, age time.Duration) string {
+		p := filepath.Join(dir, name)
+		req.NoError(os.WriteFile(p, make([]byte, size), 0644))
+		modTime := time.Now().Add(-age)
+		req.NoError(os.Chtimes(p, modTime, modTime))
+		return p
+	}
+	inUse := writeFile("in-use.download", 10, 2*time.Hour)
+	oldOrphan := writeFile("old.download", 20, 2*time.Hour)
+	newOrphan := writeFile("new.download", 30, time.Minute)
+	retained := writeFile("retained.download", 40, 2*time.Hour)
+	req.NoError(os.Mkdir(filepath.Join(dir, "subdir"), os.ModePerm))
+
+	inUsePaths := map[string]struct{}{inUse: {}}
+	retainedUntil := time.Now().Add(time.Hour)
+	retainedPaths := map[string]time.Time{retained: retainedUntil}
+
+	// A dry run should report the orphaned files without deleting them
+	rep, err := sm.GC(ctx, inUsePaths, retainedPaths, time.Hour, true)
+	req.NoError(err)
+	req.True(rep.DryRun)
+	req.Len(rep.Orphaned, 2)
+	req.Equal([]RetainedFile{{Path: retained, Size: 40, Until: retainedUntil}}, rep.Retained)
+	req.EqualValues(40, rep.RetainedBytes)
+	req.EqualValues(20, rep.ReclaimableBytes)
+	req.EqualValues(0, rep.DeletedBytes)
+	for _, o := range rep.Orphaned {
+		req.False(o.Deleted)
+		req.Equal(o.Path == newOrphan, o.InGracePeriod)
+	}
+	req.FileExists(oldOrphan)
+
+	// Only orphaned files older than the grace period should be deleted
+	rep, err = sm.GC(ctx, inUsePaths, retainedPaths, time.Hour, false)
+	req.NoError(err)
+	req.Len(rep.Orphaned, 2)
+	req.EqualValues(0, rep.ReclaimableBytes)
+	req.EqualValues(20, rep.DeletedBytes)
+	req.NoFileExists(oldOrphan)
+	req.FileExists(newOrphan)
+	req.FileExists(inUse)
+	req.FileExists(retained)
+	req.DirExists(filepath.Join(dir, "subdir"))
+
+	// Once a file is no longer retained it is orphaned
+	rep, err = sm.GC(ctx, inUsePaths, nil, time.Hour, false)
+	req.NoError(err)
+	req.Len(rep.Orphaned, 2)
+	req.EqualValues(40, rep.DeletedBytes)
+	req.NoFileExists(retained)
+}
+
+func newRepo(t *testing.T) lotus_repo.LockedRepo {
+	r, err := lotus_repo.NewFS(t.TempDir())
+	require.NoError(t, err)
+	lr, err := r.Lock(lotus_repo.StorageMiner)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lr.Close() })
+	return lr
+}
@@ -0,0 +1,397 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/sealingpipeline"
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/extern/sector-storage/sealtasks"
+	sealing "github.com/filecoin-project/lotus/extern/storage-sealing"
+	"github.com/google/uuid"
+)
+
+type AddPieceSchedulerConfig struct {
+	// Deals are held while there are more than this many sectors in the
+	// PreCommit1 state (0 means no limit)
+	MaxSectorsPreCommit1 uint64
+	// Deals are held while there are more than this many sectors in the
+	// WaitDeals state (0 means no limit)
+	MaxSectorsWaitDeals uint64
+	// Deals are held while there are more than this many PreCommit1 jobs
+	// on the sealing workers (0 means no limit)
+	MaxPreCommit1Jobs uint64
+	// How often to check the sealing pipeline while deals are held
+	CheckInterval time.Duration
+	// A held deal is released whatever the limits once the chain head is
+	// within this many epochs of the deal's start epoch, because a
+	// published deal that is not sealed by its start epoch costs the
+	// provider its collateral (0 means deals are held for as long as the
+	// sealing pipeline is over the limits)
+	StartEpochSafetyMargin abi.ChainEpoch
+}
+
+func (c AddPieceSchedulerConfig) enabled() bool {
+	return c.MaxSectorsPreCommit1 > 0 || c.MaxSectorsWaitDeals > 0 || c.MaxPreCommit1Jobs > 0
+}
+
+// AddPieceQueue describes the deals that are waiting to be added to a
+// sector, and the scheduler's latest decision about them
+type AddPieceQueue struct {
+	// The uuids of the held deals, in the order in which they will be added
+	// to a sector (earliest start epoch first)
+	Deals []uuid.UUID
+	// True if deals are being held because the sealing pipeline is over
+	// capacity, or the deals that were released have not yet been added
+	// to a sector
+	Waiting bool
+	// Why the deals are being held
+	Reason string
+	// When the sealing pipeline was last checked
+	CheckedAt time.Time
+	Config    AddPieceSchedulerConfig
+}
+
+// addPieceScheduler holds published deals until the sealing pipeline has
+// capacity for them, so that deals aren't piled up in the sealer while the
+// sealing workers are saturated.
+//
+// Held deals are ordered by start epoch, so that the deal that is most at
+// risk of missing its start epoch is added to a sector first. Each check
+// releases the deals that are within the safety margin of their start
+// epoch, and then as many deals as the limits allow, assuming that each deal may
+// add another sector or job to the sealing pipeline. Released deals count
+// against the limits until the sealer has responded to the first attempt
+// to add them to a sector, so that they show up in the sealing pipeline
+// status.
+type addPieceScheduler struct {
+	cfg       AddPieceSchedulerConfig
+	getStatus func(ctx context.Context) (*sealingpipeline.Status, error)
+	getHead   func(ctx context.Context) (abi.ChainEpoch, error)
+	// wakes up the scheduler loop
+	wake chan struct{}
+
+	lk sync.Mutex
+	// held deals, ordered by start epoch
+	queue []*heldAddPiece
+	// the deals that have been released, until the first attempt to add
+	// them to a sector returns
+	active    map[uuid.UUID]struct{}
+	waiting   bool
+	reason    string
+	checkedAt time.Time
+}
+
+type heldAddPiece struct {
+	dealUuid   uuid.UUID
+	startEpoch abi.ChainEpoch
+	// closed when the deal can be added to a sector
+	ready chan struct{}
+	// called when the deal is held
+	onHeld func(position int, reason string)
+	// the reason the deal was held when onHeld was last called
+	heldReason string
+}
+
+func newAddPieceScheduler(cfg AddPieceSchedulerConfig, getStatus func(ctx context.Context) (*sealingpipeline.Status, error), getHead func(ctx context.Context) (abi.ChainEpoch, error)) *addPieceScheduler {
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = time.Minute
+	}
+	return &addPieceScheduler{
+		cfg:       cfg,
+		getStatus: getStatus,
+		getHead:   getHead,
+		wake:      make(chan struct{}, 1),
+		active:    make(map[uuid.UUID]struct{}),
+	}
+}
+
+// AddPieceQueue returns the deals that are waiting for the sealing pipeline
+// to have capacity before they are added to a sector
+func (p *Provider) AddPieceQueue() AddPieceQueue {
+	return p.addPieceScheduler.status()
+}
+
+// AddPieceQueuePosition returns the position of the deal in the queue of
+// deals waiting to be added to a sector, starting from 1. It returns 0 if
+// the deal is not queued.
+func (p *Provider) AddPieceQueuePosition(dealUuid uuid.UUID) int {
+	return p.addPieceScheduler.queuePosition(dealUuid)
+}
+
+// waitToAddPiece blocks until the deal can be added to a sector, or the
+// context is cancelled. onHeld is called with the position of the deal in
+// the queue whenever the scheduler holds the deal (for a different reason
+// than the last time). Once the deal has been released, the caller must
+// call complete when the first attempt to add it to a sector returns.
+func (s *addPieceScheduler) waitToAddPiece(ctx context.Context, dealUuid uuid.UUID, startEpoch abi.ChainEpoch, onHeld func(position int, reason string)) error {
+	if !s.cfg.enabled() {
+		return nil
+	}
+
+	h := &heldAddPiece{dealUuid: dealUuid, startEpoch: startEpoch, ready: make(chan struct{}), onHeld: onHeld}
+
+	s.lk.Lock()
+	s.queue = append(s.queue, h)
+	sort.SliceStable(s.queue, func(i, j int) bool {
+		return s.queue[i].startEpoch < s.queue[j].startEpoch
+	})
+	s.lk.Unlock()
+
+	s.wakeUp()
+
+	select {
+	case <-h.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	select {
+	case <-h.ready:
+		// The deal was released at the same time as the context was
+		// cancelled, so give up its place to the next deal
+		s.completeLocked(dealUuid)
+	default:
+		s.removeFromQueueLocked(dealUuid)
+	}
+	return ctx.Err()
+}
+
+// complete is called when the first attempt to add a released deal to a
+// sector returns, so that the deal no longer counts against the limits.
+// It is safe to call more than once.
+func (s *addPieceScheduler) complete(dealUuid uuid.UUID) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.completeLocked(dealUuid)
+}
+
+func (s *addPieceScheduler) status() AddPieceQueue {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	deals := make([]uuid.UUID, 0, len(s.queue))
+	for _, h := range s.queue {
+		deals = append(deals, h.dealUuid)
+	}
+	return AddPieceQueue{
+		Deals:     deals,
+		Waiting:   s.waiting,
+		Reason:    s.reason,
+		CheckedAt: s.checkedAt,
+		Config:    s.cfg,
+	}
+}
+
+func (s *addPieceScheduler) queuePosition(dealUuid uuid.UUID) int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.queuePositionLocked(dealUuid)
+}
+
+func (s *addPieceScheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+
+		s.check(ctx)
+	}
+}
+
+// check releases the held deals that are close to their start epoch, and
+// as many of the next most urgent held deals as the sealing pipeline has
+// capacity for, and notifies the deals that are still held
+func (s *addPieceScheduler) check(ctx context.Context) {
+	s.lk.Lock()
+	if len(s.queue) == 0 {
+		s.waiting = false
+		s.reason = "no deals waiting to be added to a sector"
+		s.lk.Unlock()
+		return
+	}
+	s.lk.Unlock()
+
+	capacity, reason, err := s.capacity(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		// Don't hold deals just because the sealing pipeline status is
+		// not available, but only release one deal at a time
+		log.Warnw("checking sealing pipeline capacity for add piece", "err", err)
+		capacity, reason = 1, ""
+	}
+	releaseBefore := s.releaseBefore(ctx)
+
+	s.lk.Lock()
+
+	s.checkedAt = time.Now()
+	for len(s.queue) > 0 && s.queue[0].startEpoch <= releaseBefore {
+		next := s.releaseNextLocked()
+		log.Infow("releasing held deal because it is close to its start epoch",
+			"id", next.dealUuid, "start epoch", next.startEpoch, "reason held", next.heldReason)
+	}
+	if capacity > 0 {
+		// Deals that have been released but have not yet shown up in the
+		// sealing pipeline status use up some of the capacity
+		capacity -= len(s.active)
+		for capacity > 0 && len(s.queue) > 0 {
+			s.releaseNextLocked()
+			capacity--
+		}
+		reason = fmt.Sprintf("waiting for %d released deals to be added to a sector", len(s.active))
+	}
+
+	s.waiting = len(s.queue) > 0
+	if !s.waiting {
+		s.reason = "sealing pipeline has capacity"
+		s.lk.Unlock()
+		return
+	}
+	s.reason = reason
+	log.Debugw("holding deals until sealing pipeline has capacity", "count", len(s.queue), "reason", reason)
+
+	// Notify the held deals, outside the lock
+	var notify []func()
+	for i, h := range s.queue {
+		if h.heldReason == reason {
+			continue
+		}
+		h.heldReason = reason
+		onHeld, position := h.onHeld, i+1
+		notify = append(notify, func() { onHeld(position, reason) })
+	}
+	s.lk.Unlock()
+
+	for _, n := range notify {
+		n()
+	}
+}
+
+// releaseBefore returns the epoch up to which held deals are released
+// whatever the limits, or -1 if no deal should be released because of its
+// start epoch
+func (s *addPieceScheduler) releaseBefore(ctx context.Context) abi.ChainEpoch {
+	if s.cfg.StartEpochSafetyMargin <= 0 {
+		return -1
+	}
+
+	head, err := s.getHead(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warnw("getting chain head for add piece", "err", err)
+		}
+		return -1
+	}
+	return head + s.cfg.StartEpochSafetyMargin
+}
+
+// capacity returns the number of deals that the sealing pipeline has
+// capacity for, assuming each deal may add one sector or job in each of
+// the states that are limited. If there is no capacity it also returns the
+// reason the sealing pipeline is over capacity.
+func (s *addPieceScheduler) capacity(ctx context.Context) (int, string, error) {
+	st, err := s.getStatus(ctx)
+	if err != nil {
+		return 0, "", fmt.Errorf("getting sealing pipeline status: %w", err)
+	}
+
+	var pc1Jobs uint64
+	for _, w := range st.Workers {
+		if w.Stage == sealtasks.TTPreCommit1.Short() {
+			pc1Jobs++
+		}
+	}
+
+	limits := []struct {
+		name    string
+		current uint64
+		max     uint64
+	}{{
+		name:    "sectors in PreCommit1",
+		current: uint64(st.SectorStates[lapi.SectorState(sealing.PreCommit1)]),
+		max:     s.cfg.MaxSectorsPreCommit1,
+	}, {
+		name:    "sectors in WaitDeals",
+		current: uint64(st.SectorStates[lapi.SectorState(sealing.WaitDeals)]),
+		max:     s.cfg.MaxSectorsWaitDeals,
+	}, {
+		name:    "PreCommit1 jobs on sealing workers",
+		current: pc1Jobs,
+		max:     s.cfg.MaxPreCommit1Jobs,
+	}}
+
+	capacity := -1
+	for _, l := range limits {
+		if l.max == 0 {
+			continue
+		}
+		if l.current > l.max {
+			return 0, fmt.Sprintf("%d %s is above the maximum %d", l.current, l.name, l.max), nil
+		}
+		// Deals are held while the count is above the maximum, so the
+		// pipeline has capacity for deals up to one over the maximum
+		if c := int(l.max-l.current) + 1; capacity < 0 || c < capacity {
+			capacity = c
+		}
+	}
+	return capacity, "", nil
+}
+
+func (s *addPieceScheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// releaseNextLocked releases the most urgent held deal
+func (s *addPieceScheduler) releaseNextLocked() *heldAddPiece {
+	next := s.queue[0]
+	s.queue = s.queue[1:]
+	s.active[next.dealUuid] = struct{}{}
+	close(next.ready)
+	return next
+}
+
+func (s *addPieceScheduler) completeLocked(dealUuid uuid.UUID) {
+	if _, ok := s.active[dealUuid]; !ok {
+		return
+	}
+	delete(s.active, dealUuid)
+	s.wakeUp()
+}
+
+func (s *addPieceScheduler) queuePositionLocked(dealUuid uuid.UUID) int {
+	for i, h := range s.queue {
+		if h.dealUuid == dealUuid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *addPieceScheduler) removeFromQueueLocked(dealUuid uuid.UUID) {
+	for i, h := range s.queue {
+		if h.dealUuid == dealUuid {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
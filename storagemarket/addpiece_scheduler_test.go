@@ -0,0 +1,249 @@
+package storagemarket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/sealingpipeline"
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPieceScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Hold deals while there are more than two sectors in PreCommit1
+	var lk sync.Mutex
+	pc1Sectors := 3
+	s := newAddPieceScheduler(AddPieceSchedulerConfig{
+		MaxSectorsPreCommit1: 2,
+		CheckInterval:        10 * time.Millisecond,
+	}, func(ctx context.Context) (*sealingpipeline.Status, error) {
+		lk.Lock()
+		defer lk.Unlock()
+		return &sealingpipeline.Status{
+			SectorStates: map[lapi.SectorState]int{"PreCommit1": pc1Sectors},
+		}, nil
+	}, headAt(0))
+	go s.run(ctx)
+
+	// The deals should be held in order of start epoch
+	d1, d2, d3 := uuid.New(), uuid.New(), uuid.New()
+	d1Ready := waitToAddPieceInBackground(ctx, s, d1, 300)
+	d2Ready := waitToAddPieceInBackground(ctx, s, d2, 100)
+	d3Ready := waitToAddPieceInBackground(ctx, s, d3, 200)
+	require.Eventually(t, func() bool {
+		st := s.status()
+		return len(st.Deals) == 3 && st.Waiting
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []uuid.UUID{d2, d3, d1}, s.status().Deals)
+	require.Contains(t, s.status().Reason, "PreCommit1")
+	require.Equal(t, 1, s.queuePosition(d2))
+
+	// When the sealing pipeline has capacity for one more deal, the most
+	// urgent deal should be released, and the next deal should wait until
+	// the sealer has responded to the first attempt to add it to a sector
+	lk.Lock()
+	pc1Sectors = 2
+	lk.Unlock()
+	require.NoError(t, <-d2Ready)
+	require.Equal(t, []uuid.UUID{d3, d1}, s.status().Deals)
+	require.Eventually(t, func() bool {
+		return s.status().Reason == "waiting for 1 released deals to be added to a sector"
+	}, time.Second, 10*time.Millisecond)
+
+	s.complete(d2)
+	require.NoError(t, <-d3Ready)
+	s.complete(d3)
+	require.NoError(t, <-d1Ready)
+	s.complete(d1)
+	require.Empty(t, s.status().Deals)
+}
+
+func TestAddPieceSchedulerReleasesWhileDealBlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Hold deals while there are more than two sectors in WaitDeals
+	var lk sync.Mutex
+	waitDealsSectors := 3
+	s := newAddPieceScheduler(AddPieceSchedulerConfig{
+		MaxSectorsWaitDeals: 2,
+		CheckInterval:       10 * time.Millisecond,
+	}, func(ctx context.Context) (*sealingpipeline.Status, error) {
+		lk.Lock()
+		defer lk.Unlock()
+		return &sealingpipeline.Status{
+			SectorStates: map[lapi.SectorState]int{"WaitDeals": waitDealsSectors},
+		}, nil
+	}, headAt(0))
+	go s.run(ctx)
+
+	// Each held deal should be told that it is being held
+	d1, d2, d3 := uuid.New(), uuid.New(), uuid.New()
+	type heldDeal struct {
+		dealUuid uuid.UUID
+		reason   string
+	}
+	held := make(chan heldDeal, 3)
+	wait := func(dealUuid uuid.UUID, startEpoch abi.ChainEpoch) chan error {
+		ready := make(chan error, 1)
+		go func() {
+			ready <- s.waitToAddPiece(ctx, dealUuid, startEpoch, func(position int, reason string) {
+				held <- heldDeal{dealUuid: dealUuid, reason: reason}
+			})
+		}()
+		return ready
+	}
+	d1Ready := wait(d1, 100)
+	d2Ready := wait(d2, 200)
+	h1, h2 := <-held, <-held
+	require.ElementsMatch(t, []uuid.UUID{d1, d2}, []uuid.UUID{h1.dealUuid, h2.dealUuid})
+	require.Contains(t, h1.reason, "WaitDeals")
+	require.Contains(t, h2.reason, "WaitDeals")
+
+	// When the sealing pipeline has capacity for another sector, the most
+	// urgent deal should be released
+	lk.Lock()
+	waitDealsSectors = 2
+	lk.Unlock()
+	require.NoError(t, <-d1Ready)
+
+	// While the first attempt to add the released deal to a sector is
+	// blocked the next deal should be held, without holding up the
+	// scheduler
+	require.Eventually(t, func() bool {
+		return s.status().Reason == "waiting for 1 released deals to be added to a sector"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []uuid.UUID{d2}, s.status().Deals)
+	h := <-held
+	require.Equal(t, d2, h.dealUuid)
+	require.Equal(t, s.status().Reason, h.reason)
+
+	// When the sealing pipeline has more capacity, the held deal and any
+	// new deal should be released, even though the first deal is still
+	// blocked
+	lk.Lock()
+	waitDealsSectors = 0
+	lk.Unlock()
+	require.NoError(t, <-d2Ready)
+	d3Ready := wait(d3, 300)
+	require.NoError(t, <-d3Ready)
+	require.Empty(t, s.status().Deals)
+
+	s.complete(d1)
+	s.complete(d2)
+	s.complete(d3)
+}
+
+func TestAddPieceSchedulerCancelHeld(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newAddPieceScheduler(AddPieceSchedulerConfig{
+		MaxSectorsWaitDeals: 1,
+		CheckInterval:       10 * time.Millisecond,
+	}, func(ctx context.Context) (*sealingpipeline.Status, error) {
+		return &sealingpipeline.Status{
+			SectorStates: map[lapi.SectorState]int{"WaitDeals": 2},
+		}, nil
+	}, headAt(0))
+	go s.run(ctx)
+
+	dealCtx, dealCancel := context.WithCancel(ctx)
+	d1 := uuid.New()
+	d1Ready := waitToAddPieceInBackground(dealCtx, s, d1, 100)
+	require.Eventually(t, func() bool { return s.queuePosition(d1) == 1 }, time.Second, 10*time.Millisecond)
+
+	// Cancelling a held deal should remove it from the queue
+	dealCancel()
+	require.ErrorIs(t, <-d1Ready, context.Canceled)
+	require.Empty(t, s.status().Deals)
+}
+
+func TestAddPieceSchedulerDisabled(t *testing.T) {
+	s := newAddPieceScheduler(AddPieceSchedulerConfig{}, func(ctx context.Context) (*sealingpipeline.Status, error) {
+		require.Fail(t, "sealing pipeline should not be checked when there are no limits")
+		return nil, nil
+	}, headAt(0))
+
+	// With no limits the deal should not be held
+	require.NoError(t, s.waitToAddPiece(context.Background(), uuid.New(), 100, func(int, string) {
+		require.Fail(t, "deal should not be held")
+	}))
+}
+
+func TestAddPieceSchedulerReleasesNearStartEpoch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The sealing pipeline is always over the limits
+	var lk sync.Mutex
+	head := abi.ChainEpoch(0)
+	s := newAddPieceScheduler(AddPieceSchedulerConfig{
+		MaxSectorsPreCommit1:   1,
+		CheckInterval:          10 * time.Millisecond,
+		StartEpochSafetyMargin: 100,
+	}, func(ctx context.Context) (*sealingpipeline.Status, error) {
+		return &sealingpipeline.Status{
+			SectorStates: map[lapi.SectorState]int{"PreCommit1": 5},
+		}, nil
+	}, func(ctx context.Context) (abi.ChainEpoch, error) {
+		lk.Lock()
+		defer lk.Unlock()
+		return head, nil
+	})
+	go s.run(ctx)
+
+	// A deal that is already within the safety margin of its start epoch
+	// should not be held
+	d1, d2, d3 := uuid.New(), uuid.New(), uuid.New()
+	d1Ready := waitToAddPieceInBackground(ctx, s, d1, 50)
+	require.NoError(t, <-d1Ready)
+
+	// Deals further from their start epoch should be held
+	d2Ready := waitToAddPieceInBackground(ctx, s, d2, 300)
+	d3Ready := waitToAddPieceInBackground(ctx, s, d3, 200)
+	require.Eventually(t, func() bool {
+		st := s.status()
+		return len(st.Deals) == 2 && st.Waiting
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []uuid.UUID{d3, d2}, s.status().Deals)
+
+	// As the chain head approaches each deal's start epoch, the deal should
+	// be released even though the sealing pipeline is still over the limits
+	lk.Lock()
+	head = 100
+	lk.Unlock()
+	require.NoError(t, <-d3Ready)
+	require.Equal(t, []uuid.UUID{d2}, s.status().Deals)
+
+	lk.Lock()
+	head = 200
+	lk.Unlock()
+	require.NoError(t, <-d2Ready)
+	require.Empty(t, s.status().Deals)
+
+	s.complete(d1)
+	s.complete(d2)
+	s.complete(d3)
+}
+
+func headAt(epoch abi.ChainEpoch) func(ctx context.Context) (abi.ChainEpoch, error) {
+	return func(ctx context.Context) (abi.ChainEpoch, error) {
+		return epoch, nil
+	}
+}
+
+func waitToAddPieceInBackground(ctx context.Context, s *addPieceScheduler, dealUuid uuid.UUID, startEpoch abi.ChainEpoch) chan error {
+	ready := make(chan error, 1)
+	go func() {
+		ready <- s.waitToAddPiece(ctx, dealUuid, startEpoch, func(int, string) {})
+	}()
+	return ready
+}
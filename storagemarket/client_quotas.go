@@ -0,0 +1,257 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types"
+)
+
+// ClientQuotas limits the deals that are accepted from any single client
+// address or peer (0 means no limit)
+type ClientQuotas struct {
+	// The maximum number of deal proposals per minute
+	MaxProposalsPerMinute uint64
+	// The maximum number of deals that have not yet been handed off to the
+	// sealer
+	MaxActiveDeals uint64
+	// The maximum number of bytes of deal data (by piece size) accepted in
+	// the last 24 hours
+	MaxBytesPerDay uint64
+	// Client addresses and peer IDs that are not subject to the quotas
+	Allowlist []string
+}
+
+// ClientQuotaUsage is the current usage of the quotas by a client address
+// or peer
+type ClientQuotaUsage struct {
+	// The client address or peer ID
+	Client string
+	// True if Client is a peer ID
+	IsPeer bool
+	// True if the client is not subject to the quotas
+	Allowlisted         bool
+	ProposalsLastMinute uint64
+	ActiveDeals         uint64
+	BytesLastDay        uint64
+}
+
+// clientQuotas enforces the client quotas on deal proposals. It keeps track
+// of the deal proposals in the last minute in memory. The number of active
+// deals and the number of bytes accepted per day come from the deals DB.
+type clientQuotas struct {
+	cfg     ClientQuotas
+	allow   map[string]struct{}
+	dealsDB *db.DealsDB
+
+	lk sync.Mutex
+	// maps from client address or peer ID -> the times of the proposals in
+	// the last minute
+	proposals map[quotaKey][]time.Time
+}
+
+// quotaKey identifies a client address or a peer
+type quotaKey struct {
+	client string
+	isPeer bool
+}
+
+func (k quotaKey) String() string {
+	if k.isPeer {
+		return "peer " + k.client
+	}
+	return "client " + k.client
+}
+
+func newClientQuotas(cfg ClientQuotas, dealsDB *db.DealsDB) *clientQuotas {
+	allow := make(map[string]struct{}, len(cfg.Allowlist))
+	for _, c := range cfg.Allowlist {
+		allow[c] = struct{}{}
+	}
+	return &clientQuotas{
+		cfg:       cfg,
+		allow:     allow,
+		dealsDB:   dealsDB,
+		proposals: make(map[quotaKey][]time.Time),
+	}
+}
+
+func (q *clientQuotas) isAllowlisted(client string) bool {
+	_, ok := q.allow[client]
+	return ok
+}
+
+// check checks the deal proposal against the quotas for the client address
+// and peer that sent it. If the deal is over a quota, check returns the
+// reason for rejecting the deal. Otherwise the proposal is recorded against
+// the proposals per minute quota of the client address and peer: a
+// proposal that is rejected by any quota is not recorded.
+func (q *clientQuotas) check(ctx context.Context, deal *types.ProviderDealState) (string, error) {
+	client := quotaKey{client: deal.ClientDealProposal.Proposal.Client.String()}
+	peerID := quotaKey{client: deal.ClientPeerID.String(), isPeer: true}
+	if q.isAllowlisted(client.client) || q.isAllowlisted(peerID.client) {
+		return "", nil
+	}
+
+	// Hold the lock until the proposal has been recorded, so that
+	// concurrent proposals from the same client are checked one at a time
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	now := time.Now()
+	if reason := q.checkProposalsLocked(now, client, peerID); reason != "" {
+		return reason, nil
+	}
+
+	if reason, err := q.checkUsage(ctx, now, deal, client, peerID); err != nil || reason != "" {
+		return reason, err
+	}
+
+	q.recordProposalLocked(now, client, peerID)
+	return "", nil
+}
+
+// checkUsage checks the deal against the active deals and bytes per day
+// quotas of the client address and peer that sent it
+func (q *clientQuotas) checkUsage(ctx context.Context, now time.Time, deal *types.ProviderDealState, client, peerID quotaKey) (string, error) {
+	if q.cfg.MaxActiveDeals == 0 && q.cfg.MaxBytesPerDay == 0 {
+		return "", nil
+	}
+
+	clientUsage, peerUsage, err := q.dealsDB.ClientUsage(ctx, now.Add(-24*time.Hour), deal.ClientDealProposal.Proposal.Client, deal.ClientPeerID)
+	if err != nil {
+		return "", fmt.Errorf("getting client usage: %w", err)
+	}
+
+	usages := []struct {
+		key   quotaKey
+		usage *db.ClientUsage
+	}{{client, clientUsage}, {peerID, peerUsage}}
+	// Use the piece size rather than the transfer size, as offline deals
+	// have no transfer size
+	pieceSize := uint64(deal.ClientDealProposal.Proposal.PieceSize)
+	for _, u := range usages {
+		if q.cfg.MaxActiveDeals > 0 && uint64(u.usage.ActiveDeals) >= q.cfg.MaxActiveDeals {
+			return fmt.Sprintf("%s has reached the quota of %d active deals", u.key, q.cfg.MaxActiveDeals), nil
+		}
+		if q.cfg.MaxBytesPerDay > 0 && u.usage.PieceBytes+pieceSize > q.cfg.MaxBytesPerDay {
+			return fmt.Sprintf("deal would take %s over the quota of %s of deal data per day (%s accepted in the last 24 hours)",
+				u.key, humanize.IBytes(q.cfg.MaxBytesPerDay), humanize.IBytes(u.usage.PieceBytes)), nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkProposalsLocked returns the reason for rejecting the deal if any of
+// the clients (the client address and peer that sent it) has reached the
+// quota of proposals in the last minute
+func (q *clientQuotas) checkProposalsLocked(now time.Time, clients ...quotaKey) string {
+	if q.cfg.MaxProposalsPerMinute == 0 {
+		return ""
+	}
+
+	for _, client := range clients {
+		if uint64(len(q.recentLocked(now, client))) >= q.cfg.MaxProposalsPerMinute {
+			return fmt.Sprintf("%s has exceeded the quota of %d deal proposals per minute", client, q.cfg.MaxProposalsPerMinute)
+		}
+	}
+	return ""
+}
+
+// recordProposalLocked adds a proposal to the proposals in the last minute
+// of each of the clients
+func (q *clientQuotas) recordProposalLocked(now time.Time, clients ...quotaKey) {
+	if q.cfg.MaxProposalsPerMinute == 0 {
+		return
+	}
+
+	for _, client := range clients {
+		q.proposals[client] = append(q.proposals[client], now)
+	}
+}
+
+// recentLocked removes proposals older than a minute, and returns the
+// remaining proposals for the client
+func (q *clientQuotas) recentLocked(now time.Time, client quotaKey) []time.Time {
+	times := q.proposals[client]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= time.Minute {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(q.proposals, client)
+		return nil
+	}
+	q.proposals[client] = times
+	return times
+}
+
+// usage returns the current usage of the quotas by each client address and
+// peer that has made proposals or deals recently
+func (q *clientQuotas) usage(ctx context.Context) ([]ClientQuotaUsage, error) {
+	now := time.Now()
+	byClient, byPeer, err := q.dealsDB.AllClientUsage(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("getting client usage: %w", err)
+	}
+
+	usages := make(map[quotaKey]*ClientQuotaUsage)
+	get := func(k quotaKey) *ClientQuotaUsage {
+		u, ok := usages[k]
+		if !ok {
+			u = &ClientQuotaUsage{Client: k.client, IsPeer: k.isPeer, Allowlisted: q.isAllowlisted(k.client)}
+			usages[k] = u
+		}
+		return u
+	}
+
+	for client, u := range byClient {
+		cu := get(quotaKey{client: client})
+		cu.ActiveDeals = uint64(u.ActiveDeals)
+		cu.BytesLastDay = u.PieceBytes
+	}
+	for peerID, u := range byPeer {
+		cu := get(quotaKey{client: peerID, isPeer: true})
+		cu.ActiveDeals = uint64(u.ActiveDeals)
+		cu.BytesLastDay = u.PieceBytes
+	}
+
+	q.lk.Lock()
+	for k := range q.proposals {
+		if recent := q.recentLocked(now, k); len(recent) > 0 {
+			get(k).ProposalsLastMinute = uint64(len(recent))
+		}
+	}
+	q.lk.Unlock()
+
+	res := make([]ClientQuotaUsage, 0, len(usages))
+	for _, u := range usages {
+		res = append(res, *u)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].IsPeer != res[j].IsPeer {
+			return !res[i].IsPeer
+		}
+		return res[i].Client < res[j].Client
+	})
+	return res, nil
+}
+
+// ClientQuotas returns the limits on the deals accepted from any single
+// client address or peer
+func (p *Provider) ClientQuotas() ClientQuotas {
+	return p.clientQuotas.cfg
+}
+
+// ClientQuotaUsage returns the current usage of the client quotas by each
+// client address and peer that has made proposals or deals recently
+func (p *Provider) ClientQuotaUsage(ctx context.Context) ([]ClientQuotaUsage, error) {
+	return p.clientQuotas.usage(ctx)
+}
@@ -0,0 +1,186 @@
+package storagemarket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientQuotas(t *testing.T) {
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, db.Migrate(sqldb))
+	dealsDB := db.NewDealsDB(sqldb)
+
+	deals, err := db.GenerateDeals()
+	require.NoError(t, err)
+
+	t.Run("proposals per minute", func(t *testing.T) {
+		q := newClientQuotas(ClientQuotas{MaxProposalsPerMinute: 2}, dealsDB)
+
+		deal := deals[0]
+		for i := 0; i < 2; i++ {
+			reason, err := q.check(ctx, &deal)
+			require.NoError(t, err)
+			require.Empty(t, reason)
+		}
+
+		// The third proposal in a minute from the same client should be rejected
+		reason, err := q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Contains(t, reason, "proposals per minute")
+
+		// Proposals from another client should be accepted
+		other := deals[1]
+		reason, err = q.check(ctx, &other)
+		require.NoError(t, err)
+		require.Empty(t, reason)
+
+		// Once the proposals are more than a minute old, the client should
+		// be able to make proposals again
+		q.lk.Lock()
+		for k, times := range q.proposals {
+			for i := range times {
+				times[i] = times[i].Add(-time.Minute)
+			}
+			q.proposals[k] = times
+		}
+		q.lk.Unlock()
+		reason, err = q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Empty(t, reason)
+	})
+
+	t.Run("proposal rejected for peer is not recorded for client", func(t *testing.T) {
+		q := newClientQuotas(ClientQuotas{MaxProposalsPerMinute: 2}, dealsDB)
+
+		// Use up the quota of the peer
+		deal := deals[0]
+		for i := 0; i < 2; i++ {
+			reason, err := q.check(ctx, &deal)
+			require.NoError(t, err)
+			require.Empty(t, reason)
+		}
+
+		// A proposal from another client address through the same peer
+		// should be rejected
+		other := deals[1]
+		other.ClientPeerID = deal.ClientPeerID
+		reason, err := q.check(ctx, &other)
+		require.NoError(t, err)
+		require.Contains(t, reason, "peer")
+
+		// The rejected proposal should not count towards the quota of the
+		// other client address
+		other = deals[1]
+		for i := 0; i < 2; i++ {
+			reason, err := q.check(ctx, &other)
+			require.NoError(t, err)
+			require.Empty(t, reason)
+		}
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		deal := deals[0]
+		q := newClientQuotas(ClientQuotas{
+			MaxProposalsPerMinute: 1,
+			Allowlist:             []string{deal.ClientDealProposal.Proposal.Client.String()},
+		}, dealsDB)
+
+		for i := 0; i < 3; i++ {
+			reason, err := q.check(ctx, &deal)
+			require.NoError(t, err)
+			require.Empty(t, reason)
+		}
+	})
+
+	// Add some active deals to the database. deals[0] and deals[4] are
+	// from the same client.
+	for i := range deals {
+		deals[i].Checkpoint = dealcheckpoints.Transferred
+		require.NoError(t, dealsDB.Insert(ctx, &deals[i]))
+	}
+
+	t.Run("active deals", func(t *testing.T) {
+		q := newClientQuotas(ClientQuotas{MaxActiveDeals: 2}, dealsDB)
+
+		// The client already has two active deals
+		deal := deals[0]
+		reason, err := q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Contains(t, reason, "active deals")
+
+		// Another client only has one active deal
+		other := deals[1]
+		reason, err = q.check(ctx, &other)
+		require.NoError(t, err)
+		require.Empty(t, reason)
+	})
+
+	t.Run("proposal rejected for active deals is not recorded", func(t *testing.T) {
+		q := newClientQuotas(ClientQuotas{MaxProposalsPerMinute: 2, MaxActiveDeals: 2}, dealsDB)
+
+		// The client already has two active deals, so its proposals should
+		// be rejected without using up its proposals per minute
+		deal := deals[0]
+		for i := 0; i < 3; i++ {
+			reason, err := q.check(ctx, &deal)
+			require.NoError(t, err)
+			require.Contains(t, reason, "active deals")
+		}
+		q.lk.Lock()
+		require.Empty(t, q.proposals)
+		q.lk.Unlock()
+	})
+
+	t.Run("bytes per day", func(t *testing.T) {
+		deal := deals[1]
+		q := newClientQuotas(ClientQuotas{MaxBytesPerDay: uint64(deal.ClientDealProposal.Proposal.PieceSize) + 100}, dealsDB)
+
+		deal.ClientDealProposal.Proposal.PieceSize = 100
+		reason, err := q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Empty(t, reason)
+
+		deal.ClientDealProposal.Proposal.PieceSize = 101
+		reason, err = q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Contains(t, reason, "per day")
+
+		// Offline deals have no transfer size, so the piece size should be
+		// counted
+		deal.IsOffline = true
+		deal.Transfer.Size = 0
+		reason, err = q.check(ctx, &deal)
+		require.NoError(t, err)
+		require.Contains(t, reason, "per day")
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		q := newClientQuotas(ClientQuotas{MaxProposalsPerMinute: 10}, dealsDB)
+		deal := deals[0]
+		_, err := q.check(ctx, &deal)
+		require.NoError(t, err)
+
+		usage, err := q.usage(ctx)
+		require.NoError(t, err)
+
+		client := deal.ClientDealProposal.Proposal.Client.String()
+		var found bool
+		for _, u := range usage {
+			if u.Client == client && !u.IsPeer {
+				found = true
+				require.EqualValues(t, 1, u.ProposalsLastMinute)
+				require.EqualValues(t, 2, u.ActiveDeals)
+				require.Equal(t, uint64(deals[0].ClientDealProposal.Proposal.PieceSize+deals[4].ClientDealProposal.Proposal.PieceSize), u.BytesLastDay)
+			}
+		}
+		require.True(t, found)
+	})
+}
@@ -10,11 +10,13 @@ import (
 
 	sealing "github.com/filecoin-project/lotus/extern/storage-sealing"
 
+	"github.com/filecoin-project/boost/storagemarket/streamcommp"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
 	"github.com/filecoin-project/boost/transport"
 	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-commp-utils/writer"
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	commp "github.com/filecoin-project/go-fil-commp-hashhash"
@@ -37,6 +39,19 @@ const (
 	DealCancelled = "Cancelled"
 )
 
+// dealCancelledError is the error that a deal fails with when it is
+// cancelled by the user
+type dealCancelledError struct {
+	reason string
+}
+
+func (e *dealCancelledError) Error() string {
+	if e.reason == "" {
+		return DealCancelled
+	}
+	return DealCancelled + ": " + e.reason
+}
+
 type dealMakingError struct {
 	recoverable bool
 	err         error
@@ -49,6 +64,10 @@ func (p *Provider) doDeal(deal *types.ProviderDealState, dh *dealHandler) {
 	dcpy.ClientDealProposal.ClientSignature = acrypto.Signature{}
 	p.dealLogger.Infow(deal.DealUuid, "deal execution initiated", "deal state", dcpy)
 
+	// If the deal execution ends without the deal being failed or cancelled,
+	// a user waiting to cancel the deal is told that it's too late
+	defer dh.endCancellable(errors.New("deal execution has already finished"))
+
 	// Set up pubsub for deal updates
 	pub, err := dh.bus.Emitter(&types.ProviderDealState{}, eventbus.Stateful)
 	if err != nil {
@@ -85,11 +104,18 @@ func (p *Provider) doDeal(deal *types.ProviderDealState, dh *dealHandler) {
 
 	// Execute the deal synchronously
 	if derr := p.execDealUptoAddPiece(dh.providerCtx, pub, deal, dh); derr != nil {
+		// If the user cancelled the deal, fail the deal with the reason it
+		// was cancelled
+		if cancelled, reason := dh.cancelledByUser(); cancelled {
+			derr = &dealMakingError{err: &dealCancelledError{reason: reason}}
+		}
+
 		// If the error is NOT recoverable, fail the deal and cleanup state.
 		if !derr.recoverable {
 			p.failDeal(pub, deal, derr.err)
 			p.cleanupDealLogged(deal)
 			p.dealLogger.Infow(deal.DealUuid, "deal cleanup complete")
+			dh.endCancellable(nil)
 		} else {
 			// TODO For now, we will get recoverable errors only when the process is gracefully shutdown and
 			// the provider context gets cancelled.
@@ -111,10 +137,11 @@ func (p *Provider) doDeal(deal *types.ProviderDealState, dh *dealHandler) {
 
 	// Watch the sealing status of the deal and fire events for each change
 	p.fireSealingUpdateEvents(dh, pub, deal.DealUuid, deal.SectorID)
-	p.cleanupDealHandler(deal.DealUuid)
 
-	// TODO
-	// Watch deal on chain and change state in DB and emit notifications.
+	// Watch the deal on chain until it expires or is slashed, and update the
+	// state in DB / emit notifications for each change
+	p.watchDealOnChain(pub, deal)
+	p.cleanupDealHandler(deal.DealUuid)
 }
 
 func (p *Provider) execDealUptoAddPiece(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState, dh *dealHandler) *dealMakingError {
@@ -151,12 +178,17 @@ func (p *Provider) execDealUptoAddPiece(ctx context.Context, pub event.Emitter,
 		// transfer can no longer be cancelled
 		dh.transferCancelled(errors.New("transfer already complete"))
 		p.dealLogger.Infow(deal.DealUuid, "deal data-transfer can no longer be cancelled")
-	} else {
-		// verify CommP matches for an offline deal
-		if err := p.verifyCommP(deal); err != nil {
-			return &dealMakingError{err: fmt.Errorf("error when matching commP for imported data for offline deal: %w", err)}
+	} else if deal.Checkpoint < dealcheckpoints.Transferred {
+		// verify CommP matches for an offline deal, unless it was already
+		// matched when the data was imported
+		if dh.importedPieceCid.Defined() && dh.importedPieceCid.Equals(deal.ClientDealProposal.Proposal.PieceCID) {
+			p.dealLogger.Infow(deal.DealUuid, "commp was already matched when the data for the offline deal was imported")
+		} else {
+			if err := p.verifyCommP(deal); err != nil {
+				return &dealMakingError{err: fmt.Errorf("error when matching commP for imported data for offline deal: %w", err)}
+			}
+			p.dealLogger.Infow(deal.DealUuid, "commp matched successfully for imported data for offline deal")
 		}
-		p.dealLogger.Infow(deal.DealUuid, "commp matched successfully for imported data for offline deal")
 
 		// update checkpoint
 		if err := p.updateCheckpoint(pub, deal, dealcheckpoints.Transferred); err != nil {
@@ -164,11 +196,13 @@ func (p *Provider) execDealUptoAddPiece(ctx context.Context, pub event.Emitter,
 				err: fmt.Errorf("failed to update checkpoint: %w", err),
 			}
 		}
+	} else {
+		p.dealLogger.Infow(deal.DealUuid, "commp has already been verified for imported data for offline deal")
 	}
 
 	// Publish
 	if deal.Checkpoint <= dealcheckpoints.Published {
-		if err := p.publishDeal(ctx, pub, deal); err != nil {
+		if err := p.publishDeal(ctx, pub, deal, dh); err != nil {
 			if xerrors.Is(err, context.Canceled) {
 				return &dealMakingError{
 					recoverable: true,
@@ -291,23 +325,54 @@ func (p *Provider) untagFundsAfterPublish(ctx context.Context, deal *types.Provi
 }
 
 func (p *Provider) transferAndVerify(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState) error {
+	// wait until the number of running transfers is within the limits
+	queued := false
+	err := p.transferLimiter.waitToStart(ctx, deal.DealUuid, deal.ClientDealProposal.Proposal.Client, func(position int) {
+		queued = true
+		p.dealLogger.Infow(deal.DealUuid, "deal queued for transfer", "queue position", position)
+		// fire an event so that subscribers can see that the deal is queued
+		p.fireEventDealUpdate(pub, deal)
+	})
+	if err != nil {
+		return fmt.Errorf("waiting in transfer queue: %w", err)
+	}
+	defer p.transferLimiter.complete(deal.DealUuid)
+	if queued {
+		p.dealLogger.Infow(deal.DealUuid, "deal reached front of transfer queue")
+	}
+
 	p.dealLogger.Infow(deal.DealUuid, "transferring deal data", "transfer client id", deal.Transfer.ClientID)
 
 	tctx, cancel := context.WithDeadline(ctx, time.Now().Add(p.config.MaxTransferDuration))
 	defer cancel()
 
+	// calculate commP as the data arrives, so that the data doesn't need to
+	// be read again to verify it once the transfer completes
+	commpStream := streamcommp.Open(deal.InboundFilePath)
+	closeCommpStream := func() {
+		if err := commpStream.Close(); err != nil {
+			p.dealLogger.Warnw(deal.DealUuid, "failed to save commP checkpoint", "err", err.Error())
+		}
+	}
+
 	st := time.Now()
-	handler, err := p.Transport.Execute(tctx, deal.Transfer.Params, &transporttypes.TransportDealInfo{
-		OutputFile: deal.InboundFilePath,
-		DealUuid:   deal.DealUuid,
-		DealSize:   int64(deal.Transfer.Size),
+	handler, err := p.transportFor(deal.Transfer.Type).Execute(tctx, deal.Transfer.Params, &transporttypes.TransportDealInfo{
+		OutputFile:   deal.InboundFilePath,
+		DealUuid:     deal.DealUuid,
+		DealSize:     int64(deal.Transfer.Size),
+		DealDataRoot: deal.DealDataRoot,
+		ClientAddr:   deal.ClientDealProposal.Proposal.Client.String(),
+		CommP:        commpStream,
 	})
 	if err != nil {
+		closeCommpStream()
 		return fmt.Errorf("transferAndVerify failed data transfer: %w", err)
 	}
 
 	// wait for data-transfer to finish
-	if err := p.waitForTransferFinish(tctx, handler, pub, deal); err != nil {
+	err = p.waitForTransferFinish(tctx, handler, pub, deal)
+	closeCommpStream()
+	if err != nil {
 		return fmt.Errorf("data-transfer failed: %w", err)
 	}
 	p.dealLogger.Infow(deal.DealUuid, "deal data-transfer completed successfully", "bytes received", deal.NBytesReceived, "time taken",
@@ -324,16 +389,30 @@ func (p *Provider) transferAndVerify(ctx context.Context, pub event.Emitter, dea
 
 func (p *Provider) verifyCommP(deal *types.ProviderDealState) error {
 	p.dealLogger.Infow(deal.DealUuid, "checking commP")
-	pieceCid, err := GeneratePieceCommitment(deal.InboundFilePath, deal.ClientDealProposal.Proposal.PieceSize)
+	clientPieceCid := deal.ClientDealProposal.Proposal.PieceCID
+	pieceSize := deal.ClientDealProposal.Proposal.PieceSize
+
+	// use the commP that was calculated as the data was written, if any
+	pieceCid, streamed, err := streamedPieceCommitment(deal.InboundFilePath, pieceSize)
+	if err == nil && streamed && pieceCid != clientPieceCid {
+		// the saved hasher state may be stale (eg if the file was modified
+		// after the state was saved) so check against the file contents
+		p.dealLogger.Warnw(deal.DealUuid, "streamed commP does not match, generating commP from file", "streamed commP", pieceCid)
+		streamed = false
+	}
+	if err == nil && !streamed {
+		pieceCid, err = GeneratePieceCommitment(deal.InboundFilePath, pieceSize)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to generate CommP: %w", err)
 	}
 
-	clientPieceCid := deal.ClientDealProposal.Proposal.PieceCID
 	if pieceCid != clientPieceCid {
 		return fmt.Errorf("commP mismatch, expected=%s, actual=%s", clientPieceCid, pieceCid)
 	}
 
+	// the data has been verified so the hasher state is no longer needed
+	streamcommp.RemoveCheckpoint(deal.InboundFilePath)
 	return nil
 }
 
@@ -363,6 +442,7 @@ func (p *Provider) waitForTransferFinish(ctx context.Context, handler transport.
 			}
 			deal.NBytesReceived = evt.NBytesReceived
 			p.transfers.setBytes(deal.DealUuid, uint64(evt.NBytesReceived))
+			p.storageManager.SetReceived(deal.DealUuid, uint64(evt.NBytesReceived))
 			p.fireEventDealUpdate(pub, deal)
 			logTransferProgress(deal.NBytesReceived)
 
@@ -429,85 +509,187 @@ func GeneratePieceCommitment(filepath string, dealSize abi.PaddedPieceSize) (c c
 		return cid.Undef, err
 	}
 
-	if cidAndSize.PieceSize < dealSize {
+	return padPieceCommitment(cidAndSize.PieceCID, cidAndSize.PieceSize, dealSize)
+}
+
+// streamedPieceCommitment generates the pieceCid for a CARv1 file using the
+// commP hasher state that was saved as the file was written, so that only
+// data that was not hashed as it was written needs to be read from the file.
+// It returns false if the file is not a CARv1 file, in which case the pieceCid
+// must be generated from the CARv1 payload with GeneratePieceCommitment.
+func streamedPieceCommitment(filepath string, dealSize abi.PaddedPieceSize) (cid.Cid, bool, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return cid.Undef, false, fmt.Errorf("failed to open deal data file: %w", err)
+	}
+	version, err := carv2.ReadVersion(f)
+	_ = f.Close()
+	if err != nil {
+		return cid.Undef, false, fmt.Errorf("failed to read CAR version: %w", err)
+	}
+	if version != 1 {
+		return cid.Undef, false, nil
+	}
+
+	commpStream := streamcommp.Open(filepath)
+	defer commpStream.Close() //nolint:errcheck
+
+	pieceCid, pieceSize, err := commpStream.Sum()
+	if err != nil {
+		return cid.Undef, false, fmt.Errorf("failed to get CommP: %w", err)
+	}
+
+	pieceCid, err = padPieceCommitment(pieceCid, pieceSize, dealSize)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	return pieceCid, true, nil
+}
+
+// padPieceCommitment pads the pieceCid up to the deal size
+func padPieceCommitment(pieceCid cid.Cid, pieceSize abi.PaddedPieceSize, dealSize abi.PaddedPieceSize) (cid.Cid, error) {
+	if pieceSize < dealSize {
 		// need to pad up!
 		rawPaddedCommp, err := commp.PadCommP(
 			// we know how long a pieceCid "hash" is, just blindly extract the trailing 32 bytes
-			cidAndSize.PieceCID.Hash()[len(cidAndSize.PieceCID.Hash())-32:],
-			uint64(cidAndSize.PieceSize),
+			pieceCid.Hash()[len(pieceCid.Hash())-32:],
+			uint64(pieceSize),
 			uint64(dealSize),
 		)
 		if err != nil {
 			return cid.Undef, fmt.Errorf("failed to pad data: %w", err)
 		}
-		cidAndSize.PieceCID, _ = commcid.DataCommitmentV1ToCID(rawPaddedCommp)
+		pieceCid, _ = commcid.DataCommitmentV1ToCID(rawPaddedCommp)
 	}
 
-	return cidAndSize.PieceCID, err
+	return pieceCid, nil
 }
 
-func (p *Provider) publishDeal(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState) error {
-	// Publish the deal on chain. At this point collateral and payment for the
-	// deal are locked and can no longer be withdrawn. Payment is transferred
-	// to the provider's wallet at each epoch.
-	if deal.Checkpoint < dealcheckpoints.Published {
-		p.dealLogger.Infow(deal.DealUuid, "sending deal to deal publisher")
+// The number of times a deal is sent to the deal publisher when its publish
+// message fails on chain, before the deal is failed
+var publishMaxAttempts = 3
+
+func (p *Provider) publishDeal(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState, dh *dealHandler) error {
+	for attempt := 1; ; attempt++ {
+		// Publish the deal on chain. At this point collateral and payment for the
+		// deal are locked and can no longer be withdrawn. Payment is transferred
+		// to the provider's wallet at each epoch.
+		if deal.Checkpoint < dealcheckpoints.Published {
+			p.dealLogger.Infow(deal.DealUuid, "sending deal to deal publisher", "attempt", attempt)
+
+			// The deal context is cancelled if the user cancels the deal, which
+			// removes the deal from the publisher's pending batch
+			var mcid cid.Cid
+			var err error
+			if lp, ok := p.dealPublisher.(types.LoggingDealPublisher); ok {
+				// Publish the deal from the wallet that funds for the publish
+				// message were tagged in
+				wallet, werr := p.fundManager.PublishMsgWallet(ctx, deal.DealUuid)
+				if werr != nil {
+					p.dealLogger.Warnw(deal.DealUuid, "could not get wallet that publish message funds were tagged in", "err", werr.Error())
+					wallet = address.Undef
+				}
 
-		mcid, err := p.dealPublisher.Publish(p.ctx, deal.ClientDealProposal)
-		if err != nil && ctx.Err() != nil {
-			p.dealLogger.Warnw(deal.DealUuid, "context timed out while waiting for publish")
-			return fmt.Errorf("publish did not complete: %w", ctx.Err())
-		}
+				// Record what happens to the deal in the publisher (eg if its
+				// batch is split because of an invalid deal) in the deal log
+				mcid, err = lp.PublishWithLog(dh.dealCtx, deal.DealUuid, deal.ClientDealProposal, wallet, func(msg string, kvs ...interface{}) {
+					p.dealLogger.Infow(deal.DealUuid, msg, kvs...)
+				})
+			} else {
+				mcid, err = p.dealPublisher.Publish(dh.dealCtx, deal.ClientDealProposal)
+			}
+			if err == nil {
+				dh.endCancellable(errors.New("deal has already been published"))
+			}
+			if err != nil && ctx.Err() != nil {
+				p.dealLogger.Warnw(deal.DealUuid, "context timed out while waiting for publish")
+				return fmt.Errorf("publish did not complete: %w", ctx.Err())
+			}
 
-		if err != nil {
-			return fmt.Errorf("failed to publish deal %s: %w", deal.DealUuid, err)
-		}
+			if err != nil {
+				return fmt.Errorf("failed to publish deal %s: %w", deal.DealUuid, err)
+			}
 
-		deal.PublishCID = &mcid
-		if err := p.updateCheckpoint(pub, deal, dealcheckpoints.Published); err != nil {
-			return err
+			deal.PublishCID = &mcid
+			if err := p.updateCheckpoint(pub, deal, dealcheckpoints.Published); err != nil {
+				return err
+			}
+			p.dealLogger.Infow(deal.DealUuid, "deal published successfully, will await deal publish confirmation")
+		} else {
+			dh.endCancellable(errors.New("deal has already been published"))
+			p.dealLogger.Infow(deal.DealUuid, "deal has already been published")
 		}
-		p.dealLogger.Infow(deal.DealUuid, "deal published successfully, will await deal publish confirmation")
-	} else {
-		p.dealLogger.Infow(deal.DealUuid, "deal has already been published")
-	}
 
-	// Wait for the publish deals message to land on chain.
-	// Note that multiple deals may be published in a batch, so the message CID
-	// may be for a batch of deals.
-	p.dealLogger.Infow(deal.DealUuid, "awaiting deal publish confirmation")
-	res, err := p.chainDealManager.WaitForPublishDeals(p.ctx, *deal.PublishCID, deal.ClientDealProposal.Proposal)
+		// Wait for the publish deals message to land on chain.
+		// Note that multiple deals may be published in a batch, so the message CID
+		// may be for a batch of deals.
+		p.dealLogger.Infow(deal.DealUuid, "awaiting deal publish confirmation")
+		res, err := p.chainDealManager.WaitForPublishDeals(p.ctx, *deal.PublishCID, deal.ClientDealProposal.Proposal)
 
-	// The `WaitForPublishDeals` call above is a remote RPC call to the full node
-	// and if it fails because of a context cancellation, the error we get back doesn't
-	// unwrap into a context cancelled error because of how error handling is implemented in the RPC layer.
-	// The below check is a work around for that.
-	if err != nil && ctx.Err() != nil {
-		p.dealLogger.Warnw(deal.DealUuid, "context timed out while waiting for publish confirmation")
-		return fmt.Errorf("wait for publish confirmation did not complete: %w", ctx.Err())
-	}
-	if err != nil {
-		p.dealLogger.LogError(deal.DealUuid, "error while waiting for publish confirm", err)
-		return fmt.Errorf("wait for publish message %s failed: %w", deal.PublishCID, err)
-	}
+		// The `WaitForPublishDeals` call above is a remote RPC call to the full node
+		// and if it fails because of a context cancellation, the error we get back doesn't
+		// unwrap into a context cancelled error because of how error handling is implemented in the RPC layer.
+		// The below check is a work around for that.
+		if err != nil && ctx.Err() != nil {
+			p.dealLogger.Warnw(deal.DealUuid, "context timed out while waiting for publish confirmation")
+			return fmt.Errorf("wait for publish confirmation did not complete: %w", ctx.Err())
+		}
+		if xerrors.Is(err, ErrPublishMsgFailed) {
+			if attempt >= publishMaxAttempts {
+				p.dealLogger.LogError(deal.DealUuid, "publish message failed on chain too many times", err)
+				return fmt.Errorf("publish message %s failed on chain after %d attempts: %w", deal.PublishCID, attempt, err)
+			}
 
-	p.dealLogger.Infow(deal.DealUuid, "successfully finished deal publish confirmation")
+			// The message failed on chain, most likely because another deal in
+			// the batch is no longer valid. Send the deal back to the deal
+			// publisher: it simulates each batch before sending it, so the
+			// invalid deals are removed and the valid deals are published again.
+			// If the deal itself is invalid it fails simulation (or its start
+			// epoch passes) and the deal fails.
+			// The deal goes back to the Transferred checkpoint, but it remains
+			// non-cancellable: cancelling it returns an error saying that the
+			// deal has already been published.
+			p.dealLogger.Warnw(deal.DealUuid, "publish message failed on chain, sending deal to deal publisher again",
+				"publish cid", deal.PublishCID.String(), "attempt", attempt, "err", err.Error())
+			deal.PublishCID = nil
+			if err := p.updateCheckpoint(pub, deal, dealcheckpoints.Transferred); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			p.dealLogger.LogError(deal.DealUuid, "error while waiting for publish confirm", err)
+			return fmt.Errorf("wait for publish message %s failed: %w", deal.PublishCID, err)
+		}
 
-	// If there's a re-org, the publish deal CID may change, so use the
-	// final CID.
-	deal.PublishCID = &res.FinalCid
-	deal.ChainDealID = res.DealID
-	if err := p.updateCheckpoint(pub, deal, dealcheckpoints.PublishConfirmed); err != nil {
-		return err
-	}
+		p.dealLogger.Infow(deal.DealUuid, "successfully finished deal publish confirmation")
 
-	return nil
+		// If there's a re-org, the publish deal CID may change, so use the
+		// final CID.
+		deal.PublishCID = &res.FinalCid
+		deal.ChainDealID = res.DealID
+		return p.updateCheckpoint(pub, deal, dealcheckpoints.PublishConfirmed)
+	}
 }
 
 // addPiece hands off a published deal for sealing and commitment in a sector
 func (p *Provider) addPiece(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState) error {
 	p.dealLogger.Infow(deal.DealUuid, "add piece called")
 
+	// Wait until the sealing pipeline has capacity for the deal
+	proposal := deal.ClientDealProposal.Proposal
+	err := p.addPieceScheduler.waitToAddPiece(ctx, deal.DealUuid, proposal.StartEpoch, func(position int, reason string) {
+		p.dealLogger.Infow(deal.DealUuid, "deal held until sealing pipeline has capacity", "queue position", position, "reason", reason)
+		// fire an event so that subscribers can see that the deal is held
+		p.fireEventDealUpdate(pub, deal)
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for sealing pipeline capacity: %w", err)
+	}
+	// AddPieceToSector lets the scheduler release the next deal once the
+	// sealer responds, but the deal may fail before it gets that far
+	defer p.addPieceScheduler.complete(deal.DealUuid)
+
 	// Open a reader against the CAR file with the deal data
 	v2r, err := carv2.OpenReader(deal.InboundFilePath)
 	if err != nil {
@@ -532,7 +714,6 @@ func (p *Provider) addPiece(ctx context.Context, pub event.Emitter, deal *types.
 	}
 
 	// Inflate the deal size so that it exactly fills a piece
-	proposal := deal.ClientDealProposal.Proposal
 	paddedReader, err := padreader.NewInflator(v2r.DataReader(), size, proposal.PieceSize.Unpadded())
 	if err != nil {
 		return fmt.Errorf("failed to create inflator: %w", err)
@@ -674,9 +855,18 @@ func isFinalSealingState(state lapi.SectorState) bool {
 }
 
 func (p *Provider) failDeal(pub event.Emitter, deal *types.ProviderDealState, err error) {
-	// Update state in DB with error
+	// Update state in DB with error, keeping track of the last checkpoint
+	// the deal reached so that the deal can be retried from there
+	if deal.Checkpoint != dealcheckpoints.Complete {
+		deal.ErrCheckpoint = deal.Checkpoint
+	}
 	deal.Checkpoint = dealcheckpoints.Complete
-	if xerrors.Is(err, context.Canceled) {
+	deal.CheckpointAt = time.Now()
+	var cancelErr *dealCancelledError
+	if xerrors.As(err, &cancelErr) {
+		deal.Err = cancelErr.Error()
+		p.dealLogger.Infow(deal.DealUuid, "deal cancelled by user", "reason", cancelErr.reason)
+	} else if xerrors.Is(err, context.Canceled) {
 		deal.Err = DealCancelled
 		p.dealLogger.Infow(deal.DealUuid, "deal cancelled")
 	} else {
@@ -703,9 +893,21 @@ func (p *Provider) cleanupDealLogged(deal *types.ProviderDealState) {
 }
 
 func (p *Provider) cleanupDeal(deal *types.ProviderDealState) {
-	// remove the temp file created for inbound deal data if it is not an offline deal
+	// remove the temp file created for inbound deal data if it is not an
+	// offline deal (and it's not needed to retry the deal)
 	if !deal.IsOffline {
-		_ = os.Remove(deal.InboundFilePath)
+		if !p.keepInboundFileForRetry(deal) {
+			_ = os.Remove(deal.InboundFilePath)
+		}
+		// the commP has already been verified for a deal that can be retried
+		streamcommp.RemoveCheckpoint(deal.InboundFilePath)
+	}
+
+	// the client can no longer upload data for the deal
+	if ua, ok := p.transportFor(deal.Transfer.Type).(transport.UploadAuthorizer); ok && !deal.IsOffline {
+		if err := ua.RevokeUpload(p.ctx, deal.DealUuid); err != nil {
+			p.dealLogger.LogError(deal.DealUuid, "failed to revoke deal data upload token", err)
+		}
 	}
 
 	if deal.Checkpoint == dealcheckpoints.Complete {
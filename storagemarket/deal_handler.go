@@ -13,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/event"
 
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 )
 
 // dealHandler keeps track of the deal while it's executing
@@ -21,6 +22,17 @@ type dealHandler struct {
 	dealUuid    uuid.UUID
 	bus         event.Bus
 
+	// Deal cancellation state: the deal can be cancelled by the user until
+	// it has been published
+	dealCtx      context.Context
+	dealCancel   context.CancelFunc
+	cancelMu     sync.Mutex
+	cancelled    bool
+	cancelReason string
+	cancelEnded  bool
+	cancelErr    error
+	cancelDone   chan struct{}
+
 	// Transfer cancellation state
 	transferCtx             context.Context
 	transferCancel          context.CancelFunc
@@ -32,6 +44,15 @@ type dealHandler struct {
 	transferFinished bool
 	transferErr      error
 
+	// Offline deal import state: importing the data for an offline deal
+	// and cancelling the deal before it's imported are serialized on
+	// importMu
+	importMu sync.Mutex
+	imported bool
+	// The commP of the imported file padded up to the deal size, if it was
+	// calculated when the file was imported (cid.Undef otherwise)
+	importedPieceCid cid.Cid
+
 	activeSubsLk sync.RWMutex
 	activeSubs   map[*updatesSubscription]struct{}
 }
@@ -40,12 +61,17 @@ func newDealHandler(ctx context.Context, dealUuid uuid.UUID) *dealHandler {
 	// Create a deal handler
 	bus := eventbus.NewBus()
 
-	transferCtx, cancel := context.WithCancel(ctx)
+	dealCtx, dealCancel := context.WithCancel(ctx)
+	transferCtx, cancel := context.WithCancel(dealCtx)
 	return &dealHandler{
 		providerCtx: ctx,
 		dealUuid:    dealUuid,
 		bus:         bus,
 
+		dealCtx:    dealCtx,
+		dealCancel: dealCancel,
+		cancelDone: make(chan struct{}),
+
 		transferCtx:    transferCtx,
 		transferCancel: cancel,
 		transferDone:   make(chan error, 1),
@@ -137,7 +163,71 @@ func (dh *dealHandler) transferCancelled(err error) {
 	})
 }
 
+// cancelDeal cancels the deal if it has not yet been published, and waits
+// for the deal to be failed and cleaned up. If the deal is already being
+// cancelled, it just waits for the cancellation to complete.
+func (dh *dealHandler) cancelDeal(ctx context.Context, reason string) error {
+	dh.cancelMu.Lock()
+	if dh.cancelEnded {
+		defer dh.cancelMu.Unlock()
+		if dh.cancelErr == nil && !dh.cancelled {
+			return errors.New("deal execution has already finished")
+		}
+		return dh.cancelErr
+	}
+	if !dh.cancelled {
+		dh.cancelled = true
+		dh.cancelReason = reason
+		// the transfer is cancelled along with the deal
+		dh.transferCancelledByUser.Store(true)
+		dh.dealCancel()
+	}
+	dh.cancelMu.Unlock()
+
+	select {
+	case <-dh.cancelDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-dh.providerCtx.Done():
+		return dh.providerCtx.Err()
+	}
+
+	dh.cancelMu.Lock()
+	defer dh.cancelMu.Unlock()
+	return dh.cancelErr
+}
+
+// cancelledByUser returns true and the reason the user gave for cancelling
+// the deal, if the deal has been cancelled
+func (dh *dealHandler) cancelledByUser() (bool, string) {
+	dh.cancelMu.Lock()
+	defer dh.cancelMu.Unlock()
+	return dh.cancelled, dh.cancelReason
+}
+
+// endCancellable idempotently marks the deal as no longer cancellable. err
+// is the reason the deal can no longer be cancelled (eg because it has been
+// published), or nil if deal execution finished because the deal failed or
+// was cancelled.
+func (dh *dealHandler) endCancellable(err error) {
+	dh.cancelMu.Lock()
+	defer dh.cancelMu.Unlock()
+
+	if dh.cancelEnded {
+		return
+	}
+	dh.cancelEnded = true
+	dh.cancelErr = err
+	if err != nil {
+		// the deal can't be failed as a result of the cancellation
+		dh.cancelled = false
+		dh.cancelReason = ""
+	}
+	close(dh.cancelDone)
+}
+
 func (dh *dealHandler) close() {
+	dh.dealCancel()
 	dh.transferCancel()
 	dh.transferCancelled(errors.New("deal handler closed"))
 }
@@ -0,0 +1,341 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/abi"
+	ctypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/event"
+)
+
+// watchDealOnChain follows a deal that has been handed off to the sealer
+// through sector pre-commit and activation. Each transition is persisted as
+// a checkpoint and fired as a deal update event. Once the deal is active it
+// is watched for expiry and slashing by the active deals watcher.
+func (p *Provider) watchDealOnChain(pub event.Emitter, deal *types.ProviderDealState) {
+	p.dealLogger.Infow(deal.DealUuid, "watching deal on chain", "checkpoint", deal.Checkpoint.String())
+
+	if err := p.watchDealActivation(pub, deal); err != nil {
+		p.onChainWatchFailed(pub, deal, err)
+		return
+	}
+
+	// Check straight away if the deal has already expired or been slashed
+	p.dealLogger.Infow(deal.DealUuid, "watching active deal for expiry and slashing")
+	select {
+	case p.activeDealsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// watchDealActivation waits for the deal to become active on chain.
+// The deal has already been handed off to the sealer, so errors watching the
+// chain (eg because the full node is unreachable) are retried. An error is
+// only returned if Boost is shutting down, or if the deal can no longer be
+// activated because its start epoch has passed.
+func (p *Provider) watchDealActivation(pub event.Emitter, deal *types.ProviderDealState) error {
+	for {
+		err := p.waitForDealActivation(pub, deal)
+		if err == nil || p.ctx.Err() != nil {
+			return err
+		}
+
+		p.dealLogger.Warnw(deal.DealUuid, "failed watching deal activation on chain, will retry",
+			"err", err.Error(), "retry in", onChainRetryWait.String())
+		select {
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		case <-time.After(onChainRetryWait):
+		}
+
+		// The deal may have been activated while we weren't watching
+		isActive, err := p.checkDealActivation(p.ctx, deal)
+		if err != nil {
+			return err
+		}
+		if isActive {
+			p.dealLogger.Infow(deal.DealUuid, "deal is active on chain", "sector", deal.SectorID.String())
+			return p.updateCheckpoint(pub, deal, dealcheckpoints.Active)
+		}
+	}
+}
+
+// waitForDealActivation waits for the sector containing the deal to be
+// pre-committed and then proven on chain
+func (p *Provider) waitForDealActivation(pub event.Emitter, deal *types.ProviderDealState) error {
+	if deal.Checkpoint < dealcheckpoints.PreCommitted {
+		p.dealLogger.Infow(deal.DealUuid, "waiting for sector pre-commit")
+		sectorNum, isActive, err := p.waitForSectorPreCommit(p.ctx, deal)
+		if err != nil {
+			return fmt.Errorf("waiting for sector pre-commit: %w", err)
+		}
+
+		if isActive {
+			// The deal was already active by the time we started watching
+			p.dealLogger.Infow(deal.DealUuid, "deal is already active on chain")
+			return p.updateCheckpoint(pub, deal, dealcheckpoints.Active)
+		}
+
+		if sectorNum != deal.SectorID {
+			p.dealLogger.Infow(deal.DealUuid, "deal was pre-committed in a different sector",
+				"old sector", deal.SectorID.String(), "new sector", sectorNum.String())
+			deal.SectorID = sectorNum
+		}
+		if err := p.updateCheckpoint(pub, deal, dealcheckpoints.PreCommitted); err != nil {
+			return err
+		}
+		p.dealLogger.Infow(deal.DealUuid, "sector with deal pre-committed", "sector", deal.SectorID.String())
+	}
+
+	if deal.Checkpoint < dealcheckpoints.Active {
+		p.dealLogger.Infow(deal.DealUuid, "waiting for sector prove-commit", "sector", deal.SectorID.String())
+		if err := p.waitForSectorCommitted(p.ctx, deal); err != nil {
+			return fmt.Errorf("waiting for sector prove-commit: %w", err)
+		}
+
+		if err := p.updateCheckpoint(pub, deal, dealcheckpoints.Active); err != nil {
+			return err
+		}
+		p.dealLogger.Infow(deal.DealUuid, "deal is active on chain", "sector", deal.SectorID.String())
+	}
+
+	return nil
+}
+
+// checkDealActivation looks up the deal in the market actor state. It returns
+// true if the deal has been activated, and an error if the deal was not
+// activated before its start epoch. Errors getting the state from the full
+// node are logged and the deal is reported as not yet active.
+func (p *Provider) checkDealActivation(ctx context.Context, deal *types.ProviderDealState) (bool, error) {
+	head, err := p.fullnodeApi.ChainHead(ctx)
+	if err != nil {
+		p.dealLogger.Warnw(deal.DealUuid, "failed to get chain head", "err", err.Error())
+		return false, nil
+	}
+
+	md, err := p.fullnodeApi.StateMarketStorageDeal(ctx, deal.ChainDealID, head.Key())
+	if err != nil {
+		// The market actor removes a deal from state if it is not activated
+		// by its start epoch
+		startEpoch := deal.ClientDealProposal.Proposal.StartEpoch
+		if strings.Contains(err.Error(), "not found") && head.Height() > startEpoch {
+			return false, fmt.Errorf("deal %d was not activated before its start epoch %d", deal.ChainDealID, startEpoch)
+		}
+		p.dealLogger.Warnw(deal.DealUuid, "failed to get market deal", "deal id", deal.ChainDealID, "err", err.Error())
+		return false, nil
+	}
+
+	return md.State.SectorStartEpoch > -1, nil
+}
+
+// onChainWatchFailed is called when there is an error watching the deal on
+// chain. If Boost is shutting down the deal watch will be resumed on restart,
+// otherwise the deal is failed.
+func (p *Provider) onChainWatchFailed(pub event.Emitter, deal *types.ProviderDealState, err error) {
+	// The on-chain watch calls go over RPC to the full node, so a context
+	// cancellation error doesn't necessarily unwrap into a context.Canceled
+	if p.ctx.Err() != nil {
+		p.dealLogger.Infow(deal.DealUuid, "on-chain deal watch paused because Boost is shutting down",
+			"checkpoint", deal.Checkpoint.String())
+		return
+	}
+
+	p.failDeal(pub, deal, fmt.Errorf("failed watching deal on chain: %w", err))
+}
+
+func (p *Provider) waitForSectorPreCommit(ctx context.Context, deal *types.ProviderDealState) (abi.SectorNumber, bool, error) {
+	type preCommitResult struct {
+		sectorNum abi.SectorNumber
+		isActive  bool
+		err       error
+	}
+
+	done := make(chan preCommitResult, 1)
+	cb := func(sectorNum abi.SectorNumber, isActive bool, err error) {
+		done <- preCommitResult{sectorNum: sectorNum, isActive: isActive, err: err}
+	}
+	err := p.sectorCommittedManager.OnDealSectorPreCommitted(ctx, p.Address, deal.ClientDealProposal.Proposal, *deal.PublishCID, cb)
+	if err != nil {
+		return 0, false, err
+	}
+
+	select {
+	case res := <-done:
+		return res.sectorNum, res.isActive, res.err
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	}
+}
+
+func (p *Provider) waitForSectorCommitted(ctx context.Context, deal *types.ProviderDealState) error {
+	done := make(chan error, 1)
+	cb := func(err error) {
+		done <- err
+	}
+	err := p.sectorCommittedManager.OnDealSectorCommitted(ctx, p.Address, deal.SectorID, deal.ClientDealProposal.Proposal, *deal.PublishCID, cb)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runActiveDealsWatcher checks the market actor state of deals that are
+// active on chain at each chain head, and moves deals that have reached the
+// end of their lifetime to the Expired or Slashed checkpoint
+func (p *Provider) runActiveDealsWatcher() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(dealStateCheckInterval)
+	defer ticker.Stop()
+
+	var lastChecked ctypes.TipSetKey
+	force := true
+	for {
+		head, err := p.fullnodeApi.ChainHead(p.ctx)
+		if err != nil {
+			// Errors here are usually transient (eg the full node is
+			// unreachable) so just try again on the next tick
+			log.Warnw("failed to get chain head to check active deals", "err", err)
+		} else if force || head.Key() != lastChecked {
+			p.checkActiveDeals(p.ctx, head)
+			lastChecked = head.Key()
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			force = false
+		case <-p.activeDealsChanged:
+			force = true
+		}
+	}
+}
+
+// checkActiveDeals checks deals that are active on chain against the market
+// actor state at the given tipset. Deals that have reached their end epoch
+// are always checked. So that the number of lookups per chain head doesn't
+// grow with the number of active deals, the other deals are checked
+// activeDealsCheckBatchSize at a time, taking turns across chain heads.
+func (p *Provider) checkActiveDeals(ctx context.Context, head *ctypes.TipSet) {
+	deals, err := p.dealsDB.ListByCheckpoint(ctx, dealcheckpoints.Active)
+	if err != nil {
+		log.Errorw("failed to list deals that are active on chain", "err", err)
+		return
+	}
+
+	var ended, notEnded []*types.ProviderDealState
+	for _, deal := range deals {
+		if head.Height() >= deal.ClientDealProposal.Proposal.EndEpoch {
+			ended = append(ended, deal)
+		} else {
+			notEnded = append(notEnded, deal)
+		}
+	}
+	// Sort the deals so that each deal gets a turn at being checked as the
+	// set of active deals changes
+	sort.Slice(notEnded, func(i, j int) bool {
+		return notEnded[i].DealUuid.String() < notEnded[j].DealUuid.String()
+	})
+
+	toCheck := ended
+	if len(notEnded) <= activeDealsCheckBatchSize {
+		toCheck = append(toCheck, notEnded...)
+	} else {
+		if p.activeDealsCheckNext >= len(notEnded) {
+			p.activeDealsCheckNext = 0
+		}
+		for i := 0; i < activeDealsCheckBatchSize; i++ {
+			toCheck = append(toCheck, notEnded[(p.activeDealsCheckNext+i)%len(notEnded)])
+		}
+		p.activeDealsCheckNext = (p.activeDealsCheckNext + activeDealsCheckBatchSize) % len(notEnded)
+	}
+
+	for _, deal := range toCheck {
+		ckpt, err := p.checkDealExpiredOrSlashed(ctx, head, deal)
+		if err != nil {
+			// The deal will be checked again at the next chain head
+			log.Warnw("failed to check deal state on chain", "id", deal.DealUuid, "err", err)
+			continue
+		}
+		if ckpt == dealcheckpoints.Active {
+			continue
+		}
+
+		prev := deal.Checkpoint
+		deal.Checkpoint = ckpt
+		deal.CheckpointAt = time.Now()
+		// we don't want a graceful shutdown to mess with db updates so pass a background context
+		if err := p.dealsDB.Update(context.Background(), deal); err != nil {
+			p.dealLogger.LogError(deal.DealUuid, "failed to persist deal state", err)
+			continue
+		}
+		p.dealLogger.Infow(deal.DealUuid, "updated deal checkpoint in DB", "old checkpoint", prev.String(), "new checkpoint", ckpt.String())
+		p.dealLogger.Infow(deal.DealUuid, "finished watching deal on chain", "checkpoint", ckpt.String())
+	}
+}
+
+// checkDealExpiredOrSlashed gets the checkpoint of an active deal from the
+// market actor state at the given tipset
+func (p *Provider) checkDealExpiredOrSlashed(ctx context.Context, head *ctypes.TipSet, deal *types.ProviderDealState) (dealcheckpoints.Checkpoint, error) {
+	endEpoch := deal.ClientDealProposal.Proposal.EndEpoch
+	md, err := p.fullnodeApi.StateMarketStorageDeal(ctx, deal.ChainDealID, head.Key())
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			return deal.Checkpoint, fmt.Errorf("getting market deal %d: %w", deal.ChainDealID, err)
+		}
+
+		// The market actor removes a deal from state once it has expired
+		if head.Height() >= endEpoch {
+			p.dealLogger.Infow(deal.DealUuid, "deal expired", "deal id", deal.ChainDealID, "end epoch", endEpoch)
+			return dealcheckpoints.Expired, nil
+		}
+
+		// An active deal is only removed from state before its end epoch
+		// if it was slashed (the slash epoch may not have been seen before
+		// the deal was removed)
+		p.dealLogger.Infow(deal.DealUuid, "deal slashed: removed from market actor state before end epoch",
+			"deal id", deal.ChainDealID, "end epoch", endEpoch, "current epoch", head.Height())
+		return dealcheckpoints.Slashed, nil
+	}
+
+	if md.State.SlashEpoch > -1 {
+		p.dealLogger.Infow(deal.DealUuid, "deal slashed", "deal id", deal.ChainDealID, "slash epoch", md.State.SlashEpoch)
+		return dealcheckpoints.Slashed, nil
+	}
+
+	if head.Height() >= endEpoch {
+		p.dealLogger.Infow(deal.DealUuid, "deal expired", "deal id", deal.ChainDealID, "end epoch", endEpoch)
+		return dealcheckpoints.Expired, nil
+	}
+
+	return dealcheckpoints.Active, nil
+}
+
+// resumeWatchDealOnChain is called on startup for deals that have finished
+// sealing but are not yet active, to go back to watching the deal on chain
+func (p *Provider) resumeWatchDealOnChain(deal *types.ProviderDealState, dh *dealHandler) {
+	defer p.cleanupDealHandler(deal.DealUuid)
+
+	pub, err := dh.bus.Emitter(&types.ProviderDealState{}, eventbus.Stateful)
+	if err != nil {
+		p.dealLogger.LogError(deal.DealUuid, "failed to create event emitter", err)
+		return
+	}
+
+	p.dealLogger.Infow(deal.DealUuid, "resuming on-chain deal watch on boost restart", "checkpoint on resumption", deal.Checkpoint.String())
+	p.watchDealOnChain(pub, deal)
+}
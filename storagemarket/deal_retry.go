@@ -0,0 +1,189 @@
+package storagemarket
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/google/uuid"
+	carv2 "github.com/ipld/go-car/v2"
+	"golang.org/x/xerrors"
+)
+
+// RetryDeal is called when the Storage Provider wants to retry a failed deal.
+// The deal is executed again from the last checkpoint it reached before it
+// failed, as long as the deal proposal and the deal data are still valid.
+func (p *Provider) RetryDeal(dealUuid uuid.UUID) error {
+	p.dealLogger.Infow(dealUuid, "retry failed deal")
+
+	ds, err := p.dealsDB.ByID(p.ctx, dealUuid)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("getting deal %s: %w", dealUuid, ErrDealNotFound)
+		}
+		return fmt.Errorf("getting deal %s: %w", dealUuid, err)
+	}
+	if ds.Checkpoint != dealcheckpoints.Complete || ds.Err == "" {
+		return fmt.Errorf("deal %s has not failed (deal is at checkpoint %s)", dealUuid, ds.Checkpoint)
+	}
+
+	// work out which checkpoint to restart the deal from
+	ckpt, err := p.retryCheckpoint(ds)
+	if err != nil {
+		return fmt.Errorf("cannot retry deal %s: %w", dealUuid, err)
+	}
+	ds.Checkpoint = ckpt
+
+	// create a deal handler for the deal, unless the deal is already being
+	// retried (or the failed deal is still being cleaned up)
+	p.dhsMu.Lock()
+	if _, ok := p.dhs[dealUuid]; ok {
+		p.dhsMu.Unlock()
+		return fmt.Errorf("deal %s is already running", dealUuid)
+	}
+	dh := newDealHandler(p.ctx, dealUuid)
+	p.dhs[dealUuid] = dh
+	p.dhsMu.Unlock()
+
+	// setup clean-up code
+	cleanup := func() {
+		dh.close()
+		p.delDealHandler(dealUuid)
+	}
+
+	resp, err := p.sendAcceptDealReq(acceptDealReq{deal: ds, dh: dh, isRetry: true})
+	if err != nil {
+		cleanup()
+		p.dealLogger.LogError(dealUuid, "failed to send deal for retry", err)
+		return fmt.Errorf("failed to send deal for retry: %w", err)
+	}
+
+	if resp.err != nil {
+		cleanup()
+		return fmt.Errorf("failed to retry deal: %w", resp.err)
+	}
+
+	if !resp.ri.Accepted {
+		cleanup()
+		p.dealLogger.Infow(dealUuid, "deal retry rejected by provider", "reason", resp.ri.Reason)
+		return fmt.Errorf("deal retry rejected: %s", resp.ri.Reason)
+	}
+
+	p.dealLogger.Infow(dealUuid, "failed deal scheduled for execution", "checkpoint", ckpt.String())
+	return nil
+}
+
+// retryCheckpoint checks that a failed deal can still be executed, and
+// returns the checkpoint from which execution should restart
+func (p *Provider) retryCheckpoint(deal *types.ProviderDealState) (dealcheckpoints.Checkpoint, error) {
+	ckpt := deal.ErrCheckpoint
+
+	// The checkpoint is not known for deals that failed before retries were
+	// supported
+	if ckpt == dealcheckpoints.Complete {
+		return ckpt, fmt.Errorf("the checkpoint the deal reached before it failed is not known")
+	}
+
+	// Once the deal data has been handed off to the sealer, retrying the deal
+	// just means going back to watching it
+	if ckpt >= dealcheckpoints.AddedPiece {
+		return ckpt, nil
+	}
+
+	// The deal must be added to a sector before its start epoch
+	head, err := p.fullnodeApi.ChainHead(p.ctx)
+	if err != nil {
+		return ckpt, fmt.Errorf("getting chain head: %w", err)
+	}
+	startEpoch := deal.ClientDealProposal.Proposal.StartEpoch
+	if head.Height() >= startEpoch {
+		return ckpt, fmt.Errorf("deal start epoch %d has already passed (current epoch %d)", startEpoch, head.Height())
+	}
+
+	if deal.IsOffline {
+		if deal.InboundFilePath == "" {
+			return ckpt, fmt.Errorf("data for offline deal has not been imported")
+		}
+		if _, err := os.Stat(deal.InboundFilePath); err != nil {
+			return ckpt, fmt.Errorf("checking imported data for offline deal: %w", err)
+		}
+		return ckpt, nil
+	}
+
+	// If the data transfer didn't complete, the transfer will resume from
+	// the end of the inbound file
+	if ckpt < dealcheckpoints.Transferred {
+		return ckpt, nil
+	}
+
+	// Once the retention period has passed, the inbound file may be removed
+	// by the staging area garbage collection at any time
+	if ckpt >= dealcheckpoints.Published && !p.keepInboundFileForRetry(deal) {
+		return ckpt, fmt.Errorf("the data for the failed deal was kept for %s and may have been removed", p.config.RetryDataRetention)
+	}
+
+	fileSize, dataSize, err := inboundFileSizes(deal.InboundFilePath)
+	if err == nil && (fileSize == deal.Transfer.Size || dataSize == deal.Transfer.Size) {
+		return ckpt, nil
+	}
+
+	// The deal hasn't been published yet, so the deal data can be downloaded
+	// again from the start
+	if ckpt < dealcheckpoints.Published {
+		p.dealLogger.Infow(deal.DealUuid, "inbound file for failed deal is no longer valid: deal data will be downloaded again",
+			"path", deal.InboundFilePath)
+		_ = os.Remove(deal.InboundFilePath)
+		return dealcheckpoints.Accepted, nil
+	}
+
+	if err != nil {
+		return ckpt, fmt.Errorf("checking inbound file for deal: %w", err)
+	}
+	return ckpt, fmt.Errorf("inbound file %s has size %d (CAR data size %d) but deal transfer size is %d",
+		deal.InboundFilePath, fileSize, dataSize, deal.Transfer.Size)
+}
+
+// inboundFileSizes returns the size of the inbound file and the size of the
+// CAR data in the file. The graphsync transport writes a CARv2 file, whose
+// size includes the CARv2 header and index, so for a CARv2 file the size of
+// the CAR data is the size of the inner CARv1. For any other file the data
+// size is the file size.
+func inboundFileSizes(path string) (uint64, uint64, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	fileSize := uint64(st.Size())
+
+	r, err := carv2.OpenReader(path)
+	if err != nil {
+		// The file is not a CAR file, or the download didn't complete
+		return fileSize, fileSize, nil
+	}
+	defer r.Close() //nolint:errcheck
+
+	if r.Version == 2 {
+		return fileSize, r.Header.DataSize, nil
+	}
+	return fileSize, fileSize, nil
+}
+
+// keepInboundFileForRetry indicates whether the inbound file for an online
+// deal should be kept after the deal fails. Once a deal has been published
+// the data can no longer be downloaded again by retrying the deal, so the
+// file is kept for the retention period, until the data has been added to a
+// sector.
+func (p *Provider) keepInboundFileForRetry(deal *types.ProviderDealState) bool {
+	return deal.Checkpoint == dealcheckpoints.Complete && deal.Err != "" &&
+		deal.ErrCheckpoint >= dealcheckpoints.Published && deal.ErrCheckpoint < dealcheckpoints.AddedPiece &&
+		time.Since(deal.CheckpointAt) < p.config.RetryDataRetention
+}
+
+// retryDataExpiry is the time until which the inbound file of a failed deal
+// is kept so that the deal can be retried
+func (p *Provider) retryDataExpiry(deal *types.ProviderDealState) time.Time {
+	return deal.CheckpointAt.Add(p.config.RetryDataRetention)
+}
@@ -9,43 +9,65 @@ import (
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 
 	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket/types"
 )
 
 const agent = "boost"
-const jsonVersion = "2.0.0"
+
+// The versions of the documents passed to storage and retrieval deal filters
+const storageJsonVersion = "2.1.0"
+const retrievalJsonVersion = "2.0.0"
 
 func CliStorageDealFilter(cmd string) dtypes.StorageDealFilter {
 	return func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
-		d := struct {
-			types.DealParams
-			DealType      string
-			FormatVersion string
-			Agent         string
-		}{
-			DealParams:    *deal.DealParams,
-			DealType:      "storage",
-			FormatVersion: jsonVersion,
-			Agent:         agent,
-		}
-		return runDealFilter(ctx, cmd, d)
+		return runDealFilter(ctx, cmd, storageDealDoc(deal))
 	}
 }
 
 func CliRetrievalDealFilter(cmd string) dtypes.RetrievalDealFilter {
 	return func(ctx context.Context, deal retrievalmarket.ProviderDealState) (bool, string, error) {
-		d := struct {
-			retrievalmarket.ProviderDealState
-			DealType      string
-			FormatVersion string
-			Agent         string
-		}{
-			ProviderDealState: deal,
-			DealType:          "retrieval",
-			FormatVersion:     jsonVersion,
-			Agent:             agent,
-		}
-		return runDealFilter(ctx, cmd, d)
+		return runDealFilter(ctx, cmd, retrievalDealDoc(deal))
+	}
+}
+
+// storageDealDoc is the document that is passed to a storage deal filter
+func storageDealDoc(deal types.DealFilterParams) interface{} {
+	return struct {
+		types.DealParams
+		ClientPeerID         string
+		SealingPipelineState *sealingpipeline.Status
+		FundsState           *types.FundsState
+		StorageState         *types.StorageState
+		ActiveDeals          *types.ActiveDealCounts
+		DealType             string
+		FormatVersion        string
+		Agent                string
+	}{
+		DealParams:           *deal.DealParams,
+		ClientPeerID:         deal.ClientPeerID.String(),
+		SealingPipelineState: deal.SealingPipelineState,
+		FundsState:           deal.FundsState,
+		StorageState:         deal.StorageState,
+		ActiveDeals:          deal.ActiveDeals,
+		DealType:             "storage",
+		FormatVersion:        storageJsonVersion,
+		Agent:                agent,
+	}
+}
+
+// retrievalDealDoc is the document that is passed to a retrieval deal filter
+func retrievalDealDoc(deal retrievalmarket.ProviderDealState) interface{} {
+	return struct {
+		retrievalmarket.ProviderDealState
+		DealType      string
+		FormatVersion string
+		Agent         string
+	}{
+		ProviderDealState: deal,
+		DealType:          "retrieval",
+		FormatVersion:     retrievalJsonVersion,
+		Agent:             agent,
 	}
 }
 
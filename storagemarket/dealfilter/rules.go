@@ -0,0 +1,467 @@
+package dealfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	ltypes "github.com/filecoin-project/lotus/chain/types"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("dealfilter")
+
+const (
+	ActionAccept = "accept"
+	ActionReject = "reject"
+)
+
+// Rules are the rules of the built-in storage deal filter. They are
+// evaluated in order, and the first rule that matches a deal decides whether
+// the deal is accepted or rejected. If no rule matches, DefaultAction
+// decides.
+//
+// Example rules file:
+//
+//	DefaultAction = "reject"
+//	DefaultReason = "deal does not match any accepted profile"
+//
+//	[[Rule]]
+//	Name = "blocked clients"
+//	Action = "reject"
+//	Reason = "client is not allowed to make deals"
+//	[Rule.Match]
+//	Clients = ["f01234"]
+//
+//	[[Rule]]
+//	Name = "verified deals"
+//	Action = "accept"
+//	[Rule.Match]
+//	Verified = true
+//	MinPieceSize = "1GiB"
+//	TransferTypes = ["http", "graphsync"]
+type Rules struct {
+	// The action to take if no rule matches the deal: "accept" or "reject".
+	// Defaults to "accept".
+	DefaultAction string
+	// The reason sent to the client if the deal is rejected because no rule
+	// matches it
+	DefaultReason string
+	Rule          []Rule
+}
+
+type Rule struct {
+	// A name for the rule, used in logs
+	Name string
+	// The action to take if the rule matches the deal: "accept" or "reject"
+	Action string
+	// The reason sent to the client if the rule rejects the deal
+	Reason string
+	// The conditions that a deal must meet for the rule to match. All of
+	// the conditions that are set must be met.
+	Match Match
+}
+
+type Match struct {
+	// The deal client address is one of these addresses
+	Clients []string
+	// The ID of the peer that sent the deal proposal is one of these IDs
+	PeerIDs []string
+	// The piece size is at least this size, eg "1GiB"
+	MinPieceSize string
+	// The piece size is at most this size, eg "32GiB"
+	MaxPieceSize string
+	// The deal is (or is not) a verified deal
+	Verified *bool
+	// The storage price per epoch is at least this amount, eg "0.0000001 FIL"
+	MinPricePerEpoch string
+	// The storage price per epoch is at most this amount
+	MaxPricePerEpoch string
+	// The deal duration is at least this many epochs
+	MinDuration abi.ChainEpoch
+	// The deal duration is at most this many epochs
+	MaxDuration abi.ChainEpoch
+	// The deal start epoch is at least this many epochs after the current
+	// chain head
+	MinStartEpochDelay abi.ChainEpoch
+	// The deal start epoch is at most this many epochs after the current
+	// chain head
+	MaxStartEpochDelay abi.ChainEpoch
+	// The transfer type is one of these types, eg "http", "manual"
+	TransferTypes []string
+	// The deal label matches this regular expression
+	Label string
+}
+
+// ChainHeadFunc returns the epoch of the current chain head
+type ChainHeadFunc func(ctx context.Context) (abi.ChainEpoch, error)
+
+// Decision is the result of evaluating the rules against a deal
+type Decision struct {
+	Accept bool
+	// The reason that the deal was rejected
+	Reason string
+	// The name of the rule that matched the deal, or empty if no rule matched
+	Rule string
+}
+
+// compiledRules is the parsed form of Rules that is evaluated against deals
+type compiledRules struct {
+	defaultAccept bool
+	defaultReason string
+	rules         []*compiledRule
+}
+
+type compiledRule struct {
+	name   string
+	accept bool
+	reason string
+
+	clients            []address.Address
+	peerIDs            []peer.ID
+	minPieceSize       abi.PaddedPieceSize
+	maxPieceSize       abi.PaddedPieceSize
+	verified           *bool
+	minPrice           *big.Int
+	maxPrice           *big.Int
+	minDuration        abi.ChainEpoch
+	maxDuration        abi.ChainEpoch
+	minStartEpochDelay abi.ChainEpoch
+	maxStartEpochDelay abi.ChainEpoch
+	transferTypes      []string
+	label              *regexp.Regexp
+}
+
+// LoadRules reads the rules from a TOML file
+func LoadRules(path string) (*Rules, error) {
+	rules := &Rules{}
+	if _, err := toml.DecodeFile(path, rules); err != nil {
+		return nil, fmt.Errorf("parsing deal filter rules file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func parseAction(action string) (bool, error) {
+	switch action {
+	case ActionAccept:
+		return true, nil
+	case ActionReject:
+		return false, nil
+	default:
+		return false, fmt.Errorf("action must be %q or %q but is %q", ActionAccept, ActionReject, action)
+	}
+}
+
+func (r *Rules) compile() (*compiledRules, error) {
+	cr := &compiledRules{defaultAccept: true, defaultReason: r.DefaultReason}
+	if r.DefaultAction != "" {
+		accept, err := parseAction(r.DefaultAction)
+		if err != nil {
+			return nil, fmt.Errorf("DefaultAction: %w", err)
+		}
+		cr.defaultAccept = accept
+	}
+	if !cr.defaultAccept && cr.defaultReason == "" {
+		cr.defaultReason = "deal rejected by provider's deal filter rules"
+	}
+
+	for i, rule := range r.Rule {
+		c, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, rule.Name, err)
+		}
+		if c.name == "" {
+			c.name = fmt.Sprintf("rule %d", i+1)
+		}
+		cr.rules = append(cr.rules, c)
+	}
+	return cr, nil
+}
+
+func (r *Rule) compile() (*compiledRule, error) {
+	accept, err := parseAction(r.Action)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiledRule{
+		name:               r.Name,
+		accept:             accept,
+		reason:             r.Reason,
+		verified:           r.Match.Verified,
+		minDuration:        r.Match.MinDuration,
+		maxDuration:        r.Match.MaxDuration,
+		minStartEpochDelay: r.Match.MinStartEpochDelay,
+		maxStartEpochDelay: r.Match.MaxStartEpochDelay,
+		transferTypes:      r.Match.TransferTypes,
+	}
+	if !accept && c.reason == "" {
+		c.reason = "deal rejected by provider's deal filter rules"
+	}
+
+	for _, a := range r.Match.Clients {
+		addr, err := address.NewFromString(a)
+		if err != nil {
+			return nil, fmt.Errorf("parsing client address '%s': %w", a, err)
+		}
+		c.clients = append(c.clients, addr)
+	}
+	for _, p := range r.Match.PeerIDs {
+		pid, err := peer.Decode(p)
+		if err != nil {
+			return nil, fmt.Errorf("parsing peer ID '%s': %w", p, err)
+		}
+		c.peerIDs = append(c.peerIDs, pid)
+	}
+
+	parseSize := func(s string) (abi.PaddedPieceSize, error) {
+		if s == "" {
+			return 0, nil
+		}
+		sz, err := units.RAMInBytes(s)
+		if err != nil {
+			return 0, fmt.Errorf("parsing piece size '%s': %w", s, err)
+		}
+		return abi.PaddedPieceSize(sz), nil
+	}
+	if c.minPieceSize, err = parseSize(r.Match.MinPieceSize); err != nil {
+		return nil, err
+	}
+	if c.maxPieceSize, err = parseSize(r.Match.MaxPieceSize); err != nil {
+		return nil, err
+	}
+
+	parsePrice := func(s string) (*big.Int, error) {
+		if s == "" {
+			return nil, nil
+		}
+		fil, err := ltypes.ParseFIL(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing price '%s': %w", s, err)
+		}
+		price := big.Int(fil)
+		return &price, nil
+	}
+	if c.minPrice, err = parsePrice(r.Match.MinPricePerEpoch); err != nil {
+		return nil, err
+	}
+	if c.maxPrice, err = parsePrice(r.Match.MaxPricePerEpoch); err != nil {
+		return nil, err
+	}
+
+	if r.Match.Label != "" {
+		if c.label, err = regexp.Compile(r.Match.Label); err != nil {
+			return nil, fmt.Errorf("parsing label regular expression: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *compiledRules) evaluate(ctx context.Context, params types.DealFilterParams, head ChainHeadFunc) (*Decision, error) {
+	for _, rule := range c.rules {
+		match, err := rule.matches(ctx, params, head)
+		if err != nil {
+			return nil, fmt.Errorf("evaluating deal filter rule %s: %w", rule.name, err)
+		}
+		if match {
+			return &Decision{Accept: rule.accept, Reason: rule.reason, Rule: rule.name}, nil
+		}
+	}
+	return &Decision{Accept: c.defaultAccept, Reason: c.defaultReason}, nil
+}
+
+func (r *compiledRule) matches(ctx context.Context, params types.DealFilterParams, head ChainHeadFunc) (bool, error) {
+	deal := params.DealParams
+	prop := deal.ClientDealProposal.Proposal
+
+	if len(r.clients) > 0 && !containsAddress(r.clients, prop.Client) {
+		return false, nil
+	}
+	if len(r.peerIDs) > 0 && !containsPeer(r.peerIDs, params.ClientPeerID) {
+		return false, nil
+	}
+	if r.minPieceSize > 0 && prop.PieceSize < r.minPieceSize {
+		return false, nil
+	}
+	if r.maxPieceSize > 0 && prop.PieceSize > r.maxPieceSize {
+		return false, nil
+	}
+	if r.verified != nil && prop.VerifiedDeal != *r.verified {
+		return false, nil
+	}
+	if r.minPrice != nil && prop.StoragePricePerEpoch.LessThan(*r.minPrice) {
+		return false, nil
+	}
+	if r.maxPrice != nil && prop.StoragePricePerEpoch.GreaterThan(*r.maxPrice) {
+		return false, nil
+	}
+	duration := prop.EndEpoch - prop.StartEpoch
+	if r.minDuration > 0 && duration < r.minDuration {
+		return false, nil
+	}
+	if r.maxDuration > 0 && duration > r.maxDuration {
+		return false, nil
+	}
+	if len(r.transferTypes) > 0 && !containsString(r.transferTypes, deal.Transfer.Type) {
+		return false, nil
+	}
+	if r.label != nil && !r.label.MatchString(prop.Label) {
+		return false, nil
+	}
+
+	// Only get the chain head if the rule needs it
+	if r.minStartEpochDelay > 0 || r.maxStartEpochDelay > 0 {
+		if head == nil {
+			return false, errors.New("rule matches on start epoch delay but the current epoch is not available")
+		}
+		epoch, err := head(ctx)
+		if err != nil {
+			return false, fmt.Errorf("getting chain head: %w", err)
+		}
+		delay := prop.StartEpoch - epoch
+		if r.minStartEpochDelay > 0 && delay < r.minStartEpochDelay {
+			return false, nil
+		}
+		if r.maxStartEpochDelay > 0 && delay > r.maxStartEpochDelay {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func containsAddress(addrs []address.Address, addr address.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPeer(peers []peer.ID, p peer.ID) bool {
+	for _, pid := range peers {
+		if pid == p {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RulesFilter is a storage deal filter that evaluates the rules in a rules
+// file. It checks if the file has changed each time a deal is evaluated, and
+// if so reloads the rules.
+type RulesFilter struct {
+	path string
+	head ChainHeadFunc
+
+	lk      sync.Mutex
+	rules   *compiledRules
+	modTime time.Time
+	size    int64
+}
+
+// NewRulesFilter loads the rules from the file at path. The head function
+// is used to get the current epoch for rules that match on the deal start
+// epoch.
+func NewRulesFilter(path string, head ChainHeadFunc) (*RulesFilter, error) {
+	f := &RulesFilter{path: path, head: head}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Evaluate evaluates the rules against the deal
+func (f *RulesFilter) Evaluate(ctx context.Context, params types.DealFilterParams) (*Decision, error) {
+	rules, err := f.currentRules()
+	if err != nil {
+		return nil, err
+	}
+	return rules.evaluate(ctx, params, f.head)
+}
+
+// Filter evaluates the rules against the deal, in the form of a deal filter
+func (f *RulesFilter) Filter(ctx context.Context, params types.DealFilterParams) (bool, string, error) {
+	d, err := f.Evaluate(ctx, params)
+	if err != nil {
+		return false, "", err
+	}
+	if !d.Accept {
+		log.Infow("deal rejected by deal filter rules", "deal", params.DealParams.DealUUID, "rule", d.Rule, "reason", d.Reason)
+	}
+	return d.Accept, d.Reason, nil
+}
+
+// currentRules reloads the rules if the rules file has changed. If the new
+// rules are invalid, the previous rules are used.
+func (f *RulesFilter) currentRules() (*compiledRules, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	st, err := os.Stat(f.path)
+	if err != nil {
+		log.Errorw("failed to stat deal filter rules file, using previous rules", "path", f.path, "err", err)
+		return f.rules, nil
+	}
+	if st.ModTime().Equal(f.modTime) && st.Size() == f.size {
+		return f.rules, nil
+	}
+
+	if err := f.reloadLocked(st); err != nil {
+		log.Errorw("failed to reload deal filter rules file, using previous rules", "path", f.path, "err", err)
+		// Don't try to reload again until the file changes
+		f.modTime = st.ModTime()
+		f.size = st.Size()
+		return f.rules, nil
+	}
+	log.Infow("reloaded deal filter rules", "path", f.path, "rules", len(f.rules.rules))
+	return f.rules, nil
+}
+
+func (f *RulesFilter) reload() error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("deal filter rules file: %w", err)
+	}
+	return f.reloadLocked(st)
+}
+
+func (f *RulesFilter) reloadLocked(st os.FileInfo) error {
+	rules, err := LoadRules(f.path)
+	if err != nil {
+		return err
+	}
+	compiled, err := rules.compile()
+	if err != nil {
+		return fmt.Errorf("deal filter rules file %s: %w", f.path, err)
+	}
+
+	f.rules = compiled
+	f.modTime = st.ModTime()
+	f.size = st.Size()
+	return nil
+}
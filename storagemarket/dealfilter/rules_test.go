@@ -0,0 +1,220 @@
+package dealfilter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/require"
+)
+
+// testRules is formatted with the ID of a blocked peer
+const testRules = `
+DefaultAction = "reject"
+DefaultReason = "no rule matched"
+
+[[Rule]]
+Name = "blocked client"
+Action = "reject"
+Reason = "client is blocked"
+  [Rule.Match]
+  Clients = ["f01000"]
+
+[[Rule]]
+Name = "blocked peer"
+Action = "reject"
+Reason = "peer is blocked"
+  [Rule.Match]
+  PeerIDs = ["%s"]
+
+[[Rule]]
+Name = "large verified deals"
+Action = "accept"
+  [Rule.Match]
+  Verified = true
+  MinPieceSize = "1GiB"
+  TransferTypes = ["http"]
+
+[[Rule]]
+Name = "paid deals"
+Action = "accept"
+  [Rule.Match]
+  Verified = false
+  MinPricePerEpoch = "0.000001 FIL"
+  MinDuration = 518400
+  MaxStartEpochDelay = 2880
+  Label = "^backup-"
+`
+
+func TestRulesFilter(t *testing.T) {
+	ctx := context.Background()
+	blockedPeer, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(testRules, blockedPeer)), 0644))
+
+	head := func(ctx context.Context) (abi.ChainEpoch, error) { return 1000, nil }
+	f, err := NewRulesFilter(path, head)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name   string
+		deal   func(p *types.DealFilterParams)
+		accept bool
+		rule   string
+	}{{
+		name:   "verified deal",
+		deal:   func(p *types.DealFilterParams) {},
+		accept: true,
+		rule:   "large verified deals",
+	}, {
+		name: "blocked client",
+		deal: func(p *types.DealFilterParams) {
+			p.DealParams.ClientDealProposal.Proposal.Client = mustAddr(t, "f01000")
+		},
+		accept: false,
+		rule:   "blocked client",
+	}, {
+		name: "blocked peer",
+		deal: func(p *types.DealFilterParams) {
+			p.ClientPeerID = blockedPeer
+		},
+		accept: false,
+		rule:   "blocked peer",
+	}, {
+		name: "verified deal too small",
+		deal: func(p *types.DealFilterParams) {
+			p.DealParams.ClientDealProposal.Proposal.PieceSize = 512 << 20
+		},
+		accept: false,
+	}, {
+		name: "verified deal wrong transfer type",
+		deal: func(p *types.DealFilterParams) {
+			p.DealParams.Transfer.Type = "graphsync"
+		},
+		accept: false,
+	}, {
+		name:   "paid deal",
+		deal:   paidDeal,
+		accept: true,
+		rule:   "paid deals",
+	}, {
+		name: "paid deal price too low",
+		deal: func(p *types.DealFilterParams) {
+			paidDeal(p)
+			p.DealParams.ClientDealProposal.Proposal.StoragePricePerEpoch = abi.NewTokenAmount(1)
+		},
+		accept: false,
+	}, {
+		name: "paid deal starts too late",
+		deal: func(p *types.DealFilterParams) {
+			paidDeal(p)
+			p.DealParams.ClientDealProposal.Proposal.StartEpoch = 1000 + 2881
+			p.DealParams.ClientDealProposal.Proposal.EndEpoch = 1000 + 2881 + 518400
+		},
+		accept: false,
+	}, {
+		name: "paid deal label doesn't match",
+		deal: func(p *types.DealFilterParams) {
+			paidDeal(p)
+			p.DealParams.ClientDealProposal.Proposal.Label = "archive-1"
+		},
+		accept: false,
+	}}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			params := verifiedDeal(t)
+			tc.deal(&params)
+			d, err := f.Evaluate(ctx, params)
+			require.NoError(t, err)
+			require.Equal(t, tc.accept, d.Accept)
+			require.Equal(t, tc.rule, d.Rule)
+			if !d.Accept {
+				require.NotEmpty(t, d.Reason)
+			}
+		})
+	}
+}
+
+func TestRulesFilterReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`DefaultAction = "accept"`), 0644))
+
+	f, err := NewRulesFilter(path, nil)
+	require.NoError(t, err)
+
+	accept, _, err := f.Filter(ctx, verifiedDeal(t))
+	require.NoError(t, err)
+	require.True(t, accept)
+
+	// Change the rules file. The new rules should be used for the next deal.
+	writeRules(t, path, `DefaultAction = "reject"`+"\n"+`DefaultReason = "closed for business"`)
+	accept, reason, err := f.Filter(ctx, verifiedDeal(t))
+	require.NoError(t, err)
+	require.False(t, accept)
+	require.Equal(t, "closed for business", reason)
+
+	// If the new rules are invalid, the previous rules should still be used
+	writeRules(t, path, `DefaultAction = "maybe"`)
+	accept, reason, err = f.Filter(ctx, verifiedDeal(t))
+	require.NoError(t, err)
+	require.False(t, accept)
+	require.Equal(t, "closed for business", reason)
+
+	// Invalid rules should be rejected at startup
+	_, err = NewRulesFilter(path, nil)
+	require.Error(t, err)
+}
+
+// writeRules writes the rules file, making sure that its modification time
+// changes
+func writeRules(t *testing.T, path string, rules string) {
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0644))
+	modTime := st.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func verifiedDeal(t *testing.T) types.DealFilterParams {
+	return types.DealFilterParams{
+		DealParams: &types.DealParams{
+			ClientDealProposal: market.ClientDealProposal{
+				Proposal: market.DealProposal{
+					PieceSize:            32 << 30,
+					VerifiedDeal:         true,
+					Client:               mustAddr(t, "f01001"),
+					StartEpoch:           2000,
+					EndEpoch:             2000 + 518400,
+					StoragePricePerEpoch: big.Zero(),
+				},
+			},
+			Transfer: types.Transfer{Type: "http"},
+		},
+	}
+}
+
+func paidDeal(p *types.DealFilterParams) {
+	prop := &p.DealParams.ClientDealProposal.Proposal
+	prop.VerifiedDeal = false
+	prop.StoragePricePerEpoch = abi.NewTokenAmount(1_000_000_000_000)
+	prop.Label = "backup-1"
+}
+
+func mustAddr(t *testing.T, a string) address.Address {
+	addr, err := address.NewFromString(a)
+	require.NoError(t, err)
+	return addr
+}
@@ -0,0 +1,162 @@
+package dealfilter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+const (
+	// The default time to wait for the webhook to respond
+	defaultWebhookTimeout = 10 * time.Second
+	// The time to wait between attempts to reach the webhook
+	webhookRetryWait = time.Second
+	// The maximum size of the response body that is read from the webhook
+	maxWebhookResponseSize = 4096
+)
+
+// WebhookConfig configures a deal filter that POSTs the deal to an HTTP
+// endpoint.
+//
+// The endpoint receives the same JSON document as a deal filter command. It
+// should respond with a 2xx status code to accept the deal, or a 4xx status
+// code to reject it, with the reason for the rejection in the response body.
+// A 401 or 403 status code means that the endpoint did not accept the auth
+// header, so the deal is accepted or rejected according to FailOpen.
+type WebhookConfig struct {
+	// The URL that deals are POSTed to
+	URL string
+	// The value of the Authorization header sent with each request, eg
+	// "Bearer <token>"
+	AuthHeader string
+	// How long to wait for the endpoint to respond to each request
+	Timeout time.Duration
+	// The number of times to retry a request that fails with a network error
+	// or a 5xx status code
+	Retries int
+	// If true, deals are accepted when the endpoint can't be reached (fail
+	// open). Otherwise they are rejected (fail closed).
+	FailOpen bool
+}
+
+func WebhookStorageDealFilter(cfg WebhookConfig) dtypes.StorageDealFilter {
+	w := newWebhook(cfg)
+	return func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
+		return w.filter(ctx, storageDealDoc(deal))
+	}
+}
+
+func WebhookRetrievalDealFilter(cfg WebhookConfig) dtypes.RetrievalDealFilter {
+	w := newWebhook(cfg)
+	return func(ctx context.Context, deal retrievalmarket.ProviderDealState) (bool, string, error) {
+		return w.filter(ctx, retrievalDealDoc(deal))
+	}
+}
+
+type webhook struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+func newWebhook(cfg WebhookConfig) *webhook {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	return &webhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// webhookError is an error that means the webhook could not evaluate the
+// deal (as opposed to the webhook rejecting the deal)
+type webhookError struct {
+	error
+	// whether the request should be retried
+	retry bool
+}
+
+func (w *webhook) filter(ctx context.Context, deal interface{}) (bool, string, error) {
+	j, err := json.MarshalIndent(deal, "", "  ")
+	if err != nil {
+		return false, "", err
+	}
+
+	var lastErr *webhookError
+	for attempt := 0; attempt <= w.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(webhookRetryWait):
+			case <-ctx.Done():
+				return false, "", ctx.Err()
+			}
+		}
+
+		accept, reason, werr := w.post(ctx, j)
+		if werr == nil {
+			return accept, reason, nil
+		}
+
+		lastErr = werr
+		log.Warnw("deal filter webhook request failed", "url", w.cfg.URL, "attempt", attempt+1, "err", werr)
+		if !werr.retry {
+			break
+		}
+	}
+
+	if w.cfg.FailOpen {
+		log.Warnw("deal filter webhook unavailable, accepting deal (fail open)", "url", w.cfg.URL, "err", lastErr)
+		return true, "", nil
+	}
+	log.Warnw("deal filter webhook unavailable, rejecting deal (fail closed)", "url", w.cfg.URL, "err", lastErr)
+	return false, "deal filter unavailable", nil
+}
+
+func (w *webhook) post(ctx context.Context, body []byte) (bool, string, *webhookError) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, "", &webhookError{error: fmt.Errorf("creating request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.cfg.AuthHeader != "" {
+		req.Header.Set("Authorization", w.cfg.AuthHeader)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false, "", &webhookError{error: fmt.Errorf("sending request: %w", err), retry: ctx.Err() == nil}
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return false, "", &webhookError{error: fmt.Errorf("reading response: %w", err), retry: true}
+	}
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return true, "", nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		// The provider is not authorized to call the webhook, so the webhook
+		// hasn't evaluated the deal
+		return false, "", &webhookError{error: fmt.Errorf("webhook responded with %s: check the auth header", resp.Status)}
+	case resp.StatusCode/100 == 4:
+		reason := strings.TrimSpace(string(respBody))
+		if reason == "" {
+			reason = "deal rejected by deal filter"
+		}
+		return false, reason, nil
+	default:
+		return false, "", &webhookError{error: fmt.Errorf("webhook responded with %s", resp.Status), retry: true}
+	}
+}
@@ -0,0 +1,119 @@
+package dealfilter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookStorageDealFilter(t *testing.T) {
+	ctx := context.Background()
+
+	var requests int32
+	var failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Fail the configured number of requests before responding
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		bz, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var doc struct {
+			DealType      string
+			FormatVersion string
+			Agent         string
+			Transfer      struct{ Type string }
+		}
+		require.NoError(t, json.Unmarshal(bz, &doc))
+		require.Equal(t, "storage", doc.DealType)
+		require.Equal(t, storageJsonVersion, doc.FormatVersion)
+		require.Equal(t, agent, doc.Agent)
+
+		if doc.Transfer.Type == "graphsync" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			_, _ = w.Write([]byte("graphsync deals are not accepted"))
+			return
+		}
+	}))
+	defer srv.Close()
+
+	cfg := WebhookConfig{
+		URL:        srv.URL,
+		AuthHeader: "Bearer secret",
+		Timeout:    time.Second,
+		Retries:    2,
+	}
+	reset := func(fail int32) {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, fail)
+	}
+
+	t.Run("accept", func(t *testing.T) {
+		reset(0)
+		accept, _, err := WebhookStorageDealFilter(cfg)(ctx, verifiedDeal(t))
+		require.NoError(t, err)
+		require.True(t, accept)
+		require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("reject with reason", func(t *testing.T) {
+		reset(0)
+		deal := verifiedDeal(t)
+		deal.DealParams.Transfer.Type = "graphsync"
+		accept, reason, err := WebhookStorageDealFilter(cfg)(ctx, deal)
+		require.NoError(t, err)
+		require.False(t, accept)
+		require.Equal(t, "graphsync deals are not accepted", reason)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		reset(2)
+		accept, _, err := WebhookStorageDealFilter(cfg)(ctx, verifiedDeal(t))
+		require.NoError(t, err)
+		require.True(t, accept)
+		require.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		reset(3)
+		accept, reason, err := WebhookStorageDealFilter(cfg)(ctx, verifiedDeal(t))
+		require.NoError(t, err)
+		require.False(t, accept)
+		require.NotEmpty(t, reason)
+		require.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		reset(3)
+		failOpen := cfg
+		failOpen.FailOpen = true
+		accept, _, err := WebhookStorageDealFilter(failOpen)(ctx, verifiedDeal(t))
+		require.NoError(t, err)
+		require.True(t, accept)
+	})
+
+	t.Run("bad auth header is not retried", func(t *testing.T) {
+		reset(0)
+		badAuth := cfg
+		badAuth.AuthHeader = "Bearer wrong"
+		accept, _, err := WebhookStorageDealFilter(badAuth)(ctx, verifiedDeal(t))
+		require.NoError(t, err)
+		require.False(t, accept)
+		require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+	})
+}
@@ -3,6 +3,7 @@ package storagemarket
 import (
 	"bytes"
 	"context"
+	"errors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api/v1api"
@@ -17,6 +18,11 @@ import (
 	"golang.org/x/xerrors"
 )
 
+// ErrPublishMsgFailed is returned by WaitForPublishDeals when the publish
+// storage deals message landed on chain but failed to execute (eg because
+// one of the deals in the message was no longer valid)
+var ErrPublishMsgFailed = errors.New("publish storage deals message failed on chain")
+
 type ChainDealManagerCfg struct {
 	PublishDealsConfidence uint64
 }
@@ -37,7 +43,7 @@ func (c *ChainDealManager) WaitForPublishDeals(ctx context.Context, publishCid c
 		return nil, xerrors.Errorf("WaitForPublishDeals errored: %w", err)
 	}
 	if receipt.Receipt.ExitCode != exitcode.Ok {
-		return nil, xerrors.Errorf("WaitForPublishDeals exit code: %s", receipt.Receipt.ExitCode)
+		return nil, xerrors.Errorf("WaitForPublishDeals exit code: %s: %w", receipt.Receipt.ExitCode, ErrPublishMsgFailed)
 	}
 
 	// The deal ID may have changed since publish if there was a reorg, so
@@ -203,7 +203,7 @@ func (p *DealProvider) handleNewDealStream(s network.Stream) {
 
 	// Write the response to the client
 	log.Infow("send deal proposal response", "id", proposal.DealUUID, "accepted", res.Accepted, "msg", res.Reason)
-	err = cborutil.WriteCborRPC(s, &types.DealResponse{Accepted: res.Accepted, Message: res.Reason})
+	err = cborutil.WriteCborRPC(s, &types.DealResponse{Accepted: res.Accepted, Message: res.Reason, TransferToken: res.TransferToken})
 	if err != nil {
 		log.Warnw("writing deal response", "id", proposal.DealUUID, "err", err)
 		return
@@ -0,0 +1,471 @@
+package storagemarket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// The interval at which the offline deal drop directories are scanned for
+// new files
+var offlineDropPollInterval = 10 * time.Second
+
+// A file that could not be imported is not tried again for
+// offlineDropRetryMin, and the delay doubles after each failed attempt up to
+// offlineDropRetryMax
+var offlineDropRetryMin = time.Minute
+var offlineDropRetryMax = 30 * time.Minute
+
+type OfflineDropConfig struct {
+	// The directories that are watched for offline deal data files. When a
+	// new file has finished writing, its commP is calculated and the file is
+	// imported as the data for the offline deal for that piece.
+	Dirs []string
+	// A file is considered to have finished writing once its size and
+	// modification time have not changed for this long
+	StableDuration time.Duration
+}
+
+// OfflineDropFile is a file in an offline deal drop directory that could not
+// be imported
+type OfflineDropFile struct {
+	Path      string
+	Size      int64
+	FirstSeen time.Time
+	// The commP of the file (undefined if it could not be calculated)
+	PieceCID cid.Cid
+	// Why the file could not be imported
+	Error string
+}
+
+type offlineDropFile struct {
+	size      int64
+	modTime   time.Time
+	firstSeen time.Time
+	// when the size or modification time of the file last changed
+	changedAt time.Time
+
+	// true while the file is waiting for its commP to be calculated
+	queued bool
+	// true once the file has finished writing and its commP has been
+	// calculated
+	processed bool
+	pieceCid  cid.Cid
+	pieceSize abi.PaddedPieceSize
+	// true once the file has been imported for a deal
+	imported bool
+	err      string
+
+	// the number of failed attempts to import the file, and when it
+	// should next be tried
+	attempts int
+	retryAt  time.Time
+}
+
+// offlineDropQueued is a file that is waiting for its commP to be calculated
+type offlineDropQueued struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type offlineDropWatcher struct {
+	db *db.OfflineDropDB
+
+	lk sync.Mutex
+	// file path => file state
+	files map[string]*offlineDropFile
+	// files waiting for their commP to be calculated
+	queue []offlineDropQueued
+
+	// signals the commP worker that there are files in the queue
+	queued chan struct{}
+	// signals the watcher to scan the drop directories
+	kick chan struct{}
+}
+
+func newOfflineDropWatcher(sqldb *sql.DB) *offlineDropWatcher {
+	return &offlineDropWatcher{
+		db:     db.NewOfflineDropDB(sqldb),
+		files:  make(map[string]*offlineDropFile),
+		queued: make(chan struct{}, 1),
+		kick:   make(chan struct{}, 1),
+	}
+}
+
+func (w *offlineDropWatcher) enqueue(q offlineDropQueued) {
+	w.lk.Lock()
+	if f, ok := w.files[q.path]; ok && f.size == q.size && f.modTime.Equal(q.modTime) {
+		f.queued = true
+		w.queue = append(w.queue, q)
+	}
+	w.lk.Unlock()
+
+	nonBlockingSend(w.queued)
+}
+
+func (w *offlineDropWatcher) dequeue() (offlineDropQueued, bool) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if len(w.queue) == 0 {
+		return offlineDropQueued{}, false
+	}
+	q := w.queue[0]
+	w.queue = w.queue[1:]
+	return q, true
+}
+
+// nonBlockingSend sends on a channel with a buffer of one without blocking
+func nonBlockingSend(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// offlineDropRetryDelay returns how long to wait before trying to import a
+// file again after the given number of failed attempts
+func offlineDropRetryDelay(attempts int) time.Duration {
+	delay := offlineDropRetryMin
+	for i := 1; i < attempts && delay < offlineDropRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > offlineDropRetryMax {
+		delay = offlineDropRetryMax
+	}
+	return delay
+}
+
+// OfflineDropUnmatched returns the files in the offline deal drop
+// directories that have finished writing but could not be imported, eg
+// because there is no offline deal for the file's piece
+func (p *Provider) OfflineDropUnmatched() []OfflineDropFile {
+	p.offlineDrop.lk.Lock()
+	defer p.offlineDrop.lk.Unlock()
+
+	unmatched := make([]OfflineDropFile, 0)
+	for path, f := range p.offlineDrop.files {
+		if !f.processed || f.imported {
+			continue
+		}
+		unmatched = append(unmatched, OfflineDropFile{
+			Path:      path,
+			Size:      f.size,
+			FirstSeen: f.firstSeen,
+			PieceCID:  f.pieceCid,
+			Error:     f.err,
+		})
+	}
+	sort.Slice(unmatched, func(i, j int) bool {
+		return unmatched[i].Path < unmatched[j].Path
+	})
+	return unmatched
+}
+
+func (p *Provider) runOfflineDropWatcher() {
+	defer p.wg.Done()
+
+	log.Infow("watching offline deal drop directories", "dirs", p.config.OfflineDrop.Dirs,
+		"stable duration", p.config.OfflineDrop.StableDuration)
+
+	// Calculate commP in a separate go routine so that a large file
+	// doesn't hold up scanning for and importing other files
+	p.wg.Add(1)
+	go p.runOfflineDropCommP()
+
+	ticker := time.NewTicker(offlineDropPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.scanOfflineDropDirs(p.ctx); err != nil && p.ctx.Err() == nil {
+			log.Errorw("scanning offline deal drop directories", "err", err)
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.offlineDrop.kick:
+		}
+	}
+}
+
+func (p *Provider) runOfflineDropCommP() {
+	defer p.wg.Done()
+
+	for {
+		p.calculateOfflineDropCommPs(p.ctx)
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.offlineDrop.queued:
+		}
+	}
+}
+
+// calculateOfflineDropCommPs calculates the commP of each file in the queue
+// and saves it, so that the file doesn't need to be processed again after
+// boost restarts
+func (p *Provider) calculateOfflineDropCommPs(ctx context.Context) {
+	for ctx.Err() == nil {
+		q, ok := p.offlineDrop.dequeue()
+		if !ok {
+			return
+		}
+
+		saved := &db.OfflineDropFile{Path: q.path, Size: q.size, ModTime: q.modTime}
+		cidAndSize, err := GenerateCommP(q.path)
+		if err != nil {
+			log.Warnw("calculating commP of offline deal drop file", "path", q.path, "err", err)
+			saved.Error = fmt.Sprintf("calculating commP: %s", err)
+		} else {
+			saved.PieceCID = cidAndSize.PieceCID
+			saved.PieceSize = cidAndSize.PieceSize
+		}
+
+		// The file may have changed or been removed while its commP was
+		// being calculated
+		if !p.setOfflineDropFileProcessed(q, savedOfflineDropFile(saved)) {
+			continue
+		}
+		if err := p.offlineDrop.db.Set(ctx, saved); err != nil {
+			log.Warnw("saving commP of offline deal drop file", "path", q.path, "err", err)
+		}
+
+		// Try to import the file now, rather than waiting for the next scan
+		nonBlockingSend(p.offlineDrop.kick)
+	}
+}
+
+func savedOfflineDropFile(saved *db.OfflineDropFile) *offlineDropFile {
+	return &offlineDropFile{
+		processed: true,
+		pieceCid:  saved.PieceCID,
+		pieceSize: saved.PieceSize,
+		err:       saved.Error,
+	}
+}
+
+// scanOfflineDropDirs looks for new files in the drop directories. Once a
+// file has finished writing, it queues the file to have its commP
+// calculated. Files with a known commP are imported as the data for the
+// offline deal for that piece.
+func (p *Provider) scanOfflineDropDirs(ctx context.Context) error {
+	stable, removed := p.updateOfflineDropFiles()
+	for _, path := range removed {
+		if err := p.offlineDrop.db.Delete(ctx, path); err != nil {
+			log.Warnw("deleting removed offline deal drop file", "path", path, "err", err)
+		}
+	}
+
+	if len(stable) > 0 {
+		// Files that were already imported before boost restarted don't
+		// need to be processed again
+		inUse, err := p.offlineDealFilePaths(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, q := range stable {
+			if _, ok := inUse[q.path]; ok {
+				p.setOfflineDropFileProcessed(q, &offlineDropFile{processed: true, imported: true})
+				continue
+			}
+
+			// The commP may have been calculated before boost restarted
+			saved, err := p.offlineDrop.db.Get(ctx, q.path)
+			if err == nil && saved.Size == q.size && saved.ModTime.Equal(q.modTime) {
+				p.setOfflineDropFileProcessed(q, savedOfflineDropFile(saved))
+				continue
+			}
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				log.Warnw("getting saved commP of offline deal drop file", "path", q.path, "err", err)
+			}
+
+			p.offlineDrop.enqueue(q)
+		}
+	}
+
+	return p.importOfflineDropFiles(ctx)
+}
+
+// updateOfflineDropFiles records the size of each file in the drop
+// directories. It returns the files that have finished writing but have not
+// yet been processed or queued, and the paths of files that were removed.
+func (p *Provider) updateOfflineDropFiles() ([]offlineDropQueued, []string) {
+	now := time.Now()
+	seen := make(map[string]struct{})
+
+	p.offlineDrop.lk.Lock()
+	defer p.offlineDrop.lk.Unlock()
+
+	for _, dir := range p.config.OfflineDrop.Dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Warnw("reading offline deal drop directory", "dir", dir, "err", err)
+			continue
+		}
+
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+
+			path := filepath.Join(dir, e.Name())
+			seen[path] = struct{}{}
+			f, ok := p.offlineDrop.files[path]
+			if ok && f.size == info.Size() && f.modTime.Equal(info.ModTime()) {
+				continue
+			}
+
+			// The file is new or has changed, so wait for it to finish
+			// writing before processing it (again)
+			firstSeen := now
+			if ok {
+				firstSeen = f.firstSeen
+			}
+			p.offlineDrop.files[path] = &offlineDropFile{
+				size:      info.Size(),
+				modTime:   info.ModTime(),
+				firstSeen: firstSeen,
+				changedAt: now,
+			}
+		}
+	}
+
+	var stable []offlineDropQueued
+	var removed []string
+	for path, f := range p.offlineDrop.files {
+		if _, ok := seen[path]; !ok {
+			delete(p.offlineDrop.files, path)
+			removed = append(removed, path)
+			continue
+		}
+		if !f.processed && !f.queued && now.Sub(f.changedAt) >= p.config.OfflineDrop.StableDuration {
+			stable = append(stable, offlineDropQueued{path: path, size: f.size, modTime: f.modTime})
+		}
+	}
+	sort.Slice(stable, func(i, j int) bool {
+		return stable[i].path < stable[j].path
+	})
+	return stable, removed
+}
+
+// setOfflineDropFileProcessed records the result of processing the file.
+// It returns false if the file has changed or been removed since it was
+// queued.
+func (p *Provider) setOfflineDropFileProcessed(q offlineDropQueued, processed *offlineDropFile) bool {
+	p.offlineDrop.lk.Lock()
+	defer p.offlineDrop.lk.Unlock()
+
+	f, ok := p.offlineDrop.files[q.path]
+	if !ok || f.size != q.size || !f.modTime.Equal(q.modTime) {
+		return false
+	}
+	f.queued = false
+	f.processed = processed.processed
+	f.pieceCid = processed.pieceCid
+	f.pieceSize = processed.pieceSize
+	f.imported = processed.imported
+	f.err = processed.err
+	return true
+}
+
+// importOfflineDropFiles matches each processed file that has not yet been
+// imported with an offline deal for the file's piece, and imports it.
+// Files that didn't match a deal or failed to import are tried again after
+// a delay that grows with each failed attempt, in case the deal was made
+// after the file was dropped.
+func (p *Provider) importOfflineDropFiles(ctx context.Context) error {
+	now := time.Now()
+
+	type candidate struct {
+		path      string
+		pieceCid  cid.Cid
+		pieceSize abi.PaddedPieceSize
+	}
+
+	p.offlineDrop.lk.Lock()
+	var candidates []candidate
+	for path, f := range p.offlineDrop.files {
+		if f.processed && !f.imported && f.pieceCid.Defined() && !now.Before(f.retryAt) {
+			candidates = append(candidates, candidate{path: path, pieceCid: f.pieceCid, pieceSize: f.pieceSize})
+		}
+	}
+	p.offlineDrop.lk.Unlock()
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].path < candidates[j].path
+	})
+
+	waiting, err := p.offlineDealsAwaitingData(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		var importErr string
+		deal, pieceCid, err := waiting.popByCommP(c.pieceCid, c.pieceSize)
+		if err != nil {
+			return err
+		}
+		if deal == nil {
+			importErr = fmt.Sprintf("no offline deal for piece %s is waiting for data", c.pieceCid)
+		} else {
+			p.dealLogger.Infow(deal.DealUuid, "importing offline deal data from drop directory",
+				"filepath", c.path, "piece cid", c.pieceCid)
+			importErr = p.importOfflineDealResult(deal.DealUuid, c.path, pieceCid)
+			if importErr != "" {
+				p.dealLogger.LogError(deal.DealUuid, "failed to import offline deal data from drop directory", errors.New(importErr))
+				importErr = fmt.Sprintf("importing data for deal %s: %s", deal.DealUuid, importErr)
+			}
+		}
+
+		p.offlineDrop.lk.Lock()
+		if f, ok := p.offlineDrop.files[c.path]; ok {
+			f.imported = importErr == ""
+			f.err = importErr
+			if !f.imported {
+				f.attempts++
+				f.retryAt = time.Now().Add(offlineDropRetryDelay(f.attempts))
+			}
+		}
+		p.offlineDrop.lk.Unlock()
+	}
+
+	return nil
+}
+
+// offlineDealFilePaths returns the paths of the data files that have been
+// imported for offline deals
+func (p *Provider) offlineDealFilePaths(ctx context.Context) (map[string]struct{}, error) {
+	deals, err := p.dealsDB.ListOfflineImported(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing offline deals with imported data: %w", err)
+	}
+
+	paths := make(map[string]struct{}, len(deals))
+	for _, d := range deals {
+		paths[filepath.Clean(d.InboundFilePath)] = struct{}{}
+	}
+	return paths, nil
+}
@@ -0,0 +1,399 @@
+package storagemarket
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// The commP of a file has been calculated (auto-match only)
+	OfflineImportEventCommP = "commp"
+	// A file has been imported as the data for an offline deal
+	OfflineImportEventImport = "import"
+)
+
+// OfflineImportEntry matches a file with the offline deal that it contains
+// the data for. If DealUUID is not set, the file is matched with an offline
+// deal for PieceCID that is waiting for data.
+type OfflineImportEntry struct {
+	DealUUID uuid.UUID
+	PieceCID cid.Cid
+	FilePath string
+}
+
+// ReadOfflineImportManifest reads the entries from a CSV or JSON (.json)
+// manifest file. Relative file paths are resolved relative to the directory
+// that contains the manifest.
+func ReadOfflineImportManifest(manifestPath string) ([]OfflineImportEntry, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening manifest: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	var entries []OfflineImportEntry
+	if strings.EqualFold(filepath.Ext(manifestPath), ".json") {
+		entries, err = readJSONOfflineImportManifest(f)
+	} else {
+		entries, err = readCSVOfflineImportManifest(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("manifest %s has no entries", manifestPath)
+	}
+
+	dir := filepath.Dir(manifestPath)
+	for i, e := range entries {
+		if e.FilePath == "" {
+			return nil, fmt.Errorf("manifest entry %d has no file path", i+1)
+		}
+		if e.DealUUID == uuid.Nil && !e.PieceCID.Defined() {
+			return nil, fmt.Errorf("manifest entry %d for %s has no deal uuid or piece CID", i+1, e.FilePath)
+		}
+		if !filepath.IsAbs(e.FilePath) {
+			entries[i].FilePath = filepath.Join(dir, e.FilePath)
+		}
+	}
+
+	return entries, nil
+}
+
+func readJSONOfflineImportManifest(r io.Reader) ([]OfflineImportEntry, error) {
+	var rows []struct {
+		DealUUID string
+		PieceCID string
+		FilePath string
+	}
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("parsing JSON manifest: %w", err)
+	}
+
+	entries := make([]OfflineImportEntry, 0, len(rows))
+	for i, row := range rows {
+		entry := OfflineImportEntry{FilePath: row.FilePath}
+		if row.DealUUID != "" {
+			dealUuid, err := uuid.Parse(row.DealUUID)
+			if err != nil {
+				return nil, fmt.Errorf("manifest entry %d: parsing deal uuid '%s': %w", i+1, row.DealUUID, err)
+			}
+			entry.DealUUID = dealUuid
+		}
+		if row.PieceCID != "" {
+			pieceCid, err := cid.Parse(row.PieceCID)
+			if err != nil {
+				return nil, fmt.Errorf("manifest entry %d: parsing piece CID '%s': %w", i+1, row.PieceCID, err)
+			}
+			entry.PieceCID = pieceCid
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readCSVOfflineImportManifest reads a CSV manifest with one row per file:
+// the deal uuid or piece CID, followed by the file path
+func readCSVOfflineImportManifest(r io.Reader) ([]OfflineImportEntry, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = 2
+	rd.TrimLeadingSpace = true
+	rd.Comment = '#'
+
+	var entries []OfflineImportEntry
+	for row := 1; ; row++ {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing CSV manifest: %w", err)
+		}
+
+		entry := OfflineImportEntry{FilePath: rec[1]}
+		if dealUuid, err := uuid.Parse(rec[0]); err == nil {
+			entry.DealUUID = dealUuid
+		} else if pieceCid, err := cid.Parse(rec[0]); err == nil {
+			entry.PieceCID = pieceCid
+		} else if row == 1 {
+			// Skip the header row
+			continue
+		} else {
+			return nil, fmt.Errorf("manifest row %d: '%s' is not a deal uuid or a piece CID", row, rec[0])
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// OfflineImportEvent reports the progress of a bulk offline deal data import
+type OfflineImportEvent struct {
+	Event    string
+	FilePath string
+	DealUUID uuid.UUID
+	PieceCID cid.Cid
+	// The error, or the reason the deal was rejected. Empty on success.
+	Error string
+	// The number of files
+	Total int
+	// The number of files that have completed this step so far
+	Current int
+}
+
+// ImportOfflineDealsData imports the data for several offline deals, in the
+// order of the entries. Entries without a deal uuid are matched with the
+// oldest offline deal for the entry's piece CID that is waiting for data.
+// onEvent is called with the result of each import.
+func (p *Provider) ImportOfflineDealsData(ctx context.Context, entries []OfflineImportEntry, onEvent func(OfflineImportEvent)) error {
+	waiting, err := p.offlineDealsAwaitingData(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Deals that are explicitly matched by uuid can't be matched by piece CID
+	for _, e := range entries {
+		if e.DealUUID != uuid.Nil {
+			waiting.remove(e.DealUUID)
+		}
+	}
+
+	for i, e := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		evt := OfflineImportEvent{
+			Event:    OfflineImportEventImport,
+			FilePath: e.FilePath,
+			DealUUID: e.DealUUID,
+			PieceCID: e.PieceCID,
+			Total:    len(entries),
+			Current:  i + 1,
+		}
+		if evt.DealUUID == uuid.Nil {
+			deal := waiting.popByPieceCID(e.PieceCID)
+			if deal == nil {
+				evt.Error = fmt.Sprintf("no offline deal for piece %s is waiting for data", e.PieceCID)
+				onEvent(evt)
+				continue
+			}
+			evt.DealUUID = deal.DealUuid
+		}
+
+		evt.Error = p.importOfflineDealResult(evt.DealUUID, e.FilePath, cid.Undef)
+		onEvent(evt)
+	}
+
+	return nil
+}
+
+// AutoMatchOfflineDealsData calculates the commP of every file in dir, and
+// imports each file as the data for the oldest offline deal for that piece
+// that is waiting for data. Up to parallel files are read at the same time.
+// onEvent is called once the commP of each file has been calculated, and
+// with the result of each import.
+func (p *Provider) AutoMatchOfflineDealsData(ctx context.Context, dir string, parallel int, onEvent func(OfflineImportEvent)) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading directory %s: %w", dir, err)
+	}
+	var files []string
+	for _, e := range dirEntries {
+		if e.Type().IsRegular() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	waiting, err := p.offlineDealsAwaitingData(ctx)
+	if err != nil {
+		return err
+	}
+
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	type commpResult struct {
+		filePath  string
+		pieceCid  cid.Cid
+		pieceSize abi.PaddedPieceSize
+		err       error
+	}
+
+	// Calculate commP for each file in parallel
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	paths := make(chan string)
+	results := make(chan commpResult)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				res := commpResult{filePath: filePath}
+				cidAndSize, err := GenerateCommP(filePath)
+				if err != nil {
+					res.err = err
+				} else {
+					res.pieceCid = cidAndSize.PieceCID
+					res.pieceSize = cidAndSize.PieceSize
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(paths)
+		for _, filePath := range files {
+			select {
+			case paths <- filePath:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Match and import each file as soon as its commP has been calculated
+	hashed := 0
+	imported := 0
+	for res := range results {
+		hashed++
+		evt := OfflineImportEvent{
+			Event:    OfflineImportEventCommP,
+			FilePath: res.filePath,
+			PieceCID: res.pieceCid,
+			Total:    len(files),
+			Current:  hashed,
+		}
+		if res.err != nil {
+			evt.Error = fmt.Sprintf("calculating commP: %s", res.err)
+			onEvent(evt)
+			continue
+		}
+		onEvent(evt)
+
+		imported++
+		evt.Event = OfflineImportEventImport
+		evt.Current = imported
+		deal, pieceCid, err := waiting.popByCommP(res.pieceCid, res.pieceSize)
+		if err != nil {
+			return err
+		}
+		if deal == nil {
+			evt.Error = fmt.Sprintf("no offline deal for piece %s is waiting for data", res.pieceCid)
+			onEvent(evt)
+			continue
+		}
+
+		evt.DealUUID = deal.DealUuid
+		evt.PieceCID = deal.ClientDealProposal.Proposal.PieceCID
+		evt.Error = p.importOfflineDealResult(deal.DealUuid, res.filePath, pieceCid)
+		onEvent(evt)
+	}
+
+	return ctx.Err()
+}
+
+// importOfflineDealResult imports the file as the data for the offline deal,
+// and returns the error or the reason the deal was rejected, or the empty
+// string if the deal data was imported. pieceCid is the commP of the file
+// padded up to the deal size if it has already been calculated, or
+// cid.Undef.
+func (p *Provider) importOfflineDealResult(dealUuid uuid.UUID, filePath string, pieceCid cid.Cid) string {
+	ri, _, err := p.importOfflineDealFile(dealUuid, filePath, pieceCid)
+	if err != nil {
+		return err.Error()
+	}
+	if ri != nil && !ri.Accepted {
+		return fmt.Sprintf("deal rejected: %s", ri.Reason)
+	}
+	return ""
+}
+
+// offlineDeals is a list of offline deals, oldest first
+type offlineDeals []*types.ProviderDealState
+
+// offlineDealsAwaitingData lists the offline deals whose data has not yet
+// been imported
+func (p *Provider) offlineDealsAwaitingData(ctx context.Context) (*offlineDeals, error) {
+	active, err := p.dealsDB.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing active deals: %w", err)
+	}
+
+	var deals offlineDeals
+	for _, d := range active {
+		if d.IsOffline && d.InboundFilePath == "" && d.Checkpoint <= dealcheckpoints.Accepted {
+			deals = append(deals, d)
+		}
+	}
+	sort.Slice(deals, func(i, j int) bool {
+		return deals[i].CreatedAt.Before(deals[j].CreatedAt)
+	})
+	return &deals, nil
+}
+
+func (ds *offlineDeals) remove(dealUuid uuid.UUID) {
+	for i, d := range *ds {
+		if d.DealUuid == dealUuid {
+			*ds = append((*ds)[:i], (*ds)[i+1:]...)
+			return
+		}
+	}
+}
+
+// popByPieceCID removes and returns the oldest deal for the piece, or nil
+// if there is none
+func (ds *offlineDeals) popByPieceCID(pieceCid cid.Cid) *types.ProviderDealState {
+	for _, d := range *ds {
+		if d.ClientDealProposal.Proposal.PieceCID.Equals(pieceCid) {
+			ds.remove(d.DealUuid)
+			return d
+		}
+	}
+	return nil
+}
+
+// popByCommP removes and returns the oldest deal whose piece CID matches
+// the commP of a file once it's padded up to the deal size, and the padded
+// commP. It returns a nil deal if there is none.
+func (ds *offlineDeals) popByCommP(pieceCid cid.Cid, pieceSize abi.PaddedPieceSize) (*types.ProviderDealState, cid.Cid, error) {
+	for _, d := range *ds {
+		prop := d.ClientDealProposal.Proposal
+		if prop.PieceSize < pieceSize {
+			continue
+		}
+		padded, err := padPieceCommitment(pieceCid, pieceSize, prop.PieceSize)
+		if err != nil {
+			return nil, cid.Undef, fmt.Errorf("padding commP %s to deal size %d: %w", pieceCid, prop.PieceSize, err)
+		}
+		if padded.Equals(prop.PieceCID) {
+			ds.remove(d.DealUuid)
+			return d, padded, nil
+		}
+	}
+	return nil, cid.Undef, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	sealing "github.com/filecoin-project/lotus/extern/storage-sealing"
 	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -14,7 +15,10 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	miner5 "github.com/filecoin-project/specs-actors/v5/actors/builtin/miner"
 
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/events"
@@ -33,17 +37,52 @@ type diffPreCommitsAPI interface {
 	diffPreCommits(ctx context.Context, actor address.Address, pre, cur types.TipSetKey) (*miner.PreCommitChanges, error)
 }
 
+// preCommitsDiffer gets the sectors that were pre-committed by a miner
+// between two tipsets
+type preCommitsDiffer struct {
+	fullnodeApi v1api.FullNode
+}
+
+func (d *preCommitsDiffer) diffPreCommits(ctx context.Context, actor address.Address, pre, cur types.TipSetKey) (*miner.PreCommitChanges, error) {
+	store := adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(d.fullnodeApi)))
+
+	preAct, err := d.fullnodeApi.StateGetActor(ctx, actor, pre)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pre actor: %w", err)
+	}
+	curAct, err := d.fullnodeApi.StateGetActor(ctx, actor, cur)
+	if err != nil {
+		return nil, xerrors.Errorf("getting cur actor: %w", err)
+	}
+
+	preSt, err := miner.Load(store, preAct)
+	if err != nil {
+		return nil, xerrors.Errorf("loading miner actor: %w", err)
+	}
+	curSt, err := miner.Load(store, curAct)
+	if err != nil {
+		return nil, xerrors.Errorf("loading miner actor: %w", err)
+	}
+
+	diff, err := miner.DiffPreCommits(preSt, curSt)
+	if err != nil {
+		return nil, xerrors.Errorf("diff precommits: %w", err)
+	}
+
+	return diff, nil
+}
+
 type SectorCommittedManager struct {
 	ev       eventsCalledAPI
 	dealInfo dealInfoAPI
 	dpc      diffPreCommitsAPI
 }
 
-func NewSectorCommittedManager(ev eventsCalledAPI, tskAPI sealing.CurrentDealInfoTskAPI, dpcAPI diffPreCommitsAPI) *SectorCommittedManager {
+func NewSectorCommittedManager(ev eventsCalledAPI, fullnodeApi v1api.FullNode) *SectorCommittedManager {
 	dim := &sealing.CurrentDealInfoManager{
-		CDAPI: &sealing.CurrentDealInfoAPIAdapter{CurrentDealInfoTskAPI: tskAPI},
+		CDAPI: &sealing.CurrentDealInfoAPIAdapter{CurrentDealInfoTskAPI: fullnodeApi},
 	}
-	return newSectorCommittedManager(ev, dim, dpcAPI)
+	return newSectorCommittedManager(ev, dim, &preCommitsDiffer{fullnodeApi: fullnodeApi})
 }
 
 func newSectorCommittedManager(ev eventsCalledAPI, dealInfo dealInfoAPI, dpcAPI diffPreCommitsAPI) *SectorCommittedManager {
@@ -18,6 +18,7 @@ import (
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/storagemarket/streamcommp"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
@@ -31,11 +32,14 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/build"
 	ctypes "github.com/filecoin-project/lotus/chain/types"
 	sealing "github.com/filecoin-project/lotus/extern/storage-sealing"
 	"github.com/filecoin-project/lotus/markets/utils"
 	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-eventbus"
 	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -50,10 +54,38 @@ var (
 var (
 	addPieceRetryWait    = 5 * time.Minute
 	addPieceRetryTimeout = 6 * time.Hour
+
+	// the interval at which the provider checks the market actor state of
+	// active deals to see if they have expired or been slashed
+	dealStateCheckInterval = time.Duration(build.BlockDelaySecs) * time.Second
+	// the maximum number of active deals that have not reached their end
+	// epoch that are looked up in the market actor state per chain head
+	activeDealsCheckBatchSize = 100
+	// the time to wait before retrying after an error watching a deal on chain
+	onChainRetryWait = time.Minute
 )
 
 type Config struct {
 	MaxTransferDuration time.Duration
+	// The maximum number of deal data transfers that can run at the same
+	// time (0 means no limit)
+	MaxConcurrentTransfers uint64
+	// The maximum number of deal data transfers from a single client that
+	// can run at the same time (0 means no limit)
+	MaxConcurrentTransfersPerClient uint64
+	// Limits on the deals accepted from any single client address or peer
+	ClientQuotas ClientQuotas
+	// How long the inbound file of an online deal that failed after it was
+	// published is kept, so that the deal can be retried (0 means the file
+	// is removed when the deal fails)
+	RetryDataRetention time.Duration
+	// Garbage collection of orphaned files in the staging areas
+	StagingGC StagingGCConfig
+	// Automatic import of offline deal data from drop directories
+	OfflineDrop OfflineDropConfig
+	// Holds deals until the sealing pipeline has capacity to add them to a
+	// sector
+	AddPiece AddPieceSchedulerConfig
 }
 
 var log = logging.Logger("boost-provider")
@@ -88,15 +120,37 @@ type Provider struct {
 	logsSqlDB *sql.DB
 	logsDB    *db.LogsDB
 
-	Transport      transport.Transport
+	Transport transport.Transport
+	// transports for transfer types other than http
+	transports     map[string]transport.Transport
 	fundManager    *fundmanager.FundManager
 	storageManager *storagemanager.StorageManager
 	dealPublisher  types.DealPublisher
 	transfers      *dealTransfers
 
+	// limits the number of concurrent data transfers
+	transferLimiter *transferLimiter
+	// limits the deals accepted from each client
+	clientQuotas *clientQuotas
+	// holds deals until the sealing pipeline has capacity for them
+	addPieceScheduler *addPieceScheduler
+
+	stagingGCLk sync.Mutex
+	// the result of the most recent staging area garbage collection
+	lastStagingGC *storagemanager.GCReport
+
+	// watches the offline deal drop directories
+	offlineDrop *offlineDropWatcher
+	// signals the active deals watcher that a deal has become active
+	activeDealsChanged chan struct{}
+	// the index of the next active deal to check (only accessed by the
+	// active deals watcher)
+	activeDealsCheckNext int
+
 	pieceAdder                  types.PieceAdder
 	maxDealCollateralMultiplier uint64
 	chainDealManager            types.ChainDealManager
+	sectorCommittedManager      types.SectorCommittedManager
 
 	fullnodeApi v1api.FullNode
 
@@ -113,8 +167,8 @@ type Provider struct {
 	sigVerifier types.SignatureVerifier
 }
 
-func NewProvider(h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, fullnodeApi v1api.FullNode, dp types.DealPublisher, addr address.Address, pa types.PieceAdder,
-	sps sealingpipeline.API, cm types.ChainDealManager, df dtypes.StorageDealFilter, logsSqlDB *sql.DB, logsDB *db.LogsDB,
+func NewProvider(cfg Config, h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, fullnodeApi v1api.FullNode, dp types.DealPublisher, addr address.Address, pa types.PieceAdder,
+	sps sealingpipeline.API, cm types.ChainDealManager, scm types.SectorCommittedManager, df dtypes.StorageDealFilter, logsSqlDB *sql.DB, logsDB *db.LogsDB,
 	dagst stores.DAGStoreWrapper, ps piecestore.PieceStore, ip types.IndexProvider, askGetter types.AskGetter,
 	sigVerifier types.SignatureVerifier, httpOpts ...httptransport.Option) (*Provider, error) {
 
@@ -124,12 +178,21 @@ func NewProvider(h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundm
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	dl := logs.NewDealLogger(logsDB)
+	sealingStatus := func(ctx context.Context) (*sealingpipeline.Status, error) {
+		return sealingpipeline.GetStatus(ctx, fullnodeApi, sps)
+	}
+	headEpoch := func(ctx context.Context) (abi.ChainEpoch, error) {
+		head, err := fullnodeApi.ChainHead(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return head.Height(), nil
+	}
 
 	return &Provider{
-		ctx:    ctx,
-		cancel: cancel,
-		// TODO Make this configurable
-		config:    Config{MaxTransferDuration: 24 * 3600 * time.Second},
+		ctx:       ctx,
+		cancel:    cancel,
+		config:    cfg,
 		Address:   addr,
 		newDealPS: newDealPS,
 		db:        sqldb,
@@ -144,6 +207,7 @@ func NewProvider(h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundm
 		storageSpaceChan:  make(chan storageSpaceDealReq),
 
 		Transport:      httptransport.New(h, dl, httpOpts...),
+		transports:     make(map[string]transport.Transport),
 		fundManager:    fundMgr,
 		storageManager: storageMgr,
 
@@ -151,8 +215,14 @@ func NewProvider(h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundm
 		fullnodeApi:                 fullnodeApi,
 		pieceAdder:                  pa,
 		chainDealManager:            cm,
+		sectorCommittedManager:      scm,
 		maxDealCollateralMultiplier: 2,
 		transfers:                   newDealTransfers(),
+		transferLimiter:             newTransferLimiter(cfg.MaxConcurrentTransfers, cfg.MaxConcurrentTransfersPerClient),
+		clientQuotas:                newClientQuotas(cfg.ClientQuotas, dealsDB),
+		addPieceScheduler:           newAddPieceScheduler(cfg.AddPiece, sealingStatus, headEpoch),
+		offlineDrop:                 newOfflineDropWatcher(sqldb),
+		activeDealsChanged:          make(chan struct{}, 1),
 
 		dhs:        make(map[uuid.UUID]*dealHandler),
 		dealLogger: dl,
@@ -167,6 +237,21 @@ func NewProvider(h host.Host, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundm
 	}, nil
 }
 
+// AddTransport sets the transport used to transfer the data for deals with
+// the given transfer type. Deals with a transfer type that has no transport
+// use the http transport. AddTransport must be called before the provider
+// is started.
+func (p *Provider) AddTransport(transferType string, t transport.Transport) {
+	p.transports[transferType] = t
+}
+
+func (p *Provider) transportFor(transferType string) transport.Transport {
+	if t, ok := p.transports[transferType]; ok {
+		return t
+	}
+	return p.Transport
+}
+
 func (p *Provider) Deal(ctx context.Context, dealUuid uuid.UUID) (*types.ProviderDealState, error) {
 	deal, err := p.dealsDB.ByID(ctx, dealUuid)
 	if xerrors.Is(err, sql.ErrNoRows) {
@@ -186,32 +271,96 @@ func (p *Provider) GetAsk() *storagemarket.SignedStorageAsk {
 // ImportOfflineDealData is called when the Storage Provider imports data for
 // an offline deal (the deal must already have been proposed by the client)
 func (p *Provider) ImportOfflineDealData(dealUuid uuid.UUID, filePath string) (pi *api.ProviderDealRejectionInfo, handler *dealHandler, err error) {
+	return p.importOfflineDealFile(dealUuid, filePath, cid.Undef)
+}
+
+// importOfflineDealFile imports the file as the data for the offline deal.
+// If the commP of the file has already been calculated, pieceCid is the
+// commP padded up to the deal size, so that it isn't calculated again when
+// the deal is executed. Otherwise pieceCid is cid.Undef.
+func (p *Provider) importOfflineDealFile(dealUuid uuid.UUID, filePath string, pieceCid cid.Cid) (*api.ProviderDealRejectionInfo, *dealHandler, error) {
 	p.dealLogger.Infow(dealUuid, "import data for offline deal", "filepath", filePath)
 
+	if _, err := p.offlineDealForImport(dealUuid); err != nil {
+		return nil, nil, err
+	}
+
+	// the file was not written by boost, so any commP hasher state saved for
+	// the file path can't be trusted
+	streamcommp.RemoveCheckpoint(filePath)
+
+	return p.importOfflineDealData(dealUuid, filePath, pieceCid)
+}
+
+// ImportOfflineDealDataStream writes the data for an offline deal from the
+// stream to a new file at filePath, calculating commP as the data is
+// written so that the file doesn't need to be read again to verify it. It
+// then imports the file as the deal's data.
+func (p *Provider) ImportOfflineDealDataStream(dealUuid uuid.UUID, filePath string, data io.Reader) (pi *api.ProviderDealRejectionInfo, handler *dealHandler, err error) {
+	p.dealLogger.Infow(dealUuid, "import data stream for offline deal", "filepath", filePath)
+
+	if _, err := p.offlineDealForImport(dealUuid); err != nil {
+		return nil, nil, err
+	}
+
+	n, err := streamcommp.WriteFile(filePath, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write data for offline deal %s: %w", dealUuid, err)
+	}
+	p.dealLogger.Infow(dealUuid, "wrote data stream for offline deal", "filepath", filePath, "bytes", n)
+
+	return p.importOfflineDealData(dealUuid, filePath, cid.Undef)
+}
+
+// offlineDealForImport gets the offline deal and checks that its data has
+// not already been imported
+func (p *Provider) offlineDealForImport(dealUuid uuid.UUID) (*types.ProviderDealState, error) {
 	// db should already have a deal with this uuid as the deal proposal should have been agreed before hand
 	ds, err := p.dealsDB.ByID(p.ctx, dealUuid)
 	if err != nil {
 		if xerrors.Is(err, sql.ErrNoRows) {
-			return nil, nil, fmt.Errorf("no pre-existing deal proposal for offline deal %s: %w", dealUuid, err)
+			return nil, fmt.Errorf("no pre-existing deal proposal for offline deal %s: %w", dealUuid, err)
 		}
-		return nil, nil, fmt.Errorf("getting offline deal %s: %w", dealUuid, err)
+		return nil, fmt.Errorf("getting offline deal %s: %w", dealUuid, err)
 	}
 	if !ds.IsOffline {
-		return nil, nil, fmt.Errorf("deal %s is not an offline deal", dealUuid)
+		return nil, fmt.Errorf("deal %s is not an offline deal", dealUuid)
+	}
+	if ds.Checkpoint == dealcheckpoints.Complete && ds.Err != "" {
+		// eg because the deal was cancelled
+		return nil, fmt.Errorf("deal %s has failed: %s", dealUuid, ds.Err)
 	}
 	if ds.Checkpoint > dealcheckpoints.Accepted {
-		return nil, nil, fmt.Errorf("deal %s has already been imported and reached checkpoint %s", dealUuid, ds.Checkpoint)
+		return nil, fmt.Errorf("deal %s has already been imported and reached checkpoint %s", dealUuid, ds.Checkpoint)
 	}
+	return ds, nil
+}
 
-	ds.InboundFilePath = filePath
-
+func (p *Provider) importOfflineDealData(dealUuid uuid.UUID, filePath string, pieceCid cid.Cid) (*api.ProviderDealRejectionInfo, *dealHandler, error) {
 	// get the deal handler for the deal
 	dh := p.getDealHandler(dealUuid)
 	if dh == nil {
 		// deal handlers are created for each active deal on startup, so if
 		// there is no deal handler then the deal is not active
-		return nil, nil, fmt.Errorf("deal %s is no longer active (deal is at checkpoint %s)", dealUuid, ds.Checkpoint)
+		return nil, nil, fmt.Errorf("deal %s is no longer active", dealUuid)
+	}
+
+	// Hold the deal's import lock until the deal has been sent for
+	// execution, so that the deal can't be cancelled (or imported by
+	// another caller, eg the drop directory watcher) at the same time
+	dh.importMu.Lock()
+	defer dh.importMu.Unlock()
+
+	// Check the deal again now that no-one else can change it
+	ds, err := p.offlineDealForImport(dealUuid)
+	if err != nil {
+		return nil, nil, err
 	}
+	if dh.imported {
+		return nil, nil, fmt.Errorf("deal %s has already been imported", dealUuid)
+	}
+	ds.InboundFilePath = filePath
+	dh.importedPieceCid = pieceCid
 
 	// setup clean-up code
 	cleanup := func() {
@@ -239,6 +388,7 @@ func (p *Provider) ImportOfflineDealData(dealUuid uuid.UUID, filePath string) (p
 		return resp.ri, nil, nil
 	}
 
+	dh.imported = true
 	p.dealLogger.Infow(dealUuid, "offline deal data imported and deal scheduled for execution")
 	return resp.ri, dh, nil
 }
@@ -275,6 +425,20 @@ func (p *Provider) ExecuteDeal(dp *types.DealParams, clientPeer peer.ID) (*api.P
 // executeOnlineDeal sets up a download location for the deal, then sends the
 // deal to the main provider loop for execution
 func (p *Provider) executeDeal(ds smtypes.ProviderDealState) (*api.ProviderDealRejectionInfo, *dealHandler, error) {
+	// If the client pushes the deal data to the provider, issue a token that
+	// authorizes the client to upload the data for this deal
+	ua, isUpload := p.transportFor(ds.Transfer.Type).(transport.UploadAuthorizer)
+	isUpload = isUpload && !ds.IsOffline
+	var uploadToken string
+	if isUpload {
+		var err error
+		uploadToken, err = ua.AuthorizeUpload(p.ctx, ds.DealUuid, ds.Transfer.Size)
+		if err != nil {
+			p.dealLogger.LogError(ds.DealUuid, "failed to authorize deal data upload", err)
+			return nil, nil, fmt.Errorf("failed to authorize deal data upload: %w", err)
+		}
+	}
+
 	dh := p.mkAndInsertDealHandler(ds.DealUuid)
 	ri, err := func() (*api.ProviderDealRejectionInfo, error) {
 		// send the deal to the main provider loop for execution
@@ -301,9 +465,18 @@ func (p *Provider) executeDeal(ds smtypes.ProviderDealState) (*api.ProviderDealR
 		// clean up the deal handler
 		dh.close()
 		p.delDealHandler(ds.DealUuid)
+		if isUpload {
+			if rerr := ua.RevokeUpload(p.ctx, ds.DealUuid); rerr != nil {
+				p.dealLogger.LogError(ds.DealUuid, "failed to revoke deal data upload token", rerr)
+			}
+		}
 		return ri, nil, err
 	}
 
+	if isUpload {
+		ri.TransferToken = uploadToken
+	}
+
 	if ds.IsOffline {
 		p.dealLogger.Infow(ds.DealUuid, "offline deal accepted, waiting for data import")
 	} else {
@@ -314,11 +487,16 @@ func (p *Provider) executeDeal(ds smtypes.ProviderDealState) (*api.ProviderDealR
 }
 
 func (p *Provider) checkForDealAcceptance(ds *types.ProviderDealState, dh *dealHandler, isImport bool) (acceptDealResp, error) {
+	return p.sendAcceptDealReq(acceptDealReq{deal: ds, dh: dh, isImport: isImport})
+}
+
+func (p *Provider) sendAcceptDealReq(req acceptDealReq) (acceptDealResp, error) {
 	// send message to event loop to run the deal through the acceptance filter and reserve the required resources
 	// then wait for a response and return the response to the client.
 	respChan := make(chan acceptDealResp, 1)
+	req.rsp = respChan
 	select {
-	case p.acceptDealChan <- acceptDealReq{rsp: respChan, deal: ds, dh: dh, isImport: isImport}:
+	case p.acceptDealChan <- req:
 	case <-p.ctx.Done():
 		return acceptDealResp{}, p.ctx.Err()
 	}
@@ -358,6 +536,9 @@ func (p *Provider) Start() ([]*dealHandler, error) {
 
 	log.Infow("db initialized")
 
+	// pause new data transfers while the staging area disk space is low
+	p.storageManager.OnDiskSpaceLow(p.transferLimiter.setPaused)
+
 	// cleanup all completed deals in case Boost resumed before they were cleanedup
 	finished, err := p.dealsDB.ListCompleted(p.ctx)
 	if err != nil {
@@ -374,15 +555,23 @@ func (p *Provider) Start() ([]*dealHandler, error) {
 		return nil, fmt.Errorf("failed to list active deals: %w", err)
 	}
 
-	// cleanup all deals that have finished successfully
-	for i := range pds {
-		deal := pds[i]
-		// TODO Update this once we start listening for expired/slashed deals etc
-		if deal.Checkpoint >= dealcheckpoints.IndexedAndAnnounced {
-			// cleanup if cleanup didn't finish before we restarted
-			p.cleanupDealOnRestart(deal)
-		}
+	// record how much of the data for each deal is already in the staging
+	// area, so that the space still needed for the data isn't over-counted
+	for _, d := range pds {
+		p.setReceivedOnRestart(d)
+	}
+
+	// cleanup all deals that have been handed off to the sealer but are not
+	// yet active on chain (the provider resumes watching these deals on chain)
+	sealing, err := p.dealsDB.ListByCheckpoint(p.ctx, dealcheckpoints.IndexedAndAnnounced, dealcheckpoints.PreCommitted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sealing deals: %w", err)
+	}
+	for i := range sealing {
+		// cleanup if cleanup didn't finish before we restarted
+		p.cleanupDealOnRestart(sealing[i])
 	}
+	pds = append(pds, sealing...)
 
 	// resume all in-progress deals
 	var dhs []*dealHandler
@@ -406,6 +595,9 @@ func (p *Provider) Start() ([]*dealHandler, error) {
 			if d.Checkpoint >= dealcheckpoints.IndexedAndAnnounced {
 				si, err := p.sps.SectorsStatus(p.ctx, d.SectorID, false)
 				if err != nil || isFinalSealingState(si.State) {
+					// The sealer is done with the deal, so just go back to
+					// watching the deal on chain
+					p.resumeWatchDealOnChain(d, dh)
 					return
 				}
 			}
@@ -420,14 +612,67 @@ func (p *Provider) Start() ([]*dealHandler, error) {
 	go p.loop()
 	go p.transfers.start(p.ctx)
 
+	p.wg.Add(1)
+	go p.runActiveDealsWatcher()
+
+	if p.config.StagingGC.Interval > 0 {
+		p.wg.Add(1)
+		go p.runStagingGC()
+	}
+
+	if len(p.config.OfflineDrop.Dirs) > 0 {
+		p.wg.Add(1)
+		go p.runOfflineDropWatcher()
+	}
+
+	if p.config.AddPiece.enabled() {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.addPieceScheduler.run(p.ctx)
+		}()
+	}
+
 	log.Infow("storage provider: started")
 	return dhs, nil
 }
 
+// setReceivedOnRestart records the number of bytes of data that have been
+// downloaded to the staging area for an online deal that is being resumed
+func (p *Provider) setReceivedOnRestart(deal *types.ProviderDealState) {
+	if deal.IsOffline {
+		return
+	}
+
+	if deal.Checkpoint >= dealcheckpoints.Transferred {
+		p.storageManager.SetReceived(deal.DealUuid, deal.Transfer.Size)
+		return
+	}
+
+	// The transfer will resume from the end of the partially downloaded file
+	if deal.InboundFilePath == "" {
+		return
+	}
+	fi, err := os.Stat(deal.InboundFilePath)
+	if err != nil {
+		return
+	}
+	received := uint64(fi.Size())
+	if received > deal.Transfer.Size {
+		received = deal.Transfer.Size
+	}
+	p.storageManager.SetReceived(deal.DealUuid, received)
+}
+
 func (p *Provider) cleanupDealOnRestart(deal *types.ProviderDealState) {
-	// remove the temp file created for inbound deal data if it is not an offline deal
+	// remove the temp file created for inbound deal data if it is not an
+	// offline deal (and it's not needed to retry the deal)
 	if !deal.IsOffline {
-		_ = os.Remove(deal.InboundFilePath)
+		if !p.keepInboundFileForRetry(deal) {
+			_ = os.Remove(deal.InboundFilePath)
+		}
+		// the commP has already been verified for a deal that can be retried
+		streamcommp.RemoveCheckpoint(deal.InboundFilePath)
 	}
 
 	// untag storage space
@@ -504,6 +749,81 @@ func (p *Provider) CancelDealDataTransfer(dealUuid uuid.UUID) error {
 	return err
 }
 
+// CancelDeal cancels a deal that has not yet been published, including a
+// deal that is waiting in the deal publisher's pending batch. The deal is
+// failed with the reason it was cancelled, and the funds and storage space
+// tagged for the deal are released.
+// A deal whose publish message failed on chain is back at the Transferred
+// checkpoint while it is published again, but it cannot be cancelled.
+func (p *Provider) CancelDeal(ctx context.Context, dealUuid uuid.UUID, reason string) error {
+	pds, err := p.dealsDB.ByID(ctx, dealUuid)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("getting deal %s: %w", dealUuid, ErrDealNotFound)
+		}
+		return fmt.Errorf("getting deal %s: %w", dealUuid, err)
+	}
+	if pds.Checkpoint == dealcheckpoints.Complete {
+		return fmt.Errorf("deal %s has already completed", dealUuid)
+	}
+	if pds.Checkpoint >= dealcheckpoints.Published {
+		return fmt.Errorf("deal %s has already been published", dealUuid)
+	}
+
+	dh := p.getDealHandler(dealUuid)
+	if dh == nil {
+		return ErrDealHandlerNotFound
+	}
+
+	p.dealLogger.Infow(dealUuid, "user requested deal cancellation", "reason", reason, "checkpoint", pds.Checkpoint.String())
+
+	// If it's an offline deal that is waiting for its data to be imported,
+	// the deal is not executing, so just fail the deal and clean it up
+	if pds.IsOffline {
+		cancelled, err := p.cancelOfflineDealBeforeImport(ctx, dh, dealUuid, reason)
+		if err != nil || cancelled {
+			return err
+		}
+	}
+
+	if err := dh.cancelDeal(ctx, reason); err != nil {
+		p.dealLogger.Warnw(dealUuid, "error when user tried to cancel deal", "err", err)
+		return fmt.Errorf("cancelling deal %s: %w", dealUuid, err)
+	}
+	return nil
+}
+
+// cancelOfflineDealBeforeImport fails the offline deal if its data has not
+// yet been imported. It returns false if the data has been imported (so the
+// deal is executing and must be cancelled through its deal handler).
+func (p *Provider) cancelOfflineDealBeforeImport(ctx context.Context, dh *dealHandler, dealUuid uuid.UUID, reason string) (bool, error) {
+	// Hold the deal's import lock so that the data can't be imported while
+	// the deal is being cancelled
+	dh.importMu.Lock()
+	defer dh.importMu.Unlock()
+
+	// Check the deal again, in case it was imported before the lock was
+	// taken
+	pds, err := p.dealsDB.ByID(ctx, dealUuid)
+	if err != nil {
+		return false, fmt.Errorf("getting deal %s: %w", dealUuid, err)
+	}
+	if dh.imported || pds.InboundFilePath != "" {
+		return false, nil
+	}
+	if pds.Checkpoint == dealcheckpoints.Complete {
+		return false, fmt.Errorf("deal %s has already completed", dealUuid)
+	}
+
+	pub, err := dh.bus.Emitter(&types.ProviderDealState{}, eventbus.Stateful)
+	if err != nil {
+		p.dealLogger.Warnw(dealUuid, "failed to create event emitter", "err", err.Error())
+	}
+	p.failDeal(pub, pds, &dealCancelledError{reason: reason})
+	p.cleanupDealLogged(pds)
+	return true, nil
+}
+
 func (p *Provider) getDealHandler(id uuid.UUID) *dealHandler {
 	p.dhsMu.RLock()
 	defer p.dhsMu.RUnlock()
@@ -543,6 +863,11 @@ func (p *Provider) AddPieceToSector(ctx context.Context, deal smtypes.ProviderDe
 	sectorNum, offset, err := p.pieceAdder.AddPiece(ctx, pieceSize, pieceData, sdInfo)
 	curTime := build.Clock.Now()
 
+	// Once the sealer has responded the deal shows up in the sealing
+	// pipeline status (or the sealer is refusing deals), so let the
+	// scheduler release the next held deal
+	p.addPieceScheduler.complete(deal.DealUuid)
+
 	for build.Clock.Since(curTime) < addPieceRetryTimeout {
 		if !xerrors.Is(err, sealing.ErrTooManySectorsSealing) {
 			if err != nil {
@@ -1,6 +1,7 @@
 package storagemarket
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/filecoin-project/boost/storagemarket/types"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/google/uuid"
 	"github.com/libp2p/go-eventbus"
 	"golang.org/x/xerrors"
@@ -24,6 +26,7 @@ type acceptDealReq struct {
 	deal     *types.ProviderDealState
 	dh       *dealHandler
 	isImport bool
+	isRetry  bool
 }
 
 type acceptDealResp struct {
@@ -77,6 +80,23 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 		return aerr
 	}
 
+	// Check that the client is within its quotas
+	quotaReason, err := p.clientQuotas.check(p.ctx, deal)
+	if err != nil {
+		return &acceptError{
+			error:         fmt.Errorf("failed to check client quotas: %w", err),
+			reason:        "server error: check client quotas",
+			isSevereError: true,
+		}
+	}
+	if quotaReason != "" {
+		return &acceptError{
+			error:         fmt.Errorf("client quota exceeded: %s", quotaReason),
+			reason:        quotaReason,
+			isSevereError: false,
+		}
+	}
+
 	// get current sealing pipeline status
 	status, err := sealingpipeline.GetStatus(p.ctx, p.fullnodeApi, p.sps)
 	if err != nil {
@@ -95,9 +115,41 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 		Transfer:           deal.Transfer,
 	}
 
+	fundsState, err := p.getFundsState(p.ctx)
+	if err != nil {
+		return &acceptError{
+			error:         fmt.Errorf("failed to fetch funds state: %w", err),
+			reason:        "server error: get funds state",
+			isSevereError: true,
+		}
+	}
+
+	storageState, err := p.getStorageState(p.ctx)
+	if err != nil {
+		return &acceptError{
+			error:         fmt.Errorf("failed to fetch storage state: %w", err),
+			reason:        "server error: get storage state",
+			isSevereError: true,
+		}
+	}
+
+	activeDeals, err := p.dealsDB.CountActive(p.ctx)
+	if err != nil {
+		return &acceptError{
+			error:         fmt.Errorf("failed to count active deals: %w", err),
+			reason:        "server error: count active deals",
+			isSevereError: true,
+		}
+	}
+
 	accept, reason, err := p.df(p.ctx, types.DealFilterParams{
 		DealParams:           &params,
-		SealingPipelineState: status})
+		SealingPipelineState: status,
+		ClientPeerID:         deal.ClientPeerID,
+		FundsState:           fundsState,
+		StorageState:         storageState,
+		ActiveDeals:          activeDeals,
+	})
 
 	if err != nil {
 		return &acceptError{
@@ -175,7 +227,7 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 	}
 
 	// create a file in the staging area to which we will download the deal data
-	downloadFilePath, err := p.storageManager.DownloadFilePath(deal.DealUuid)
+	downloadFilePath, err := p.storageManager.DownloadFilePath(p.ctx, deal.DealUuid)
 	if err != nil {
 		cleanup()
 
@@ -287,6 +339,114 @@ func (p *Provider) processImportOfflineDealData(deal *types.ProviderDealState) *
 	return nil
 }
 
+// processDealRetry tags the funds and storage space that a failed deal still
+// needs, and resets the deal so that it's executed again from the checkpoint
+// it failed at
+func (p *Provider) processDealRetry(deal *types.ProviderDealState) *acceptError {
+	cleanup := func() {
+		collat, pub, errf := p.fundManager.UntagFunds(p.ctx, deal.DealUuid)
+		if errf != nil && !xerrors.Is(errf, db.ErrNotFound) {
+			p.dealLogger.LogError(deal.DealUuid, "failed to untag funds during deal cleanup", errf)
+		} else if errf == nil {
+			p.dealLogger.Infow(deal.DealUuid, "untagged funds for deal cleanup", "untagged publish", pub, "untagged collateral", collat)
+		}
+
+		errs := p.storageManager.Untag(p.ctx, deal.DealUuid)
+		if errs != nil && !xerrors.Is(errs, db.ErrNotFound) {
+			p.dealLogger.LogError(deal.DealUuid, "failed to untag storage during deal cleanup", errs)
+		} else if errs == nil {
+			p.dealLogger.Infow(deal.DealUuid, "untagged storage for deal cleanup", deal.Transfer.Size)
+		}
+	}
+
+	// Funds are untagged once the deal has been published, so they only need
+	// to be tagged if the deal failed before the publish message was sent
+	if deal.Checkpoint < dealcheckpoints.Published {
+		trsp, err := p.fundManager.TagFunds(p.ctx, deal.DealUuid, deal.ClientDealProposal.Proposal)
+		if err != nil {
+			cleanup()
+
+			err = fmt.Errorf("failed to tag funds for deal: %w", err)
+			aerr := &acceptError{
+				error:         err,
+				reason:        "server error: tag funds",
+				isSevereError: true,
+			}
+			if xerrors.Is(err, fundmanager.ErrInsufficientFunds) {
+				aerr.reason = "server error: provider has insufficient funds to accept deal"
+				aerr.isSevereError = false
+			}
+			return aerr
+		}
+		p.logFunds(deal.DealUuid, trsp)
+	}
+
+	// Storage space in the staging area is tagged for online deals until the
+	// deal data has been added to a sector
+	if !deal.IsOffline && deal.Checkpoint < dealcheckpoints.AddedPiece {
+		// If the deal data has already been downloaded (or partly
+		// downloaded), tag the space in the staging area that holds it
+		var err error
+		if _, statErr := os.Stat(deal.InboundFilePath); statErr == nil {
+			err = p.storageManager.TagFile(p.ctx, deal.DealUuid, deal.Transfer.Size, deal.InboundFilePath)
+		} else {
+			err = p.storageManager.Tag(p.ctx, deal.DealUuid, deal.Transfer.Size)
+		}
+		if err != nil {
+			cleanup()
+
+			err = fmt.Errorf("failed to tag storage for deal: %w", err)
+			aerr := &acceptError{
+				error:         err,
+				reason:        "server error: tag storage",
+				isSevereError: true,
+			}
+			if xerrors.Is(err, storagemanager.ErrNoSpaceLeft) {
+				aerr.reason = "server error: provider has no space left for storage deals"
+				aerr.isSevereError = false
+			}
+			return aerr
+		}
+		if deal.Checkpoint >= dealcheckpoints.Transferred {
+			// All the deal data has already been written to the staging area
+			p.storageManager.SetReceived(deal.DealUuid, deal.Transfer.Size)
+		}
+
+		// If the deal data is going to be downloaded again, make sure there
+		// is a file in the staging area to download it to
+		if _, err := os.Stat(deal.InboundFilePath); deal.Checkpoint == dealcheckpoints.Accepted && err != nil {
+			downloadFilePath, err := p.storageManager.DownloadFilePath(p.ctx, deal.DealUuid)
+			if err != nil {
+				cleanup()
+
+				return &acceptError{
+					error:         fmt.Errorf("failed to create download staging file for deal: %w", err),
+					reason:        "server error: creating download staging file",
+					isSevereError: true,
+				}
+			}
+			deal.InboundFilePath = downloadFilePath
+			p.dealLogger.Infow(deal.DealUuid, "created deal download staging file", "path", deal.InboundFilePath)
+		}
+	}
+
+	// Clear the error and write the deal state to the database
+	deal.Err = ""
+	deal.CheckpointAt = time.Now()
+	if err := p.dealsDB.Update(p.ctx, deal); err != nil {
+		cleanup()
+
+		return &acceptError{
+			error:         fmt.Errorf("failed to update deal in db: %w", err),
+			reason:        "server error: save to db",
+			isSevereError: true,
+		}
+	}
+
+	p.dealLogger.Infow(deal.DealUuid, "reset failed deal for retry", "checkpoint", deal.Checkpoint.String())
+	return nil
+}
+
 func (p *Provider) checkDealPropUnique(deal *smtypes.ProviderDealState) *acceptError {
 	signedPropCid, err := deal.SignedProposalCid()
 	if err != nil {
@@ -346,6 +506,63 @@ func (p *Provider) checkDealUuidUnique(deal *smtypes.ProviderDealState) *acceptE
 	}
 }
 
+// getFundsState gets a snapshot of the provider's funds for the deal filter
+func (p *Provider) getFundsState(ctx context.Context) (*types.FundsState, error) {
+	tagged, err := p.fundManager.TotalTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting total tagged: %w", err)
+	}
+
+	balMkt, err := p.fundManager.BalanceMarket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting market balance: %w", err)
+	}
+
+	balPubMsg, err := p.fundManager.BalancePublishMsg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting publish message balance: %w", err)
+	}
+
+	return &types.FundsState{
+		EscrowAvailable:     balMkt.Available,
+		EscrowLocked:        balMkt.Locked,
+		CollateralTagged:    tagged.Collateral,
+		CollateralAvailable: big.Sub(balMkt.Available, tagged.Collateral),
+		PublishMsgBalance:   balPubMsg,
+		PublishMsgTagged:    tagged.PubMsg,
+		PublishMsgAvailable: big.Sub(balPubMsg, tagged.PubMsg),
+	}, nil
+}
+
+// getStorageState gets a snapshot of the staging area for the deal filter
+func (p *Provider) getStorageState(ctx context.Context) (*types.StorageState, error) {
+	tagged, err := p.storageManager.TotalTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting total tagged: %w", err)
+	}
+	usage, err := p.storageManager.StagingAreas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting staging areas: %w", err)
+	}
+
+	st := &types.StorageState{
+		MaxStagingDealsBytes: p.storageManager.MaxStagingDealsBytes(),
+		Tagged:               tagged,
+		Free:                 p.storageManager.FreeSpace(tagged, usage),
+		StagingAreas:         make([]types.StagingAreaState, 0, len(usage)),
+	}
+	for _, u := range usage {
+		st.StagingAreas = append(st.StagingAreas, types.StagingAreaState{
+			Path:          u.Path,
+			MaxBytes:      u.MaxBytes,
+			Tagged:        u.Tagged,
+			Free:          u.Free(),
+			DiskAvailable: u.DiskAvailable,
+		})
+	}
+	return st, nil
+}
+
 // The provider loop effectively implements a lock over resources used by
 // the provider, like funds and storage space, so that only one deal at a
 // time can change the value of these resources.
@@ -362,12 +579,17 @@ func (p *Provider) loop() {
 		// - accept an offline deal proposal and save it for execution later
 		//   when the data is imported
 		// - accept a request to import data for an offline deal
+		// - accept a request to retry a failed deal
 		case dealReq := <-p.acceptDealChan:
 			deal := dealReq.deal
 			p.dealLogger.Infow(deal.DealUuid, "processing deal acceptance request")
 
 			var aerr *acceptError
-			if deal.IsOffline {
+			if dealReq.isRetry {
+				// The Storage Provider is retrying a failed deal, so tag the
+				// resources that the deal still needs and execute it
+				aerr = p.processDealRetry(dealReq.deal)
+			} else if deal.IsOffline {
 				// It's an offline deal
 				if dealReq.isImport {
 					// The Storage Provider is importing the deal data, so tag
@@ -430,9 +652,9 @@ func (p *Provider) loop() {
 		case publishedDeal := <-p.publishedDealChan:
 			deal := publishedDeal.deal
 			collat, pub, errf := p.fundManager.UntagFunds(p.ctx, deal.DealUuid)
-			if errf != nil {
+			if errf != nil && !xerrors.Is(errf, db.ErrNotFound) {
 				p.dealLogger.LogError(deal.DealUuid, "failed to untag funds", errf)
-			} else {
+			} else if errf == nil {
 				p.dealLogger.Infow(deal.DealUuid, "untagged funds for deal after publish", "untagged publish", pub, "untagged collateral", collat)
 			}
 			publishedDeal.done <- struct{}{}
@@ -2,8 +2,15 @@ package storagemarket
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
@@ -56,6 +63,55 @@ func TestSimpleOfflineDealHappy(t *testing.T) {
 	require.NotEmpty(t, lgs)
 }
 
+func TestOfflineDealImportAfterCancel(t *testing.T) {
+	ctx := context.Background()
+
+	harness := NewHarness(t, ctx)
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	td := harness.newDealBuilder(t, 1, withOfflineDeal()).withAllMinerCallsBlocking().build()
+	pi, _, err := harness.Provider.ExecuteDeal(td.params, peer.ID(""))
+	require.NoError(t, err)
+	require.True(t, pi.Accepted)
+
+	// Cancel the deal while it's waiting for its data to be imported
+	require.NoError(t, harness.Provider.CancelDeal(ctx, td.params.DealUUID, "client asked to cancel"))
+
+	// The data for the cancelled deal should not be imported
+	_, _, err = harness.Provider.ImportOfflineDealData(td.params.DealUUID, td.carv2FilePath)
+	require.Error(t, err)
+	td.assertDealFailedNonRecoverable(t, ctx, "client asked to cancel")
+	dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	require.Empty(t, dbState.InboundFilePath)
+}
+
+func TestOfflineDealImportTwice(t *testing.T) {
+	ctx := context.Background()
+
+	harness := NewHarness(t, ctx)
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	td := harness.newDealBuilder(t, 1, withOfflineDeal()).withAllMinerCallsBlocking().build()
+	pi, _, err := harness.Provider.ExecuteDeal(td.params, peer.ID(""))
+	require.NoError(t, err)
+	require.True(t, pi.Accepted)
+
+	require.NoError(t, td.executeAndSubscribeImportOfflineDeal())
+	td.waitForAndAssert(t, ctx, dealcheckpoints.Accepted)
+
+	// The deal is executing, so its data should not be imported again
+	_, _, err = harness.Provider.ImportOfflineDealData(td.params.DealUUID, td.carv2FilePath)
+	require.Error(t, err)
+
+	// Cancelling the deal should cancel it through the deal handler
+	require.NoError(t, harness.Provider.CancelDeal(ctx, td.params.DealUUID, "client asked to cancel"))
+	require.NoError(t, td.waitForError(DealCancelled+": client asked to cancel"))
+	td.assertDealFailedNonRecoverable(t, ctx, "client asked to cancel")
+}
+
 func TestOfflineDealInsufficientProviderFunds(t *testing.T) {
 	ctx := context.Background()
 
@@ -82,3 +138,255 @@ func TestOfflineDealInsufficientProviderFunds(t *testing.T) {
 	require.False(t, pi.Accepted)
 	require.Contains(t, pi.Reason, "insufficient funds")
 }
+
+func TestBulkOfflineDealImport(t *testing.T) {
+	ctx := context.Background()
+
+	harness := NewHarness(t, ctx)
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// make several offline deal proposals
+	var tds []*testDeal
+	for i := 0; i < 3; i++ {
+		td := harness.newDealBuilder(t, i+1, withOfflineDeal()).withAllMinerCallsBlocking().build()
+		pi, _, err := harness.Provider.ExecuteDeal(td.params, peer.ID(""))
+		require.NoError(t, err)
+		require.True(t, pi.Accepted)
+		tds = append(tds, td)
+	}
+
+	collect := func(events *[]OfflineImportEvent) func(OfflineImportEvent) {
+		return func(evt OfflineImportEvent) {
+			*events = append(*events, evt)
+		}
+	}
+
+	// import the first deal by deal uuid and the second by piece CID
+	var events []OfflineImportEvent
+	err := harness.Provider.ImportOfflineDealsData(ctx, []OfflineImportEntry{{
+		DealUUID: tds[0].params.DealUUID,
+		FilePath: tds[0].carv2FilePath,
+	}, {
+		PieceCID: tds[1].params.ClientDealProposal.Proposal.PieceCID,
+		FilePath: tds[1].carv2FilePath,
+	}, {
+		// there is no longer a deal waiting for this piece
+		PieceCID: tds[0].params.ClientDealProposal.Proposal.PieceCID,
+		FilePath: tds[0].carv2FilePath,
+	}}, collect(&events))
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Empty(t, events[0].Error)
+	require.Equal(t, tds[0].params.DealUUID, events[0].DealUUID)
+	require.Empty(t, events[1].Error)
+	require.Equal(t, tds[1].params.DealUUID, events[1].DealUUID)
+	require.Contains(t, events[2].Error, "no offline deal")
+	require.Equal(t, uuid.Nil, events[2].DealUUID)
+	require.Equal(t, 3, events[2].Current)
+	require.Equal(t, 3, events[2].Total)
+
+	// auto-match the third deal with its file by commP
+	dir := t.TempDir()
+	bz, err := os.ReadFile(tds[2].carv2FilePath)
+	require.NoError(t, err)
+	carPath := filepath.Join(dir, "deal.car")
+	require.NoError(t, os.WriteFile(carPath, bz, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "not-a-car.txt"), []byte("hello"), 0644))
+
+	events = nil
+	err = harness.Provider.AutoMatchOfflineDealsData(ctx, dir, 2, collect(&events))
+	require.NoError(t, err)
+
+	var commps, imports []OfflineImportEvent
+	for _, evt := range events {
+		switch evt.Event {
+		case OfflineImportEventCommP:
+			commps = append(commps, evt)
+		case OfflineImportEventImport:
+			imports = append(imports, evt)
+		}
+	}
+	require.Len(t, commps, 2)
+	require.Len(t, imports, 1)
+	require.Empty(t, imports[0].Error)
+	require.Equal(t, carPath, imports[0].FilePath)
+	require.Equal(t, tds[2].params.DealUUID, imports[0].DealUUID)
+	require.Equal(t, tds[2].params.ClientDealProposal.Proposal.PieceCID, imports[0].PieceCID)
+
+	// all the deals should have their data imported
+	for _, td := range tds {
+		dealUuid := td.params.DealUUID
+		require.Eventually(t, func() bool {
+			deal, err := harness.Provider.dealsDB.ByID(ctx, dealUuid)
+			return err == nil && deal.InboundFilePath != ""
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// the commP of the auto-matched file should not be calculated again
+	// when the deal is executed
+	require.Eventually(t, func() bool {
+		lgs, err := harness.Provider.logsDB.Logs(ctx, tds[2].params.DealUUID)
+		if err != nil {
+			return false
+		}
+		for _, l := range lgs {
+			if strings.Contains(l.LogMsg, "commp was already matched") {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	lgs, err := harness.Provider.logsDB.Logs(ctx, tds[2].params.DealUUID)
+	require.NoError(t, err)
+	for _, l := range lgs {
+		require.NotContains(t, l.LogMsg, "checking commP")
+	}
+}
+
+func TestReadOfflineImportManifest(t *testing.T) {
+	dir := t.TempDir()
+	dealUuid := uuid.New()
+	pieceCid := testutil.GenerateCid()
+
+	csvPath := filepath.Join(dir, "manifest.csv")
+	csvData := "deal,file\n" + dealUuid.String() + ",data/a.car\n" + pieceCid.String() + ",/abs/b.car\n"
+	require.NoError(t, os.WriteFile(csvPath, []byte(csvData), 0644))
+
+	// relative file paths should be relative to the manifest directory
+	entries, err := ReadOfflineImportManifest(csvPath)
+	require.NoError(t, err)
+	require.Equal(t, []OfflineImportEntry{
+		{DealUUID: dealUuid, FilePath: filepath.Join(dir, "data", "a.car")},
+		{PieceCID: pieceCid, FilePath: "/abs/b.car"},
+	}, entries)
+
+	jsonPath := filepath.Join(dir, "manifest.json")
+	jsonData := `[{"DealUUID":"` + dealUuid.String() + `","FilePath":"a.car"},{"PieceCID":"` + pieceCid.String() + `","FilePath":"/abs/b.car"}]`
+	require.NoError(t, os.WriteFile(jsonPath, []byte(jsonData), 0644))
+
+	entries, err = ReadOfflineImportManifest(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, []OfflineImportEntry{
+		{DealUUID: dealUuid, FilePath: filepath.Join(dir, "a.car")},
+		{PieceCID: pieceCid, FilePath: "/abs/b.car"},
+	}, entries)
+
+	// every entry must have a deal uuid or piece CID
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"FilePath":"a.car"}]`), 0644))
+	_, err = ReadOfflineImportManifest(jsonPath)
+	require.Error(t, err)
+}
+
+func TestOfflineDropWatcher(t *testing.T) {
+	ctx := context.Background()
+
+	harness := NewHarness(t, ctx)
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// make an offline deal proposal
+	td := harness.newDealBuilder(t, 1, withOfflineDeal()).withAllMinerCallsBlocking().build()
+	pi, _, err := harness.Provider.ExecuteDeal(td.params, peer.ID(""))
+	require.NoError(t, err)
+	require.True(t, pi.Accepted)
+	dealUuid := td.params.DealUUID
+
+	// drop the deal data, a CAR file that doesn't match any deal, and a file
+	// that is not a CAR file into the drop directory
+	dir := t.TempDir()
+	bz, err := os.ReadFile(td.carv2FilePath)
+	require.NoError(t, err)
+	carPath := filepath.Join(dir, "deal.car")
+	require.NoError(t, os.WriteFile(carPath, bz, 0644))
+	randomFilepath, err := testutil.CreateRandomFile(t.TempDir(), 2, 1000)
+	require.NoError(t, err)
+	_, unmatchedPath, err := testutil.CreateDenseCARv2(dir, randomFilepath)
+	require.NoError(t, err)
+	notCarPath := filepath.Join(dir, "not-a-car.txt")
+	require.NoError(t, os.WriteFile(notCarPath, []byte("hello"), 0644))
+
+	// files should not be processed until they have finished writing
+	harness.Provider.config.OfflineDrop = OfflineDropConfig{Dirs: []string{dir}, StableDuration: time.Hour}
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Empty(t, harness.Provider.OfflineDropUnmatched())
+	deal, err := harness.Provider.dealsDB.ByID(ctx, dealUuid)
+	require.NoError(t, err)
+	require.Empty(t, deal.InboundFilePath)
+
+	// once the files have finished writing they should be queued to have
+	// their commP calculated
+	harness.Provider.config.OfflineDrop.StableDuration = 0
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Len(t, harness.Provider.offlineDrop.queue, 3)
+	require.Empty(t, harness.Provider.OfflineDropUnmatched())
+
+	// once the commP has been calculated, the deal data should be imported
+	// and the other files should be reported as unmatched
+	harness.Provider.calculateOfflineDropCommPs(ctx)
+	require.Empty(t, harness.Provider.offlineDrop.queue)
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Eventually(t, func() bool {
+		deal, err := harness.Provider.dealsDB.ByID(ctx, dealUuid)
+		return err == nil && deal.InboundFilePath == carPath
+	}, 5*time.Second, 10*time.Millisecond)
+
+	unmatched := harness.Provider.OfflineDropUnmatched()
+	require.Len(t, unmatched, 2)
+	byPath := make(map[string]OfflineDropFile)
+	for _, f := range unmatched {
+		byPath[f.Path] = f
+	}
+	require.True(t, byPath[unmatchedPath].PieceCID.Defined())
+	require.Contains(t, byPath[unmatchedPath].Error, "no offline deal")
+	require.False(t, byPath[notCarPath].PieceCID.Defined())
+	require.Contains(t, byPath[notCarPath].Error, "commP")
+
+	// the import should be recorded in the deal log
+	lgs, err := harness.Provider.logsDB.Logs(ctx, dealUuid)
+	require.NoError(t, err)
+	found := false
+	for _, l := range lgs {
+		if strings.Contains(l.LogMsg, "drop directory") {
+			found = true
+		}
+	}
+	require.True(t, found)
+
+	// the unmatched file should not be tried again until the retry delay
+	// has passed
+	unmatchedFile := harness.Provider.offlineDrop.files[unmatchedPath]
+	require.Equal(t, 1, unmatchedFile.attempts)
+	require.True(t, unmatchedFile.retryAt.After(time.Now()))
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Equal(t, 1, unmatchedFile.attempts)
+
+	// the retry delay should double after each failed attempt
+	unmatchedFile.retryAt = time.Time{}
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Equal(t, 2, unmatchedFile.attempts)
+	require.Equal(t, 2*offlineDropRetryMin, offlineDropRetryDelay(unmatchedFile.attempts))
+	require.Equal(t, offlineDropRetryMax, offlineDropRetryDelay(100))
+
+	// after a restart, the saved commP should be used instead of calculating
+	// it again, and the imported file should not be imported again, even
+	// once its deal is active on chain
+	deal, err = harness.Provider.dealsDB.ByID(ctx, dealUuid)
+	require.NoError(t, err)
+	deal.Checkpoint = dealcheckpoints.Active
+	require.NoError(t, harness.Provider.dealsDB.Update(ctx, deal))
+	harness.Provider.offlineDrop.files = make(map[string]*offlineDropFile)
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	require.Empty(t, harness.Provider.offlineDrop.queue)
+	unmatched = harness.Provider.OfflineDropUnmatched()
+	require.Len(t, unmatched, 2)
+	for _, f := range unmatched {
+		require.Equal(t, byPath[f.Path].PieceCID, f.PieceCID)
+	}
+
+	// when a file is removed its saved commP should be deleted
+	require.NoError(t, os.Remove(unmatchedPath))
+	require.NoError(t, harness.Provider.scanOfflineDropDirs(ctx))
+	_, err = harness.Provider.offlineDrop.db.Get(ctx, unmatchedPath)
+	require.ErrorIs(t, err, db.ErrNotFound)
+}
@@ -114,3 +114,36 @@ func TestDealCompletionOnProcessResumption(t *testing.T) {
 		})
 	}
 }
+
+func TestStagingAreaPendingOnProcessResumption(t *testing.T) {
+	ctx := context.Background()
+
+	harness := NewHarness(t, ctx)
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// transfer the deal data and block before publishing
+	td := harness.newDealBuilder(t, 1).withPublishBlocking().withNormalHttpServer().build()
+	require.NoError(t, td.executeAndSubscribe())
+	td.waitForAndAssert(t, ctx, dealcheckpoints.Transferred)
+
+	// the number of bytes received for a deal is only held in memory, so
+	// clear it to simulate the process restarting
+	harness.shutdownAndCreateNewProvider(t, ctx)
+	harness.Provider.storageManager.SetReceived(td.params.DealUUID, 0)
+	td = td.updateWithRestartedProvider(harness).withPublishBlocking().build()
+
+	_, err := harness.Provider.Start()
+	require.NoError(t, err)
+
+	// the deal data is already in the staging area, so no more space should
+	// be needed for the deal
+	areas, err := harness.Provider.storageManager.StagingAreas(ctx)
+	require.NoError(t, err)
+	var pending uint64
+	for _, a := range areas {
+		pending += a.Pending
+	}
+	require.Zero(t, pending)
+	harness.AssertStorageManagerState(t, ctx, td.params.Transfer.Size)
+}
@@ -56,6 +56,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -107,6 +108,194 @@ func TestSimpleDealHappy(t *testing.T) {
 	require.NotEmpty(t, lgs)
 }
 
+func TestDealWatchedOnChainUntilSlashed(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal whose sector gets pre-committed and proven on chain
+	td := harness.newDealBuilder(t, 1).withAllMinerCallsNonBlocking().withSectorCommitted().withNormalHttpServer().build()
+
+	// the deal is slashed as soon as it becomes active
+	harness.MockFullNode.EXPECT().StateMarketStorageDeal(gomock.Any(), td.stubOutput.DealID, gomock.Any()).Return(&lapi.MarketDeal{
+		Proposal: td.params.ClientDealProposal.Proposal,
+		State:    market.DealState{SectorStartEpoch: 1, LastUpdatedEpoch: 1, SlashEpoch: 2},
+	}, nil).AnyTimes()
+
+	// execute deal and wait for it to become active
+	require.NoError(t, td.executeAndSubscribe())
+	require.NoError(t, td.waitForCheckpoint(dealcheckpoints.Active))
+
+	// the active deals watcher should find that the deal has been slashed
+	require.Eventually(t, func() bool {
+		dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+		require.NoError(t, err)
+		return dbState.Checkpoint == dealcheckpoints.Slashed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	harness.AssertDealDBState(t, ctx, td.params, td.stubOutput.DealID, &td.stubOutput.FinalPublishCid, dealcheckpoints.Slashed,
+		td.stubOutput.SectorID, td.stubOutput.Offset, td.params.ClientDealProposal.Proposal.PieceSize.Unpadded().Padded(), "")
+	td.assertEventuallyDealCleanedup(t, ctx)
+
+	// the on-chain transitions should be recorded in the deal logs
+	lgs, err := harness.Provider.logsDB.Logs(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	var sawActive bool
+	for _, l := range lgs {
+		if strings.Contains(l.LogMsg, "deal is active on chain") {
+			sawActive = true
+		}
+	}
+	require.True(t, sawActive)
+}
+
+func TestCheckDealExpiredOrSlashed(t *testing.T) {
+	ctx := context.Background()
+	harness := NewHarness(t, ctx)
+
+	// The chain head is at epoch 0
+	head := &ctypes.TipSet{}
+	notFound := errors.New("deal 1 not found")
+	tcs := []struct {
+		name       string
+		endEpoch   abi.ChainEpoch
+		slashEpoch abi.ChainEpoch
+		err        error
+		expected   dealcheckpoints.Checkpoint
+		expectErr  bool
+	}{{
+		name:       "active",
+		endEpoch:   100,
+		slashEpoch: -1,
+		expected:   dealcheckpoints.Active,
+	}, {
+		name:       "slashed",
+		endEpoch:   100,
+		slashEpoch: 0,
+		expected:   dealcheckpoints.Slashed,
+	}, {
+		name:       "expired",
+		endEpoch:   0,
+		slashEpoch: -1,
+		expected:   dealcheckpoints.Expired,
+	}, {
+		name:     "removed from state after end epoch",
+		endEpoch: 0,
+		err:      notFound,
+		expected: dealcheckpoints.Expired,
+	}, {
+		// An active deal is only removed from state before its end epoch
+		// if it was slashed
+		name:     "removed from state before end epoch",
+		endEpoch: 100,
+		err:      notFound,
+		expected: dealcheckpoints.Slashed,
+	}, {
+		name:      "rpc error",
+		endEpoch:  0,
+		err:       errors.New("connection refused"),
+		expected:  dealcheckpoints.Active,
+		expectErr: true,
+	}}
+
+	for i, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			deal := &types.ProviderDealState{
+				DealUuid:    uuid.New(),
+				ChainDealID: abi.DealID(i),
+				Checkpoint:  dealcheckpoints.Active,
+			}
+			deal.ClientDealProposal.Proposal.EndEpoch = tc.endEpoch
+
+			var md *lapi.MarketDeal
+			if tc.err == nil {
+				md = &lapi.MarketDeal{State: market.DealState{SectorStartEpoch: 0, SlashEpoch: tc.slashEpoch}}
+			}
+			harness.MockFullNode.EXPECT().StateMarketStorageDeal(gomock.Any(), deal.ChainDealID, gomock.Any()).Return(md, tc.err)
+
+			ckpt, err := harness.Provider.checkDealExpiredOrSlashed(ctx, head, deal)
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expected, ckpt)
+		})
+	}
+}
+
+func TestCheckActiveDealsInBatches(t *testing.T) {
+	ctx := context.Background()
+	harness := NewHarness(t, ctx)
+
+	batchSize := activeDealsCheckBatchSize
+	activeDealsCheckBatchSize = 2
+	defer func() { activeDealsCheckBatchSize = batchSize }()
+
+	// Five deals are active on chain, the last of which has reached its
+	// end epoch
+	deals, err := db.GenerateDeals()
+	require.NoError(t, err)
+	require.Len(t, deals, 5)
+	for i := range deals {
+		deals[i].Checkpoint = dealcheckpoints.Active
+		deals[i].ChainDealID = abi.DealID(i + 1)
+		deals[i].ClientDealProposal.Proposal.EndEpoch = 100
+		if i == len(deals)-1 {
+			deals[i].ClientDealProposal.Proposal.EndEpoch = 0
+		}
+		require.NoError(t, harness.DealsDB.Insert(ctx, &deals[i]))
+	}
+
+	// The second deal has been removed from the market actor state because
+	// it was slashed
+	var lk sync.Mutex
+	checked := make(map[abi.DealID]int)
+	harness.MockFullNode.EXPECT().StateMarketStorageDeal(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, dealID abi.DealID, _ ctypes.TipSetKey) (*lapi.MarketDeal, error) {
+			lk.Lock()
+			defer lk.Unlock()
+			checked[dealID]++
+			if dealID == deals[1].ChainDealID {
+				return nil, fmt.Errorf("deal %d not found", dealID)
+			}
+			return &lapi.MarketDeal{State: market.DealState{SectorStartEpoch: 0, SlashEpoch: -1}}, nil
+		}).AnyTimes()
+
+	// Each check should look up the deal that has ended, and a batch of
+	// the other deals
+	head := &ctypes.TipSet{}
+	harness.Provider.checkActiveDeals(ctx, head)
+	total := 0
+	for _, n := range checked {
+		total += n
+	}
+	require.Equal(t, 3, total)
+	require.Equal(t, 1, checked[deals[4].ChainDealID])
+
+	// After enough checks for every deal to have had a turn, the ended deal
+	// should be expired and the slashed deal should be recorded as slashed
+	harness.Provider.checkActiveDeals(ctx, head)
+	harness.Provider.checkActiveDeals(ctx, head)
+	require.Equal(t, 1, checked[deals[4].ChainDealID])
+	for _, d := range deals[:4] {
+		require.NotZero(t, checked[d.ChainDealID])
+	}
+	expired, err := harness.DealsDB.ByID(ctx, deals[4].DealUuid)
+	require.NoError(t, err)
+	require.Equal(t, dealcheckpoints.Expired, expired.Checkpoint)
+	slashed, err := harness.DealsDB.ByID(ctx, deals[1].DealUuid)
+	require.NoError(t, err)
+	require.Equal(t, dealcheckpoints.Slashed, slashed.Checkpoint)
+	active, err := harness.DealsDB.ListByCheckpoint(ctx, dealcheckpoints.Active)
+	require.NoError(t, err)
+	require.Len(t, active, 3)
+}
+
 func TestMultipleDealsConcurrent(t *testing.T) {
 	nDeals := 10
 	ctx := context.Background()
@@ -225,6 +414,40 @@ func TestMultipleDealsConcurrentWithFundsAndStorage(t *testing.T) {
 	}
 }
 
+func TestDealTransfersQueuedOverLimit(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness so that only one transfer can run at a time
+	harness := NewHarness(t, ctx, withTransferLimits(1, 0))
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	td1 := harness.newDealBuilder(t, 1).withAllMinerCallsBlocking().withBlockingHttpServer().build()
+	td2 := harness.newDealBuilder(t, 2).withAllMinerCallsBlocking().withBlockingHttpServer().build()
+
+	// the first deal's transfer should start, and the second deal's
+	// transfer should be queued behind it
+	require.NoError(t, td1.executeAndSubscribe())
+	td1.waitForAndAssert(t, ctx, dealcheckpoints.Accepted)
+	require.NoError(t, td2.executeAndSubscribe())
+	require.Eventually(t, func() bool {
+		return harness.Provider.TransferQueuePosition(td2.params.DealUUID) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Equal(t, 0, harness.Provider.TransferQueuePosition(td1.params.DealUUID))
+
+	// once the first transfer completes the second transfer should start
+	td1.unblockTransfer()
+	td1.waitForAndAssert(t, ctx, dealcheckpoints.Transferred)
+	require.Eventually(t, func() bool {
+		return harness.Provider.TransferQueuePosition(td2.params.DealUUID) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Empty(t, harness.Provider.QueuedTransfers())
+
+	td2.unblockTransfer()
+	td2.waitForAndAssert(t, ctx, dealcheckpoints.Transferred)
+}
+
 func TestDealsRejectedForFunds(t *testing.T) {
 	ctx := context.Background()
 	// setup the provider test harness with configured publish fee per deal and a total wallet balance.
@@ -437,6 +660,248 @@ func TestDealFailuresHandlingNonRecoverableErrors(t *testing.T) {
 	harness.EventuallyAssertNoTagged(t, ctx)
 }
 
+func TestDealPublishedAgainAfterPublishMsgFailsOnChain(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal whose publish message fails on chain
+	pubErr := fmt.Errorf("WaitForPublishDeals exit code: 16: %w", ErrPublishMsgFailed)
+	td := harness.newDealBuilder(t, 1).withPublishNonBlocking().withPublishConfirmFailing(pubErr).
+		withAddPieceNonBlocking().withNormalHttpServer().build()
+
+	// the second time the deal is published, the message succeeds
+	td.tBuilder.ms.SetupPublish(false).SetupPublishConfirm(false)
+
+	require.NoError(t, td.executeAndSubscribe())
+	td.waitForAndAssert(t, ctx, dealcheckpoints.AddedPiece)
+}
+
+func TestDealFailsAfterPublishMsgFailsOnChainRepeatedly(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal whose publish message fails on chain every time it is
+	// published
+	pubErr := fmt.Errorf("WaitForPublishDeals exit code: 16: %w", ErrPublishMsgFailed)
+	td := harness.newDealBuilder(t, 1).withPublishNonBlocking().withPublishConfirmFailing(pubErr).
+		withNormalHttpServer().build()
+	for i := 1; i < publishMaxAttempts; i++ {
+		td.tBuilder.ms.SetupPublish(false).SetupPublishConfirmFailure(pubErr)
+	}
+
+	// the deal should fail once it has been published the maximum number
+	// of times
+	require.NoError(t, td.executeAndSubscribe())
+	require.NoError(t, td.waitForError(fmt.Sprintf("failed on chain after %d attempts", publishMaxAttempts)))
+	td.assertEventuallyDealCleanedup(t, ctx)
+	harness.EventuallyAssertNoTagged(t, ctx)
+}
+
+func TestDealRetryAfterAddPieceFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal that fails when adding the piece to a sector
+	addPieceErr := errors.New("add piece error")
+	td := harness.newDealBuilder(t, 1).withPublishNonBlocking().withPublishConfirmNonBlocking().
+		withAddPieceFailing(addPieceErr).withNormalHttpServer().build()
+	require.NoError(t, td.executeAndSubscribe())
+	require.NoError(t, td.waitForError(addPieceErr.Error()))
+	td.assertEventuallyDealCleanedup(t, ctx)
+	harness.EventuallyAssertNoTagged(t, ctx)
+
+	// the inbound file should be kept so that the deal can be retried
+	dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	require.Equal(t, dealcheckpoints.PublishConfirmed, dbState.ErrCheckpoint)
+	require.FileExists(t, dbState.InboundFilePath)
+
+	// retry the deal, this time with add piece succeeding
+	td = td.updateWithRestartedProvider(harness).withAddPieceBlocking().build()
+	require.NoError(t, harness.Provider.RetryDeal(td.params.DealUUID))
+	sub, err := harness.Provider.SubscribeDealUpdates(td.params.DealUUID)
+	require.NoError(t, err)
+	td.sub = sub
+
+	// the deal should not be retried twice
+	require.Error(t, harness.Provider.RetryDeal(td.params.DealUUID))
+
+	// storage space is tagged again until the piece has been added
+	harness.EventuallyAssertStorageFundState(t, ctx, td.params.Transfer.Size, abi.NewTokenAmount(0), abi.NewTokenAmount(0))
+	td.unblockAddPiece()
+	td.waitForAndAssert(t, ctx, dealcheckpoints.AddedPiece)
+	harness.EventuallyAssertNoTagged(t, ctx)
+}
+
+func TestRetryCheckpointGraphsyncDeal(t *testing.T) {
+	ctx := context.Background()
+	harness := NewHarness(t, ctx)
+	harness.Provider.config.RetryDataRetention = time.Hour
+
+	// The graphsync transport writes the deal data to a CARv2 file, so the
+	// size of the file is larger than the transfer size
+	randomFilepath, err := testutil.CreateRandomFile(t.TempDir(), 1, 2000)
+	require.NoError(t, err)
+	_, carPath, err := testutil.CreateDenseCARv2(t.TempDir(), randomFilepath)
+	require.NoError(t, err)
+	r, err := carv2.OpenReader(carPath)
+	require.NoError(t, err)
+	dataSize := r.Header.DataSize
+	require.NoError(t, r.Close())
+	st, err := os.Stat(carPath)
+	require.NoError(t, err)
+	require.NotEqual(t, dataSize, uint64(st.Size()))
+
+	for _, ckpt := range []dealcheckpoints.Checkpoint{dealcheckpoints.Transferred, dealcheckpoints.PublishConfirmed} {
+		t.Run(ckpt.String(), func(t *testing.T) {
+			deal := &types.ProviderDealState{
+				DealUuid:        uuid.New(),
+				InboundFilePath: carPath,
+				Transfer:        types.Transfer{Type: "graphsync", Size: dataSize},
+				Checkpoint:      dealcheckpoints.Complete,
+				CheckpointAt:    time.Now(),
+				ErrCheckpoint:   ckpt,
+				Err:             "deal failed",
+			}
+			deal.ClientDealProposal.Proposal.StartEpoch = 100
+
+			// The deal should be retried from the checkpoint it failed at,
+			// without downloading the data again
+			retryCkpt, err := harness.Provider.retryCheckpoint(deal)
+			require.NoError(t, err)
+			require.Equal(t, ckpt, retryCkpt)
+			require.FileExists(t, carPath)
+		})
+	}
+}
+
+func TestDealRetryAfterDataRetentionPeriod(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal that fails when adding the piece to a sector
+	addPieceErr := errors.New("add piece error")
+	td := harness.newDealBuilder(t, 1).withPublishNonBlocking().withPublishConfirmNonBlocking().
+		withAddPieceFailing(addPieceErr).withNormalHttpServer().build()
+	require.NoError(t, td.executeAndSubscribe())
+	require.NoError(t, td.waitForError(addPieceErr.Error()))
+	td.assertEventuallyDealCleanedup(t, ctx)
+
+	// the inbound file should be reported as retained by the staging area gc
+	dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	rep, err := harness.Provider.StagingGC(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, rep.Retained, 1)
+	require.Equal(t, dbState.InboundFilePath, rep.Retained[0].Path)
+
+	// once the retention period has passed the deal can no longer be
+	// retried, and the inbound file is removed by the staging area gc
+	harness.Provider.config.RetryDataRetention = time.Nanosecond
+	require.Error(t, harness.Provider.RetryDeal(td.params.DealUUID))
+	rep, err = harness.Provider.StagingGC(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, rep.Retained)
+	require.NoFileExists(t, dbState.InboundFilePath)
+}
+
+func TestStagingGCKeepsFilesForActiveDeals(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// start a deal with a transfer that blocks
+	td := harness.newDealBuilder(t, 1).withAllMinerCallsBlocking().withBlockingHttpServer().build()
+	require.NoError(t, td.executeAndSubscribe())
+	td.waitForAndAssert(t, ctx, dealcheckpoints.Accepted)
+
+	// write the files that a parallel download keeps next to the deal data
+	dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	derived := []string{
+		httptransport.RangesFilePath(dbState.InboundFilePath),
+		httptransport.RangesFilePath(dbState.InboundFilePath) + ".tmp",
+	}
+	for _, path := range derived {
+		require.NoError(t, ioutil.WriteFile(path, []byte("{}"), 0644))
+	}
+
+	// an unrelated file in the staging area should be deleted
+	orphan := filepath.Join(filepath.Dir(dbState.InboundFilePath), "orphan.download")
+	require.NoError(t, ioutil.WriteFile(orphan, []byte("orphan"), 0644))
+
+	// the staging area gc should not delete the files of the active deal
+	rep, err := harness.Provider.StagingGC(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, rep.Orphaned, 1)
+	require.Equal(t, orphan, rep.Orphaned[0].Path)
+	require.NoFileExists(t, orphan)
+	require.FileExists(t, dbState.InboundFilePath)
+	for _, path := range derived {
+		require.FileExists(t, path)
+	}
+
+	td.unblockTransfer()
+	td.waitForAndAssert(t, ctx, dealcheckpoints.Transferred)
+}
+
+func TestCancelDealWaitingToBePublished(t *testing.T) {
+	ctx := context.Background()
+
+	// setup the provider test harness
+	harness := NewHarness(t, ctx)
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	// build a deal that waits in the deal publisher
+	td := harness.newDealBuilder(t, 1).withPublishBlocking().withNormalHttpServer().build()
+	require.NoError(t, td.executeAndSubscribe())
+	require.NoError(t, td.waitForCheckpoint(dealcheckpoints.Transferred))
+	harness.AssertStorageAndFundManagerState(t, ctx, td.params.Transfer.Size, harness.MinPublishFees, td.params.ClientDealProposal.Proposal.ProviderCollateral)
+
+	// cancel the deal
+	require.NoError(t, harness.Provider.CancelDeal(ctx, td.params.DealUUID, "client asked to cancel"))
+	require.NoError(t, td.waitForError(DealCancelled+": client asked to cancel"))
+
+	// the deal should be failed with the reason it was cancelled, and the
+	// inbound file, funds and storage space released
+	td.assertDealFailedNonRecoverable(t, ctx, "client asked to cancel")
+	td.assertEventuallyDealCleanedup(t, ctx)
+	harness.EventuallyAssertNoTagged(t, ctx)
+	dbState, err := harness.DealsDB.ByID(ctx, td.params.DealUUID)
+	require.NoError(t, err)
+	require.Equal(t, dealcheckpoints.Transferred, dbState.ErrCheckpoint)
+	require.NoFileExists(t, dbState.InboundFilePath)
+
+	// the deal can't be cancelled again
+	require.Error(t, harness.Provider.CancelDeal(ctx, td.params.DealUUID, "client asked to cancel"))
+}
+
 func TestDealAskValidation(t *testing.T) {
 	ctx := context.Background()
 
@@ -828,19 +1293,28 @@ func (h *ProviderHarness) AssertSealedContents(t *testing.T, carV2FilePath strin
 }
 
 func (h *ProviderHarness) AssertEventuallyDealCleanedup(t *testing.T, ctx context.Context, dp *types.DealParams) {
-	dbState, err := h.DealsDB.ByID(ctx, dp.DealUUID)
-	require.NoError(t, err)
 	// assert that the deal has been cleanedup and there are no leaks
 	require.Eventually(t, func() bool {
-		// deal handler should be deleted
+		dbState, err := h.DealsDB.ByID(ctx, dp.DealUUID)
+		if err != nil {
+			return false
+		}
+
+		// deal handler should be deleted, unless the deal has been handed
+		// off to the sealer and the provider is still watching it on chain
+		watchingOnChain := dbState.Checkpoint >= dealcheckpoints.IndexedAndAnnounced && dbState.Checkpoint < dealcheckpoints.Active
 		dh := h.Provider.getDealHandler(dbState.DealUuid)
-		if dh != nil {
+		if dh != nil && !watchingOnChain {
 			return false
 		}
 
-		// the deal inbound file should no longer exist if it is an online deal
+		// the deal inbound file should no longer exist if it is an online
+		// deal, unless it's being kept so that the deal can be retried
 		if !dp.IsOffline {
 			_, statErr := os.Stat(dbState.InboundFilePath)
+			if h.Provider.keepInboundFileForRetry(dbState) {
+				return statErr == nil
+			}
 			return statErr != nil
 		}
 		return true
@@ -907,6 +1381,9 @@ type providerConfig struct {
 	disconnectAfterEvery int64
 	httpOpts             []httptransport.Option
 
+	maxConcurrentTransfers          uint64
+	maxConcurrentTransfersPerClient uint64
+
 	lockedFunds      big.Int
 	escrowFunds      big.Int
 	publishWalletBal int64
@@ -934,6 +1411,15 @@ func withHttpDisconnectServerAfter(afterEvery int64) harnessOpt {
 	}
 }
 
+// withTransferLimits configures the maximum number of concurrent transfers,
+// in total and per client
+func withTransferLimits(maxConcurrent, maxConcurrentPerClient uint64) harnessOpt {
+	return func(pc *providerConfig) {
+		pc.maxConcurrentTransfers = maxConcurrent
+		pc.maxConcurrentTransfersPerClient = maxConcurrentPerClient
+	}
+}
+
 func withMinPublishFees(fee abi.TokenAmount) harnessOpt {
 	return func(pc *providerConfig) {
 		pc.minPublishFees = fee
@@ -1062,10 +1548,10 @@ func NewHarness(t *testing.T, ctx context.Context, opts ...harnessOpt) *Provider
 
 	// fund manager
 	fminitF := fundmanager.New(fundmanager.Config{
-		PubMsgBalMin: ph.MinPublishFees,
-		PubMsgWallet: pw,
+		PubMsgBalMin:  ph.MinPublishFees,
+		PubMsgWallets: []address.Address{pw},
 	})
-	fm := fminitF(fn, fundsDB)
+	fm := fminitF(fxtest.NewLifecycle(t), fn, fundsDB)
 
 	// storage manager
 	fsRepo, err := repo.NewFS(dir)
@@ -1075,7 +1561,7 @@ func NewHarness(t *testing.T, ctx context.Context, opts ...harnessOpt) *Provider
 	smInitF := storagemanager.New(storagemanager.Config{
 		MaxStagingDealsBytes: ph.MaxStagingDealBytes,
 	})
-	sm, err := smInitF(lr, sqldb)
+	sm, err := smInitF(fxtest.NewLifecycle(t), lr, sqldb)
 	require.NoError(t, err)
 
 	// no-op deal filter, as we are mostly testing the Provider and provider_loop here
@@ -1090,7 +1576,13 @@ func NewHarness(t *testing.T, ctx context.Context, opts ...harnessOpt) *Provider
 	askStore := &mockAskStore{}
 	askStore.SetAsk(pc.price, pc.verifiedPrice, pc.minPieceSize, pc.maxPieceSize)
 
-	prov, err := NewProvider(h, sqldb, dealsDB, fm, sm, fn, minerStub, minerAddr, minerStub, sps, minerStub, df, sqldb,
+	provCfg := Config{
+		MaxTransferDuration:             24 * time.Hour,
+		RetryDataRetention:              24 * time.Hour,
+		MaxConcurrentTransfers:          pc.maxConcurrentTransfers,
+		MaxConcurrentTransfersPerClient: pc.maxConcurrentTransfersPerClient,
+	}
+	prov, err := NewProvider(provCfg, h, sqldb, dealsDB, fm, sm, fn, minerStub, minerAddr, minerStub, sps, minerStub, minerStub, df, sqldb,
 		db.NewLogsDB(sqldb), dagStore, ps, &NoOpIndexProvider{}, askStore, &mockSignatureVerifier{true, nil}, pc.httpOpts...)
 	require.NoError(t, err)
 	ph.Provider = prov
@@ -1107,6 +1599,8 @@ func NewHarness(t *testing.T, ctx context.Context, opts ...harnessOpt) *Provider
 	}, nil).AnyTimes()
 
 	fn.EXPECT().WalletBalance(gomock.Any(), ph.PublishWallet).Return(abi.NewTokenAmount(pc.publishWalletBal), nil).AnyTimes()
+	fn.EXPECT().StateGetActor(gomock.Any(), ph.PublishWallet, gomock.Any()).Return(&ctypes.Actor{Balance: abi.NewTokenAmount(pc.publishWalletBal)}, nil).AnyTimes()
+	fn.EXPECT().MpoolGetNonce(gomock.Any(), ph.PublishWallet).Return(uint64(0), nil).AnyTimes()
 
 	ph.MockSealingPipelineAPI.EXPECT().WorkerJobs(gomock.Any()).Return(map[uuid.UUID][]storiface.WorkerJob{}, nil).AnyTimes()
 
@@ -1142,9 +1636,9 @@ func (h *ProviderHarness) shutdownAndCreateNewProvider(t *testing.T, ctx context
 	}
 
 	// construct a new provider with pre-existing state
-	prov, err := NewProvider(h.Host, h.Provider.db, h.Provider.dealsDB, h.Provider.fundManager,
+	prov, err := NewProvider(h.Provider.config, h.Host, h.Provider.db, h.Provider.dealsDB, h.Provider.fundManager,
 		h.Provider.storageManager, h.Provider.fullnodeApi, h.MinerStub, h.MinerAddr, h.MinerStub, h.MockSealingPipelineAPI, h.MinerStub,
-		df, h.Provider.logsSqlDB, h.Provider.logsDB, h.Provider.dagst, h.Provider.ps, &NoOpIndexProvider{}, h.Provider.askGetter, h.Provider.sigVerifier, pc.httpOpts...)
+		h.MinerStub, df, h.Provider.logsSqlDB, h.Provider.logsDB, h.Provider.dagst, h.Provider.ps, &NoOpIndexProvider{}, h.Provider.askGetter, h.Provider.sigVerifier, pc.httpOpts...)
 
 	require.NoError(t, err)
 	h.Provider = prov
@@ -1366,6 +1860,8 @@ type testDealBuilder struct {
 	msPublish        *minerStubCall
 	msPublishConfirm *minerStubCall
 	msAddPiece       *minerStubCall
+
+	msSectorCommitted bool
 }
 
 func (tbuilder *testDealBuilder) withPublishFailing(err error) *testDealBuilder {
@@ -1428,6 +1924,11 @@ func (tbuilder *testDealBuilder) withAllMinerCallsBlocking() *testDealBuilder {
 	return tbuilder
 }
 
+func (tbuilder *testDealBuilder) withSectorCommitted() *testDealBuilder {
+	tbuilder.msSectorCommitted = true
+	return tbuilder
+}
+
 func (tbuilder *testDealBuilder) withFailingHttpServer() *testDealBuilder {
 	tbuilder.setTransferParams(tbuilder.td.ph.FailingServer.URL)
 	return tbuilder
@@ -1470,6 +1971,7 @@ func (tbuilder *testDealBuilder) build() *testDeal {
 	} else {
 		tbuilder.buildPublish().buildPublishConfirm().buildAddPiece()
 	}
+	tbuilder.buildSectorCommitted()
 
 	testDeal := tbuilder.td
 
@@ -1513,6 +2015,16 @@ func (tbuilder *testDealBuilder) buildAddPiece() *testDealBuilder {
 	return tbuilder
 }
 
+func (tbuilder *testDealBuilder) buildSectorCommitted() *testDealBuilder {
+	if tbuilder.msSectorCommitted {
+		tbuilder.ms.SetupSectorCommitted()
+	} else {
+		tbuilder.ms.SetupAwaitPreCommit()
+	}
+
+	return tbuilder
+}
+
 type testDeal struct {
 	ph            *ProviderHarness
 	params        *types.DealParams
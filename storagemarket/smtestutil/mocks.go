@@ -9,6 +9,7 @@ import (
 	lapi "github.com/filecoin-project/lotus/api"
 
 	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api"
@@ -24,6 +25,7 @@ type MinerStub struct {
 	*mock_types.MockDealPublisher
 	*mock_types.MockChainDealManager
 	*mock_types.MockPieceAdder
+	*mock_types.MockSectorCommittedManager
 
 	lk                    sync.Mutex
 	unblockPublish        map[uuid.UUID]chan struct{}
@@ -37,6 +39,8 @@ func NewMinerStub(ctrl *gomock.Controller) *MinerStub {
 		MockChainDealManager: mock_types.NewMockChainDealManager(ctrl),
 		MockPieceAdder:       mock_types.NewMockPieceAdder(ctrl),
 
+		MockSectorCommittedManager: mock_types.NewMockSectorCommittedManager(ctrl),
+
 		unblockPublish:        make(map[uuid.UUID]chan struct{}),
 		unblockWaitForPublish: make(map[uuid.UUID]chan struct{}),
 		unblockAddPiece:       make(map[uuid.UUID]chan struct{}),
@@ -255,6 +259,30 @@ func (mb *MinerStubBuilder) SetupAddPieceFailure(err error) {
 	})
 }
 
+// SetupAwaitPreCommit sets up the stub so that the sector containing the
+// deal is never pre-committed (ie the deal stays in the IndexedAndAnnounced state)
+func (mb *MinerStubBuilder) SetupAwaitPreCommit() *MinerStubBuilder {
+	mb.stub.MockSectorCommittedManager.EXPECT().OnDealSectorPreCommitted(gomock.Any(), gomock.Any(), gomock.Eq(mb.dp.ClientDealProposal.Proposal), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	return mb
+}
+
+// SetupSectorCommitted sets up the stub so that the sector containing the
+// deal is pre-committed and then proven
+func (mb *MinerStubBuilder) SetupSectorCommitted() *MinerStubBuilder {
+	mb.stub.MockSectorCommittedManager.EXPECT().OnDealSectorPreCommitted(gomock.Any(), gomock.Any(), gomock.Eq(mb.dp.ClientDealProposal.Proposal), gomock.Eq(mb.finalPublishCid), gomock.Any()).DoAndReturn(func(_ context.Context, _ address.Address, _ market2.DealProposal, _ cid.Cid, cb storagemarket.DealSectorPreCommittedCallback) error {
+		cb(mb.sectorId, false, nil)
+		return nil
+	})
+
+	mb.stub.MockSectorCommittedManager.EXPECT().OnDealSectorCommitted(gomock.Any(), gomock.Any(), gomock.Eq(mb.sectorId), gomock.Eq(mb.dp.ClientDealProposal.Proposal), gomock.Eq(mb.finalPublishCid), gomock.Any()).DoAndReturn(func(_ context.Context, _ address.Address, _ abi.SectorNumber, _ market2.DealProposal, _ cid.Cid, cb storagemarket.DealSectorCommittedCallback) error {
+		cb(nil)
+		return nil
+	})
+
+	return mb
+}
+
 func (mb *MinerStubBuilder) Output() *StubbedMinerOutput {
 	return &StubbedMinerOutput{
 		PublishCid:      mb.publishCid,
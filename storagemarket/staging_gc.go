@@ -0,0 +1,139 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemanager"
+	"github.com/filecoin-project/boost/storagemarket/streamcommp"
+	"github.com/filecoin-project/boost/transport/httptransport"
+	"github.com/filecoin-project/boost/transport/localtransport"
+)
+
+type StagingGCConfig struct {
+	// How often to check the staging areas for orphaned files. The first
+	// check runs when the provider starts. Zero disables the check.
+	Interval time.Duration
+	// Orphaned files that were modified more recently than the grace period
+	// are not deleted
+	GracePeriod time.Duration
+	// If true, orphaned files are only reported, not deleted
+	DryRun bool
+}
+
+// StagingGC finds files in the staging areas that are not used by any deal,
+// for example because boost crashed or a deal failed partway through.
+// Orphaned files that are older than the grace period are deleted, unless
+// dryRun is true.
+func (p *Provider) StagingGC(ctx context.Context, dryRun bool) (*storagemanager.GCReport, error) {
+	p.stagingGCLk.Lock()
+	defer p.stagingGCLk.Unlock()
+
+	inUse, retained, err := p.stagingFilesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rep, err := p.storageManager.GC(ctx, inUse, retained, p.config.StagingGC.GracePeriod, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("collecting orphaned staging files: %w", err)
+	}
+
+	p.lastStagingGC = rep
+	return rep, nil
+}
+
+// StagingReclaimableBytes is the total size of the orphaned files in the
+// staging areas that could be deleted, as of the most recent check
+func (p *Provider) StagingReclaimableBytes() uint64 {
+	p.stagingGCLk.Lock()
+	defer p.stagingGCLk.Unlock()
+
+	if p.lastStagingGC == nil {
+		return 0
+	}
+	return p.lastStagingGC.ReclaimableBytes
+}
+
+// stagingFilesInUse returns the paths of the deal data files (and the files
+// derived from them) that are still needed by a deal, and the paths of
+// the files that are kept so that failed deals can be retried, mapped to the
+// time until which they are kept
+func (p *Provider) stagingFilesInUse(ctx context.Context) (map[string]struct{}, map[string]time.Time, error) {
+	active, err := p.dealsDB.ListActive(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing active deals: %w", err)
+	}
+	failed, err := p.dealsDB.ListRetryable(ctx, time.Now().Add(-p.config.RetryDataRetention))
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing failed deals: %w", err)
+	}
+
+	inUse := make(map[string]struct{})
+	for _, deal := range active {
+		if deal.InboundFilePath == "" {
+			continue
+		}
+		for _, path := range inboundFilePaths(filepath.Clean(deal.InboundFilePath)) {
+			inUse[path] = struct{}{}
+		}
+	}
+
+	retained := make(map[string]time.Time, len(failed))
+	for _, deal := range failed {
+		// The inbound file is kept for deals that failed in a way that
+		// means they can be retried
+		if !deal.IsOffline && deal.InboundFilePath != "" && p.keepInboundFileForRetry(deal) {
+			retained[filepath.Clean(deal.InboundFilePath)] = p.retryDataExpiry(deal)
+		}
+	}
+	return inUse, retained, nil
+}
+
+// inboundFilePaths returns the path of the deal data file, and the paths of
+// the files that are kept next to it while the deal is in progress
+func inboundFilePaths(path string) []string {
+	ranges := httptransport.RangesFilePath(path)
+	checkpoint := streamcommp.CheckpointFilePath(path)
+	return []string{
+		path,
+		// the progress of a parallel download
+		ranges,
+		// the hasher state for the streaming commP calculation
+		checkpoint,
+		// the hard link made by a local transfer, before it is renamed to
+		// the deal data file
+		localtransport.LinkFilePath(path),
+		// the ranges and checkpoint files are written to a temp file
+		// which is then renamed, so that they are never left half-written
+		ranges + ".tmp",
+		checkpoint + ".tmp",
+	}
+}
+
+func (p *Provider) runStagingGC() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.StagingGC.Interval)
+	defer ticker.Stop()
+
+	for {
+		rep, err := p.StagingGC(p.ctx, p.config.StagingGC.DryRun)
+		if err != nil {
+			if p.ctx.Err() == nil {
+				log.Errorw("staging area gc", "err", err)
+			}
+		} else if len(rep.Orphaned) > 0 {
+			log.Infow("staging area gc", "orphaned files", len(rep.Orphaned),
+				"deleted bytes", rep.DeletedBytes, "reclaimable bytes", rep.ReclaimableBytes, "dry run", rep.DryRun)
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
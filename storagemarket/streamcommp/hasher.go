@@ -0,0 +1,280 @@
+package streamcommp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// The number of bytes of data that are fr32-padded into each quad of
+	// four leaf nodes
+	quadUnpaddedSize = 127
+	quadPaddedSize   = 128
+	nodeSize         = 32
+
+	// The version of the serialized hasher state
+	stateVersion = 1
+	// The maximum height of the merkle tree (far larger than any piece)
+	maxLayers = 64
+)
+
+type node [nodeSize]byte
+
+// Hasher calculates the piece commitment (commP) of data as it is written.
+// Unlike a regular commP writer its state can be serialized, so that hashing
+// can be resumed later without reading the data that was already hashed.
+type Hasher struct {
+	written int64
+	// data that has not yet been hashed because it doesn't fill a quad
+	pending []byte
+	// the left-hand node at each layer of the merkle tree that is waiting
+	// for its right-hand sibling (if full is set for that layer)
+	layers []node
+	full   []bool
+}
+
+var _ io.Writer = (*Hasher)(nil)
+
+// Written returns the number of bytes that have been hashed
+func (h *Hasher) Written() int64 {
+	return h.written
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.written += int64(n)
+
+	// fill up the partial quad left over from the last write
+	if len(h.pending) > 0 {
+		fill := quadUnpaddedSize - len(h.pending)
+		if fill > len(p) {
+			fill = len(p)
+		}
+		h.pending = append(h.pending, p[:fill]...)
+		p = p[fill:]
+		if len(h.pending) < quadUnpaddedSize {
+			return n, nil
+		}
+		h.addQuad(h.pending)
+		h.pending = h.pending[:0]
+	}
+
+	for len(p) >= quadUnpaddedSize {
+		h.addQuad(p[:quadUnpaddedSize])
+		p = p[quadUnpaddedSize:]
+	}
+	h.pending = append(h.pending, p...)
+
+	return n, nil
+}
+
+// Sum returns the piece commitment and padded piece size of the data written
+// so far. It doesn't change the state of the hasher, so more data can be
+// written afterwards.
+func (h *Hasher) Sum() (cid.Cid, abi.PaddedPieceSize, error) {
+	if h.written == 0 {
+		return cid.Undef, 0, errors.New("cannot calculate commP: no data has been written")
+	}
+
+	c := &Hasher{
+		layers: append([]node(nil), h.layers...),
+		full:   append([]bool(nil), h.full...),
+	}
+
+	// pad the last partial quad with zeros
+	if len(h.pending) > 0 {
+		var quad [quadUnpaddedSize]byte
+		copy(quad[:], h.pending)
+		c.addQuad(quad[:])
+	}
+
+	// pad the tree with zero nodes up to the next power of two leaves
+	quads := (h.written + quadUnpaddedSize - 1) / quadUnpaddedSize
+	leaves := uint64(quads) * (quadPaddedSize / nodeSize)
+	height := bits.Len64(leaves - 1)
+	var zero node
+	for layer := 0; layer < height; layer++ {
+		if layer < len(c.full) && c.full[layer] {
+			c.full[layer] = false
+			c.addNode(layer+1, hashNodes(&c.layers[layer], &zero))
+		}
+		zero = hashNodes(&zero, &zero)
+	}
+
+	if height >= len(c.full) || !c.full[height] {
+		return cid.Undef, 0, fmt.Errorf("cannot calculate commP: merkle tree has no root at height %d", height)
+	}
+	root := c.layers[height]
+	pieceCid, err := commcid.DataCommitmentV1ToCID(root[:])
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("converting commP to cid: %w", err)
+	}
+	return pieceCid, abi.PaddedPieceSize(uint64(nodeSize) << height), nil
+}
+
+// addQuad fr32-pads 127 bytes of data into four leaf nodes and adds them to
+// the tree
+func (h *Hasher) addQuad(quad []byte) {
+	var padded [quadPaddedSize]byte
+	fr32Pad(quad, padded[:])
+	for i := 0; i < quadPaddedSize; i += nodeSize {
+		var leaf node
+		copy(leaf[:], padded[i:i+nodeSize])
+		h.addNode(0, leaf)
+	}
+}
+
+// addNode adds a node at the given layer, hashing it together with its
+// left-hand sibling (and so on up the tree) if the sibling is waiting
+func (h *Hasher) addNode(layer int, n node) {
+	for ; ; layer++ {
+		if layer == len(h.layers) {
+			h.layers = append(h.layers, node{})
+			h.full = append(h.full, false)
+		}
+		if !h.full[layer] {
+			h.layers[layer] = n
+			h.full[layer] = true
+			return
+		}
+		n = hashNodes(&h.layers[layer], &n)
+		h.full[layer] = false
+	}
+}
+
+// hashNodes hashes two sibling nodes with sha256, truncated to 254 bits
+func hashNodes(left, right *node) node {
+	var buf [2 * nodeSize]byte
+	copy(buf[:nodeSize], left[:])
+	copy(buf[nodeSize:], right[:])
+	out := node(sha256.Sum256(buf[:]))
+	out[nodeSize-1] &= 0x3F
+	return out
+}
+
+// fr32Pad expands 127 bytes of data into 128 bytes by inserting two zero
+// bits after every 254 bits
+func fr32Pad(in, out []byte) {
+	copy(out[:31], in[:31])
+	out[31] = in[31] & 0x3F
+
+	for i := 32; i < 64; i++ {
+		out[i] = in[i-1]>>6 | in[i]<<2
+	}
+	out[63] &= 0x3F
+
+	for i := 64; i < 96; i++ {
+		out[i] = in[i-1]>>4 | in[i]<<4
+	}
+	out[95] &= 0x3F
+
+	for i := 96; i < 127; i++ {
+		out[i] = in[i-1]>>2 | in[i]<<6
+	}
+	out[127] = in[126] >> 2
+}
+
+// MarshalBinary serializes the state of the hasher
+func (h *Hasher) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	var vbuf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		buf.Write(vbuf[:binary.PutUvarint(vbuf[:], v)])
+	}
+
+	buf.WriteByte(stateVersion)
+	putUvarint(uint64(h.written))
+	putUvarint(uint64(len(h.pending)))
+	buf.Write(h.pending)
+	putUvarint(uint64(len(h.layers)))
+	for i := range h.layers {
+		if h.full[i] {
+			buf.WriteByte(1)
+			buf.Write(h.layers[i][:])
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores the state of the hasher from the output of
+// MarshalBinary
+func (h *Hasher) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("reading hasher state version: %w", err)
+	}
+	if version != stateVersion {
+		return fmt.Errorf("unsupported hasher state version %d", version)
+	}
+
+	written, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("reading bytes written: %w", err)
+	}
+	pendingLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("reading pending data length: %w", err)
+	}
+	if pendingLen != written%quadUnpaddedSize {
+		return fmt.Errorf("pending data length %d is inconsistent with bytes written %d", pendingLen, written)
+	}
+	pending := make([]byte, pendingLen)
+	if _, err := io.ReadFull(r, pending); err != nil {
+		return fmt.Errorf("reading pending data: %w", err)
+	}
+
+	layerCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("reading layer count: %w", err)
+	}
+	if layerCount > maxLayers {
+		return fmt.Errorf("layer count %d exceeds maximum %d", layerCount, maxLayers)
+	}
+	layers := make([]node, layerCount)
+	full := make([]bool, layerCount)
+	for i := range layers {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading layer %d: %w", i, err)
+		}
+		if flag == 1 {
+			if _, err := io.ReadFull(r, layers[i][:]); err != nil {
+				return fmt.Errorf("reading layer %d: %w", i, err)
+			}
+			full[i] = true
+		}
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d unexpected trailing bytes in hasher state", r.Len())
+	}
+
+	// The nodes waiting for a sibling at each layer correspond to the bits
+	// of the number of leaves that have been hashed
+	leaves := (written / quadUnpaddedSize) * (quadPaddedSize / nodeSize)
+	for i := range full {
+		if full[i] != ((leaves>>uint(i))&1 == 1) {
+			return fmt.Errorf("layer %d is inconsistent with bytes written %d", i, written)
+		}
+	}
+	if leaves>>uint(len(full)) != 0 {
+		return fmt.Errorf("hasher state is missing layers for bytes written %d", written)
+	}
+
+	h.written = int64(written)
+	h.pending = pending
+	h.layers = layers
+	h.full = full
+	return nil
+}
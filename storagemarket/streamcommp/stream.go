@@ -0,0 +1,236 @@
+package streamcommp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("streamcommp")
+
+const (
+	// The suffix of the file that the hasher state is saved to
+	checkpointFileSuffix = ".commp"
+	// How often to save the hasher state while data is being written
+	checkpointInterval = 5 * time.Second
+)
+
+// Stream calculates the commP of a file as data is written to it. It saves
+// the hasher state to a checkpoint file next to the data file, so that if
+// the transfer is interrupted, the data that was already hashed doesn't need
+// to be read again when it resumes.
+//
+// Data must be written to the file sequentially. If there is a gap between
+// the data that has been hashed and the data that is written, the missing
+// data is read from the file.
+type Stream struct {
+	path string
+
+	lk        sync.Mutex
+	h         *Hasher
+	lastSaved time.Time
+}
+
+var _ io.WriterAt = (*Stream)(nil)
+
+// CheckpointFilePath returns the path of the checkpoint file for the data
+// file at path
+func CheckpointFilePath(path string) string {
+	return path + checkpointFileSuffix
+}
+
+// Open creates a Stream for the file at path. If there is a checkpoint for
+// the file, hashing resumes from the checkpoint.
+func Open(path string) *Stream {
+	s := &Stream{path: path, h: &Hasher{}, lastSaved: time.Now()}
+
+	h, err := loadCheckpoint(path)
+	if err != nil {
+		log.Warnw("discarding commP checkpoint", "path", path, "err", err)
+		return s
+	}
+	if h != nil {
+		s.h = h
+	}
+	return s
+}
+
+func loadCheckpoint(path string) (*Hasher, error) {
+	bz, err := ioutil.ReadFile(CheckpointFilePath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading checkpoint file: %w", err)
+	}
+
+	h := &Hasher{}
+	if err := h.UnmarshalBinary(bz); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint file: %w", err)
+	}
+
+	// If the file is smaller than the data that was hashed, it must have
+	// been truncated or re-created since the checkpoint was saved
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat data file: %w", err)
+	}
+	if st.Size() < h.Written() {
+		return nil, fmt.Errorf("data file size %d is less than checkpoint size %d", st.Size(), h.Written())
+	}
+	return h, nil
+}
+
+// Written returns the number of bytes of the file that have been hashed
+func (s *Stream) Written() int64 {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.h.Written()
+}
+
+// WriteAt hashes p, which has just been written to the file at offset off
+func (s *Stream) WriteAt(p []byte, off int64) (int, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if off < s.h.Written() {
+		// The data that was already hashed has been overwritten, so start
+		// again from the beginning of the file
+		log.Warnw("data was overwritten, restarting commP calculation", "path", s.path,
+			"offset", off, "hashed", s.h.Written())
+		s.h = &Hasher{}
+	}
+	if off > s.h.Written() {
+		if err := s.catchUp(off); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.h.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if time.Since(s.lastSaved) > checkpointInterval {
+		if err := s.save(); err != nil {
+			log.Warnw("failed to save commP checkpoint", "path", s.path, "err", err)
+		}
+	}
+	return n, nil
+}
+
+// catchUp reads the data from the file between the end of the hashed data and
+// the given offset
+func (s *Stream) catchUp(to int64) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	from := s.h.Written()
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek data file: %w", err)
+	}
+	n, err := io.Copy(s.h, io.LimitReader(f, to-from))
+	if err != nil {
+		return fmt.Errorf("reading data file: %w", err)
+	}
+	if n != to-from {
+		return fmt.Errorf("expected to read %d bytes from data file at offset %d but read %d", to-from, from, n)
+	}
+	return nil
+}
+
+// Sum returns the commP of the whole file. Any data in the file that was not
+// written through the Stream is read from the file first.
+func (s *Stream) Sum() (cid.Cid, abi.PaddedPieceSize, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	st, err := os.Stat(s.path)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("failed to stat data file: %w", err)
+	}
+	if st.Size() < s.h.Written() {
+		return cid.Undef, 0, fmt.Errorf("data file size %d is less than the number of bytes hashed %d", st.Size(), s.h.Written())
+	}
+	if st.Size() > s.h.Written() {
+		if err := s.catchUp(st.Size()); err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+
+	return s.h.Sum()
+}
+
+// Close saves the hasher state to the checkpoint file
+func (s *Stream) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.h.Written() == 0 {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *Stream) save() error {
+	bz, err := s.h.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshalling hasher state: %w", err)
+	}
+
+	// Write to a temp file and rename it so that the checkpoint file is
+	// never left half-written
+	path := CheckpointFilePath(s.path)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bz, 0644); err != nil {
+		return fmt.Errorf("writing checkpoint file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("renaming checkpoint file: %w", err)
+	}
+	s.lastSaved = time.Now()
+	return nil
+}
+
+// RemoveCheckpoint removes the checkpoint file for the file at path, if
+// there is one
+func RemoveCheckpoint(path string) {
+	_ = os.Remove(CheckpointFilePath(path))
+}
+
+// WriteFile writes the data from r to a new file at path, calculating commP
+// as the data is written. The hasher state is saved to the checkpoint file,
+// so that a Stream opened for the file can return the commP without reading
+// the file again.
+func WriteFile(path string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	s := &Stream{path: path, h: &Hasher{}}
+	n, err := io.Copy(io.MultiWriter(f, s.h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.Close()
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		RemoveCheckpoint(path)
+		return 0, fmt.Errorf("writing file %s: %w", path, err)
+	}
+	return n, nil
+}
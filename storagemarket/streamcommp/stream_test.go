@@ -0,0 +1,152 @@
+package streamcommp
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasherMatchesCommPWriter(t *testing.T) {
+	sizes := []int{127, 128, 254, 1000, 127 * 8, 127*8 + 1, 100000, 1 << 20}
+	for _, size := range sizes {
+		data := randBytes(size)
+
+		expected := &writer.Writer{}
+		_, err := expected.Write(data)
+		require.NoError(t, err)
+		exp, err := expected.Sum()
+		require.NoError(t, err)
+
+		// write the data in uneven chunks
+		h := &Hasher{}
+		for rest := data; len(rest) > 0; {
+			n := 1 + rand.Intn(300) //nolint:gosec
+			if n > len(rest) {
+				n = len(rest)
+			}
+			_, err := h.Write(rest[:n])
+			require.NoError(t, err)
+			rest = rest[n:]
+		}
+
+		pieceCid, pieceSize, err := h.Sum()
+		require.NoError(t, err)
+		require.Equal(t, exp.PieceCID, pieceCid, "size %d", size)
+		require.Equal(t, exp.PieceSize, pieceSize, "size %d", size)
+	}
+}
+
+func TestHasherMarshal(t *testing.T) {
+	data := randBytes(100000)
+
+	full := &Hasher{}
+	_, err := full.Write(data)
+	require.NoError(t, err)
+	exp, _, err := full.Sum()
+	require.NoError(t, err)
+
+	// Serialize the hasher part way through, and resume from the saved state
+	h := &Hasher{}
+	_, err = h.Write(data[:54321])
+	require.NoError(t, err)
+	bz, err := h.MarshalBinary()
+	require.NoError(t, err)
+
+	resumed := &Hasher{}
+	require.NoError(t, resumed.UnmarshalBinary(bz))
+	require.EqualValues(t, 54321, resumed.Written())
+	_, err = resumed.Write(data[54321:])
+	require.NoError(t, err)
+	pieceCid, _, err := resumed.Sum()
+	require.NoError(t, err)
+	require.Equal(t, exp, pieceCid)
+
+	// Corrupted state should be rejected
+	require.Error(t, (&Hasher{}).UnmarshalBinary(bz[:len(bz)-1]))
+}
+
+func TestStreamCheckpoint(t *testing.T) {
+	data := randBytes(100000)
+	expected := &Hasher{}
+	_, err := expected.Write(data)
+	require.NoError(t, err)
+	exp, _, err := expected.Sum()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "data")
+
+	// Write the first part of the data and save a checkpoint
+	require.NoError(t, os.WriteFile(path, data[:30000], 0644))
+	s := Open(path)
+	_, err = s.WriteAt(data[:30000], 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Simulate more data being written to the file after the checkpoint was
+	// saved, before the transfer was interrupted
+	require.NoError(t, os.WriteFile(path, data[:50000], 0644))
+
+	// Resume the transfer. The data after the checkpoint should be read from
+	// the file.
+	s = Open(path)
+	require.EqualValues(t, 30000, s.Written())
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(data[50000:])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = s.WriteAt(data[50000:], 50000)
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), s.Written())
+
+	pieceCid, _, err := s.Sum()
+	require.NoError(t, err)
+	require.Equal(t, exp, pieceCid)
+
+	// A checkpoint for more data than there is in the file should be ignored
+	require.NoError(t, s.Close())
+	require.NoError(t, os.WriteFile(path, data[:10], 0644))
+	require.EqualValues(t, 0, Open(path).Written())
+}
+
+func TestWriteFile(t *testing.T) {
+	data := randBytes(100000)
+	path := filepath.Join(t.TempDir(), "data")
+
+	n, err := WriteFile(path, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), n)
+
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, data, bz)
+
+	// The commP should be available from the checkpoint without reading the
+	// file again
+	s := Open(path)
+	require.EqualValues(t, len(data), s.Written())
+	pieceCid, _, err := s.Sum()
+	require.NoError(t, err)
+
+	expected := &Hasher{}
+	_, err = expected.Write(data)
+	require.NoError(t, err)
+	exp, _, err := expected.Sum()
+	require.NoError(t, err)
+	require.Equal(t, exp, pieceCid)
+
+	// WriteFile should not overwrite an existing file
+	_, err = WriteFile(path, bytes.NewReader(data))
+	require.Error(t, err)
+}
+
+func randBytes(n int) []byte {
+	bz := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(bz) //nolint:gosec
+	return bz
+}
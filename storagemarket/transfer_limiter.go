@@ -0,0 +1,205 @@
+package storagemarket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+)
+
+// transferLimiter limits the number of deal data transfers that run
+// concurrently, in total and per client. Transfers that are over the limit
+// are queued, and started in the order in which they were queued as soon as
+// the limits allow it.
+type transferLimiter struct {
+	// The maximum number of concurrent transfers (0 means no limit)
+	maxConcurrent uint64
+	// The maximum number of concurrent transfers from a single client
+	// (0 means no limit)
+	maxConcurrentPerClient uint64
+
+	lk sync.Mutex
+	// maps from deal uuid -> client for each running transfer
+	active map[uuid.UUID]address.Address
+	// maps from client -> number of running transfers
+	perClient map[address.Address]uint64
+	// transfers waiting to start, in the order in which they were queued
+	queue []*queuedTransfer
+	// while paused, new transfers are queued instead of being started
+	paused bool
+}
+
+type queuedTransfer struct {
+	dealUuid uuid.UUID
+	client   address.Address
+	// closed when the transfer can start
+	ready chan struct{}
+}
+
+func newTransferLimiter(maxConcurrent uint64, maxConcurrentPerClient uint64) *transferLimiter {
+	return &transferLimiter{
+		maxConcurrent:          maxConcurrent,
+		maxConcurrentPerClient: maxConcurrentPerClient,
+		active:                 make(map[uuid.UUID]address.Address),
+		perClient:              make(map[address.Address]uint64),
+	}
+}
+
+// waitToStart blocks until the transfer for the deal can start, or the
+// context is cancelled. If the transfer has to wait, onQueued is called with
+// the position of the transfer in the queue. Once the transfer has started,
+// the caller must call complete when the transfer finishes.
+func (l *transferLimiter) waitToStart(ctx context.Context, dealUuid uuid.UUID, client address.Address, onQueued func(position int)) error {
+	l.lk.Lock()
+	if _, ok := l.active[dealUuid]; ok {
+		l.lk.Unlock()
+		return nil
+	}
+
+	// Every transfer in the queue is waiting on a limit, so if there is space
+	// for this transfer it can start straight away
+	if l.canStartLocked(client) {
+		l.startLocked(dealUuid, client)
+		l.lk.Unlock()
+		return nil
+	}
+
+	qt := &queuedTransfer{dealUuid: dealUuid, client: client, ready: make(chan struct{})}
+	l.queue = append(l.queue, qt)
+	position := len(l.queue)
+	l.lk.Unlock()
+
+	onQueued(position)
+
+	select {
+	case <-qt.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	select {
+	case <-qt.ready:
+		// The transfer was started at the same time as the context was
+		// cancelled, so give up its place to the next transfer
+		l.completeLocked(dealUuid)
+	default:
+		l.removeFromQueueLocked(dealUuid)
+	}
+	return ctx.Err()
+}
+
+// complete is called when a transfer finishes, so that queued transfers can
+// take its place
+func (l *transferLimiter) complete(dealUuid uuid.UUID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.completeLocked(dealUuid)
+}
+
+// queuePosition returns the position of the deal in the transfer queue,
+// starting from 1, or 0 if the deal is not queued
+func (l *transferLimiter) queuePosition(dealUuid uuid.UUID) int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	for i, qt := range l.queue {
+		if qt.dealUuid == dealUuid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// queued returns the uuids of the deals in the transfer queue, in queue order
+func (l *transferLimiter) queued() []uuid.UUID {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	ids := make([]uuid.UUID, 0, len(l.queue))
+	for _, qt := range l.queue {
+		ids = append(ids, qt.dealUuid)
+	}
+	return ids
+}
+
+// setPaused pauses or resumes the start of new transfers. Transfers that
+// are already running are not affected.
+func (l *transferLimiter) setPaused(paused bool) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if l.paused == paused {
+		return
+	}
+	l.paused = paused
+	if paused {
+		log.Warnw("pausing new data transfers", "queued", len(l.queue))
+		return
+	}
+
+	log.Infow("resuming data transfers", "queued", len(l.queue))
+	l.startQueuedLocked()
+}
+
+func (l *transferLimiter) canStartLocked(client address.Address) bool {
+	if l.paused {
+		return false
+	}
+	if l.maxConcurrent > 0 && uint64(len(l.active)) >= l.maxConcurrent {
+		return false
+	}
+	if l.maxConcurrentPerClient > 0 && l.perClient[client] >= l.maxConcurrentPerClient {
+		return false
+	}
+	return true
+}
+
+func (l *transferLimiter) startLocked(dealUuid uuid.UUID, client address.Address) {
+	l.active[dealUuid] = client
+	l.perClient[client]++
+}
+
+func (l *transferLimiter) completeLocked(dealUuid uuid.UUID) {
+	client, ok := l.active[dealUuid]
+	if !ok {
+		return
+	}
+
+	delete(l.active, dealUuid)
+	l.perClient[client]--
+	if l.perClient[client] == 0 {
+		delete(l.perClient, client)
+	}
+
+	l.startQueuedLocked()
+}
+
+func (l *transferLimiter) startQueuedLocked() {
+	// Start any queued transfers that are now within the limits. A transfer
+	// may be skipped over if its client is at the per-client limit, so that
+	// it doesn't hold up transfers from other clients.
+	remaining := make([]*queuedTransfer, 0, len(l.queue))
+	for _, qt := range l.queue {
+		if l.canStartLocked(qt.client) {
+			l.startLocked(qt.dealUuid, qt.client)
+			close(qt.ready)
+			continue
+		}
+		remaining = append(remaining, qt)
+	}
+	l.queue = remaining
+}
+
+func (l *transferLimiter) removeFromQueueLocked(dealUuid uuid.UUID) {
+	for i, qt := range l.queue {
+		if qt.dealUuid == dealUuid {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+}
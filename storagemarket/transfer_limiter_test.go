@@ -0,0 +1,123 @@
+package storagemarket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	clientA, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	clientB, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	// Allow two transfers at a time, and one per client
+	l := newTransferLimiter(2, 1)
+
+	// The first transfer from each client should start straight away
+	d1, d2, d3, d4 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	require.NoError(t, l.waitToStart(ctx, d1, clientA, failIfQueued(t)))
+
+	// The second transfer from client A is over the per-client limit
+	d2Started := startInBackground(ctx, l, d2, clientA)
+	require.Eventually(t, func() bool { return l.queuePosition(d2) == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, l.waitToStart(ctx, d3, clientB, failIfQueued(t)))
+
+	// The second transfer from client B is over both limits
+	d4Started := startInBackground(ctx, l, d4, clientB)
+	require.Eventually(t, func() bool { return l.queuePosition(d4) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []uuid.UUID{d2, d4}, l.queued())
+
+	// When the transfer for client A completes, the next transfer for
+	// client A should start, and the transfer for client B should move up
+	// the queue
+	l.complete(d1)
+	require.NoError(t, <-d2Started)
+	require.Equal(t, 1, l.queuePosition(d4))
+
+	// When the transfer for client B completes, the next transfer for
+	// client B should start
+	l.complete(d3)
+	require.NoError(t, <-d4Started)
+	require.Empty(t, l.queued())
+
+	l.complete(d2)
+	l.complete(d4)
+	require.Empty(t, l.active)
+	require.Empty(t, l.perClient)
+}
+
+func TestTransferLimiterCancelQueued(t *testing.T) {
+	clientA, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	l := newTransferLimiter(1, 0)
+
+	d1, d2 := uuid.New(), uuid.New()
+	require.NoError(t, l.waitToStart(context.Background(), d1, clientA, failIfQueued(t)))
+
+	// Cancelling a queued transfer should remove it from the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	d2Started := startInBackground(ctx, l, d2, clientA)
+	require.Eventually(t, func() bool { return l.queuePosition(d2) == 1 }, time.Second, 10*time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-d2Started, context.Canceled)
+	require.Empty(t, l.queued())
+
+	// Completing the running transfer should not start the cancelled one
+	l.complete(d1)
+	require.Empty(t, l.active)
+}
+
+func TestTransferLimiterPause(t *testing.T) {
+	ctx := context.Background()
+
+	clientA, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	l := newTransferLimiter(0, 0)
+
+	d1, d2 := uuid.New(), uuid.New()
+	require.NoError(t, l.waitToStart(ctx, d1, clientA, failIfQueued(t)))
+
+	// While paused, new transfers should be queued even though there are no
+	// limits
+	l.setPaused(true)
+	d2Started := startInBackground(ctx, l, d2, clientA)
+	require.Eventually(t, func() bool { return l.queuePosition(d2) == 1 }, time.Second, 10*time.Millisecond)
+
+	// Completing a running transfer should not start a queued transfer
+	// while paused
+	l.complete(d1)
+	require.Equal(t, 1, l.queuePosition(d2))
+
+	// Resuming should start the queued transfer
+	l.setPaused(false)
+	require.NoError(t, <-d2Started)
+	require.Empty(t, l.queued())
+
+	l.complete(d2)
+	require.Empty(t, l.active)
+}
+
+func failIfQueued(t *testing.T) func(int) {
+	return func(position int) {
+		t.Fatalf("transfer should not be queued (queue position %d)", position)
+	}
+}
+
+func startInBackground(ctx context.Context, l *transferLimiter, dealUuid uuid.UUID, client address.Address) chan error {
+	started := make(chan error, 1)
+	go func() {
+		started <- l.waitToStart(ctx, dealUuid, client, func(int) {})
+	}()
+	return started
+}
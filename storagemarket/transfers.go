@@ -2,9 +2,12 @@ package storagemarket
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/filecoin-project/boost/transport"
+	transporttypes "github.com/filecoin-project/boost/transport/types"
 	"github.com/google/uuid"
 )
 
@@ -16,6 +19,43 @@ func (p *Provider) Transfers() map[uuid.UUID][]transferPoint {
 	return p.transfers.transfers()
 }
 
+// TransferQueuePosition returns the position of the deal in the queue of
+// deals waiting for their data transfer to start, starting from 1. It returns
+// 0 if the deal is not queued.
+func (p *Provider) TransferQueuePosition(dealUuid uuid.UUID) int {
+	return p.transferLimiter.queuePosition(dealUuid)
+}
+
+// QueuedTransfers returns the uuids of the deals that are waiting for their
+// data transfer to start, in queue order
+func (p *Provider) QueuedTransfers() []uuid.UUID {
+	return p.transferLimiter.queued()
+}
+
+// TransferBandwidthLimits returns the current limits on the rate at which
+// deal data is transferred
+func (p *Provider) TransferBandwidthLimits() (transporttypes.BandwidthLimits, error) {
+	th, ok := p.Transport.(transport.Throttler)
+	if !ok {
+		return transporttypes.BandwidthLimits{}, fmt.Errorf("transport does not support bandwidth limits")
+	}
+	return th.BandwidthLimits(), nil
+}
+
+// SetTransferBandwidthLimits changes the limits on the rate at which deal
+// data is transferred, including for transfers that are already running.
+// Note that the new limits are not saved to the config file.
+func (p *Provider) SetTransferBandwidthLimits(limits transporttypes.BandwidthLimits) error {
+	th, ok := p.Transport.(transport.Throttler)
+	if !ok {
+		return fmt.Errorf("transport does not support bandwidth limits")
+	}
+	log.Infow("set transfer bandwidth limits", "total", limits.Total, "per deal", limits.PerDeal,
+		"per client", limits.PerClient)
+	th.SetBandwidthLimits(limits)
+	return nil
+}
+
 // A sample of the number of bytes transferred at the given time
 type transferPoint struct {
 	// The time at which the sample was taken, truncated to the nearest second
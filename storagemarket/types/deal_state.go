@@ -54,6 +54,9 @@ type ProviderDealState struct {
 
 	// set if there's an error
 	Err string
+	// ErrCheckpoint is the last checkpoint the deal reached before it failed.
+	// A failed deal can be retried from this checkpoint.
+	ErrCheckpoint dealcheckpoints.Checkpoint
 
 	// NBytesReceived is the number of bytes Received for this deal
 	NBytesReceived int64
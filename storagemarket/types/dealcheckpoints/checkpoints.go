@@ -11,6 +11,10 @@ const (
 	PublishConfirmed
 	AddedPiece
 	IndexedAndAnnounced
+	PreCommitted
+	Active
+	Expired
+	Slashed
 	Complete
 )
 
@@ -21,6 +25,10 @@ var names = map[Checkpoint]string{
 	PublishConfirmed:    "PublishConfirmed",
 	AddedPiece:          "AddedPiece",
 	IndexedAndAnnounced: "IndexedAndAnnounced",
+	PreCommitted:        "PreCommitted",
+	Active:              "Active",
+	Expired:             "Expired",
+	Slashed:             "Slashed",
 	Complete:            "Complete",
 }
 
@@ -9,6 +9,7 @@ import (
 	io "io"
 	reflect "reflect"
 
+	address "github.com/filecoin-project/go-address"
 	storagemarket "github.com/filecoin-project/go-fil-markets/storagemarket"
 	abi "github.com/filecoin-project/go-state-types/abi"
 	api "github.com/filecoin-project/lotus/api"
@@ -131,3 +132,54 @@ func (mr *MockChainDealManagerMockRecorder) WaitForPublishDeals(ctx, publishCid,
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitForPublishDeals", reflect.TypeOf((*MockChainDealManager)(nil).WaitForPublishDeals), ctx, publishCid, proposal)
 }
+
+// MockSectorCommittedManager is a mock of SectorCommittedManager interface.
+type MockSectorCommittedManager struct {
+	ctrl     *gomock.Controller
+	recorder *MockSectorCommittedManagerMockRecorder
+}
+
+// MockSectorCommittedManagerMockRecorder is the mock recorder for MockSectorCommittedManager.
+type MockSectorCommittedManagerMockRecorder struct {
+	mock *MockSectorCommittedManager
+}
+
+// NewMockSectorCommittedManager creates a new mock instance.
+func NewMockSectorCommittedManager(ctrl *gomock.Controller) *MockSectorCommittedManager {
+	mock := &MockSectorCommittedManager{ctrl: ctrl}
+	mock.recorder = &MockSectorCommittedManagerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSectorCommittedManager) EXPECT() *MockSectorCommittedManagerMockRecorder {
+	return m.recorder
+}
+
+// OnDealSectorCommitted mocks base method.
+func (m *MockSectorCommittedManager) OnDealSectorCommitted(ctx context.Context, provider address.Address, sectorNumber abi.SectorNumber, proposal market.DealProposal, publishCid cid.Cid, callback storagemarket.DealSectorCommittedCallback) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnDealSectorCommitted", ctx, provider, sectorNumber, proposal, publishCid, callback)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OnDealSectorCommitted indicates an expected call of OnDealSectorCommitted.
+func (mr *MockSectorCommittedManagerMockRecorder) OnDealSectorCommitted(ctx, provider, sectorNumber, proposal, publishCid, callback interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnDealSectorCommitted", reflect.TypeOf((*MockSectorCommittedManager)(nil).OnDealSectorCommitted), ctx, provider, sectorNumber, proposal, publishCid, callback)
+}
+
+// OnDealSectorPreCommitted mocks base method.
+func (m *MockSectorCommittedManager) OnDealSectorPreCommitted(ctx context.Context, provider address.Address, proposal market.DealProposal, publishCid cid.Cid, callback storagemarket.DealSectorPreCommittedCallback) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnDealSectorPreCommitted", ctx, provider, proposal, publishCid, callback)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OnDealSectorPreCommitted indicates an expected call of OnDealSectorPreCommitted.
+func (mr *MockSectorCommittedManagerMockRecorder) OnDealSectorPreCommitted(ctx, provider, proposal, publishCid, callback interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnDealSectorPreCommitted", reflect.TypeOf((*MockSectorCommittedManager)(nil).OnDealSectorPreCommitted), ctx, provider, proposal, publishCid, callback)
+}
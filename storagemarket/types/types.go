@@ -8,6 +8,7 @@ import (
 
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/go-address"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
@@ -16,9 +17,10 @@ import (
 	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
 )
 
-//go:generate cbor-gen-for --map-encoding StorageAsk DealParams Transfer DealResponse DealStatusRequest DealStatusResponse DealStatus
+//go:generate cbor-gen-for --map-encoding StorageAsk DealParams Transfer DealResponse DealStatusRequest DealStatusResponse DealStatus DealDataTransferVoucher
 
 // StorageAsk defines the parameters by which a miner will choose to accept or
 // reject a deal. Note: making a storage deal proposal which matches the miner's
@@ -80,6 +82,77 @@ type DealParams struct {
 type DealFilterParams struct {
 	DealParams           *DealParams
 	SealingPipelineState *sealingpipeline.Status
+	// The peer that sent the deal proposal
+	ClientPeerID peer.ID
+	// The provider's funds when the deal was proposed
+	FundsState *FundsState
+	// The state of the staging area when the deal was proposed
+	StorageState *StorageState
+	// The provider's active deals when the deal was proposed
+	ActiveDeals *ActiveDealCounts
+}
+
+// FundsState is a snapshot of the funds available to the provider for
+// making deals
+type FundsState struct {
+	// Funds in escrow that are not locked as collateral for published deals
+	EscrowAvailable abi.TokenAmount
+	// Funds in escrow that are locked as collateral for published deals
+	EscrowLocked abi.TokenAmount
+	// Funds in escrow that are tagged as collateral for deals that have not
+	// yet been published
+	CollateralTagged abi.TokenAmount
+	// Funds in escrow that can be used as collateral for new deals
+	// (EscrowAvailable - CollateralTagged)
+	CollateralAvailable abi.TokenAmount
+	// The balance of the wallet used to send publish storage deals messages
+	PublishMsgBalance abi.TokenAmount
+	// Funds in the publish storage deals wallet that are tagged for deals
+	// that have not yet been published
+	PublishMsgTagged abi.TokenAmount
+	// Funds in the publish storage deals wallet that can be used for new
+	// deals (PublishMsgBalance - PublishMsgTagged)
+	PublishMsgAvailable abi.TokenAmount
+}
+
+// StorageState is a snapshot of the staging area used for deal data
+type StorageState struct {
+	// The maximum number of bytes of deal data in the staging area.
+	// Zero means that the size of the staging area is unlimited.
+	MaxStagingDealsBytes uint64
+	// The number of bytes tagged for deals that are in the staging area
+	Tagged uint64
+	// The number of bytes available for new deals, taking into account the
+	// max size of each staging area. Always zero if the size of the staging
+	// area is unlimited.
+	Free uint64
+	// The state of each staging area
+	StagingAreas []StagingAreaState
+}
+
+// StagingAreaState is a snapshot of a single staging area directory
+type StagingAreaState struct {
+	Path string
+	// The maximum number of bytes of deal data in the staging area.
+	// Zero means that the size of the staging area is unlimited.
+	MaxBytes uint64
+	// The number of bytes tagged for deals in the staging area
+	Tagged uint64
+	// The number of bytes available for new deals in the staging area.
+	// Always zero if the size of the staging area is unlimited.
+	Free uint64
+	// The disk space available on the filesystem of the staging area
+	DiskAvailable uint64
+}
+
+// ActiveDealCounts is the number of deals that have not yet been handed off
+// to the sealer
+type ActiveDealCounts struct {
+	Total int
+	// The number of active deals by client address
+	ByClient map[string]int
+	// The number of active deals by checkpoint
+	ByCheckpoint map[string]int
 }
 
 // Transfer has the parameters for a data transfer
@@ -95,11 +168,26 @@ type Transfer struct {
 	Size uint64
 }
 
+// DealDataTransferVoucher is sent with a go-data-transfer request to pull
+// the data for a deal over graphsync, so that the client can match the
+// request to the deal (see graphsynctransport.Server)
+type DealDataTransferVoucher struct {
+	DealUUID uuid.UUID
+}
+
+// Type is the voucher type identifier used by go-data-transfer
+func (v *DealDataTransferVoucher) Type() datatransfer.TypeIdentifier {
+	return "BoostDealDataTransferVoucher"
+}
+
 type DealResponse struct {
 	Accepted bool
 	// Message is the reason the deal proposal was rejected. It is empty if
 	// the deal was accepted.
 	Message string
+	// TransferToken authenticates the client's upload of the deal data, for
+	// deals with the "push" transfer type. It is empty for other deals.
+	TransferToken string
 }
 
 type PieceAdder interface {
@@ -110,10 +198,27 @@ type DealPublisher interface {
 	Publish(ctx context.Context, deal market2.ClientDealProposal) (cid.Cid, error)
 }
 
+// DealPublishLog records a message about a deal in the deal's log
+type DealPublishLog func(msg string, kvs ...interface{})
+
+// LoggingDealPublisher is a DealPublisher that publishes the deal from the
+// wallet in which funds were tagged for the deal's publish message, and that
+// can record what happens to the deal while it is being published (eg when
+// the deal's publish batch is split because another deal in the batch is
+// invalid)
+type LoggingDealPublisher interface {
+	PublishWithLog(ctx context.Context, dealUuid uuid.UUID, deal market2.ClientDealProposal, wallet address.Address, log DealPublishLog) (cid.Cid, error)
+}
+
 type ChainDealManager interface {
 	WaitForPublishDeals(ctx context.Context, publishCid cid.Cid, proposal market2.DealProposal) (*storagemarket.PublishDealsWaitResult, error)
 }
 
+type SectorCommittedManager interface {
+	OnDealSectorPreCommitted(ctx context.Context, provider address.Address, proposal market2.DealProposal, publishCid cid.Cid, callback storagemarket.DealSectorPreCommittedCallback) error
+	OnDealSectorCommitted(ctx context.Context, provider address.Address, sectorNumber abi.SectorNumber, proposal market2.DealProposal, publishCid cid.Cid, callback storagemarket.DealSectorCommittedCallback) error
+}
+
 type IndexProvider interface {
 	AnnounceBoostDeal(ctx context.Context, pds *ProviderDealState) (cid.Cid, error)
 	Start(ctx context.Context)
@@ -638,7 +638,7 @@ func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{162}); err != nil {
+	if _, err := w.Write([]byte{163}); err != nil {
 		return err
 	}
 
@@ -682,6 +682,29 @@ func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 	if _, err := io.WriteString(w, string(t.Message)); err != nil {
 		return err
 	}
+
+	// t.TransferToken (string) (string)
+	if len("TransferToken") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferToken\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferToken"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferToken")); err != nil {
+		return err
+	}
+
+	if len(t.TransferToken) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.TransferToken was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.TransferToken))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.TransferToken)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -747,6 +770,17 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) error {
 
 				t.Message = string(sval)
 			}
+			// t.TransferToken (string) (string)
+		case "TransferToken":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.TransferToken = string(sval)
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -1410,3 +1444,106 @@ func (t *DealStatus) UnmarshalCBOR(r io.Reader) error {
 
 	return nil
 }
+func (t *DealDataTransferVoucher) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.DealUUID (uuid.UUID) (array)
+	if len("DealUUID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
+		return err
+	}
+
+	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.DealUUID[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealDataTransferVoucher) UnmarshalCBOR(r io.Reader) error {
+	*t = DealDataTransferVoucher{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealDataTransferVoucher: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.DealUUID (uuid.UUID) (array)
+		case "DealUUID":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.DealUUID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.DealUUID = [16]uint8{}
+
+			if _, err := io.ReadFull(br, t.DealUUID[:]); err != nil {
+				return err
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,400 @@
+package graphsynctransport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync/storeutil"
+	logging "github.com/ipfs/go-log/v2"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-ipld-prime"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("graphsync-transport")
+
+var _ transport.Transport = (*graphsyncTransport)(nil)
+
+// StoreConfigurableTransport is implemented by go-data-transfer transports
+// that can write the data for each channel to a different store
+type StoreConfigurableTransport interface {
+	UseStore(datatransfer.ChannelID, ipld.LinkSystem) error
+}
+
+// graphsyncTransport pulls the deal data DAG from a peer with
+// go-data-transfer over graphsync, and writes it to a CAR file
+type graphsyncTransport struct {
+	h  host.Host
+	dt datatransfer.Manager
+	dl *logs.DealLogger
+
+	lk sync.Mutex
+	// maps from deal uuid -> transfer for each running transfer
+	transfers map[uuid.UUID]*transfer
+}
+
+func New(h host.Host, dt datatransfer.Manager, dealLogger *logs.DealLogger) (*graphsyncTransport, error) {
+	g := &graphsyncTransport{
+		h:         h,
+		dt:        dt,
+		dl:        dealLogger.Subsystem("graphsync-transport"),
+		transfers: make(map[uuid.UUID]*transfer),
+	}
+
+	// Boost only pulls data with this voucher type, so reject any requests
+	// from other peers that use it
+	if err := dt.RegisterVoucherType(&smtypes.DealDataTransferVoucher{}, &rejectAllValidator{}); err != nil {
+		return nil, fmt.Errorf("registering deal data transfer voucher type: %w", err)
+	}
+	// Write the data for each transfer into the deal's CAR file
+	if err := dt.RegisterTransportConfigurer(&smtypes.DealDataTransferVoucher{}, g.configureTransport); err != nil {
+		return nil, fmt.Errorf("registering deal data transfer configurer: %w", err)
+	}
+	dt.SubscribeToEvents(g.onEvent)
+
+	return g, nil
+}
+
+func (g *graphsyncTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (transport.Handler, error) {
+	duuid := dealInfo.DealUuid
+	g.dl.Infow(duuid, "execute transfer", "deal size", dealInfo.DealSize, "output file", dealInfo.OutputFile,
+		"root", dealInfo.DealDataRoot)
+
+	// de-serialize transport opaque token
+	tInfo := &types.GraphsyncRequest{}
+	if err := json.Unmarshal(transportInfo, tInfo); err != nil {
+		return nil, fmt.Errorf("failed to de-serialize transport info bytes, bytes:%s, err:%w", string(transportInfo), err)
+	}
+
+	peerID, err := peer.Decode(tInfo.PeerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer id '%s': %w", tInfo.PeerID, err)
+	}
+	var addrs []multiaddr.Multiaddr
+	for _, a := range tInfo.Multiaddrs {
+		maddr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse multiaddr '%s': %w", a, err)
+		}
+		addrs = append(addrs, maddr)
+	}
+
+	if dealInfo.DealDataRoot == cid.Undef {
+		return nil, xerrors.New("deal data root is undefined")
+	}
+
+	// Add the peer's addresses to the peerstore so we can dial it
+	addrTtl := time.Hour
+	if deadline, ok := ctx.Deadline(); ok {
+		addrTtl = time.Until(deadline)
+	}
+	g.h.Peerstore().AddAddrs(peerID, addrs, addrTtl)
+
+	// An empty output file is created before the transfer starts, but the CAR
+	// blockstore expects either no file or a partially written CAR file
+	if fi, err := os.Stat(dealInfo.OutputFile); err == nil && fi.Size() == 0 {
+		if err := os.Remove(dealInfo.OutputFile); err != nil {
+			return nil, fmt.Errorf("removing empty output file: %w", err)
+		}
+	}
+
+	// Open the output file as a CAR blockstore. If the transfer was
+	// interrupted (eg by a restart) the blockstore resumes from the blocks
+	// that were already written, and duplicate blocks are skipped.
+	bs, err := blockstore.OpenReadWrite(dealInfo.OutputFile, []cid.Cid{dealInfo.DealDataRoot}, blockstore.UseWholeCIDs(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file as CAR blockstore: %w", err)
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	t := &transfer{
+		cancel:   cancel,
+		dealInfo: dealInfo,
+		peerID:   peerID,
+		bs:       bs,
+		eventCh:  make(chan types.TransportEvent, 256),
+		progress: make(chan struct{}, 1),
+		done:     make(chan error, 1),
+		dl:       g.dl,
+	}
+
+	g.lk.Lock()
+	if _, ok := g.transfers[duuid]; ok {
+		g.lk.Unlock()
+		cancel()
+		return nil, fmt.Errorf("transfer for deal %s is already running", duuid)
+	}
+	g.transfers[duuid] = t
+	g.lk.Unlock()
+
+	cleanup := func() {
+		g.lk.Lock()
+		delete(g.transfers, duuid)
+		g.lk.Unlock()
+		cancel()
+		t.closeEvents()
+	}
+
+	// Open a pull channel with the peer for the deal data DAG
+	voucher := &smtypes.DealDataTransferVoucher{DealUUID: duuid}
+	chid, err := g.dt.OpenPullDataChannel(tctx, peerID, voucher, dealInfo.DealDataRoot, selectorparse.CommonSelector_ExploreAllRecursively)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open pull data channel with peer %s: %w", peerID, err)
+	}
+	t.setChannelID(chid)
+	g.dl.Infow(duuid, "opened graphsync pull data channel", "peer", peerID, "channel id", chid.String())
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer cleanup()
+
+		if err := g.waitForTransfer(tctx, t, chid); err != nil {
+			t.sendEvent(tctx, types.TransportEvent{Error: err})
+		}
+	}()
+
+	return t, nil
+}
+
+// waitForTransfer forwards progress events until the data transfer channel
+// completes, then finalizes the CAR file
+func (g *graphsyncTransport) waitForTransfer(ctx context.Context, t *transfer, chid datatransfer.ChannelID) error {
+	duuid := t.dealInfo.DealUuid
+
+	for done := false; !done; {
+		select {
+		case <-t.progress:
+			t.sendEvent(ctx, types.TransportEvent{NBytesReceived: t.bytesReceived()})
+		case err := <-t.done:
+			if err != nil {
+				// The deal will fail and the output file will be removed, so
+				// finalize the blockstore just to release the file
+				_ = t.bs.Finalize()
+				return err
+			}
+			done = true
+		case <-ctx.Done():
+			g.dl.Infow(duuid, "closing graphsync data channel: context cancelled", "channel id", chid.String())
+			closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := g.dt.CloseDataTransferChannel(closeCtx, chid); err != nil {
+				g.dl.LogError(duuid, "failed to close graphsync data channel", err)
+			}
+			// Don't finalize the blockstore, so that the transfer can be resumed
+			// from the blocks that have already been written
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+	}
+
+	// Write the CAR index so that the file can be read as a CARv2
+	if err := t.bs.Finalize(); err != nil {
+		return fmt.Errorf("failed to finalize CAR file: %w", err)
+	}
+
+	// Report the size of the data payload of the CAR file, which is the
+	// size of the CAR file that the client would have sent over http
+	rd, err := carv2.OpenReader(t.dealInfo.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open finalized CAR file: %w", err)
+	}
+	size := int64(rd.Header.DataSize)
+	_ = rd.Close()
+
+	g.dl.Infow(duuid, "graphsync transfer completed successfully", "data size", size)
+	t.sendEvent(ctx, types.TransportEvent{NBytesReceived: size})
+	return nil
+}
+
+// configureTransport is called by go-data-transfer when a channel is opened,
+// to direct the blocks for the channel to the deal's CAR blockstore
+func (g *graphsyncTransport) configureTransport(chid datatransfer.ChannelID, voucher datatransfer.Voucher, tp datatransfer.Transport) {
+	v, ok := voucher.(*smtypes.DealDataTransferVoucher)
+	if !ok {
+		return
+	}
+
+	t := g.getTransfer(v.DealUUID)
+	if t == nil {
+		log.Warnw("no running transfer for deal data transfer channel", "deal uuid", v.DealUUID, "channel id", chid)
+		return
+	}
+
+	gsTransport, ok := tp.(StoreConfigurableTransport)
+	if !ok {
+		t.fail(xerrors.New("data transfer transport does not support configuring the store"))
+		return
+	}
+	if err := gsTransport.UseStore(chid, storeutil.LinkSystemForBlockstore(t.bs)); err != nil {
+		t.fail(fmt.Errorf("failed to configure store for data transfer channel: %w", err))
+	}
+}
+
+// onEvent is called by go-data-transfer for each data transfer event
+func (g *graphsyncTransport) onEvent(event datatransfer.Event, state datatransfer.ChannelState) {
+	v, ok := state.Voucher().(*smtypes.DealDataTransferVoucher)
+	if !ok {
+		return
+	}
+
+	t := g.getTransfer(v.DealUUID)
+	if t == nil || !t.isChannel(state.ChannelID()) {
+		return
+	}
+
+	switch event.Code {
+	case datatransfer.DataReceivedProgress:
+		t.setReceived(int64(state.Received()))
+	}
+
+	switch state.Status() {
+	case datatransfer.Completed:
+		t.complete()
+	case datatransfer.Failed, datatransfer.Cancelled:
+		t.fail(fmt.Errorf("data transfer channel %s: %s", datatransfer.Statuses[state.Status()], state.Message()))
+	}
+}
+
+func (g *graphsyncTransport) getTransfer(dealUuid uuid.UUID) *transfer {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	return g.transfers[dealUuid]
+}
+
+type transfer struct {
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	dealInfo *types.TransportDealInfo
+	peerID   peer.ID
+	bs       *blockstore.ReadWrite
+	dl       *logs.DealLogger
+
+	// receives the result of the transfer
+	done     chan error
+	doneOnce sync.Once
+
+	// signalled when the number of bytes received changes. Progress events
+	// are sent from the transfer's goroutine so that data transfer event
+	// callbacks never block, and so that the latest progress is always
+	// sent, even if the subscriber falls behind.
+	progress chan struct{}
+
+	lk       sync.Mutex
+	chid     *datatransfer.ChannelID
+	received int64
+	eventCh  chan types.TransportEvent
+	closed   bool
+}
+
+func (t *transfer) setChannelID(chid datatransfer.ChannelID) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.chid = &chid
+}
+
+func (t *transfer) isChannel(chid datatransfer.ChannelID) bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	// events may arrive before the channel id has been set
+	return t.chid == nil || *t.chid == chid
+}
+
+func (t *transfer) complete() {
+	t.doneOnce.Do(func() { t.done <- nil })
+}
+
+func (t *transfer) fail(err error) {
+	t.doneOnce.Do(func() { t.done <- err })
+}
+
+func (t *transfer) setReceived(n int64) {
+	t.lk.Lock()
+	t.received = n
+	t.lk.Unlock()
+
+	select {
+	case t.progress <- struct{}{}:
+	default:
+		// there is already a progress update waiting to be sent
+	}
+}
+
+func (t *transfer) bytesReceived() int64 {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	return t.received
+}
+
+// sendEvent sends an event to the subscriber, waiting for space in the
+// channel if the subscriber has fallen behind. Events are only sent by the
+// transfer's goroutine, which closes the channel when it exits.
+func (t *transfer) sendEvent(ctx context.Context, evt types.TransportEvent) {
+	select {
+	case t.eventCh <- evt:
+	case <-ctx.Done():
+		// The transfer has been closed, so the subscriber may no longer be
+		// reading events
+		select {
+		case t.eventCh <- evt:
+		default:
+			t.dl.Warnw(t.dealInfo.DealUuid, "dropping transport event as transfer is closed", "event", fmt.Sprintf("%+v", evt))
+		}
+	}
+}
+
+func (t *transfer) closeEvents() {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.eventCh)
+	}
+}
+
+// Close shuts down the transfer for the given deal. It is the caller's responsibility to call Close after it no longer needs the transfer.
+func (t *transfer) Close() {
+	t.closeOnce.Do(func() {
+		t.cancel()
+		t.wg.Wait()
+	})
+}
+
+func (t *transfer) Sub() chan types.TransportEvent {
+	return t.eventCh
+}
+
+// rejectAllValidator rejects all incoming data transfer requests. Boost only
+// opens channels for the voucher type, it never accepts them.
+type rejectAllValidator struct{}
+
+func (v *rejectAllValidator) ValidatePush(isRestart bool, chid datatransfer.ChannelID, sender peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, xerrors.New("incoming push requests are not accepted for boost deal data transfer vouchers")
+}
+
+func (v *rejectAllValidator) ValidatePull(isRestart bool, chid datatransfer.ChannelID, receiver peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, xerrors.New("incoming pull requests are not accepted for boost deal data transfer vouchers")
+}
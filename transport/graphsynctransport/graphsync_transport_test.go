@@ -0,0 +1,213 @@
+package graphsynctransport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport/types"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
+	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphsyncTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provHost := newHost(t)
+	clientHost := newHost(t)
+	provHost.Peerstore().AddAddrs(clientHost.ID(), clientHost.Addrs(), peerstore.PermanentAddrTTL)
+
+	// Create a DAG on the client
+	clientBs := emptyBlockstore()
+	dserv := merkledag.NewDAGService(blockservice.New(clientBs, nil))
+	data := make([]byte, 4*1024*1024+30)
+	rand.New(rand.NewSource(1)).Read(data) //nolint:gosec
+	root := importDag(t, dserv, data)
+
+	var carBuf bytes.Buffer
+	require.NoError(t, car.WriteCar(ctx, dserv, []cid.Cid{root}, &carBuf))
+	carPath := getTempFilePath(t)
+	require.NoError(t, ioutil.WriteFile(carPath, carBuf.Bytes(), 0644))
+
+	// The client serves the CAR file for the deal to the provider
+	dealUuid := uuid.New()
+	clientDt := newDataTransfer(t, ctx, clientHost, storeutil.LinkSystemForBlockstore(emptyBlockstore()))
+	srv, err := NewServer(clientDt)
+	require.NoError(t, err)
+	waitServed, err := srv.Serve(dealUuid, provHost.ID(), root, carPath)
+	require.NoError(t, err)
+
+	// The provider pulls the deal data from the client
+	provDt := newDataTransfer(t, ctx, provHost, storeutil.LinkSystemForBlockstore(emptyBlockstore()))
+	gst, err := New(provHost, provDt, newDealLogger(t, ctx))
+	require.NoError(t, err)
+
+	bz, err := json.Marshal(types.GraphsyncRequest{PeerID: clientHost.ID().String()})
+	require.NoError(t, err)
+	of := getTempFilePath(t)
+	th, err := gst.Execute(ctx, bz, &types.TransportDealInfo{
+		OutputFile:   of,
+		DealUuid:     dealUuid,
+		DealSize:     int64(carBuf.Len()),
+		DealDataRoot: root,
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	require.NotEmpty(t, evts)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, carBuf.Len(), last.NBytesReceived)
+
+	// The progress reported should never go backwards
+	for i := 1; i < len(evts); i++ {
+		require.GreaterOrEqual(t, evts[i].NBytesReceived, evts[i-1].NBytesReceived)
+	}
+
+	// The client should stop serving the data once the transfer is complete
+	require.NoError(t, waitServed(ctx))
+
+	// The data payload of the output CAR should be the same as the CAR that
+	// the client would have sent over http
+	rd, err := carv2.OpenReader(of)
+	require.NoError(t, err)
+	defer rd.Close() //nolint:errcheck
+	payload, err := ioutil.ReadAll(rd.DataReader())
+	require.NoError(t, err)
+	require.Equal(t, carBuf.Bytes(), payload)
+}
+
+func TestGraphsyncTransferRejected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provHost := newHost(t)
+	clientHost := newHost(t)
+	provHost.Peerstore().AddAddrs(clientHost.ID(), clientHost.Addrs(), peerstore.PermanentAddrTTL)
+
+	clientBs := emptyBlockstore()
+	dserv := merkledag.NewDAGService(blockservice.New(clientBs, nil))
+	root := importDag(t, dserv, []byte("some deal data"))
+	var carBuf bytes.Buffer
+	require.NoError(t, car.WriteCar(ctx, dserv, []cid.Cid{root}, &carBuf))
+	carPath := getTempFilePath(t)
+	require.NoError(t, ioutil.WriteFile(carPath, carBuf.Bytes(), 0644))
+
+	// The client only serves the data for a different deal
+	clientDt := newDataTransfer(t, ctx, clientHost, storeutil.LinkSystemForBlockstore(emptyBlockstore()))
+	srv, err := NewServer(clientDt)
+	require.NoError(t, err)
+	_, err = srv.Serve(uuid.New(), provHost.ID(), root, carPath)
+	require.NoError(t, err)
+
+	provDt := newDataTransfer(t, ctx, provHost, storeutil.LinkSystemForBlockstore(emptyBlockstore()))
+	gst, err := New(provHost, provDt, newDealLogger(t, ctx))
+	require.NoError(t, err)
+
+	bz, err := json.Marshal(types.GraphsyncRequest{PeerID: clientHost.ID().String()})
+	require.NoError(t, err)
+	th, err := gst.Execute(ctx, bz, &types.TransportDealInfo{
+		OutputFile:   getTempFilePath(t),
+		DealUuid:     uuid.New(),
+		DealSize:     100,
+		DealDataRoot: root,
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	require.NotEmpty(t, evts)
+	require.Error(t, evts[len(evts)-1].Error)
+}
+
+func newDataTransfer(t *testing.T, ctx context.Context, h host.Host, lsys ipld.LinkSystem) datatransfer.Manager {
+	gs := gsimpl.New(ctx, gsnet.NewFromLibp2pHost(h), lsys)
+	tp := gstransport.NewTransport(h.ID(), gs)
+	dt, err := dtimpl.NewDataTransfer(dssync.MutexWrap(datastore.NewMapDatastore()), dtnet.NewFromLibp2pHost(h), tp)
+	require.NoError(t, err)
+
+	ready := make(chan error, 1)
+	dt.OnReady(func(err error) { ready <- err })
+	require.NoError(t, dt.Start(ctx))
+	require.NoError(t, <-ready)
+	t.Cleanup(func() { _ = dt.Stop(context.Background()) })
+	return dt
+}
+
+func emptyBlockstore() bstore.Blockstore {
+	return bstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+}
+
+func newHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+func newDealLogger(t *testing.T, ctx context.Context) *logs.DealLogger {
+	tmp := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, tmp, tmp))
+	return logs.NewDealLogger(db.NewLogsDB(tmp))
+}
+
+func getTempFilePath(t *testing.T) string {
+	of, err := os.CreateTemp(t.TempDir(), "")
+	require.NoError(t, err)
+	require.NoError(t, of.Close())
+	return of.Name()
+}
+
+func importDag(t *testing.T, dserv *merkledag.ComboService, data []byte) cid.Cid {
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	require.NoError(t, err)
+
+	dbp := helpers.DagBuilderParams{
+		Maxlinks:   1024,
+		RawLeaves:  true,
+		CidBuilder: prefix,
+		Dagserv:    dserv,
+	}
+	db, err := dbp.New(chunk.NewSizeSplitter(bytes.NewReader(data), 1024*1024))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+	return nd.Cid()
+}
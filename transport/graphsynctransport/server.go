@@ -0,0 +1,180 @@
+package graphsynctransport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync/storeutil"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+)
+
+// Server is used by a client to serve the data for a deal with the
+// "graphsync" transfer type: the storage provider opens a go-data-transfer
+// pull channel with a DealDataTransferVoucher for the deal, and the server
+// sends the DAG from the deal's CAR file.
+type Server struct {
+	dt datatransfer.Manager
+
+	lk sync.Mutex
+	// maps from deal uuid -> deal for each deal that is being served
+	deals map[uuid.UUID]*servedDeal
+}
+
+type servedDeal struct {
+	provider peer.ID
+	root     cid.Cid
+	bs       *blockstore.ReadOnly
+	done     chan error
+	doneOnce sync.Once
+}
+
+func (d *servedDeal) finish(err error) {
+	d.doneOnce.Do(func() { d.done <- err })
+}
+
+// NewServer registers the deal data transfer voucher type with the data
+// transfer manager, so that requests from storage providers to pull the
+// data for the deals being served are accepted
+func NewServer(dt datatransfer.Manager) (*Server, error) {
+	s := &Server{
+		dt:    dt,
+		deals: make(map[uuid.UUID]*servedDeal),
+	}
+
+	if err := dt.RegisterVoucherType(&smtypes.DealDataTransferVoucher{}, s); err != nil {
+		return nil, fmt.Errorf("registering deal data transfer voucher type: %w", err)
+	}
+	// Read the data for each transfer from the deal's CAR file
+	if err := dt.RegisterTransportConfigurer(&smtypes.DealDataTransferVoucher{}, s.configureTransport); err != nil {
+		return nil, fmt.Errorf("registering deal data transfer configurer: %w", err)
+	}
+	dt.SubscribeToEvents(s.onEvent)
+
+	return s, nil
+}
+
+// Serve starts serving the DAG with the given root in the CAR file at
+// carPath to the storage provider, for the deal with the given uuid. It
+// should be called before the deal proposal is sent, so that the storage
+// provider's request to pull the data is accepted.
+// The returned function waits until the storage provider has finished
+// pulling the data (or the context is cancelled), then stops serving it.
+func (s *Server) Serve(dealUuid uuid.UUID, provider peer.ID, root cid.Cid, carPath string) (func(context.Context) error, error) {
+	bs, err := blockstore.OpenReadOnly(carPath, blockstore.UseWholeCIDs(true))
+	if err != nil {
+		return nil, fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+
+	d := &servedDeal{provider: provider, root: root, bs: bs, done: make(chan error, 1)}
+	s.lk.Lock()
+	if _, ok := s.deals[dealUuid]; ok {
+		s.lk.Unlock()
+		_ = bs.Close()
+		return nil, fmt.Errorf("data for deal %s is already being served", dealUuid)
+	}
+	s.deals[dealUuid] = d
+	s.lk.Unlock()
+
+	wait := func(ctx context.Context) error {
+		defer func() {
+			s.lk.Lock()
+			delete(s.deals, dealUuid)
+			s.lk.Unlock()
+			_ = bs.Close()
+		}()
+
+		select {
+		case err := <-d.done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return wait, nil
+}
+
+func (s *Server) getDeal(dealUuid uuid.UUID) *servedDeal {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.deals[dealUuid]
+}
+
+// ValidatePush rejects all push requests: the storage provider always pulls
+// the deal data
+func (s *Server) ValidatePush(isRestart bool, chid datatransfer.ChannelID, sender peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, xerrors.New("incoming push requests are not accepted for boost deal data transfer vouchers")
+}
+
+// ValidatePull accepts a request to pull the data for a deal that is being
+// served, from the storage provider for the deal
+func (s *Server) ValidatePull(isRestart bool, chid datatransfer.ChannelID, receiver peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
+	v, ok := voucher.(*smtypes.DealDataTransferVoucher)
+	if !ok {
+		return nil, xerrors.Errorf("unexpected voucher type %s", voucher.Type())
+	}
+
+	d := s.getDeal(v.DealUUID)
+	if d == nil {
+		return nil, xerrors.Errorf("the data for deal %s is not being served", v.DealUUID)
+	}
+	if receiver != d.provider {
+		return nil, xerrors.Errorf("peer %s is not the storage provider %s for deal %s", receiver, d.provider, v.DealUUID)
+	}
+	if !baseCid.Equals(d.root) {
+		return nil, xerrors.Errorf("requested root %s does not match deal %s data root %s", baseCid, v.DealUUID, d.root)
+	}
+	return nil, nil
+}
+
+// configureTransport is called by go-data-transfer when a channel is opened,
+// to read the blocks for the channel from the deal's CAR file
+func (s *Server) configureTransport(chid datatransfer.ChannelID, voucher datatransfer.Voucher, tp datatransfer.Transport) {
+	v, ok := voucher.(*smtypes.DealDataTransferVoucher)
+	if !ok {
+		return
+	}
+
+	d := s.getDeal(v.DealUUID)
+	if d == nil {
+		log.Warnw("no served deal for deal data transfer channel", "deal uuid", v.DealUUID, "channel id", chid)
+		return
+	}
+
+	gsTransport, ok := tp.(StoreConfigurableTransport)
+	if !ok {
+		d.finish(xerrors.New("data transfer transport does not support configuring the store"))
+		return
+	}
+	if err := gsTransport.UseStore(chid, storeutil.LinkSystemForBlockstore(d.bs)); err != nil {
+		d.finish(fmt.Errorf("failed to configure store for data transfer channel: %w", err))
+	}
+}
+
+// onEvent is called by go-data-transfer for each data transfer event
+func (s *Server) onEvent(event datatransfer.Event, state datatransfer.ChannelState) {
+	v, ok := state.Voucher().(*smtypes.DealDataTransferVoucher)
+	if !ok {
+		return
+	}
+
+	d := s.getDeal(v.DealUUID)
+	if d == nil || state.Recipient() != d.provider {
+		return
+	}
+
+	switch state.Status() {
+	case datatransfer.Completed:
+		d.finish(nil)
+	case datatransfer.Failed, datatransfer.Cancelled:
+		d.finish(fmt.Errorf("data transfer channel %s: %s", datatransfer.Statuses[state.Status()], state.Message()))
+	}
+}
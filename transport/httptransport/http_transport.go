@@ -35,6 +35,7 @@ const (
 )
 
 var _ transport.Transport = (*httpTransport)(nil)
+var _ transport.Throttler = (*httpTransport)(nil)
 
 type Option func(*httpTransport)
 
@@ -47,6 +48,25 @@ func BackOffRetryOpt(minBackoff, maxBackoff time.Duration, factor, maxReconnectA
 	}
 }
 
+// ParallelDownloadOpt sets the maximum number of concurrent range requests
+// used to download the data for a single deal, and the minimum size of each
+// range. Parallel downloads are only used with servers that support range
+// requests, and not over libp2p.
+func ParallelDownloadOpt(maxStreams int, minSegmentSize int64) Option {
+	return func(h *httpTransport) {
+		h.maxParallelStreams = maxStreams
+		h.minSegmentSize = minSegmentSize
+	}
+}
+
+// BandwidthLimitsOpt sets the initial limits on the rate at which deal data
+// is downloaded
+func BandwidthLimitsOpt(limits types.BandwidthLimits) Option {
+	return func(h *httpTransport) {
+		h.throttle.setLimits(limits)
+	}
+}
+
 type httpTransport struct {
 	libp2pHost   host.Host
 	libp2pClient *http.Client
@@ -56,6 +76,11 @@ type httpTransport struct {
 	backOffFactor        float64
 	maxReconnectAttempts float64
 
+	maxParallelStreams int
+	minSegmentSize     int64
+
+	throttle *bandwidthThrottle
+
 	dl *logs.DealLogger
 }
 
@@ -66,6 +91,9 @@ func New(host host.Host, dealLogger *logs.DealLogger, opts ...Option) *httpTrans
 		maxBackoffWait:       maxBackOff,
 		backOffFactor:        factor,
 		maxReconnectAttempts: maxReconnectAttempts,
+		maxParallelStreams:   defaultParallelStreams,
+		minSegmentSize:       defaultMinSegmentSize,
+		throttle:             newBandwidthThrottle(types.BandwidthLimits{}),
 		dl:                   dealLogger.Subsystem("http-transport"),
 	}
 	for _, o := range opts {
@@ -81,6 +109,18 @@ func New(host host.Host, dealLogger *logs.DealLogger, opts ...Option) *httpTrans
 	return ht
 }
 
+// BandwidthLimits returns the current limits on the rate at which deal data
+// is downloaded
+func (h *httpTransport) BandwidthLimits() types.BandwidthLimits {
+	return h.throttle.getLimits()
+}
+
+// SetBandwidthLimits changes the limits on the rate at which deal data is
+// downloaded. The new limits also apply to transfers that are in progress.
+func (h *httpTransport) SetBandwidthLimits(limits types.BandwidthLimits) {
+	h.throttle.setLimits(limits)
+}
+
 func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (th transport.Handler, err error) {
 	deadline, _ := ctx.Deadline()
 	duuid := dealInfo.DealUuid
@@ -117,6 +157,24 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 	}
 	h.dl.Infow(duuid, "existing file size", "file size", fileSize, "deal size", dealInfo.DealSize)
 
+	// check if there is a parallel download in progress
+	ranges, err := loadTransferRanges(dealInfo.OutputFile, dealInfo.DealSize)
+	if err != nil {
+		return nil, err
+	}
+	nBytesReceived := fileSize
+	if ranges != nil {
+		if fileSize == 0 {
+			// the output file was re-created so start the download again
+			h.dl.Infow(duuid, "output file is empty, discarding parallel transfer ranges")
+			ranges.remove()
+			ranges = nil
+		} else {
+			nBytesReceived = ranges.received()
+			h.dl.Infow(duuid, "resuming parallel transfer", "received", nBytesReceived)
+		}
+	}
+
 	// construct the transfer instance that will act as the transfer handler
 	tctx, cancel := context.WithCancel(ctx)
 	t := &transfer{
@@ -124,7 +182,8 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 		tInfo:          tInfo,
 		dealInfo:       dealInfo,
 		eventCh:        make(chan types.TransportEvent, 256),
-		nBytesReceived: fileSize,
+		nBytesReceived: nBytesReceived,
+		ranges:         ranges,
 		backoff: &backoff.Backoff{
 			Min:    h.minBackOffWait,
 			Max:    h.maxBackoffWait,
@@ -132,6 +191,8 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 			Jitter: true,
 		},
 		maxReconnectAttempts: h.maxReconnectAttempts,
+		maxParallelStreams:   h.maxParallelStreams,
+		minSegmentSize:       h.minSegmentSize,
 		dl:                   h.dl,
 	}
 
@@ -152,6 +213,10 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 		// Use the libp2p client
 		t.client = h.libp2pClient
 
+		// The libp2p server only serves one request at a time for each
+		// transfer, so don't split the download into ranges
+		t.maxParallelStreams = 1
+
 		// Add the peer's address to the peerstore so we can dial it
 		addrTtl := time.Hour
 		if deadline, ok := ctx.Deadline(); ok {
@@ -171,8 +236,9 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 	}
 
 	// is the transfer already complete ? we check this by comparing the number of bytes
-	// in the output file with the deal size.
-	if fileSize == dealInfo.DealSize {
+	// in the output file with the deal size (unless a parallel download is in progress,
+	// in which case the output file may have gaps).
+	if ranges == nil && fileSize == dealInfo.DealSize {
 		defer cleanup()
 
 		if err := t.emitEvent(tctx, types.TransportEvent{
@@ -186,10 +252,12 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 	}
 
 	// start executing the transfer
+	t.throttle = h.throttle.register(dealInfo.ClientAddr)
 	t.wg.Add(1)
 	go func() {
 		defer t.wg.Done()
 		defer cleanup()
+		defer t.throttle.release()
 
 		if err := t.execute(tctx); err != nil {
 			if err := t.emitEvent(tctx, types.TransportEvent{
@@ -214,11 +282,20 @@ type transfer struct {
 	dealInfo *types.TransportDealInfo
 	wg       sync.WaitGroup
 
+	// guards nBytesReceived, which is updated concurrently by a parallel download
+	lk             sync.Mutex
 	nBytesReceived int64
 
+	// the progress of each range, if the download is split into ranges
+	ranges             *transferRanges
+	maxParallelStreams int
+	minSegmentSize     int64
+
 	backoff              *backoff.Backoff
 	maxReconnectAttempts float64
 
+	throttle *transferThrottle
+
 	client *http.Client
 	dl     *logs.DealLogger
 }
@@ -232,8 +309,43 @@ func (t *transfer) emitEvent(ctx context.Context, evt types.TransportEvent, id u
 	}
 }
 
+func (t *transfer) bytesReceived() int64 {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	return t.nBytesReceived
+}
+
+// addBytesReceived updates the number of bytes received and emits an event
+// with the new total
+func (t *transfer) addBytesReceived(ctx context.Context, n int) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.nBytesReceived = t.nBytesReceived + int64(n)
+
+	// emit event updating the number of bytes received
+	if err := t.emitEvent(ctx, types.TransportEvent{
+		NBytesReceived: t.nBytesReceived,
+	}, t.dealInfo.DealUuid); err != nil {
+		t.dl.LogError(t.dealInfo.DealUuid, "failed to publish transport event", err)
+	}
+}
+
 func (t *transfer) execute(ctx context.Context) error {
 	duuid := t.dealInfo.DealUuid
+
+	// if the server supports range requests, split a large download into
+	// ranges that are downloaded in parallel
+	if t.ranges == nil {
+		if count := t.parallelSegments(ctx); count > 1 {
+			t.ranges = newTransferRanges(t.dealInfo.OutputFile, t.dealInfo.DealSize, count)
+		}
+	}
+	if t.ranges != nil {
+		return t.executeParallel(ctx)
+	}
+
 	for {
 		// construct request
 		req, err := http.NewRequest("GET", t.tInfo.URL, nil)
@@ -264,9 +376,16 @@ func (t *transfer) execute(ctx context.Context) error {
 		}
 		defer of.Close()
 
+		// if commP is being calculated as the data arrives, pass it the
+		// data as it's written to the output file
+		var dst io.Writer = of
+		if t.dealInfo.CommP != nil {
+			dst = &commpWriter{w: of, commp: t.dealInfo.CommP, offset: t.nBytesReceived}
+		}
+
 		// start the http transfer
 		remaining := t.dealInfo.DealSize - t.nBytesReceived
-		reqErr := t.doHttp(ctx, req, of, remaining)
+		reqErr := t.doHttp(ctx, req, dst, remaining)
 		if reqErr == nil {
 			t.dl.Infow(duuid, "http transfer completed successfully")
 			// if there's no error, transfer was successful
@@ -342,7 +461,7 @@ func (t *transfer) execute(ctx context.Context) error {
 
 func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer, toRead int64) *httpError {
 	duid := t.dealInfo.DealUuid
-	t.dl.Infow(duid, "sending http request", "received", t.nBytesReceived, "remaining",
+	t.dl.Infow(duid, "sending http request", "received", t.bytesReceived(), "remaining",
 		toRead, "range-rq", req.Header.Get("Range"))
 
 	// send http request and validate response
@@ -359,6 +478,14 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 		}
 	}
 
+	return t.readResponse(ctx, resp, dst, toRead)
+}
+
+// readResponse reads the response body into dst, reporting progress and
+// waiting for the bandwidth limits after each read
+func (t *transfer) readResponse(ctx context.Context, resp *http.Response, dst io.Writer, toRead int64) *httpError {
+	duid := t.dealInfo.DealUuid
+
 	//  start reading the response stream `readBufferSize` at a time using a limit reader so we only read as many bytes as we need to.
 	buf := make([]byte, readBufferSize)
 	limitR := io.LimitReader(resp.Body, toRead)
@@ -367,7 +494,7 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 			t.dl.LogError(duid, "stopped reading http response: context canceled", ctx.Err())
 			return &httpError{error: ctx.Err()}
 		}
-		nr, readErr := limitR.Read(buf)
+		nr, readErr := limitR.Read(buf[:t.throttle.chunkSize()])
 
 		// if we read more than zero bytes, write whatever read.
 		if nr > 0 {
@@ -381,18 +508,17 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 				return &httpError{error: fmt.Errorf("read-write mismatch writing to the output file, read=%d, written=%d", nr, nw)}
 			}
 
-			t.nBytesReceived = t.nBytesReceived + int64(nw)
+			t.addBytesReceived(ctx, nw)
 
-			// emit event updating the number of bytes received
-			if err := t.emitEvent(ctx, types.TransportEvent{
-				NBytesReceived: t.nBytesReceived,
-			}, t.dealInfo.DealUuid); err != nil {
-				t.dl.LogError(duid, "failed to publish transport event", err)
+			// wait until the bandwidth limits allow the transfer to continue
+			if err := t.throttle.wait(ctx, nr); err != nil {
+				t.dl.LogError(duid, "stopped reading http response: context canceled", err)
+				return &httpError{error: err}
 			}
 		}
 		// the http stream we're reading from has sent us an EOF, nothing to do here.
 		if readErr == io.EOF {
-			t.dl.Infow(duid, "http server sent EOF", "received", t.nBytesReceived, "deal-size", t.dealInfo.DealSize)
+			t.dl.Infow(duid, "http server sent EOF", "received", t.bytesReceived(), "deal-size", t.dealInfo.DealSize)
 			return nil
 		}
 		if readErr != nil {
@@ -401,6 +527,24 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 	}
 }
 
+// commpWriter writes data to the output file and then passes the data that
+// was written to the commP hasher. Errors from the hasher are ignored, as
+// commP is calculated from the output file if the hasher missed any data.
+type commpWriter struct {
+	w      io.Writer
+	commp  io.WriterAt
+	offset int64
+}
+
+func (c *commpWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if n > 0 {
+		_, _ = c.commp.WriteAt(p[:n], c.offset)
+		c.offset += int64(n)
+	}
+	return n, err
+}
+
 // Close shuts down the transfer for the given deal. It is the caller's responsibility to call Close after it no longer needs the transfer.
 func (t *transfer) Close() {
 	t.closeOnce.Do(func() {
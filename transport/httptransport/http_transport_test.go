@@ -15,6 +15,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -386,6 +387,173 @@ func TestLibp2pTransferResumption(t *testing.T) {
 	require.True(t, nAttempts.Load() > 10)
 }
 
+func TestParallelTransfer(t *testing.T) {
+	ctx := context.Background()
+	size := (10 * readBufferSize) + 30
+	data := []byte(randSeq(size))
+
+	var lk sync.Mutex
+	var ranges []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			lk.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			lk.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer svr.Close()
+
+	ht := New(nil, newDealLogger(t, ctx), ParallelDownloadOpt(4, readBufferSize))
+	of := getTempFilePath(t)
+	th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+	require.NotNil(t, th)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	require.EqualValues(t, size, evts[len(evts)-1].NBytesReceived)
+	assertFileContents(t, of, data)
+
+	// the download should have been split into four ranges
+	segSize := size / 4
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf("bytes=0-%d", segSize-1),
+		fmt.Sprintf("bytes=%d-%d", segSize, 2*segSize-1),
+		fmt.Sprintf("bytes=%d-%d", 2*segSize, 3*segSize-1),
+		fmt.Sprintf("bytes=%d-%d", 3*segSize, size-1),
+	}, ranges)
+
+	// the ranges file should be removed once the transfer is complete
+	_, err := os.Stat(RangesFilePath(of))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestParallelTransferCommP(t *testing.T) {
+	ctx := context.Background()
+	size := (10 * readBufferSize) + 30
+	data := []byte(randSeq(size))
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer svr.Close()
+
+	ht := New(nil, newDealLogger(t, ctx), ParallelDownloadOpt(4, readBufferSize))
+	of := getTempFilePath(t)
+	commp := &recordingWriterAt{path: of, data: data}
+	bz, err := json.Marshal(types.HttpRequest{URL: svr.URL})
+	require.NoError(t, err)
+	th, err := ht.Execute(ctx, bz, &types.TransportDealInfo{OutputFile: of, DealSize: int64(size), CommP: commp})
+	require.NoError(t, err)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	assertFileContents(t, of, data)
+
+	// the hasher should have been passed the data for (at least) the first
+	// range as it arrived
+	commp.lk.Lock()
+	defer commp.lk.Unlock()
+	require.NoError(t, commp.err)
+	require.GreaterOrEqual(t, commp.written, int64(size/4))
+}
+
+// recordingWriterAt checks that the data it is passed follows on from the
+// data at the start of the output file without any gaps
+type recordingWriterAt struct {
+	path string
+	data []byte
+
+	lk      sync.Mutex
+	offset  int64
+	written int64
+	err     error
+}
+
+func (w *recordingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.err == nil {
+		w.err = w.check(p, off)
+	}
+	w.offset = off + int64(len(p))
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+func (w *recordingWriterAt) check(p []byte, off int64) error {
+	if off < w.offset {
+		return fmt.Errorf("write at offset %d is before the end of the last write %d", off, w.offset)
+	}
+	if !bytes.Equal(w.data[off:off+int64(len(p))], p) {
+		return fmt.Errorf("write at offset %d does not match the data", off)
+	}
+	// the data before the offset must already be in the output file
+	bz, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if int64(len(bz)) < off || !bytes.Equal(w.data[:off], bz[:off]) {
+		return fmt.Errorf("write at offset %d follows a gap in the output file", off)
+	}
+	return nil
+}
+
+func TestParallelTransferResumption(t *testing.T) {
+	ctx := context.Background()
+	size := (10 * readBufferSize) + 30
+	data := []byte(randSeq(size))
+
+	var lk sync.Mutex
+	var ranges []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			lk.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			lk.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer svr.Close()
+
+	// Simulate a parallel transfer that was interrupted: the first range is
+	// complete, the second range is partially complete and the third range
+	// hasn't started
+	of := getTempFilePath(t)
+	tr := newTransferRanges(of, int64(size), 3)
+	seg0, seg1 := tr.Segments[0], tr.Segments[1]
+	seg0.Received = seg0.End - seg0.Start
+	seg1.Received = 100
+	f, err := os.OpenFile(of, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt(data[seg0.Start:seg0.End], seg0.Start)
+	require.NoError(t, err)
+	_, err = f.WriteAt(data[seg1.Start:seg1.Start+seg1.Received], seg1.Start)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, tr.save())
+
+	ht := New(nil, newDealLogger(t, ctx), ParallelDownloadOpt(3, readBufferSize))
+	th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+	require.NotNil(t, th)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	require.EqualValues(t, size, evts[len(evts)-1].NBytesReceived)
+	assertFileContents(t, of, data)
+
+	// only the missing parts of the ranges should have been requested
+	seg2 := tr.Segments[2]
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf("bytes=%d-%d", seg1.Start+seg1.Received, seg1.End-1),
+		fmt.Sprintf("bytes=%d-%d", seg2.Start, seg2.End-1),
+	}, ranges)
+}
+
 func executeTransfer(t *testing.T, ctx context.Context, ht *httpTransport, size int, req types.HttpRequest, tmpFile string) transport.Handler {
 	dealInfo := &types.TransportDealInfo{
 		OutputFile: tmpFile,
@@ -0,0 +1,433 @@
+package httptransport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// The default maximum number of concurrent range requests per transfer
+	defaultParallelStreams = 4
+	// The default minimum size of each range that is downloaded in parallel.
+	// Deals smaller than two segments are downloaded with a single request.
+	defaultMinSegmentSize = 1 << 30
+
+	// The suffix of the file that records the progress of each range in a
+	// parallel download
+	rangesFileSuffix = ".ranges"
+	// How often to save the progress of a parallel download
+	rangesSaveInterval = 5 * time.Second
+)
+
+var errRangeNotSupported = xerrors.New("server does not support range requests")
+
+// segment is a range of bytes in the deal data
+type segment struct {
+	// The offset of the first byte in the range
+	Start int64
+	// The offset of the byte after the last byte in the range
+	End int64
+	// The number of bytes received so far, starting from Start
+	Received int64
+}
+
+// transferRanges keeps track of the progress of each range of a parallel
+// download, and saves it to a file next to the output file so that the
+// download can be resumed after a restart
+type transferRanges struct {
+	path string
+
+	// serializes recording the data received for a range with passing it
+	// to the commP hasher, so that the hasher sees the data in order
+	hashLk sync.Mutex
+
+	lk       sync.Mutex
+	DealSize int64
+	Segments []*segment
+}
+
+// RangesFilePath returns the path of the file that records the progress of
+// a parallel download to outputFile
+func RangesFilePath(outputFile string) string {
+	return outputFile + rangesFileSuffix
+}
+
+// newTransferRanges splits the deal data into the given number of ranges
+func newTransferRanges(outputFile string, dealSize int64, count int) *transferRanges {
+	r := &transferRanges{path: RangesFilePath(outputFile), DealSize: dealSize}
+	segSize := dealSize / int64(count)
+	for i := 0; i < count; i++ {
+		seg := &segment{Start: int64(i) * segSize, End: int64(i+1) * segSize}
+		if i == count-1 {
+			seg.End = dealSize
+		}
+		r.Segments = append(r.Segments, seg)
+	}
+	return r
+}
+
+// loadTransferRanges reads the progress of a parallel download from disk.
+// It returns nil if there is no parallel download in progress.
+func loadTransferRanges(outputFile string, dealSize int64) (*transferRanges, error) {
+	path := RangesFilePath(outputFile)
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading transfer ranges file: %w", err)
+	}
+
+	r := &transferRanges{path: path}
+	if err := json.Unmarshal(bz, r); err != nil {
+		return nil, fmt.Errorf("parsing transfer ranges file %s: %w", path, err)
+	}
+	if r.DealSize != dealSize {
+		return nil, fmt.Errorf("transfer ranges file %s has deal size %d but deal size is %d", path, r.DealSize, dealSize)
+	}
+	return r, nil
+}
+
+// save writes the progress of each range to disk
+func (r *transferRanges) save() error {
+	r.lk.Lock()
+	bz, err := json.Marshal(r)
+	r.lk.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshalling transfer ranges: %w", err)
+	}
+
+	// Write to a temp file and rename it so that the ranges file is never
+	// left half-written
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bz, 0644); err != nil {
+		return fmt.Errorf("writing transfer ranges file: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("renaming transfer ranges file: %w", err)
+	}
+	return nil
+}
+
+func (r *transferRanges) remove() {
+	_ = os.Remove(r.path)
+}
+
+// received returns the total number of bytes received across all ranges
+func (r *transferRanges) received() int64 {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	var total int64
+	for _, seg := range r.Segments {
+		total += seg.Received
+	}
+	return total
+}
+
+// incomplete returns the ranges that have not been completely downloaded
+func (r *transferRanges) incomplete() []*segment {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	var segs []*segment
+	for _, seg := range r.Segments {
+		if seg.Start+seg.Received < seg.End {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// remaining returns the range of bytes in the segment that have not yet been
+// received
+func (r *transferRanges) remaining(seg *segment) (int64, int64) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	return seg.Start + seg.Received, seg.End
+}
+
+func (r *transferRanges) addReceived(seg *segment, n int) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	seg.Received += int64(n)
+}
+
+// contiguous returns true if all the ranges before seg have been completely
+// downloaded, so that the data received for seg follows on from the data
+// at the start of the file without any gaps
+func (r *transferRanges) contiguous(seg *segment) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for _, s := range r.Segments {
+		if s == seg {
+			return true
+		}
+		if s.Start+s.Received < s.End {
+			return false
+		}
+	}
+	return false
+}
+
+// segmentWriter writes data to the output file at the position of the next
+// byte to be received in the segment.
+// If commP is being calculated as the data arrives, the data is passed to
+// the hasher only if there is no gap before it in the file. The hasher
+// reads any data it missed from the file when the gap is filled in (ie
+// when the range that receives the next data following the gap is the
+// lowest incomplete range).
+type segmentWriter struct {
+	f     *os.File
+	r     *transferRanges
+	seg   *segment
+	commp io.WriterAt
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	offset, _ := w.r.remaining(w.seg)
+	n, err := w.f.WriteAt(p, offset)
+	if n > 0 {
+		w.r.hashLk.Lock()
+		w.r.addReceived(w.seg, n)
+		if w.commp != nil && w.r.contiguous(w.seg) {
+			// Errors from the hasher are ignored, as commP is calculated
+			// from the output file if the hasher missed any data
+			_, _ = w.commp.WriteAt(p[:n], offset)
+		}
+		w.r.hashLk.Unlock()
+	}
+	return n, err
+}
+
+// parallelSegments returns the number of ranges to split a new download into.
+// If the download should not be split it returns 1.
+func (t *transfer) parallelSegments(ctx context.Context) int {
+	if t.maxParallelStreams < 2 || t.minSegmentSize <= 0 || t.nBytesReceived > 0 {
+		return 1
+	}
+
+	count := t.dealInfo.DealSize / t.minSegmentSize
+	if count < 2 {
+		return 1
+	}
+	if count > int64(t.maxParallelStreams) {
+		count = int64(t.maxParallelStreams)
+	}
+
+	// Check if the server supports range requests
+	req, err := http.NewRequest("HEAD", t.tInfo.URL, nil)
+	if err != nil {
+		return 1
+	}
+	for name, val := range t.tInfo.Headers {
+		req.Header.Set(name, val)
+	}
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.dl.Infow(t.dealInfo.DealUuid, "http HEAD request failed, falling back to single stream transfer", "err", err.Error())
+		return 1
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.dl.Infow(t.dealInfo.DealUuid, "server does not support range requests, using single stream transfer",
+			"http code", resp.StatusCode, "accept-ranges", resp.Header.Get("Accept-Ranges"))
+		return 1
+	}
+
+	return int(count)
+}
+
+// executeParallel downloads each incomplete range of the deal data
+// concurrently, writing each range at its offset in the output file
+func (t *transfer) executeParallel(ctx context.Context) error {
+	duuid := t.dealInfo.DealUuid
+	t.dl.Infow(duuid, "starting parallel http transfer", "ranges", len(t.ranges.Segments),
+		"incomplete ranges", len(t.ranges.incomplete()), "received", t.ranges.received())
+
+	of, err := os.OpenFile(t.dealInfo.OutputFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer of.Close()
+
+	if err := t.ranges.save(); err != nil {
+		return err
+	}
+
+	// periodically save the progress of each range so that the transfer can
+	// be resumed after a restart
+	var saveWg sync.WaitGroup
+	saveCtx, stopSaving := context.WithCancel(ctx)
+	saveWg.Add(1)
+	go func() {
+		defer saveWg.Done()
+		ticker := time.NewTicker(rangesSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.ranges.save(); err != nil {
+					t.dl.LogError(duuid, "failed to save transfer ranges", err)
+				}
+			case <-saveCtx.Done():
+				return
+			}
+		}
+	}()
+
+	errg, gctx := errgroup.WithContext(ctx)
+	for _, seg := range t.ranges.incomplete() {
+		seg := seg
+		errg.Go(func() error {
+			return t.downloadSegment(gctx, of, seg)
+		})
+	}
+	err = errg.Wait()
+
+	stopSaving()
+	saveWg.Wait()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			// The transfer was cancelled, so save the progress of each range
+			// in case the transfer is resumed
+			if err := t.ranges.save(); err != nil {
+				t.dl.LogError(duuid, "failed to save transfer ranges", err)
+			}
+			return fmt.Errorf("transfer context canceled err: %w", err)
+		}
+		t.ranges.remove()
+		return err
+	}
+
+	// --- all ranges finished successfully. see if we got the number of bytes we expected.
+	received := t.ranges.received()
+	t.ranges.remove()
+	if received != t.dealInfo.DealSize {
+		return fmt.Errorf("mismatch in dealSize vs received bytes, dealSize=%d, received=%d", t.dealInfo.DealSize, received)
+	}
+	st, err := os.Stat(t.dealInfo.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+	if st.Size() != t.dealInfo.DealSize {
+		return fmt.Errorf("mismtach in output file size vs received bytes, fileSize=%d, receivedBytes=%d", st.Size(), received)
+	}
+
+	t.dl.Infow(duuid, "parallel http transfer finished successfully", "nBytesReceived", received,
+		"file size", st.Size())
+
+	return nil
+}
+
+// downloadSegment downloads the remaining bytes in a range, retrying with
+// back-off if there is an error
+func (t *transfer) downloadSegment(ctx context.Context, of *os.File, seg *segment) error {
+	duuid := t.dealInfo.DealUuid
+	bo := &backoff.Backoff{
+		Min:    t.backoff.Min,
+		Max:    t.backoff.Max,
+		Factor: t.backoff.Factor,
+		Jitter: true,
+	}
+
+	for {
+		start, end := t.ranges.remaining(seg)
+		if start >= end {
+			return nil
+		}
+
+		req, err := http.NewRequest("GET", t.tInfo.URL, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create http req: %w", err)
+		}
+		for name, val := range t.tInfo.Headers {
+			req.Header.Set(name, val)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		req = req.WithContext(ctx)
+
+		w := &segmentWriter{f: of, r: t.ranges, seg: seg, commp: t.dealInfo.CommP}
+		reqErr := t.doRangeHttp(ctx, req, w, end-start)
+		if reqErr == nil {
+			if next, _ := t.ranges.remaining(seg); next >= end {
+				t.dl.Infow(duuid, "http range transfer completed successfully", "range-start", seg.Start, "range-end", seg.End)
+				return nil
+			}
+			reqErr = &httpError{error: xerrors.New("server closed the connection before the end of the range")}
+		}
+
+		t.dl.Infow(duuid, "http range request error", "http code", reqErr.code, "outputErr", reqErr.Error())
+
+		if xerrors.Is(reqErr.error, errRangeNotSupported) || reqErr.code/100 == 4 {
+			msg := fmt.Sprintf("terminating http request: received %d response from server", reqErr.code)
+			t.dl.LogError(duuid, msg, reqErr)
+			return reqErr.error
+		}
+
+		err = reqErr.error
+		if xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		// If some data was transferred, reset the back-off count to zero
+		if next, _ := t.ranges.remaining(seg); next > start {
+			bo.Reset()
+		}
+
+		nAttempts := bo.Attempt() + 1
+		if nAttempts >= t.maxReconnectAttempts {
+			t.dl.Errorw(duuid, "terminating http range transfer: exhausted max attempts", "err", err.Error(), "maxAttempts", t.maxReconnectAttempts)
+			return fmt.Errorf("could not finish transfer even after %.0f attempts, lastErr: %w", t.maxReconnectAttempts, err)
+		}
+		duration := bo.Duration()
+		t.dl.Infow(duuid, "backing off before retrying http range request", "backoff time", duration.String(),
+			"attempts", nAttempts, "range-start", seg.Start)
+		bt := time.NewTimer(duration)
+		select {
+		case <-bt.C:
+		case <-ctx.Done():
+			bt.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// doRangeHttp sends a range request and writes the response to dst. The
+// server must respond with the requested range.
+func (t *transfer) doRangeHttp(ctx context.Context, req *http.Request, dst *segmentWriter, toRead int64) *httpError {
+	t.dl.Infow(t.dealInfo.DealUuid, "sending http range request", "received", t.bytesReceived(), "remaining",
+		toRead, "range-rq", req.Header.Get("Range"))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &httpError{error: fmt.Errorf("failed to send  http req: %w", err)}
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusOK {
+		return &httpError{error: fmt.Errorf("http range req failed: %w", errRangeNotSupported), code: resp.StatusCode}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return &httpError{
+			error: fmt.Errorf("http range req failed: code: %d, status: %s", resp.StatusCode, resp.Status),
+			code:  resp.StatusCode,
+		}
+	}
+
+	return t.readResponse(ctx, resp, dst, toRead)
+}
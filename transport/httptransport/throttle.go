@@ -0,0 +1,217 @@
+package httptransport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+)
+
+// rateLimiter is a token bucket that limits the rate at which bytes are
+// read. The bucket holds up to one second's worth of tokens. Reading more
+// bytes than there are tokens puts the bucket into debt, and the reader must
+// wait until the debt has been paid off.
+type rateLimiter struct {
+	lk sync.Mutex
+	// bytes per second (0 means no limit)
+	limit  uint64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit uint64) *rateLimiter {
+	return &rateLimiter{limit: limit, tokens: float64(limit), last: time.Now()}
+}
+
+func (r *rateLimiter) getLimit() uint64 {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	return r.limit
+}
+
+func (r *rateLimiter) setLimit(limit uint64) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	r.refillLocked(time.Now())
+	r.limit = limit
+	if r.tokens > float64(limit) {
+		r.tokens = float64(limit)
+	}
+}
+
+// reserve takes n tokens from the bucket, and returns the amount of time the
+// caller must wait before the tokens are available
+func (r *rateLimiter) reserve(n int) time.Duration {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.limit == 0 {
+		return 0
+	}
+
+	r.refillLocked(time.Now())
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / float64(r.limit) * float64(time.Second))
+}
+
+func (r *rateLimiter) refillLocked(now time.Time) {
+	elapsed := now.Sub(r.last)
+	r.last = now
+	if r.limit == 0 {
+		r.tokens = 0
+		return
+	}
+
+	r.tokens += elapsed.Seconds() * float64(r.limit)
+	if r.tokens > float64(r.limit) {
+		r.tokens = float64(r.limit)
+	}
+}
+
+// bandwidthThrottle limits the rate at which deal data is downloaded, across
+// all transfers, per deal and per client. The limits can be changed while
+// transfers are running.
+type bandwidthThrottle struct {
+	lk     sync.Mutex
+	limits types.BandwidthLimits
+	total  *rateLimiter
+	// the throttle for each running transfer
+	transfers map[*transferThrottle]struct{}
+	// the limiter shared by all running transfers from each client
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*rateLimiter
+	refs int
+}
+
+func newBandwidthThrottle(limits types.BandwidthLimits) *bandwidthThrottle {
+	return &bandwidthThrottle{
+		limits:    limits,
+		total:     newRateLimiter(limits.Total),
+		transfers: make(map[*transferThrottle]struct{}),
+		clients:   make(map[string]*clientLimiter),
+	}
+}
+
+func (b *bandwidthThrottle) getLimits() types.BandwidthLimits {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.limits
+}
+
+// setLimits applies the new limits to all transfers, including those that
+// are already running
+func (b *bandwidthThrottle) setLimits(limits types.BandwidthLimits) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.limits = limits
+	b.total.setLimit(limits.Total)
+	for tt := range b.transfers {
+		tt.deal.setLimit(limits.PerDeal)
+	}
+	for _, cl := range b.clients {
+		cl.setLimit(limits.PerClient)
+	}
+}
+
+// register is called when a transfer starts. The caller must call release on
+// the returned throttle when the transfer ends.
+func (b *bandwidthThrottle) register(client string) *transferThrottle {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	cl, ok := b.clients[client]
+	if !ok {
+		cl = &clientLimiter{rateLimiter: newRateLimiter(b.limits.PerClient)}
+		b.clients[client] = cl
+	}
+	cl.refs++
+
+	tt := &transferThrottle{
+		b:      b,
+		client: client,
+		deal:   newRateLimiter(b.limits.PerDeal),
+	}
+	b.transfers[tt] = struct{}{}
+	return tt
+}
+
+func (b *bandwidthThrottle) release(tt *transferThrottle) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if _, ok := b.transfers[tt]; !ok {
+		return
+	}
+	delete(b.transfers, tt)
+
+	cl := b.clients[tt.client]
+	cl.refs--
+	if cl.refs == 0 {
+		delete(b.clients, tt.client)
+	}
+}
+
+// transferThrottle limits the rate of a single transfer
+type transferThrottle struct {
+	b      *bandwidthThrottle
+	client string
+	deal   *rateLimiter
+}
+
+// chunkSize returns the number of bytes to read at a time, so that a
+// transfer with a low limit doesn't read in large bursts
+func (tt *transferThrottle) chunkSize() int {
+	size := uint64(readBufferSize)
+	limits := tt.b.getLimits()
+	for _, l := range []uint64{limits.Total, limits.PerDeal, limits.PerClient} {
+		if l > 0 && l < size {
+			size = l
+		}
+	}
+	return int(size)
+}
+
+// wait blocks until the transfer is allowed to continue after reading n
+// bytes, or the context is cancelled
+func (tt *transferThrottle) wait(ctx context.Context, n int) error {
+	tt.b.lk.Lock()
+	limiters := []*rateLimiter{tt.b.total, tt.deal}
+	if cl, ok := tt.b.clients[tt.client]; ok {
+		limiters = append(limiters, cl.rateLimiter)
+	}
+	tt.b.lk.Unlock()
+
+	var delay time.Duration
+	for _, l := range limiters {
+		if d := l.reserve(n); d > delay {
+			delay = d
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (tt *transferThrottle) release() {
+	tt.b.release(tt)
+}
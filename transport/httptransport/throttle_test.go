@@ -0,0 +1,73 @@
+package httptransport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// With no limit there should never be any delay
+	r := newRateLimiter(0)
+	require.Zero(t, r.reserve(10*readBufferSize))
+
+	// The bucket starts with one second's worth of tokens
+	r = newRateLimiter(1000)
+	require.Zero(t, r.reserve(1000))
+
+	// Once the tokens are used up, reading another second's worth of bytes
+	// should mean waiting for about a second
+	d := r.reserve(1000)
+	require.Greater(t, d, 900*time.Millisecond)
+	require.LessOrEqual(t, d, time.Second)
+
+	// Removing the limit should remove the delay
+	r.setLimit(0)
+	require.Zero(t, r.reserve(1000))
+}
+
+func TestBandwidthThrottleChunkSize(t *testing.T) {
+	b := newBandwidthThrottle(types.BandwidthLimits{})
+	tt := b.register("client")
+	defer tt.release()
+	require.Equal(t, readBufferSize, tt.chunkSize())
+
+	// The chunk size should be capped to the lowest limit
+	b.setLimits(types.BandwidthLimits{Total: 4096, PerDeal: 1024, PerClient: 2048})
+	require.Equal(t, 1024, tt.chunkSize())
+}
+
+func TestBandwidthThrottleWait(t *testing.T) {
+	ctx := context.Background()
+
+	b := newBandwidthThrottle(types.BandwidthLimits{PerClient: 1000})
+	tt1 := b.register("client-a")
+	tt2 := b.register("client-a")
+	tt3 := b.register("client-b")
+
+	// Transfers from the same client share the client limit
+	require.NoError(t, tt1.wait(ctx, 1000))
+	start := time.Now()
+	require.NoError(t, tt2.wait(ctx, 100))
+	require.Greater(t, time.Since(start), 50*time.Millisecond)
+
+	// A transfer from a different client is not affected
+	start = time.Now()
+	require.NoError(t, tt3.wait(ctx, 1000))
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Waiting should stop when the context is cancelled
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, tt3.wait(cctx, 1000), context.Canceled)
+
+	// Releasing all the transfers for a client should clean up the client
+	tt1.release()
+	tt2.release()
+	tt3.release()
+	require.Empty(t, b.transfers)
+	require.Empty(t, b.clients)
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/filecoin-project/boost/storagemarket/datatransfer"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
 )
 
 type Transport interface {
@@ -18,7 +20,36 @@ type Handler interface {
 	Close()
 }
 
+// Throttler is implemented by transports that can limit the rate at which
+// deal data is transferred
+type Throttler interface {
+	BandwidthLimits() types.BandwidthLimits
+	SetBandwidthLimits(limits types.BandwidthLimits)
+}
+
+// UploadAuthorizer is implemented by transports to which the client pushes
+// the deal data. The provider issues a token for each accepted deal, that the
+// client uses to authenticate the upload.
+type UploadAuthorizer interface {
+	// AuthorizeUpload returns a token that is only valid for an upload of
+	// the given size to the deal with the given uuid
+	AuthorizeUpload(ctx context.Context, dealUuid uuid.UUID, size uint64) (string, error)
+	// RevokeUpload invalidates the token for the deal's upload, if any
+	RevokeUpload(ctx context.Context, dealUuid uuid.UUID) error
+}
+
 func TransferParamsAsJson(transfer smtypes.Transfer) (string, error) {
+	if transfer.Type == "graphsync" {
+		return graphsyncParamsAsJson(transfer)
+	}
+	if transfer.Type == datatransfer.TransferLocal.Type() {
+		return localParamsAsJson(transfer)
+	}
+	if transfer.Type == "push" {
+		// The client uploads the data to the provider, so there are no
+		// parameters for the transfer
+		return "{}", nil
+	}
 	if transfer.Type != "http" {
 		return "", fmt.Errorf("cannot parse params for unrecognized transfer type '%s'", transfer.Type)
 	}
@@ -40,3 +71,29 @@ func TransferParamsAsJson(transfer smtypes.Transfer) (string, error) {
 	}
 	return string(bz), nil
 }
+
+func graphsyncParamsAsJson(transfer smtypes.Transfer) (string, error) {
+	tInfo := &types.GraphsyncRequest{}
+	if err := json.Unmarshal(transfer.Params, tInfo); err != nil {
+		return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(transfer.Params), err)
+	}
+
+	bz, err := json.Marshal(tInfo)
+	if err != nil {
+		return "", fmt.Errorf("marshalling transfer params json: %w", err)
+	}
+	return string(bz), nil
+}
+
+func localParamsAsJson(transfer smtypes.Transfer) (string, error) {
+	tInfo, err := datatransfer.TransferLocal.UnmarshallParams(transfer.Params)
+	if err != nil {
+		return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(transfer.Params), err)
+	}
+
+	bz, err := datatransfer.TransferLocal.MarshallParams(tInfo)
+	if err != nil {
+		return "", fmt.Errorf("marshalling transfer params json: %w", err)
+	}
+	return string(bz), nil
+}
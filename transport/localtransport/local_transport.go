@@ -0,0 +1,277 @@
+package localtransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/boost/storagemarket/datatransfer"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// 1 Mib
+	copyBufferSize = 1048576
+)
+
+var _ transport.Transport = (*localTransport)(nil)
+
+type Config struct {
+	// The directories that deal data may be transferred from. The path of
+	// the deal data must be inside one of these directories.
+	AllowedRoots []string
+}
+
+// localTransport transfers deal data from a path on a filesystem that the
+// provider can access (eg a network share). It hard-links the file into the
+// staging area if possible, or otherwise copies it.
+type localTransport struct {
+	cfg Config
+	dl  *logs.DealLogger
+}
+
+func New(dealLogger *logs.DealLogger, cfg Config) *localTransport {
+	return &localTransport{
+		cfg: cfg,
+		dl:  dealLogger.Subsystem("local-transport"),
+	}
+}
+
+func (l *localTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (transport.Handler, error) {
+	duuid := dealInfo.DealUuid
+	l.dl.Infow(duuid, "execute transfer", "deal size", dealInfo.DealSize, "output file", dealInfo.OutputFile)
+
+	// de-serialize transport opaque token
+	params, err := datatransfer.TransferLocal.UnmarshallParams(transportInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to de-serialize transport info bytes, bytes:%s, err:%w", string(transportInfo), err)
+	}
+
+	srcPath, err := l.checkPath(params.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// check that the size of the source file matches the deal size
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source file '%s': %w", srcPath, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("source path '%s' is not a regular file", srcPath)
+	}
+	if fi.Size() != dealInfo.DealSize {
+		return nil, fmt.Errorf("source file size %d does not match deal size %d", fi.Size(), dealInfo.DealSize)
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	t := &transfer{
+		cancel:   cancel,
+		srcPath:  srcPath,
+		dealInfo: dealInfo,
+		eventCh:  make(chan types.TransportEvent, 256),
+		dl:       l.dl,
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer cancel()
+		defer close(t.eventCh)
+
+		if err := t.execute(tctx); err != nil {
+			select {
+			case t.eventCh <- types.TransportEvent{Error: err}:
+			case <-tctx.Done():
+			}
+		}
+	}()
+
+	return t, nil
+}
+
+// checkPath resolves the path (including any symlinks) and checks that it
+// is inside one of the allowed roots
+func (l *localTransport) checkPath(path string) (string, error) {
+	if len(l.cfg.AllowedRoots) == 0 {
+		return "", errors.New("local transfers are not enabled: no allowed paths are configured")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("source path '%s' must be an absolute path", path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve source path '%s': %w", path, err)
+	}
+
+	for _, root := range l.cfg.AllowedRoots {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolved)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+
+	return "", fmt.Errorf("source path '%s' is not inside any of the allowed paths", path)
+}
+
+type transfer struct {
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	srcPath  string
+	dealInfo *types.TransportDealInfo
+	eventCh  chan types.TransportEvent
+	dl       *logs.DealLogger
+}
+
+func (t *transfer) execute(ctx context.Context) error {
+	duuid := t.dealInfo.DealUuid
+
+	// If the output file is empty, try to hard-link the source file into
+	// the staging area, so that the data doesn't need to be copied
+	fi, err := os.Stat(t.dealInfo.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+	if fi.Size() == 0 {
+		linked, err := t.link()
+		if err != nil {
+			return err
+		}
+		if linked {
+			t.dl.Infow(duuid, "hard-linked deal data into staging area", "source", t.srcPath)
+			return t.emitEvent(ctx, t.dealInfo.DealSize)
+		}
+	}
+
+	if err := t.copy(ctx, fi.Size()); err != nil {
+		return err
+	}
+	t.dl.Infow(duuid, "copied deal data into staging area", "source", t.srcPath)
+	return nil
+}
+
+// LinkFilePath returns the path of the temporary hard link that is renamed
+// to outputFile when the deal data is linked rather than copied
+func LinkFilePath(outputFile string) string {
+	return outputFile + ".link"
+}
+
+// link replaces the (empty) output file with a hard link to the source
+// file. It returns false if the file could not be linked, eg because it is
+// on a different filesystem.
+func (t *transfer) link() (bool, error) {
+	tmpPath := LinkFilePath(t.dealInfo.OutputFile)
+	if err := os.Link(t.srcPath, tmpPath); err != nil {
+		t.dl.Infow(t.dealInfo.DealUuid, "could not hard-link deal data, copying instead", "source", t.srcPath, "err", err.Error())
+		return false, nil
+	}
+	if err := os.Rename(tmpPath, t.dealInfo.OutputFile); err != nil {
+		_ = os.Remove(tmpPath)
+		return false, fmt.Errorf("failed to move hard-linked file to output file: %w", err)
+	}
+	return true, nil
+}
+
+// copy copies the source file into the output file, starting at offset
+// (when resuming a copy that was interrupted)
+func (t *transfer) copy(ctx context.Context, offset int64) error {
+	if offset > t.dealInfo.DealSize {
+		return fmt.Errorf("deal size=%d but received=%d", t.dealInfo.DealSize, offset)
+	}
+
+	src, err := os.Open(t.srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer src.Close() //nolint:errcheck
+
+	dst, err := os.OpenFile(t.dealInfo.OutputFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer dst.Close() //nolint:errcheck
+
+	if offset > 0 {
+		t.dl.Infow(t.dealInfo.DealUuid, "resuming copy of deal data", "offset", offset)
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek source file: %w", err)
+		}
+		if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek output file: %w", err)
+		}
+	}
+
+	buf := make([]byte, copyBufferSize)
+	copied := offset
+	for copied < t.dealInfo.DealSize {
+		if ctx.Err() != nil {
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			if werr != nil {
+				return fmt.Errorf("writing output file: %w", werr)
+			}
+			if t.dealInfo.CommP != nil {
+				_, _ = t.dealInfo.CommP.WriteAt(buf[:nw], copied)
+			}
+			copied += int64(nw)
+			if err := t.emitEvent(ctx, copied); err != nil {
+				return err
+			}
+		}
+		if xerrors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return fmt.Errorf("reading source file: %w", rerr)
+		}
+	}
+
+	// The source file may have changed since its size was checked
+	if copied != t.dealInfo.DealSize {
+		return fmt.Errorf("copied %d bytes but deal size is %d", copied, t.dealInfo.DealSize)
+	}
+	return nil
+}
+
+func (t *transfer) emitEvent(ctx context.Context, received int64) error {
+	select {
+	case t.eventCh <- types.TransportEvent{NBytesReceived: received}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+	}
+}
+
+// Close shuts down the transfer for the given deal. It is the caller's responsibility to call Close after it no longer needs the transfer.
+func (t *transfer) Close() {
+	t.closeOnce.Do(func() {
+		t.cancel()
+		t.wg.Wait()
+	})
+}
+
+func (t *transfer) Sub() chan types.TransportEvent {
+	return t.eventCh
+}
@@ -0,0 +1,159 @@
+package localtransport
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/datatransfer"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTransfer(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	data := randBytes(3*copyBufferSize + 100)
+	srcPath := filepath.Join(root, "deal.car")
+	require.NoError(t, os.WriteFile(srcPath, data, 0644))
+
+	lt := New(newDealLogger(t, ctx), Config{AllowedRoots: []string{root}})
+
+	t.Run("new transfer", func(t *testing.T) {
+		of := getTempFilePath(t)
+		evts := executeTransfer(t, ctx, lt, srcPath, of, int64(len(data)))
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.EqualValues(t, len(data), last.NBytesReceived)
+
+		bz, err := os.ReadFile(of)
+		require.NoError(t, err)
+		require.Equal(t, data, bz)
+	})
+
+	t.Run("resume copy", func(t *testing.T) {
+		// Simulate a copy that was interrupted part way through
+		of := getTempFilePath(t)
+		require.NoError(t, os.WriteFile(of, data[:copyBufferSize+10], 0644))
+
+		evts := executeTransfer(t, ctx, lt, srcPath, of, int64(len(data)))
+		require.EqualValues(t, copyBufferSize*2+10, evts[0].NBytesReceived)
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.EqualValues(t, len(data), last.NBytesReceived)
+
+		bz, err := os.ReadFile(of)
+		require.NoError(t, err)
+		require.Equal(t, data, bz)
+	})
+}
+
+func TestLocalTransferValidation(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	outside := t.TempDir()
+	data := randBytes(1024)
+
+	srcPath := filepath.Join(root, "deal.car")
+	require.NoError(t, os.WriteFile(srcPath, data, 0644))
+	outsidePath := filepath.Join(outside, "deal.car")
+	require.NoError(t, os.WriteFile(outsidePath, data, 0644))
+	symlinkPath := filepath.Join(root, "link.car")
+	require.NoError(t, os.Symlink(outsidePath, symlinkPath))
+
+	lt := New(newDealLogger(t, ctx), Config{AllowedRoots: []string{root}})
+
+	tcs := []struct {
+		name     string
+		path     string
+		dealSize int64
+	}{{
+		name:     "path outside allowed roots",
+		path:     outsidePath,
+		dealSize: int64(len(data)),
+	}, {
+		name:     "symlink to path outside allowed roots",
+		path:     symlinkPath,
+		dealSize: int64(len(data)),
+	}, {
+		name:     "relative path",
+		path:     filepath.Join("..", filepath.Base(root), "deal.car"),
+		dealSize: int64(len(data)),
+	}, {
+		name:     "size mismatch",
+		path:     srcPath,
+		dealSize: int64(len(data)) + 1,
+	}, {
+		name:     "directory",
+		path:     root,
+		dealSize: int64(len(data)),
+	}}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := lt.Execute(ctx, marshalParams(t, tc.path), &types.TransportDealInfo{
+				OutputFile: getTempFilePath(t),
+				DealUuid:   uuid.New(),
+				DealSize:   tc.dealSize,
+			})
+			require.Error(t, err)
+		})
+	}
+
+	// With no allowed roots, local transfers are disabled
+	lt = New(newDealLogger(t, ctx), Config{})
+	_, err := lt.Execute(ctx, marshalParams(t, srcPath), &types.TransportDealInfo{
+		OutputFile: getTempFilePath(t),
+		DealUuid:   uuid.New(),
+		DealSize:   int64(len(data)),
+	})
+	require.Error(t, err)
+}
+
+func executeTransfer(t *testing.T, ctx context.Context, lt *localTransport, srcPath string, of string, size int64) []types.TransportEvent {
+	th, err := lt.Execute(ctx, marshalParams(t, srcPath), &types.TransportDealInfo{
+		OutputFile: of,
+		DealUuid:   uuid.New(),
+		DealSize:   size,
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	require.NotEmpty(t, evts)
+	return evts
+}
+
+func marshalParams(t *testing.T, path string) []byte {
+	bz, err := json.Marshal(datatransfer.TransferLocalParams{Path: path})
+	require.NoError(t, err)
+	return bz
+}
+
+func newDealLogger(t *testing.T, ctx context.Context) *logs.DealLogger {
+	tmp := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, tmp, tmp))
+	return logs.NewDealLogger(db.NewLogsDB(tmp))
+}
+
+func randBytes(n int) []byte {
+	bz := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(bz) //nolint:gosec
+	return bz
+}
+
+func getTempFilePath(t *testing.T) string {
+	of, err := os.CreateTemp(t.TempDir(), "")
+	require.NoError(t, err)
+	require.NoError(t, of.Close())
+	return of.Name()
+}
@@ -0,0 +1,511 @@
+package pushtransport
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/httptransport"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("push-transport")
+
+const (
+	// UploadPath is the path of the upload endpoint. The deal uuid is
+	// appended to the path, eg /upload/<deal uuid>
+	UploadPath = "/upload/"
+
+	// UploadOffsetHeader is the response header with the number of bytes of
+	// deal data that the provider has received so far. The client resumes
+	// an interrupted upload from this offset.
+	UploadOffsetHeader = "Upload-Offset"
+
+	// The number of seconds after which the client should retry an upload
+	// for a deal whose transfer has not started yet (eg because the deal is
+	// queued behind other transfers)
+	retryAfterSecs = 30
+
+	// 1 Mib
+	writeBufferSize = 1048576
+)
+
+var _ transport.Transport = (*pushTransport)(nil)
+var _ transport.UploadAuthorizer = (*pushTransport)(nil)
+
+type Config struct {
+	// The address to listen on for uploads over plain HTTP, eg
+	// "0.0.0.0:8444". If empty, uploads are only accepted over libp2p.
+	ListenAddr string
+}
+
+// pushTransport receives deal data that the client uploads to the provider
+// with HTTP PUT, over TCP and over libp2p. Each upload is authenticated with
+// a token that is only valid for a single deal.
+type pushTransport struct {
+	h      host.Host
+	tokens *uploadTokenDB
+	dl     *logs.DealLogger
+	cfg    Config
+
+	lk sync.Mutex
+	// maps from deal uuid -> upload for each running transfer
+	uploads map[uuid.UUID]*upload
+
+	servers   []*http.Server
+	listeners []net.Listener
+}
+
+func New(h host.Host, ds datastore.Batching, dealLogger *logs.DealLogger, cfg Config) *pushTransport {
+	return &pushTransport{
+		h:       h,
+		tokens:  newUploadTokenDB(ds),
+		dl:      dealLogger.Subsystem("push-transport"),
+		cfg:     cfg,
+		uploads: make(map[uuid.UUID]*upload),
+	}
+}
+
+func (p *pushTransport) Start(ctx context.Context) error {
+	handler := http.NewServeMux()
+	handler.HandleFunc(UploadPath, p.handler)
+
+	// Listen on HTTP over libp2p
+	p2pListener, err := gostream.Listen(p.h, types.DataTransferProtocol)
+	if err != nil {
+		return fmt.Errorf("starting gostream listener: %w", err)
+	}
+	p.listeners = append(p.listeners, p2pListener)
+
+	// Listen on HTTP over TCP
+	if p.cfg.ListenAddr != "" {
+		tcpListener, err := net.Listen("tcp", p.cfg.ListenAddr)
+		if err != nil {
+			_ = p2pListener.Close()
+			return fmt.Errorf("listening on %s: %w", p.cfg.ListenAddr, err)
+		}
+		p.listeners = append(p.listeners, tcpListener)
+	}
+
+	for _, l := range p.listeners {
+		srv := &http.Server{
+			Handler: handler,
+			BaseContext: func(listener net.Listener) context.Context {
+				return ctx
+			},
+		}
+		p.servers = append(p.servers, srv)
+		go srv.Serve(l) //nolint:errcheck
+	}
+
+	log.Infow("push transport started", "listen addr", p.cfg.ListenAddr)
+	return nil
+}
+
+func (p *pushTransport) Stop(ctx context.Context) error {
+	var err error
+	for _, srv := range p.servers {
+		if serr := srv.Close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// AuthorizeUpload returns a token that is only valid for an upload of the
+// given size to the deal with the given uuid
+func (p *pushTransport) AuthorizeUpload(ctx context.Context, dealUuid uuid.UUID, size uint64) (string, error) {
+	token, err := httptransport.GenerateAuthToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = p.tokens.put(ctx, dealUuid, uploadToken{Token: token, Size: size})
+	if err != nil {
+		return "", err
+	}
+
+	p.dl.Infow(dealUuid, "authorized deal data upload", "size", size)
+	return token, nil
+}
+
+// RevokeUpload invalidates the token for the deal's upload, if any
+func (p *pushTransport) RevokeUpload(ctx context.Context, dealUuid uuid.UUID) error {
+	return p.tokens.delete(ctx, dealUuid)
+}
+
+// Execute waits for the client to upload the deal data. If some of the data
+// has already been uploaded (eg before a restart), the client resumes the
+// upload from the end of the output file.
+func (p *pushTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (transport.Handler, error) {
+	duuid := dealInfo.DealUuid
+	p.dl.Infow(duuid, "execute transfer", "deal size", dealInfo.DealSize, "output file", dealInfo.OutputFile)
+
+	// check size of output file
+	fi, err := os.Stat(dealInfo.OutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat output file: %w", err)
+	}
+	received := fi.Size()
+	if received > dealInfo.DealSize {
+		return nil, fmt.Errorf("deal size=%d but received=%d", dealInfo.DealSize, received)
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	u := &upload{
+		ctx:      tctx,
+		cancel:   cancel,
+		dealInfo: dealInfo,
+		received: received,
+		eventCh:  make(chan types.TransportEvent, 256),
+		complete: make(chan struct{}),
+		dl:       p.dl,
+	}
+
+	p.lk.Lock()
+	if _, ok := p.uploads[duuid]; ok {
+		p.lk.Unlock()
+		cancel()
+		return nil, fmt.Errorf("transfer for deal %s is already running", duuid)
+	}
+	p.uploads[duuid] = u
+	p.lk.Unlock()
+
+	if received == dealInfo.DealSize {
+		p.dl.Infow(duuid, "deal data has already been uploaded", "received", received)
+		u.setComplete()
+	} else if received > 0 {
+		p.dl.Infow(duuid, "waiting for client to resume upload", "received", received)
+	} else {
+		p.dl.Infow(duuid, "waiting for client to upload deal data")
+	}
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		defer func() {
+			p.lk.Lock()
+			delete(p.uploads, duuid)
+			p.lk.Unlock()
+			cancel()
+			u.closeEvents()
+		}()
+
+		select {
+		case <-u.complete:
+			// The token can only be used once
+			if err := p.tokens.delete(context.Background(), duuid); err != nil {
+				p.dl.LogError(duuid, "failed to delete upload token", err)
+			}
+			p.dl.Infow(duuid, "upload completed successfully", "received", u.bytesReceived())
+			u.emitEvent(types.TransportEvent{NBytesReceived: u.bytesReceived()})
+		case <-tctx.Done():
+			p.dl.Infow(duuid, "stopped waiting for upload: context cancelled", "received", u.bytesReceived())
+			u.emitEvent(types.TransportEvent{
+				NBytesReceived: u.bytesReceived(),
+				Error:          fmt.Errorf("transfer context canceled err: %w", tctx.Err()),
+			})
+		}
+	}()
+
+	return u, nil
+}
+
+func (p *pushTransport) getUpload(dealUuid uuid.UUID) *upload {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	return p.uploads[dealUuid]
+}
+
+// handler is called by the http library to handle an incoming upload request
+func (p *pushTransport) handler(w http.ResponseWriter, r *http.Request) {
+	dealUuid, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, UploadPath))
+	if err != nil {
+		http.Error(w, "Failed to parse deal uuid from path '"+r.URL.Path+"'", http.StatusBadRequest)
+		return
+	}
+
+	u, herr := p.checkAuth(r, dealUuid)
+	if herr != nil {
+		log.Infow("upload request failed", "code", herr.code, "err", herr.error, "id", dealUuid, "remote-addr", r.RemoteAddr)
+		if herr.code == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSecs))
+		}
+		http.Error(w, herr.Error(), herr.code)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		// Tell the client how much data has been received so far, so that
+		// it can resume the upload from that offset
+		w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.bytesReceived(), 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		p.handlePut(w, r, u)
+	default:
+		w.Header().Set("Allow", "HEAD, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkAuth checks that the request has a valid token for the deal, and
+// returns the upload for the deal
+func (p *pushTransport) checkAuth(r *http.Request, dealUuid uuid.UUID) (*upload, *httpError) {
+	_, token, ok := r.BasicAuth()
+	if !ok {
+		return nil, &httpError{error: errors.New("rejected request with no Authorization header"), code: http.StatusUnauthorized}
+	}
+
+	tok, err := p.tokens.get(r.Context(), dealUuid)
+	if err != nil {
+		if xerrors.Is(err, ErrTokenNotFound) {
+			return nil, &httpError{error: errors.New("rejected unrecognized upload token"), code: http.StatusUnauthorized}
+		}
+		return nil, &httpError{error: fmt.Errorf("getting upload token: %w", err), code: http.StatusInternalServerError}
+	}
+	if subtle.ConstantTimeCompare([]byte(tok.Token), []byte(token)) != 1 {
+		return nil, &httpError{error: errors.New("rejected unrecognized upload token"), code: http.StatusUnauthorized}
+	}
+
+	u := p.getUpload(dealUuid)
+	if u == nil {
+		return nil, &httpError{error: errors.New("deal is not ready to receive data"), code: http.StatusServiceUnavailable}
+	}
+	if tok.Size != uint64(u.dealInfo.DealSize) {
+		return nil, &httpError{error: fmt.Errorf("upload token is for size %d but deal size is %d", tok.Size, u.dealInfo.DealSize), code: http.StatusForbidden}
+	}
+
+	return u, nil
+}
+
+func (p *pushTransport) handlePut(w http.ResponseWriter, r *http.Request, u *upload) {
+	duuid := u.dealInfo.DealUuid
+	start, end, err := parseContentRange(r.Header.Get("Content-Range"), u.dealInfo.DealSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only one request at a time can write to the file, and it must start
+	// writing at the end of the data that has been received so far
+	if err := u.startWrite(start); err != nil {
+		w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.bytesReceived(), 10))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer u.endWrite()
+
+	p.dl.Infow(duuid, "receiving upload", "offset", start, "end", end, "remote-addr", r.RemoteAddr)
+	werr := u.write(r.Body, start, end)
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.bytesReceived(), 10))
+	if werr != nil {
+		p.dl.Infow(duuid, "upload interrupted", "received", u.bytesReceived(), "err", werr.Error())
+		http.Error(w, werr.Error(), werr.code)
+		return
+	}
+
+	if u.bytesReceived() == u.dealInfo.DealSize {
+		u.setComplete()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseContentRange parses a header of the form "bytes <start>-<end>/<size>"
+// and returns the start and end (exclusive) offsets. If there is no header,
+// the request is for the whole of the deal data.
+func parseContentRange(header string, dealSize int64) (int64, int64, error) {
+	if header == "" {
+		return 0, dealSize, nil
+	}
+
+	var start, last, size int64
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &last, &size); err != nil {
+		return 0, 0, fmt.Errorf("parsing Content-Range header '%s': %w", header, err)
+	}
+	if size != dealSize {
+		return 0, 0, fmt.Errorf("upload size %d does not match deal size %d", size, dealSize)
+	}
+	if start < 0 || last < start || last >= size {
+		return 0, 0, fmt.Errorf("invalid range in Content-Range header '%s'", header)
+	}
+	return start, last + 1, nil
+}
+
+type httpError struct {
+	error
+	code int
+}
+
+// upload is the handler for a deal's transfer. It keeps track of the data
+// received from the client.
+type upload struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	dealInfo *types.TransportDealInfo
+	dl       *logs.DealLogger
+
+	complete     chan struct{}
+	completeOnce sync.Once
+
+	lk       sync.Mutex
+	received int64
+	writing  bool
+	eventCh  chan types.TransportEvent
+	closed   bool
+}
+
+func (u *upload) bytesReceived() int64 {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	return u.received
+}
+
+func (u *upload) startWrite(offset int64) error {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	if u.writing {
+		return errors.New("another upload for the deal is in progress")
+	}
+	if offset != u.received {
+		return fmt.Errorf("upload must start at offset %d but starts at %d", u.received, offset)
+	}
+	u.writing = true
+	return nil
+}
+
+func (u *upload) endWrite() {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	u.writing = false
+}
+
+// write writes the data from the request body into the output file, from
+// start up to end
+func (u *upload) write(body io.Reader, start int64, end int64) *httpError {
+	f, err := os.OpenFile(u.dealInfo.OutputFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return &httpError{error: fmt.Errorf("failed to open output file: %w", err), code: http.StatusInternalServerError}
+	}
+	defer f.Close() //nolint:errcheck
+
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return &httpError{error: fmt.Errorf("failed to seek output file: %w", err), code: http.StatusInternalServerError}
+	}
+
+	buf := make([]byte, writeBufferSize)
+	toRead := end - start
+	for toRead > 0 {
+		if u.ctx.Err() != nil {
+			return &httpError{error: fmt.Errorf("transfer cancelled: %w", u.ctx.Err()), code: http.StatusServiceUnavailable}
+		}
+
+		rdBuf := buf
+		if toRead < int64(len(rdBuf)) {
+			rdBuf = buf[:toRead]
+		}
+		nr, rerr := body.Read(rdBuf)
+		if nr > 0 {
+			nw, werr := f.Write(rdBuf[:nr])
+			if nw > 0 {
+				if u.dealInfo.CommP != nil {
+					_, _ = u.dealInfo.CommP.WriteAt(rdBuf[:nw], end-toRead)
+				}
+				u.addReceived(int64(nw))
+			}
+			if werr != nil {
+				return &httpError{error: fmt.Errorf("writing output file: %w", werr), code: http.StatusInternalServerError}
+			}
+			toRead -= int64(nr)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return &httpError{error: fmt.Errorf("reading request body: %w", rerr), code: http.StatusBadRequest}
+		}
+	}
+
+	if toRead > 0 {
+		return &httpError{error: fmt.Errorf("request body ended %d bytes before end of range", toRead), code: http.StatusBadRequest}
+	}
+
+	// Make sure the client didn't send more data than the range allows
+	var extra [1]byte
+	if n, _ := body.Read(extra[:]); n > 0 {
+		return &httpError{error: errors.New("request body is longer than the upload range"), code: http.StatusRequestEntityTooLarge}
+	}
+	return nil
+}
+
+func (u *upload) addReceived(n int64) {
+	u.lk.Lock()
+	u.received += n
+	received := u.received
+	u.lk.Unlock()
+
+	u.emitEvent(types.TransportEvent{NBytesReceived: received})
+}
+
+func (u *upload) setComplete() {
+	u.completeOnce.Do(func() { close(u.complete) })
+}
+
+func (u *upload) emitEvent(evt types.TransportEvent) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	if u.closed {
+		return
+	}
+	select {
+	case u.eventCh <- evt:
+	default:
+		u.dl.Warnw(u.dealInfo.DealUuid, "dropping transport event as channel is full", "event", fmt.Sprintf("%+v", evt))
+	}
+}
+
+func (u *upload) closeEvents() {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	if !u.closed {
+		u.closed = true
+		close(u.eventCh)
+	}
+}
+
+// Close shuts down the transfer for the given deal. It is the caller's responsibility to call Close after it no longer needs the transfer.
+func (u *upload) Close() {
+	u.closeOnce.Do(func() {
+		u.cancel()
+		u.wg.Wait()
+	})
+}
+
+func (u *upload) Sub() chan types.TransportEvent {
+	return u.eventCh
+}
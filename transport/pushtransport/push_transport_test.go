@@ -0,0 +1,214 @@
+package pushtransport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestPushTransportResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pt := newPushTransport(t, ctx, newHost(t), "127.0.0.1:0")
+	baseUrl := "http://" + pt.listeners[1].Addr().String()
+
+	data := randBytes(3*writeBufferSize + 100)
+	dealUuid := uuid.New()
+	token, err := pt.AuthorizeUpload(ctx, dealUuid, uint64(len(data)))
+	require.NoError(t, err)
+
+	// Before the transfer starts, the client should be told to retry later
+	resp := doPut(t, baseUrl, dealUuid, token, data, "")
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	of := getTempFilePath(t)
+	th, err := pt.Execute(ctx, nil, &types.TransportDealInfo{
+		OutputFile: of,
+		DealUuid:   dealUuid,
+		DealSize:   int64(len(data)),
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	// A request with the wrong token should be rejected
+	resp = doPut(t, baseUrl, dealUuid, "bad-token", data, "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Upload the first half of the data
+	half := len(data) / 2
+	resp = doPut(t, baseUrl, dealUuid, token, data[:half], fmt.Sprintf("bytes 0-%d/%d", half-1, len(data)))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, fmt.Sprint(half), resp.Header.Get(UploadOffsetHeader))
+
+	// An upload that doesn't start at the end of the received data should
+	// be rejected
+	resp = doPut(t, baseUrl, dealUuid, token, data[1:], fmt.Sprintf("bytes 1-%d/%d", len(data)-1, len(data)))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, fmt.Sprint(half), resp.Header.Get(UploadOffsetHeader))
+
+	// The uploader should resume from the end of the received data
+	uploadPath := writeTempFile(t, data)
+	err = (&Uploader{client: http.DefaultClient}).Upload(ctx, baseUrl, dealUuid.String(), token, uploadPath)
+	require.NoError(t, err)
+
+	evts := drainEvents(t, th)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, len(data), last.NBytesReceived)
+
+	bz, err := os.ReadFile(of)
+	require.NoError(t, err)
+	require.Equal(t, data, bz)
+
+	// The token can only be used once
+	_, err = pt.tokens.get(ctx, dealUuid)
+	require.True(t, xerrors.Is(err, ErrTokenNotFound))
+}
+
+func TestPushTransportLibp2p(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provHost := newHost(t)
+	clientHost := newHost(t)
+	require.NoError(t, clientHost.Connect(ctx, peer.AddrInfo{ID: provHost.ID(), Addrs: provHost.Addrs()}))
+
+	pt := newPushTransport(t, ctx, provHost, "")
+
+	data := randBytes(writeBufferSize + 100)
+	dealUuid := uuid.New()
+	token, err := pt.AuthorizeUpload(ctx, dealUuid, uint64(len(data)))
+	require.NoError(t, err)
+
+	of := getTempFilePath(t)
+	th, err := pt.Execute(ctx, nil, &types.TransportDealInfo{
+		OutputFile: of,
+		DealUuid:   dealUuid,
+		DealSize:   int64(len(data)),
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	uploader := NewUploader(clientHost)
+	err = uploader.Upload(ctx, Libp2pUploadURL(provHost.ID()), dealUuid.String(), token, writeTempFile(t, data))
+	require.NoError(t, err)
+
+	evts := drainEvents(t, th)
+	require.NoError(t, evts[len(evts)-1].Error)
+
+	bz, err := os.ReadFile(of)
+	require.NoError(t, err)
+	require.Equal(t, data, bz)
+}
+
+func TestPushTransportWrongSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pt := newPushTransport(t, ctx, newHost(t), "127.0.0.1:0")
+	baseUrl := "http://" + pt.listeners[1].Addr().String()
+
+	data := randBytes(1024)
+	dealUuid := uuid.New()
+
+	// The token is only valid for an upload of the size it was issued for
+	token, err := pt.AuthorizeUpload(ctx, dealUuid, uint64(len(data))+1)
+	require.NoError(t, err)
+
+	th, err := pt.Execute(ctx, nil, &types.TransportDealInfo{
+		OutputFile: getTempFilePath(t),
+		DealUuid:   dealUuid,
+		DealSize:   int64(len(data)),
+	})
+	require.NoError(t, err)
+	defer th.Close()
+
+	resp := doPut(t, baseUrl, dealUuid, token, data, "")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// The token is only valid for the deal it was issued for
+	token, err = pt.AuthorizeUpload(ctx, dealUuid, uint64(len(data)))
+	require.NoError(t, err)
+	resp = doPut(t, baseUrl, uuid.New(), token, data, "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func newPushTransport(t *testing.T, ctx context.Context, h host.Host, listenAddr string) *pushTransport {
+	tmp := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, tmp, tmp))
+	dl := logs.NewDealLogger(db.NewLogsDB(tmp))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	pt := New(h, ds, dl, Config{ListenAddr: listenAddr})
+	require.NoError(t, pt.Start(ctx))
+	t.Cleanup(func() { _ = pt.Stop(context.Background()) })
+	return pt
+}
+
+func doPut(t *testing.T, baseUrl string, dealUuid uuid.UUID, token string, data []byte, contentRange string) *http.Response {
+	req, err := http.NewRequest(http.MethodPut, baseUrl+UploadPath+dealUuid.String(), bytes.NewReader(data))
+	require.NoError(t, err)
+	req.SetBasicAuth("", token)
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp
+}
+
+func drainEvents(t *testing.T, th interface {
+	Sub() chan types.TransportEvent
+}) []types.TransportEvent {
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	require.NotEmpty(t, evts)
+	return evts
+}
+
+func newHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+func randBytes(n int) []byte {
+	bz := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(bz) //nolint:gosec
+	return bz
+}
+
+func getTempFilePath(t *testing.T) string {
+	of, err := os.CreateTemp(t.TempDir(), "")
+	require.NoError(t, err)
+	require.NoError(t, of.Close())
+	return of.Name()
+}
+
+func writeTempFile(t *testing.T, data []byte) string {
+	path := getTempFilePath(t)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
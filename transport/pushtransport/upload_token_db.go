@@ -0,0 +1,71 @@
+package pushtransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"golang.org/x/xerrors"
+)
+
+// ErrTokenNotFound is returned when there is no upload token for a deal
+var ErrTokenNotFound = errors.New("upload token not found")
+
+// uploadToken is the token that authorizes the upload of a deal's data
+type uploadToken struct {
+	Token     string
+	Size      uint64
+	CreatedAt time.Time
+}
+
+// uploadTokenDB keeps a database of upload tokens by deal uuid
+type uploadTokenDB struct {
+	ds datastore.Batching
+}
+
+func newUploadTokenDB(ds datastore.Batching) *uploadTokenDB {
+	return &uploadTokenDB{
+		ds: namespace.Wrap(ds, datastore.NewKey("/upload-token")),
+	}
+}
+
+func (db *uploadTokenDB) put(ctx context.Context, dealUuid uuid.UUID, tok uploadToken) error {
+	tok.CreatedAt = time.Now()
+	bz, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("marshaling upload token JSON: %w", err)
+	}
+
+	err = db.ds.Put(ctx, datastore.NewKey(dealUuid.String()), bz)
+	if err != nil {
+		return fmt.Errorf("adding upload token to datastore: %w", err)
+	}
+
+	return nil
+}
+
+func (db *uploadTokenDB) get(ctx context.Context, dealUuid uuid.UUID) (*uploadToken, error) {
+	bz, err := db.ds.Get(ctx, datastore.NewKey(dealUuid.String()))
+	if err != nil {
+		if xerrors.Is(err, datastore.ErrNotFound) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("getting upload token from datastore: %w", err)
+	}
+
+	var tok uploadToken
+	err = json.Unmarshal(bz, &tok)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling json from datastore: %w", err)
+	}
+	return &tok, nil
+}
+
+func (db *uploadTokenDB) delete(ctx context.Context, dealUuid uuid.UUID) error {
+	return db.ds.Delete(ctx, datastore.NewKey(dealUuid.String()))
+}
@@ -0,0 +1,169 @@
+package pushtransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2phttp "github.com/libp2p/go-libp2p-http"
+)
+
+const (
+	// the number of times the uploader tries to resume an interrupted upload
+	maxUploadAttempts = 10
+	// the time to wait before resuming an interrupted upload
+	uploadRetryWait = 5 * time.Second
+)
+
+// errNotReady is returned when the provider has accepted the deal but is not
+// yet ready to receive the data (eg because the transfer is queued)
+var errNotReady = errors.New("provider is not ready to receive deal data")
+
+// Uploader pushes deal data to a provider's upload endpoint, over HTTP or
+// libp2p. If an upload is interrupted, it is resumed from the offset that
+// the provider has received.
+type Uploader struct {
+	client *http.Client
+}
+
+// NewUploader creates an uploader that can upload to URLs of the form
+// http(s)://host:port or libp2p://<peer id>
+func NewUploader(h host.Host) *Uploader {
+	tr := &http.Transport{}
+	p2ptr := p2phttp.NewTransport(h, p2phttp.ProtocolOption(types.DataTransferProtocol))
+	tr.RegisterProtocol("libp2p", p2ptr)
+	return &Uploader{client: &http.Client{Transport: tr}}
+}
+
+// Libp2pUploadURL returns the base url for uploading to the peer over libp2p
+func Libp2pUploadURL(id peer.ID) string {
+	return "libp2p://" + id.String()
+}
+
+// Upload uploads the file at path to the deal's upload endpoint at baseURL,
+// authenticating with the token that the provider returned when it accepted
+// the deal
+func (u *Uploader) Upload(ctx context.Context, baseURL string, dealUuid string, token string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("getting size of file %s: %w", path, err)
+	}
+	size := fi.Size()
+
+	url := strings.TrimSuffix(baseURL, "/") + UploadPath + dealUuid
+	var lastErr error
+	for attempt := 0; attempt < maxUploadAttempts; {
+		if lastErr != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(uploadRetryWait):
+			}
+		}
+
+		// Find out how much of the data the provider already has
+		offset, retry, err := u.offset(ctx, url, token)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			// Keep waiting while the provider is not ready, without
+			// counting it as a failed attempt
+			if !errors.Is(err, errNotReady) {
+				attempt++
+			}
+			lastErr = err
+			continue
+		}
+		if offset == size {
+			return nil
+		}
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking to offset %d in file %s: %w", offset, path, err)
+		}
+		retry, err = u.put(ctx, url, token, f, offset, size)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		attempt++
+		lastErr = err
+	}
+
+	return fmt.Errorf("upload failed after %d attempts: %w", maxUploadAttempts, lastErr)
+}
+
+// offset gets the number of bytes that the provider has received so far
+func (u *Uploader) offset(ctx context.Context, url string, token string) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("creating upload offset request: %w", err)
+	}
+	req.SetBasicAuth("", token)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, true, fmt.Errorf("getting upload offset: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return 0, true, errNotReady
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, isRetryable(resp.StatusCode), fmt.Errorf("getting upload offset: unexpected status %d", resp.StatusCode)
+	}
+
+	offset, err := strconv.ParseInt(resp.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing %s header: %w", UploadOffsetHeader, err)
+	}
+	return offset, false, nil
+}
+
+// put uploads the data from the reader, starting at offset
+func (u *Uploader) put(ctx context.Context, url string, token string, r io.Reader, offset int64, size int64) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, io.NopCloser(r))
+	if err != nil {
+		return false, fmt.Errorf("creating upload request: %w", err)
+	}
+	req.SetBasicAuth("", token)
+	req.ContentLength = size - offset
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("uploading data: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return isRetryable(resp.StatusCode), fmt.Errorf("uploading data: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
+
+// isRetryable returns true if the upload may succeed if it is tried again,
+// eg because the deal was not yet ready to receive data
+func isRetryable(code int) bool {
+	return code == http.StatusServiceUnavailable || code == http.StatusConflict || code >= http.StatusInternalServerError
+}
@@ -1,6 +1,8 @@
 package types
 
 import (
+	"io"
+
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 )
@@ -21,11 +23,43 @@ type HttpRequest struct {
 	Headers map[string]string
 }
 
+// GraphsyncRequest has parameters for a graphsync transfer, in which the
+// deal data is pulled from the peer with go-data-transfer
+type GraphsyncRequest struct {
+	// The ID of the peer that has the deal data (usually the client)
+	PeerID string
+	// The multiaddrs of the peer, eg "/ip4/104.131.131.82/tcp/4001"
+	Multiaddrs []string
+}
+
 // TransportDealInfo has parameters for a transfer to be executed
 type TransportDealInfo struct {
 	OutputFile string
 	DealUuid   uuid.UUID
 	DealSize   int64
+	// DealDataRoot is the root CID of the deal data DAG
+	DealDataRoot cid.Cid
+	// ClientAddr is the address of the deal client, used to apply
+	// per-client bandwidth limits
+	ClientAddr string
+	// CommP, if set, is passed each chunk of deal data that is written to
+	// the output file in order, at the offset it was written at, so that
+	// the piece commitment can be calculated as the data arrives. Chunks
+	// that are written after a gap in the file (eg by a parallel download)
+	// are not passed to CommP; it must read the data it missed from the
+	// output file.
+	CommP io.WriterAt
+}
+
+// BandwidthLimits are the maximum rates at which deal data is transferred,
+// in bytes per second. A limit of zero means no limit.
+type BandwidthLimits struct {
+	// The maximum rate across all transfers
+	Total uint64
+	// The maximum rate for a single deal's transfer
+	PerDeal uint64
+	// The maximum rate across all transfers from a single client
+	PerClient uint64
 }
 
 // TransportEvent is fired as a transfer progresses